@@ -1,938 +1,517 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"embed"
 	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"html/template"
 	"io"
+	"io/fs"
 	"math/big"
+	"mime"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/user"
+	"path"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
 var (
-	baseDir    string
-	dirMu      sync.Mutex
-	username   string
-	password   string
-	tokens     map[string]time.Time
-	tokenMu    sync.RWMutex
-	tlsEnabled bool
-	certFile   string
-	keyFile    string
+	baseDir           string
+	pathLocks         = keyedLocks{locks: make(map[string]*keyedLock)}
+	username          string
+	password          string
+	tokens            map[string]*tokenSession
+	tokenMu           sync.RWMutex
+	tlsEnabled        bool
+	certFile          string
+	keyFile           string
+	tlsMinVer         string
+	certHosts         stringList
+	corsOrigins       stringList // -cors-origin 允许跨域访问 /api/ 的来源，"*" 表示任意来源（不携带凭据）
+	uploadAllow       stringList // -upload-allow 允许写入新文件的目录前缀（相对用户根目录），为空表示不限制
+	certCache         string
+	stateDir          string // 运行状态目录，-cert-cache-dir 未指定时证书缓存在其 certs 子目录
+	regenCert         bool
+	hsts              bool
+	sessIdle          time.Duration
+	sessMaxAge        time.Duration
+	startTime         = time.Now()
+	displayLoc        = time.Local
+	dateFormat        string
+	followSymlinks    bool
+	metricsOn         bool
+	metricsToken      string
+	maxUploadSize     int64
+	multipartMemory   int64  // 表单上传在内存中缓存的字节数，超出部分写入临时文件
+	uploadTempDir     string // -upload-temp-dir 上传临时文件目录，为空表示系统临时目录
+	maxNameLength     = 255  // 单个路径组成部分的最大字节数
+	maxPathLength     = 4096 // 写入目标完整路径的最大字节数
+	sizeUnits         string
+	auditFile         *os.File
+	accessLog         io.Writer // -log-file 访问日志，nil 表示不记录
+	auditMu           sync.Mutex
+	users             map[string]userAccount
+	anonRole          string // 未登录访问者的角色，为空表示必须登录
+	allowBasicAuth    bool
+	showAbsPath       bool
+	serveIndex        bool
+	siteTitle         string
+	uiLang            string // 界面语言，auto 表示按 Accept-Language 选择
+	defaultSort       string
+	fetchTimeout      time.Duration
+	allowPrivateFetch bool
+	defaultOrder      string // 为空时按时间排序默认降序，其余默认升序
+	logoURL           string
+	gzipTypes         map[string]bool // 下载时可按需 gzip 压缩的扩展名
+	blockedExts       map[string]bool // -block-extensions 禁止上传的扩展名（小写，不含点）
+	sniffExecutables  bool            // 按文件头识别可执行文件并拒绝上传
+	dedupeMode        string
+	hashIndex         = make(map[string][]hashEntry)
+	hashIndexMu       sync.Mutex
+	checksumAlgo      string         // -checksum 列表中显示的校验和算法（md5 或 sha256），为空表示不显示
+	checksums         *checksumCache // 后台计算的校验和缓存，未启用时为 nil
+	uploads           = make(map[string]*chunkUpload)
+	uploadsMu         sync.Mutex
+	transferSlots     chan struct{} // 并发上传/下载的信号量，nil 表示不限制
+	noListing         bool          // 禁止目录浏览，仅允许通过明确路径下载
+	copyPreserve      bool          // 复制与跨设备移动时保留权限位与修改时间
+	uploadIdle        time.Duration // -upload-idle-timeout 上传连续无数据的最长时间，0 表示不限制
+	downloadIdle      time.Duration // -download-idle-timeout 下载时客户端连续不接收数据的最长时间，0 表示不限制
+	pathPrefix        string        // -path-prefix 反向代理挂载的路径前缀（如 /files），为空表示挂载在根路径
+	cookieName        = "auth_token"
+	cookiePath        string     // 认证与排序 cookie 的 Path，默认为 pathPrefix + "/"
+	searchIndex       *nameIndex // -index 文件名索引，nil 表示搜索时实时遍历
+	indexMaxEntries   int
+	allowEmptyRoot    bool          // 允许通过 /empty-dir 清空用户根目录
+	dirPollInterval   = time.Second // -events-interval 事件流检查目录变化的间隔
+	emptyDirTokens    = make(map[string]emptyDirToken)
+	emptyDirTokensMu  sync.Mutex
 )
 
+// stringList 实现 flag.Value，用于可重复指定的命令行参数
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ",") }
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
 // TokenInfo 存储token信息
 type TokenInfo struct {
 	Token     string    `json:"token"`
 	ExpiresAt time.Time `json:"expires_at"`
 }
 
+// tokenSession 记录token的签发时间、过期时间和续期窗口
+type tokenSession struct {
+	Username  string
+	Role      string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	Lifetime  time.Duration // 每次续期后的有效时长
+}
+
 // Breadcrumb 用于生成面包屑导航数据
 type Breadcrumb struct {
-	Name string
-	Path string
+	Name string `json:"name"`
+	Path string `json:"path"`
 }
 
 // FileInfo 存储文件或目录的基本信息，RawSize 与 ModTime 用于排序
 type FileInfo struct {
 	Name       string
+	Ext        string // 小写扩展名（不含点），目录为空
 	Size       string
 	RawSize    int64
 	UploadDate string
 	ModTime    time.Time
 	IsDir      bool
+	IsSymlink  bool
+	LinkTarget string // 符号链接指向的路径（os.Readlink 原样返回）
+	Category   string // 粗略分类：image、video、audio、document、archive、other，目录为空
+	Checksum   string // 启用 -checksum 时已算好的校验和
+	// ChecksumPending 校验和仍在后台计算中，页面显示“计算中...”并轮询 /checksum-status
+	ChecksumPending bool
 }
 
 // PageData 用于传递给模板的数据，新增加 Order 字段用于记录排序顺序
 type PageData struct {
-	Files       []FileInfo
-	Breadcrumbs []Breadcrumb // 面包屑导航数据
-	CurrentPath string       // 当前目录（相对于 baseDir）
-	Sort        string       // 当前排序字段："name"、"time"、"size"
-	Order       string       // 排序顺序："asc" 或 "desc"
-	Username    string       // 当前登录用户名
-}
-
-// loginTemplate 登录页面模板
-const loginTemplate = `
-<!DOCTYPE html>
-<html lang="zh-CN">
-<head>
-  <meta charset="UTF-8">
-  <meta name="viewport" content="width=device-width, initial-scale=1.0">
-  <title>登录</title>
-  <style>
-    body {
-      font-family: Arial, sans-serif;
-      margin: 0;
-      padding: 0;
-      background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
-      min-height: 100vh;
-      display: flex;
-      align-items: center;
-      justify-content: center;
-    }
-    .login-container {
-      background: white;
-      padding: 40px;
-      border-radius: 10px;
-      box-shadow: 0 15px 35px rgba(0,0,0,0.1);
-      width: 100%;
-      max-width: 400px;
-    }
-    .login-title {
-      text-align: center;
-      margin-bottom: 30px;
-      color: #333;
-      font-size: 24px;
-    }
-    .form-group {
-      margin-bottom: 20px;
-    }
-    .form-group label {
-      display: block;
-      margin-bottom: 5px;
-      color: #555;
-      font-weight: bold;
-    }
-    .form-group input {
-      width: 100%;
-      padding: 12px;
-      border: 2px solid #ddd;
-      border-radius: 5px;
-      font-size: 16px;
-      transition: border-color 0.3s;
-      box-sizing: border-box;
-    }
-    .form-group input:focus {
-      outline: none;
-      border-color: #667eea;
-    }
-    .login-btn {
-      width: 100%;
-      padding: 12px;
-      background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
-      color: white;
-      border: none;
-      border-radius: 5px;
-      font-size: 16px;
-      cursor: pointer;
-      transition: transform 0.2s;
-    }
-    .login-btn:hover {
-      transform: translateY(-2px);
-    }
-    .error-msg {
-      color: #e74c3c;
-      text-align: center;
-      margin-top: 15px;
-      display: none;
-    }
-    .remember-me {
-      display: flex;
-      align-items: center;
-      margin-bottom: 20px;
-    }
-    .remember-me input {
-      margin-right: 8px;
-    }
-  </style>
-</head>
-<body>
-  <div class="login-container">
-    <h2 class="login-title">简易网页文件管理器</h2>
-    <form id="loginForm">
-      <div class="form-group">
-        <label for="username">用户名:</label>
-        <input type="text" id="username" name="username" required>
-      </div>
-      <div class="form-group">
-        <label for="password">密码:</label>
-        <input type="password" id="password" name="password" required>
-      </div>
-      <div class="remember-me">
-        <input type="checkbox" id="rememberMe" name="rememberMe" checked>
-        <label for="rememberMe">记住登录状态 (30天)</label>
-      </div>
-      <button type="submit" class="login-btn">登录</button>
-      <div id="errorMsg" class="error-msg"></div>
-    </form>
-  </div>
-
-  <script>
-    document.getElementById('loginForm').addEventListener('submit', async function(e) {
-      e.preventDefault();
-      
-      const username = document.getElementById('username').value;
-      const password = document.getElementById('password').value;
-      const rememberMe = document.getElementById('rememberMe').checked;
-      const errorMsg = document.getElementById('errorMsg');
-      
-      try {
-        const response = await fetch('/api/login', {
-          method: 'POST',
-          headers: {
-            'Content-Type': 'application/json',
-          },
-          body: JSON.stringify({
-            username: username,
-            password: password,
-            remember_me: rememberMe
-          })
-        });
-        
-        const data = await response.json();
-        
-        if (response.ok) {
-          // 设置cookie
-          const expires = rememberMe ? 30 * 24 * 60 * 60 * 1000 : 24 * 60 * 60 * 1000; // 30天或1天
-          document.cookie = 'auth_token=' + data.token + '; expires=' + new Date(Date.now() + expires).toUTCString() + '; path=/';
-          
-          // 跳转到主页
-          window.location.href = '/';
-        } else {
-          errorMsg.textContent = data.error || '登录失败';
-          errorMsg.style.display = 'block';
-        }
-      } catch (error) {
-        errorMsg.textContent = '网络错误，请重试';
-        errorMsg.style.display = 'block';
-      }
-    });
-  </script>
-</body>
-</html>
-`
-
-// combinedTemplate 同时定义整个页面模板（"main"）和仅文件列表部分模板（"fileList"）
-const combinedTemplate = `
-{{define "main"}}
-<!DOCTYPE html>
-<html lang="zh-CN">
-<head>
-  <meta charset="UTF-8">
-  <meta name="viewport" content="width=device-width, initial-scale=1.0">
-  <title>简易网页文件管理器</title>
-  <style>
-    body {
-      font-family: Arial, sans-serif;
-      margin: 0;
-      padding: 0;
-      background-color: #f5f5f5;
-    }
-    .container {
-      max-width: 900px;
-      margin: 20px auto;
-      padding: 10px;
-      background-color: #fff;
-      border-radius: 5px;
-      box-shadow: 0 0 10px rgba(0,0,0,0.1);
-    }
-    h1 {
-      text-align: center;
-      color: #333;
-    }
-    .breadcrumbs {
-      margin: 10px 0;
-      font-size: 14px;
-    }
-    .breadcrumbs a {
-      text-decoration: none;
-      color: #007bff;
-      margin-right: 5px;
-    }
-    .breadcrumbs span {
-      margin-right: 5px;
-    }
-    .nav-actions {
-      display: flex;
-      flex-wrap: wrap;
-      gap: 10px;
-      justify-content: space-between;
-      align-items: center;
-      margin: 10px 0;
-    }
-    .nav-actions .action-group {
-      flex: 1 1 auto;
-      display: flex;
-      gap: 10px;
-      align-items: center;
-    }
-    .progress-bar {
-      width: 100%;
-      height: 20px;
-      background-color: #e9ecef;
-      border-radius: 3px;
-      overflow: hidden;
-      display: none;
-      margin: 10px 0;
-    }
-    .progress {
-      height: 100%;
-      background-color: #007bff;
-      text-align: center;
-      color: #fff;
-      line-height: 20px;
-    }
-    .btn {
-      padding: 5px 10px;
-      border: none;
-      border-radius: 3px;
-      text-decoration: none;
-      color: #fff;
-      cursor: pointer;
-      margin-top: 5px;
-      white-space: nowrap;
-    }
-    .btn-upload {
-      background-color: #007bff;
-    }
-    .btn-create-file {
-      background-color: #28a745;
-    }
-    .btn-create-folder {
-      background-color: #fd7e14;
-    }
-    .btn-refresh {
-      background-color: #28a745;
-    }
-    .btn-enter {
-      background-color: #2196F3;
-    }
-    .btn-download {
-      background-color: #2196F3;
-    }
-    .btn-delete {
-      background-color: #E53935;
-    }
-    .btn-rename {
-      background-color: #FF9800;
-    }
-    .btn-cancel {
-      background-color: #28a745;
-    }
-    .file-name {
-      max-width: 200px;
-      white-space: normal;
-      word-break: break-all;
-      cursor: pointer;
-    }
-    .file-name.directory {
-      color: #007bff;
-      font-weight: bold;
-    }
-    table {
-      width: 100%;
-      border-collapse: collapse;
-      margin-top: 10px;
-      table-layout: auto;
-    }
-    th, td {
-      padding: 8px;
-      border: 1px solid #ddd;
-      text-align: left;
-    }
-    th {
-      background-color: #f2f2f2;
-      position: relative;
-    }
-    th a {
-      text-decoration: none;
-      color: inherit;
-      display: block;
-    }
-    table th:nth-child(2), table td:nth-child(2) {
-      width: 100px;
-      word-break: break-word;
-    }
-    table th:nth-child(3), table td:nth-child(3) {
-      width: 80px;
-      white-space: nowrap;
-    }
-    #searchInput {
-      width: 100%;
-      padding: 5px;
-      margin-bottom: 10px;
-      box-sizing: border-box;
-    }
-    @media only screen and (max-width: 600px) {
-      .container {
-        width: 95%;
-      }
-      table, th, td {
-        font-size: 14px;
-      }
-      .nav-actions {
-        flex-direction: column;
-      }
-      .nav-actions .action-group {
-        width: 100%;
-        flex-direction: row;
-        justify-content: space-between;
-      }
-      .file-name {
-        max-width: 100%;
-      }
-    }
-    .modal {
-      display: none; 
-      position: fixed; 
-      z-index: 999;
-      padding-top: 100px; 
-      left: 0;
-      top: 0;
-      width: 100%; 
-      height: 100%; 
-      overflow: auto; 
-      background-color: rgba(0,0,0,0.4);
-    }
-    .modal-content {
-      background-color: #fff;
-      margin: auto;
-      padding: 20px;
-      border-radius: 5px;
-      max-width: 90%;
-      width: 400px;
-      position: relative;
-    }
-    .close {
-      color: #aaa;
-      position: absolute;
-      top: 10px;
-      right: 20px;
-      font-size: 28px;
-      font-weight: bold;
-      cursor: pointer;
-    }
-    .close:hover,
-    .close:focus {
-      color: #000;
-    }
-    .modal-content input[type="text"] {
-      width: 100%;
-      padding: 5px;
-      margin: 10px 0;
-      border: 1px solid #ccc;
-      border-radius: 3px;
-    }
-    .modal-buttons {
-      display: flex;
-      flex-wrap: wrap;
-      gap: 10px;
-      justify-content: center;
-      margin-top: 15px;
-    }
-    .modal-buttons button {
-      flex: 1 1 30%;
-      padding: 10px;
-      border: none;
-      border-radius: 3px;
-      cursor: pointer;
-      min-width: 80px;
-      color: #fff;
-    }
-    .modal-actions {
-      text-align: center;
-      margin-top: 10px;
-    }
-    .modal-actions .btn {
-      padding: 10px 20px;
-    }
-  </style>
-  <script>
-    if (/Mobi|Android|iPhone|iPad|iPod/i.test(navigator.userAgent)) {
-      document.documentElement.classList.add('mobile');
-    }
-  </script>
-</head>
-<body>
-<div class="container">
-  <div style="display: flex; justify-content: space-between; align-items: center; margin-bottom: 10px;">
-    <h1 style="margin: 0;">简易网页文件管理器</h1>
-    {{if ne .Username ""}}
-    <button onclick="logout()" style="padding: 8px 16px; background: #dc3545; color: white; border: none; border-radius: 4px; cursor: pointer; font-size: 14px;">退出登录</button>
-    {{end}}
-  </div>
-  <div class="breadcrumbs">
-    {{range $index, $crumb := .Breadcrumbs}}
-      {{if eq $index 0}}
-        <a href="/?path={{$crumb.Path}}{{if $.Sort}}&sort={{$.Sort}}{{end}}{{if $.Order}}&order={{$.Order}}{{end}}">{{$crumb.Name}}</a>
-      {{else}}
-        <span>&gt;</span>
-        {{if eq $index (sub (len $.Breadcrumbs) 1)}}
-          <span>{{$crumb.Name}}</span>
-        {{else}}
-          <a href="/?path={{$crumb.Path}}{{if $.Sort}}&sort={{$.Sort}}{{end}}{{if $.Order}}&order={{$.Order}}{{end}}">{{$crumb.Name}}</a>
-        {{end}}
-      {{end}}
-    {{end}}
-  </div>
-
-  <div>
-    <input type="text" id="searchInput" placeholder="查找文件（输入名称筛选）" onkeyup="filterFiles()">
-  </div>
-
-  <div class="nav-actions">
-    <div class="action-group">
-      <input type="file" id="fileInput" multiple>
-      <button class="btn btn-upload" onclick="uploadFile()">上传文件</button>
-    </div>
-    <div class="action-group">
-      <button class="btn btn-create-file" onclick="showModal('modalCreateFile')">创建文件</button>
-      <button class="btn btn-create-folder" onclick="showModal('modalCreateFolder')">创建文件夹</button>
-      <button class="btn btn-refresh" onclick="refreshFileList()">刷新</button>
-    </div>
-  </div>
-  
-  <div class="progress-bar" id="progressContainer">
-    <div class="progress" id="progressBar" style="width: 0;">0%</div>
-  </div>
-  
-  <div id="fileListContainer">
-    {{template "fileList" .}}
-  </div>
-</div>
-
-<div id="modalCreateFile" class="modal">
-  <div class="modal-content">
-    <span class="close" onclick="closeModal('modalCreateFile')">&times;</span>
-    <h2>创建文件</h2>
-    <input type="text" id="modalFileName" placeholder="请输入文件名">
-    <button class="btn btn-create-file" onclick="submitCreateFile()">确定</button>
-    <button class="btn btn-cancel" onclick="closeModal('modalCreateFile')">取消</button>
-  </div>
-</div>
-
-<div id="modalCreateFolder" class="modal">
-  <div class="modal-content">
-    <span class="close" onclick="closeModal('modalCreateFolder')">&times;</span>
-    <h2>创建文件夹</h2>
-    <input type="text" id="modalFolderName" placeholder="请输入文件夹名">
-    <button class="btn btn-create-folder" onclick="submitCreateFolder()">确定</button>
-    <button class="btn btn-cancel" onclick="closeModal('modalCreateFolder')">取消</button>
-  </div>
-</div>
-
-<div id="modalFileOptions" class="modal">
-  <div class="modal-content">
-    <span class="close" onclick="closeModal('modalFileOptions')">&times;</span>
-    <h2 id="modalTitle"></h2>
-    <div id="modalButtons" class="modal-buttons"></div>
-    <div class="modal-actions">
-      <button class="btn btn-cancel" onclick="closeModal('modalFileOptions')">取消</button>
-    </div>
-  </div>
-</div>
-
-<script>
-  function sub(a, b) { return a - b; }
-
-  var currentPath = "{{.CurrentPath}}";
-  var urlParams = new URLSearchParams(window.location.search);
-  var currentSort = urlParams.get("sort") || "name";
-  var currentOrder = urlParams.get("order") || (currentSort == "time" ? "desc" : "asc");
-
-  function uploadFile() {
-    var fileInput = document.getElementById('fileInput');
-    var files = fileInput.files;
-    if (files.length === 0) {
-      alert('请选择至少一个文件');
-      return;
-    }
-    var formData = new FormData();
-    for (var i = 0; i < files.length; i++) {
-      formData.append('files[]', files[i]);
-    }
-    var xhr = new XMLHttpRequest();
-    xhr.open('POST', '/upload?path=' + encodeURIComponent(currentPath), true);
-    var progressBar = document.getElementById('progressBar');
-    var progressContainer = document.getElementById('progressContainer');
-    progressBar.style.width = '0';
-    progressBar.innerText = '0%';
-    progressContainer.style.display = 'block';
-    xhr.upload.onprogress = function (event) {
-      if (event.lengthComputable) {
-        var percentComplete = Math.round((event.loaded / event.total) * 100);
-        progressBar.style.width = percentComplete + '%';
-        progressBar.innerText = percentComplete + '%';
-      }
-    };
-    xhr.onload = function () {
-      progressContainer.style.display = 'none';
-      if (xhr.status === 200) {
-        alert('文件上传成功');
-        refreshFileList();
-      } else {
-        alert('文件上传失败');
-      }
-    };
-    xhr.send(formData);
-  }
-
-  function refreshFileList() {
-    var yOffset = window.pageYOffset;
-    var xhr = new XMLHttpRequest();
-    xhr.open('GET', '/list?path=' + encodeURIComponent(currentPath) + '&sort=' + encodeURIComponent(currentSort) + '&order=' + encodeURIComponent(currentOrder), true);
-    xhr.onload = function () {
-      if (xhr.status === 200) {
-        document.getElementById("fileListContainer").innerHTML = xhr.responseText;
-        window.scrollTo(0, yOffset);
-      } else {
-        alert('刷新文件列表失败');
-      }
-    };
-    xhr.send();
-  }
-
-  function showModal(modalId) {
-    document.getElementById(modalId).style.display = "block";
-  }
-  
-  function closeModal(modalId) {
-    document.getElementById(modalId).style.display = "none";
-  }
-
-  function submitCreateFile() {
-    var fileName = document.getElementById('modalFileName').value.trim();
-    if (!fileName) {
-      alert('请输入文件名');
-      return;
-    }
-    var xhr = new XMLHttpRequest();
-    xhr.open('POST', '/create', true);
-    xhr.setRequestHeader('Content-Type', 'application/x-www-form-urlencoded');
-    xhr.onload = function () {
-      if (xhr.status === 200) {
-        alert('文件创建成功');
-        closeModal('modalCreateFile');
-        refreshFileList();
-      } else {
-        alert('文件创建失败: ' + xhr.responseText);
-      }
-    };
-    xhr.send('type=file&name=' + encodeURIComponent(fileName) + '&path=' + encodeURIComponent(currentPath));
-  }
-
-  function submitCreateFolder() {
-    var folderName = document.getElementById('modalFolderName').value.trim();
-    if (!folderName) {
-      alert('请输入文件夹名');
-      return;
-    }
-    var xhr = new XMLHttpRequest();
-    xhr.open('POST', '/create', true);
-    xhr.setRequestHeader('Content-Type', 'application/x-www-form-urlencoded');
-    xhr.onload = function () {
-      if (xhr.status === 200) {
-        alert('文件夹创建成功');
-        closeModal('modalCreateFolder');
-        refreshFileList();
-      } else {
-        alert('文件夹创建失败: ' + xhr.responseText);
-      }
-    };
-    xhr.send('type=folder&name=' + encodeURIComponent(folderName) + '&path=' + encodeURIComponent(currentPath));
-  }
-
-  function renameFile(oldName) {
-    var newName = prompt("请输入新的名称", oldName);
-    if (!newName || newName === oldName) return;
-    closeModal('modalFileOptions');
-    var xhr = new XMLHttpRequest();
-    xhr.open('POST', '/rename', true);
-    xhr.setRequestHeader('Content-Type', 'application/x-www-form-urlencoded');
-    xhr.onload = function () {
-      if (xhr.status === 200) {
-        alert('重命名成功');
-        refreshFileList();
-      } else {
-        alert('重命名失败: ' + xhr.responseText);
-      }
-    };
-    xhr.send('old=' + encodeURIComponent(oldName) + '&new=' + encodeURIComponent(newName) + '&path=' + encodeURIComponent(currentPath));
-  }
-
-  function downloadFile(fileName, path, element) {
-    closeModal('modalFileOptions');
-    var url = '/download?file=' + encodeURIComponent(fileName) + '&path=' + encodeURIComponent(path);
-    var link = document.createElement('a');
-    link.href = url;
-    link.download = fileName;
-    document.body.appendChild(link);
-    link.click();
-    document.body.removeChild(link);
-  }
-
-  function deleteFile(fileName, path, element) {
-    if (!confirm("确定要删除 " + fileName + " 吗？")) return;
-    closeModal('modalFileOptions');
-    var xhr = new XMLHttpRequest();
-    xhr.open('GET', '/delete?file=' + encodeURIComponent(fileName) + '&path=' + encodeURIComponent(path), true);
-    xhr.setRequestHeader('X-Requested-With', 'XMLHttpRequest');
-    xhr.onload = function () {
-      if (xhr.status === 200) {
-        alert('删除成功');
-        refreshFileList();
-      } else {
-        alert('删除失败: ' + xhr.responseText);
-      }
-    };
-    xhr.send();
-  }
-
-  // 保留showFileOptions函数以防某些地方还在使用，但现在主要使用双击和右键菜单
-  function showFileOptions(fileName, isDir) {
-    // 直接执行默认操作：目录进入，文件下载
-    if (isDir) {
-      enterDirectory(fileName);
-    } else {
-      downloadFile(fileName, currentPath, null);
-    }
-  }
-
-  function enterDirectory(fileName) {
-    closeModal('modalFileOptions');
-    var newPath = currentPath ? currentPath + '/' + fileName : fileName;
-    window.location.href = '/?path=' + encodeURIComponent(newPath) + '&sort=' + encodeURIComponent(currentSort) + '&order=' + encodeURIComponent(currentOrder);
-  }
-
-  var contextFileName = "";
-  var contextIsDir = false;
-  var touchTimer = null;
-  var touchStartTime = 0;
-
-  // 点击其他地方隐藏右键菜单
-  document.addEventListener('click', function() {
-    var contextMenu = document.getElementById('contextMenu');
-    if (contextMenu) {
-      contextMenu.style.display = 'none';
-    }
-  });
-
-  // 阻止默认右键菜单，允许自定义右键菜单
-  document.addEventListener('contextmenu', function(e) {
-    // 如果是在文件名上右键，阻止默认菜单以显示自定义菜单
-    if (e.target.closest('.file-name')) {
-      e.preventDefault();
-    }
-    // 其他地方也阻止默认右键菜单，保持界面一致性
-    else {
-      e.preventDefault();
-    }
-  });
-
-  // 移动端长按支持
-  function handleTouchStart(event, fileName, isDir) {
-    touchStartTime = Date.now();
-    touchTimer = setTimeout(function() {
-      // 长按500ms后显示菜单
-      event.preventDefault();
-      showContextMenu(event, fileName, isDir);
-    }, 500);
-  }
-
-  function handleTouchEnd(event) {
-    if (touchTimer) {
-      clearTimeout(touchTimer);
-      touchTimer = null;
-    }
-    // 如果是短按（小于500ms），不阻止默认的click事件
-    if (Date.now() - touchStartTime < 500) {
-      // 短按，让click事件正常执行
-      return;
-    } else {
-      // 长按，阻止click事件
-      event.preventDefault();
-    }
-  }
-
-  function showContextMenu(event, fileName, isDir) {
-    event.preventDefault();
-    contextFileName = fileName;
-    contextIsDir = isDir;
-    
-    // 创建右键菜单（如果不存在）
-    var contextMenu = document.getElementById('contextMenu');
-    if (!contextMenu) {
-      contextMenu = document.createElement('div');
-      contextMenu.id = 'contextMenu';
-      contextMenu.style.cssText = 'position: fixed; background: white; border: 1px solid #ccc; border-radius: 4px; padding: 5px 0; box-shadow: 2px 2px 10px rgba(0,0,0,0.3); z-index: 9999; display: none; min-width: 120px;';
-      document.body.appendChild(contextMenu);
-    }
-    
-    // 清空菜单内容
-    contextMenu.innerHTML = '';
-    
-    // 添加菜单项（移除进入和下载选项）
-    addMenuItem(contextMenu, '重命名', function() {
-      renameFile(fileName);
-      contextMenu.style.display = 'none';
-    }, '#2196F3'); // 蓝色
-    
-    addMenuItem(contextMenu, '删除', function() {
-      deleteFile(fileName, currentPath, null);
-      contextMenu.style.display = 'none';
-    }, '#e74c3c'); // 红色
-    
-    // 显示菜单
-    contextMenu.style.display = 'block';
-    
-    // 获取菜单尺寸
-    var rect = contextMenu.getBoundingClientRect();
-    var menuWidth = rect.width;
-    var menuHeight = rect.height;
-    
-    // 计算最佳位置
-    var x = event.clientX;
-    var y = event.clientY;
-    
-    // 确保菜单不超出屏幕右边
-    if (x + menuWidth > window.innerWidth) {
-      x = window.innerWidth - menuWidth - 10;
-    }
-    
-    // 确保菜单不超出屏幕底部
-    if (y + menuHeight > window.innerHeight) {
-      y = window.innerHeight - menuHeight - 10;
-    }
-    
-    // 确保菜单不超出屏幕左边和顶部
-    if (x < 10) x = 10;
-    if (y < 10) y = 10;
-    
-    contextMenu.style.left = x + 'px';
-    contextMenu.style.top = y + 'px';
-  }
-  
-  function addMenuItem(menu, text, onclick, color) {
-    var item = document.createElement('div');
-    item.textContent = text;
-    item.style.cssText = 'padding: 8px 15px; cursor: pointer; border-bottom: 1px solid #eee; color: ' + (color || '#333') + ';';
-    item.onmouseover = function() {
-      if (color === '#e74c3c') {
-        this.style.backgroundColor = '#ffebee';
-      } else if (color === '#2196F3') {
-        this.style.backgroundColor = '#e3f2fd';
-      } else {
-        this.style.backgroundColor = '#f0f0f0';
-      }
-    };
-    item.onmouseout = function() {
-      this.style.backgroundColor = 'white';
-    };
-    item.onclick = onclick;
-    menu.appendChild(item);
-  }
-
-  function filterFiles() {
-    var input = document.getElementById("searchInput");
-    var filter = input.value.toLowerCase();
-    var rows = document.querySelectorAll("#fileListContainer tbody tr");
-    rows.forEach(function (row) {
-      var cellText = row.cells[0].innerText.toLowerCase();
-      row.style.display = cellText.indexOf(filter) > -1 ? "" : "none";
-    });
-  }
-
-  function logout() {
-    // 清除cookie
-    document.cookie = 'auth_token=; expires=Thu, 01 Jan 1970 00:00:00 UTC; path=/';
-    // 跳转到登出页面
-    window.location.href = '/logout';
-  }
-</script>
-</body>
-</html>
-{{end}}
-
-{{define "fileList"}}
-<table>
-  <thead>
-    <tr>
-      <th>
-        <a href="/?path={{.CurrentPath}}&sort=name&order={{toggle .Sort .Order "name"}}">
-          名称
-        </a>
-      </th>
-      <th>
-        <a href="/?path={{.CurrentPath}}&sort=time&order={{toggle .Sort .Order "time"}}">
-          最后修改
-        </a>
-      </th>
-      <th>
-        <a href="/?path={{.CurrentPath}}&sort=size&order={{toggle .Sort .Order "size"}}">
-          大小
-        </a>
-      </th>
-    </tr>
-  </thead>
-  <tbody>
-  {{range .Files}}
-    <tr>
-      <td class="file-name {{if .IsDir}}directory{{end}}" 
-          onclick="{{if .IsDir}}enterDirectory('{{.Name}}'){{else}}downloadFile('{{.Name}}', currentPath, null){{end}}" 
-          oncontextmenu="showContextMenu(event, '{{.Name}}', {{.IsDir}})" 
-          ontouchstart="handleTouchStart(event, '{{.Name}}', {{.IsDir}})" 
-          ontouchend="handleTouchEnd(event)" 
-          title="{{.Name}}">
-        {{.Name}}
-      </td>
-      <td>
-        {{with $parts := split .UploadDate " "}}
-          {{index $parts 0}}<br>{{index $parts 1}}
-        {{end}}
-      </td>
-      <td>{{.Size}}</td>
-    </tr>
-  {{end}}
-  </tbody>
-</table>
-{{end}}
-`
-
-// secureJoin 将 base 与传入的相对路径组合，确保最终路径在 base 内
+	Files        []FileInfo
+	Breadcrumbs  []Breadcrumb      // 面包屑导航数据
+	CurrentPath  string            // 当前目录（相对于 baseDir）
+	Sort         string            // 当前排序字段："name"、"time"、"size"、"type"
+	Order        string            // 排序顺序："asc" 或 "desc"
+	Username     string            // 当前登录用户名（未启用认证时为空）
+	Role         string            // 当前角色
+	CanLogin     bool              // 匿名访问且配置了账户时显示登录入口
+	CanEdit      bool              // 是否允许上传、创建、重命名、移动、复制
+	CanDelete    bool              // 是否允许删除
+	CanChmod     bool              // 是否允许修改权限（admin 且非 Windows）
+	HasParent    bool              // 是否显示返回上级目录的 ".." 行（根目录不显示）
+	ParentPath   string            // 上级目录（相对于 baseDir）
+	FileCount    int               // 当前目录直接包含的文件数
+	DirCount     int               // 当前目录直接包含的文件夹数
+	TotalSize    string            // 当前目录直接包含的文件总大小
+	AbsPath      string            // 当前目录在服务器上的绝对路径（仅 -show-abs-path 时提供）
+	ServeIndex   bool              // 启用 -serve-index 时，站内导航链接带上 browse=1 以保持显示管理界面
+	Category     string            // 当前分类筛选，为空表示不筛选
+	Title        string            // 页面标题（-title）
+	LogoURL      string            // 标题旁的图标地址（-logo-url），为空不显示
+	Lang         string            // 界面语言
+	Messages     map[string]string // 当前语言的译文表，供页面脚本使用
+	ChecksumAlgo string            // 启用 -checksum 时的算法，列表中多一列校验和
+}
+
+// LoginPageData 用于传递给登录页模板的数据
+type LoginPageData struct {
+	Title    string
+	LogoURL  string
+	Lang     string
+	Messages map[string]string
+}
+
+// NotFoundPageData 用于传递给404页面模板的数据
+type NotFoundPageData struct {
+	Title   string
+	LogoURL string
+	Lang    string
+	Path    string
+}
+
+// staticAsset 内嵌的静态资源，etag 取内容哈希，内容变化后地址随之变化
+type staticAsset struct {
+	contentType string
+	body        string
+	etag        string
+}
+
+// newStaticAsset 计算内容哈希并构造静态资源
+func newStaticAsset(contentType, body string) *staticAsset {
+	sum := sha256.Sum256([]byte(body))
+	return &staticAsset{contentType: contentType, body: body, etag: hex.EncodeToString(sum[:8])}
+}
+
+// embeddedTemplates 内嵌的默认页面模板与静态资源，-templates-dir 中的同名文件优先
+//
+//go:embed templates
+var embeddedTemplates embed.FS
+
+var (
+	staticAssets  map[string]*staticAsset // /static/ 下提供的页面样式与脚本
+	mainTemplate  *template.Template      // 主页面（"main"）与文件列表（"fileList"）模板
+	loginTemplate *template.Template
+	notFoundTmpl  *template.Template
+)
+
+// readTemplateFile 读取模板文件：dir 中存在同名文件时使用它，否则使用内嵌的默认文件
+func readTemplateFile(dir, name string) (string, error) {
+	if dir != "" {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			return string(data), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	data, err := embeddedTemplates.ReadFile("templates/" + name)
+	return string(data), err
+}
+
+// loadTemplates 启动时读取并解析全部模板与静态资源，dir 为空时只使用内嵌的默认文件
+func loadTemplates(dir string) error {
+	files := make(map[string]string)
+	for _, name := range []string{"main.html", "login.html", "app.css", "app.js", "login.css", "login.js", "notfound.html", "favicon.svg"} {
+		content, err := readTemplateFile(dir, name)
+		if err != nil {
+			return fmt.Errorf("读取模板 %s 失败: %v", name, err)
+		}
+		files[name] = content
+	}
+	staticAssets = map[string]*staticAsset{
+		"login.css":   newStaticAsset("text/css; charset=utf-8", files["login.css"]),
+		"login.js":    newStaticAsset("text/javascript; charset=utf-8", files["login.js"]),
+		"app.css":     newStaticAsset("text/css; charset=utf-8", files["app.css"]),
+		"app.js":      newStaticAsset("text/javascript; charset=utf-8", files["app.js"]),
+		"favicon.svg": newStaticAsset("image/svg+xml", files["favicon.svg"]),
+	}
+	// 语言相关的函数在渲染时按请求替换，这里先以默认语言注册以便解析
+	var err error
+	mainTemplate, err = template.New("main").Funcs(templateFuncs).Funcs(langFuncs(defaultLang)).Parse(files["main.html"])
+	if err != nil {
+		return fmt.Errorf("解析模板 main.html 失败: %v", err)
+	}
+	loginTemplate, err = template.New("login").Funcs(templateFuncs).Funcs(langFuncs(defaultLang)).Parse(files["login.html"])
+	if err != nil {
+		return fmt.Errorf("解析模板 login.html 失败: %v", err)
+	}
+	notFoundTmpl, err = template.New("notfound").Funcs(templateFuncs).Funcs(langFuncs(defaultLang)).Parse(files["notfound.html"])
+	if err != nil {
+		return fmt.Errorf("解析模板 notfound.html 失败: %v", err)
+	}
+	return nil
+}
+
+// langTemplate 复制已解析的模板并换成 lang 对应的翻译函数
+func langTemplate(t *template.Template, lang string) *template.Template {
+	return template.Must(t.Clone()).Funcs(langFuncs(lang))
+}
+
+// assetURL 返回静态资源地址，附带内容哈希作为版本号，供模板引用
+func assetURL(name string) string {
+	if a, ok := staticAssets[name]; ok {
+		return pathPrefix + "/static/" + name + "?v=" + a.etag
+	}
+	return pathPrefix + "/static/" + name
+}
+
+// staticHandler 提供内嵌的样式与脚本（无需认证，登录页同样使用）。
+// 带有正确版本号的请求可长期缓存，其余请求每次通过 ETag/Last-Modified 协商，未变化时返回304
+func staticHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/static/")
+	a, ok := staticAssets[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", a.contentType)
+	w.Header().Set("ETag", `"`+a.etag+`"`)
+	if r.URL.Query().Get("v") == a.etag {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	http.ServeContent(w, r, name, startTime, strings.NewReader(a.body))
+}
+
+// secureJoin 将 base 与传入的相对路径组合，确保最终路径在 base 内。
+// 未启用 -follow-symlinks 时还会解析符号链接，拒绝指向 base 之外的链接
 func secureJoin(base, rel string) (string, error) {
 	cleanRel := filepath.Clean(rel)
 	full := filepath.Join(base, cleanRel)
-	relPath, err := filepath.Rel(base, full)
+	if !isWithin(base, full) {
+		return "", fmt.Errorf("非法路径")
+	}
+	if followSymlinks {
+		return full, nil
+	}
+	resolvedBase, err := filepath.EvalSymlinks(base)
 	if err != nil {
 		return "", err
 	}
-	if strings.HasPrefix(relPath, "..") {
+	resolved, err := resolvePath(full)
+	if err != nil {
+		return "", fmt.Errorf("无法解析路径: %v", err)
+	}
+	if !isWithin(resolvedBase, resolved) {
 		return "", fmt.Errorf("非法路径")
 	}
 	return full, nil
 }
 
-// generateSelfSignedCert 生成自签名证书
-func generateSelfSignedCert() (certPEM, keyPEM []byte, err error) {
+// normalizeRelPath 规范化客户端传入的目录参数：反斜杠视为分隔符，去掉首尾与重复的分隔符及 . 段，
+// 拒绝空字节；.. 段保留，由 secureJoin 判断是否越界
+func normalizeRelPath(rel string) (string, error) {
+	if strings.ContainsRune(rel, 0) {
+		return "", fmt.Errorf("路径不能包含空字节")
+	}
+	var parts []string
+	for _, part := range strings.Split(strings.ReplaceAll(rel, "\\", "/"), "/") {
+		if part != "" && part != "." {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// joinRequestPath 规范化请求中的目录参数后与用户根目录组合，见 normalizeRelPath 与 secureJoin
+func joinRequestPath(r *http.Request, rel string) (string, error) {
+	rel, err := normalizeRelPath(rel)
+	if err != nil {
+		return "", err
+	}
+	return secureJoin(requestBase(r), rel)
+}
+
+// isWithin 按路径段判断 target 是否位于 base 内（允许 ..foo 这类合法名称）
+func isWithin(base, target string) bool {
+	relPath, err := filepath.Rel(base, target)
+	if err != nil {
+		return false
+	}
+	return relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator)) && !filepath.IsAbs(relPath)
+}
+
+// resolvePath 解析路径中的符号链接，末尾尚不存在的部分按字面拼接；
+// 断开的符号链接按其目标继续解析，防止借助它在 base 之外创建文件
+func resolvePath(p string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(p)
+	if err == nil {
+		return resolved, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	parent := filepath.Dir(p)
+	if parent == p {
+		return p, nil
+	}
+	resolvedParent, err := resolvePath(parent)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Base(p)
+	if target, err := os.Readlink(filepath.Join(resolvedParent, name)); err == nil {
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(resolvedParent, target)
+		}
+		return resolvePath(target)
+	}
+	return filepath.Join(resolvedParent, name), nil
+}
+
+// localIPs 返回本机所有非回环网卡地址，用于自签名证书的 SAN
+func localIPs() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, ipNet.IP)
+	}
+	return ips
+}
+
+// windowsReservedNames Windows 保留的设备名，带任意扩展名同样无效
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// checkPathLength 检查写入目标的完整路径及其每一级名称是否超过长度限制，
+// 在实际操作文件系统前调用，避免得到系统返回的难以理解的错误
+func checkPathLength(p string) error {
+	if len(p) > maxPathLength {
+		return fmt.Errorf("路径过长（%d字节，最多%d字节）", len(p), maxPathLength)
+	}
+	for _, part := range strings.Split(filepath.ToSlash(p), "/") {
+		if len(part) > maxNameLength {
+			return fmt.Errorf("路径中的名称过长（最多%d字节）: %s", maxNameLength, part)
+		}
+	}
+	return nil
+}
+
+// checkTreeLength 检查把 src（文件或目录）放到 dest 后，其中每个条目的路径是否都在长度限制内
+func checkTreeLength(src, dest string) error {
+	if err := checkPathLength(dest); err != nil {
+		return err
+	}
+	info, err := os.Lstat(src)
+	if err != nil || !info.IsDir() {
+		return nil
+	}
+	return walkTree(src, func(path string, info os.FileInfo) error {
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		return checkPathLength(filepath.Join(dest, rel))
+	})
+}
+
+// validateName 校验单个文件或目录名，拒绝跨平台不安全的名称
+func validateName(name string) error {
+	if name == "" || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("名称不能为空")
+	}
+	if name == "." || name == ".." {
+		return fmt.Errorf("名称不能为 . 或 ..")
+	}
+	if len(name) > maxNameLength {
+		return fmt.Errorf("名称过长（最多%d字节）", maxNameLength)
+	}
+	for _, c := range name {
+		if c == '/' || c == '\\' {
+			return fmt.Errorf("名称不能包含路径分隔符")
+		}
+		if c < 0x20 || c == 0x7f {
+			return fmt.Errorf("名称不能包含控制字符")
+		}
+	}
+	if strings.ContainsAny(name, `<>:"|?*`) {
+		return fmt.Errorf(`名称不能包含 < > : " | ? * 字符`)
+	}
+	if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return fmt.Errorf("名称不能以点或空格结尾")
+	}
+	base := strings.ToUpper(name)
+	if i := strings.Index(base, "."); i >= 0 {
+		base = base[:i]
+	}
+	if windowsReservedNames[strings.TrimSpace(base)] {
+		return fmt.Errorf("%s 是系统保留名称", name)
+	}
+	return nil
+}
+
+// generateSelfSignedCert 生成自签名证书，hosts 中的IP或域名会额外加入 SAN
+func generateSelfSignedCert(hosts []string) (certPEM, keyPEM []byte, err error) {
 	// 生成私钥
 	priv, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	// 随机序列号，避免浏览器因序列号重复拒绝新证书
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// 创建证书模板
 	template := x509.Certificate{
-		SerialNumber: big.NewInt(1),
+		SerialNumber: serial,
 		Subject: pkix.Name{
 			Organization:  []string{"File Manager"},
 			Country:       []string{"CN"},
@@ -945,9 +524,19 @@ func generateSelfSignedCert() (certPEM, keyPEM []byte, err error) {
 		NotAfter:    time.Now().Add(365 * 24 * time.Hour), // 1年有效期
 		KeyUsage:    x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
 		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		IPAddresses: append([]net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}, localIPs()...),
 		DNSNames:    []string{"localhost"},
 	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" && hostname != "localhost" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if h != "" {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
 
 	// 生成证书
 	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
@@ -968,6 +557,131 @@ func generateSelfSignedCert() (certPEM, keyPEM []byte, err error) {
 	return certPEM, keyPEM, nil
 }
 
+// newTLSConfig 根据 -tls-min-version 构建加固后的TLS配置
+func newTLSConfig(certs ...tls.Certificate) (*tls.Config, error) {
+	var minVersion uint16
+	switch tlsMinVer {
+	case "1.2":
+		minVersion = tls.VersionTLS12
+	case "1.3":
+		minVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("不支持的TLS最低版本: %s（可选 1.2 或 1.3）", tlsMinVer)
+	}
+	return &tls.Config{
+		Certificates: certs,
+		MinVersion:   minVersion,
+		// 仅对 TLS 1.2 生效，TLS 1.3 的套件由标准库固定
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		NextProtos:       []string{"h2", "http/1.1"},
+	}, nil
+}
+
+// checkTempDir 创建临时目录（如不存在）并确认其中可以写入文件
+func checkTempDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".hfs-check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// defaultStateDir 返回保存证书缓存等运行状态的默认目录：Windows 为 %LOCALAPPDATA%\hfs，
+// 其他系统优先使用 $XDG_STATE_HOME/hfs，均未设置时为 ~/.hfs
+func defaultStateDir() string {
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("LOCALAPPDATA"); dir != "" {
+			return filepath.Join(dir, "hfs")
+		}
+	} else if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "hfs")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".hfs")
+	}
+	return ".hfs"
+}
+
+// loadCachedCert 从缓存目录读取自签名证书，证书缺失、过期或未覆盖 hosts 时返回错误
+func loadCachedCert(dir string, hosts []string) (tls.Certificate, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, "selfsigned.crt"))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(dir, "selfsigned.key"))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	// 提前一天视为过期，避免刚启动就失效
+	if time.Now().Add(24 * time.Hour).After(leaf.NotAfter) {
+		return tls.Certificate{}, fmt.Errorf("缓存的证书已过期")
+	}
+	for _, h := range hosts {
+		if err := leaf.VerifyHostname(h); err != nil {
+			return tls.Certificate{}, fmt.Errorf("缓存的证书不包含 %s", h)
+		}
+	}
+	return cert, nil
+}
+
+// loadOrGenerateCert 优先复用缓存的自签名证书，必要时重新生成并写入缓存
+func loadOrGenerateCert(dir string, hosts []string, force bool) (tls.Certificate, error) {
+	if !force {
+		cert, err := loadCachedCert(dir, hosts)
+		if err == nil {
+			fmt.Printf("复用已缓存的自签名证书: %s\n", dir)
+			return cert, nil
+		}
+		if !os.IsNotExist(err) {
+			fmt.Printf("缓存证书不可用，将重新生成: %v\n", err)
+		}
+	}
+
+	fmt.Println("正在生成自签名证书...")
+	certPEM, keyPEM, err := generateSelfSignedCert(hosts)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("生成自签名证书失败: %v", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("加载证书失败: %v", err)
+	}
+
+	// 写入缓存失败不影响启动，仅下次需要重新生成
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		fmt.Printf("无法创建证书缓存目录 %s: %v\n", dir, err)
+		return cert, nil
+	}
+	if err := os.WriteFile(filepath.Join(dir, "selfsigned.key"), keyPEM, 0600); err != nil {
+		fmt.Printf("无法缓存证书私钥: %v\n", err)
+		return cert, nil
+	}
+	if err := os.WriteFile(filepath.Join(dir, "selfsigned.crt"), certPEM, 0644); err != nil {
+		fmt.Printf("无法缓存证书: %v\n", err)
+	}
+	return cert, nil
+}
+
 // generateToken 生成随机token
 func generateToken() string {
 	bytes := make([]byte, 32)
@@ -981,13 +695,13 @@ func isValidToken(token string) bool {
 	tokenMu.RLock()
 	defer tokenMu.RUnlock()
 
-	expireTime, exists := tokens[token]
+	sess, exists := tokens[token]
 	if !exists {
 		return false
 	}
 
 	// 检查是否过期
-	if time.Now().After(expireTime) {
+	if time.Now().After(sess.ExpiresAt) {
 		// 异步清理过期token
 		go func() {
 			tokenMu.Lock()
@@ -1000,730 +714,6128 @@ func isValidToken(token string) bool {
 	return true
 }
 
+// touchToken 校验token并在剩余有效期不足一半时滑动续期，
+// 续期后的过期时间不超过签发时间加 -session-max-age。
+// 返回token是否有效；仅在发生续期时 renewed 为新的过期时间，否则为零值
+func touchToken(token string) (renewed time.Time, ok bool) {
+	if !isValidToken(token) {
+		return time.Time{}, false
+	}
+
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+
+	sess, exists := tokens[token]
+	if !exists {
+		return time.Time{}, false
+	}
+	now := time.Now()
+	if sess.ExpiresAt.Sub(now) >= sess.Lifetime/2 {
+		return time.Time{}, true
+	}
+	expiresAt := now.Add(sess.Lifetime)
+	if limit := sess.IssuedAt.Add(sessMaxAge); expiresAt.After(limit) {
+		expiresAt = limit
+	}
+	if !expiresAt.After(sess.ExpiresAt) {
+		return time.Time{}, true
+	}
+	sess.ExpiresAt = expiresAt
+	return expiresAt, true
+}
+
+// lookupToken 返回有效token对应会话的副本
+func lookupToken(token string) (tokenSession, bool) {
+	if !isValidToken(token) {
+		return tokenSession{}, false
+	}
+	tokenMu.RLock()
+	defer tokenMu.RUnlock()
+	sess, exists := tokens[token]
+	if !exists {
+		return tokenSession{}, false
+	}
+	return *sess, true
+}
+
+// requestToken 从cookie或Authorization头中取出token
+func requestToken(r *http.Request) string {
+	if cookie, err := r.Cookie(cookieName); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
 // addToken 添加新token
-func addToken(token string, duration time.Duration) {
+func addToken(token, user, role string, duration time.Duration) {
 	tokenMu.Lock()
 	defer tokenMu.Unlock()
 
 	if tokens == nil {
-		tokens = make(map[string]time.Time)
+		tokens = make(map[string]*tokenSession)
 	}
 
-	tokens[token] = time.Now().Add(duration)
+	now := time.Now()
+	tokens[token] = &tokenSession{
+		Username:  user,
+		Role:      role,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(duration),
+		Lifetime:  duration,
+	}
 }
 
-// authHandler 基于token的认证中间件
-func authHandler(next http.HandlerFunc) http.HandlerFunc {
+// revokeUserTokens 删除属于 user 的所有 token，返回删除的数量
+func revokeUserTokens(user string) int {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+	n := 0
+	for token, sess := range tokens {
+		if sess.Username == user {
+			delete(tokens, token)
+			n++
+		}
+	}
+	return n
+}
+
+// securityHeaders 为所有响应添加通用安全响应头
+func securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "SAMEORIGIN")
+		h.Set("Referrer-Policy", "no-referrer")
+		if tlsEnabled && hsts {
+			h.Set("Strict-Transport-Security", "max-age=31536000")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// prefixURL 为站内的绝对路径加上 -path-prefix
+func prefixURL(p string) string {
+	return pathPrefix + p
+}
+
+// stripPathPrefix 在配置了 -path-prefix 时去掉请求路径中的前缀再交给各路由处理：
+// 访问前缀本身时重定向到带斜杠的首页，不在前缀下的请求返回 404
+func stripPathPrefix(next http.Handler) http.Handler {
+	if pathPrefix == "" {
+		return next
+	}
+	strip := http.StripPrefix(pathPrefix, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == pathPrefix:
+			target := pathPrefix + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, pathPrefix+"/"):
+			strip.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// corsMiddleware 为 /api/ 下的接口处理跨域请求：来源在 -cors-origin 中明确列出时回显该来源并允许携带凭据，
+// 配置为 "*" 时允许任意来源但不允许凭据；预检请求直接返回，无需登录。页面等其他路径不受影响
+func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// 如果没有设置用户名密码，直接通过
-		if username == "" || password == "" {
+		origin := r.Header.Get("Origin")
+		if len(corsOrigins) == 0 || !strings.HasPrefix(r.URL.Path, "/api/") {
 			next.ServeHTTP(w, r)
 			return
 		}
-
-		// 检查cookie中的token
-		cookie, err := r.Cookie("auth_token")
-		if err == nil && isValidToken(cookie.Value) {
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if origin == "" {
 			next.ServeHTTP(w, r)
 			return
 		}
-
-		// 检查Authorization header中的token
-		auth := r.Header.Get("Authorization")
-		if strings.HasPrefix(auth, "Bearer ") {
-			token := strings.TrimPrefix(auth, "Bearer ")
-			if isValidToken(token) {
-				next.ServeHTTP(w, r)
-				return
-			}
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		specific, wildcard := false, false
+		for _, o := range corsOrigins {
+			specific = specific || o == origin
+			wildcard = wildcard || o == "*"
 		}
-
-		// 未认证，重定向到登录页面
-		if r.URL.Path != "/login" && r.URL.Path != "/api/login" {
-			http.Redirect(w, r, "/login", http.StatusFound)
+		switch {
+		case specific:
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Credentials", "true")
+		case wildcard:
+			h.Set("Access-Control-Allow-Origin", "*")
+		case preflight:
+			httpError(w, r, "不允许该来源的跨域请求", http.StatusForbidden)
+			return
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", "ETag, X-Request-ID, X-Error-Code")
+		if preflight {
+			h.Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE, OPTIONS")
+			h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, X-Create-Parents, X-Request-ID, X-Requested-With")
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
-
 		next.ServeHTTP(w, r)
 	})
 }
 
-// indexHandler 根据 URL 参数 path 与 sort/order 读取当前目录内容，生成完整页面
-func indexHandler(w http.ResponseWriter, r *http.Request) {
-	relDir := r.URL.Query().Get("path")
-	sortType := r.URL.Query().Get("sort")
-	if sortType != "time" && sortType != "size" {
-		sortType = "name"
+// statusRecorder 记录响应状态码与写出字节数，同时保留 Flusher/ReaderFrom 能力
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
 	}
-	order := r.URL.Query().Get("order")
-	if order != "asc" && order != "desc" {
-		if sortType == "time" {
-			order = "desc"
-		} else {
-			order = "asc"
-		}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
 	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
 
-	currentDir, err := secureJoin(baseDir, relDir)
-	if err != nil {
-		http.Error(w, "无效的目录", http.StatusBadRequest)
-		return
+func (rec *statusRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
 	}
+	n, err := io.Copy(rec.ResponseWriter, src)
+	rec.bytes += n
+	return n, err
+}
 
-	dirMu.Lock()
-	entries, err := os.ReadDir(currentDir)
-	dirMu.Unlock()
-	if err != nil {
-		http.Error(w, "无法读取目录", http.StatusInternalServerError)
-		return
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
 	}
+}
 
-	var files []FileInfo
-	for _, entry := range entries {
-		info, err := entry.Info()
-		if err != nil {
-			continue
-		}
-		sizeStr := ""
-		rawSize := int64(0)
-		if !entry.IsDir() {
-			rawSize = info.Size()
-			sizeStr = calculateFileSize(rawSize)
-		}
-		files = append(files, FileInfo{
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// countingReader 统计请求体读取的字节数
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// durationBuckets 请求耗时直方图的上界（秒）
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// histogram 累积直方图，counts[i] 为耗时不超过 durationBuckets[i] 的请求数
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// metricsRegistry 保存 /metrics 暴露的计数器，以路由 pattern 作为 handler 标签
+var metricsRegistry = struct {
+	sync.Mutex
+	requests  map[[2]string]uint64 // {handler, code}
+	durations map[string]*histogram
+	reqBytes  map[string]int64
+	respBytes map[string]int64
+}{
+	requests:  make(map[[2]string]uint64),
+	durations: make(map[string]*histogram),
+	reqBytes:  make(map[string]int64),
+	respBytes: make(map[string]int64),
+}
+
+// observeRequest 记录一次请求的状态码、耗时与收发字节数
+func observeRequest(handler string, status int, d time.Duration, in, out int64) {
+	m := &metricsRegistry
+	m.Lock()
+	defer m.Unlock()
+	m.requests[[2]string{handler, strconv.Itoa(status)}]++
+	h := m.durations[handler]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		m.durations[handler] = h
+	}
+	sec := d.Seconds()
+	for i, b := range durationBuckets {
+		if sec <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += sec
+	h.count++
+	m.reqBytes[handler] += in
+	m.respBytes[handler] += out
+}
+
+// metricsMiddleware 统计每个路由的请求数、状态码、耗时与传输字节数
+func metricsMiddleware(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !metricsOn {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		_, pattern := mux.Handler(r)
+		if pattern == "" {
+			pattern = "unmatched"
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		start := time.Now()
+		mux.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		observeRequest(pattern, rec.status, time.Since(start), body.n, rec.bytes)
+	})
+}
+
+// rotatingWriter 按大小或时间轮转的日志文件，写入与轮转由 mu 串行化，可被并发的请求安全使用。
+// 轮转时当前文件改名为 path.1（压缩时为 path.1.gz），已有备份依次后移，超出 backups 的被删除
+type rotatingWriter struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64         // 0 表示不按大小轮转
+	interval time.Duration // 0 表示不按时间轮转
+	backups  int
+	compress bool
+	f        *os.File
+	size     int64
+	opened   time.Time
+}
+
+// newRotatingWriter 打开（追加）日志文件
+func newRotatingWriter(path string, maxSize int64, interval time.Duration, backups int, compress bool) (*rotatingWriter, error) {
+	w := &rotatingWriter{path: path, maxSize: maxSize, interval: interval, backups: backups, compress: compress}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotatingWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size, w.opened = f, info.Size(), time.Now()
+	return nil
+}
+
+// backupName 返回第 i 个备份的文件名
+func (w *rotatingWriter) backupName(i int) string {
+	name := fmt.Sprintf("%s.%d", w.path, i)
+	if w.compress {
+		name += ".gz"
+	}
+	return name
+}
+
+// rotate 关闭当前文件、移动备份并重新打开，调用方需持有 mu
+func (w *rotatingWriter) rotate() error {
+	w.f.Close()
+	os.Remove(w.backupName(w.backups))
+	for i := w.backups - 1; i >= 1; i-- {
+		os.Rename(w.backupName(i), w.backupName(i+1))
+	}
+	switch {
+	case w.backups == 0:
+		os.Remove(w.path)
+	case w.compress:
+		if err := gzipFile(w.path, w.backupName(1)); err != nil {
+			fmt.Printf("压缩日志 %s 失败: %v\n", w.path, err)
+		}
+		os.Remove(w.path)
+	default:
+		os.Rename(w.path, w.backupName(1))
+	}
+	return w.open()
+}
+
+// Write 写入一条日志，写入前按大小或时间判断是否需要轮转
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.size > 0 && (w.maxSize > 0 && w.size+int64(len(p)) > w.maxSize || w.interval > 0 && time.Since(w.opened) >= w.interval) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// gzipFile 将 src 压缩为 dst，先写临时文件再改名
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// requestIDKey 请求上下文中保存请求ID的键
+type requestIDKey struct{}
+
+// validRequestID 只接受长度不超过 128 的字母、数字与 - _ . :，其余值视为未提供
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDMiddleware 沿用反向代理传入的 X-Request-ID，没有时生成一个；
+// 请求ID写入请求上下文与响应头，并出现在访问日志、审计日志与错误响应中
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID 返回请求上下文中的请求ID
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// accessLogMiddleware 启用 -log-file 时按 Combined Log Format 记录每个请求，末尾附加耗时（秒）与请求ID
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if accessLog == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		user := "-"
+		if sess, ok := lookupToken(requestToken(r)); ok && sess.Username != "" {
+			user = sess.Username
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		fmt.Fprintf(accessLog, "%s - %s [%s] %q %d %d %q %q %.3f %s\n",
+			host, user, start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+r.RequestURI+" "+r.Proto, rec.status, rec.bytes,
+			r.Referer(), r.UserAgent(), time.Since(start).Seconds(), requestID(r))
+	})
+}
+
+// activeTokenCount 返回未过期的token数量
+func activeTokenCount() int {
+	tokenMu.RLock()
+	defer tokenMu.RUnlock()
+	now := time.Now()
+	n := 0
+	for _, sess := range tokens {
+		if now.Before(sess.ExpiresAt) {
+			n++
+		}
+	}
+	return n
+}
+
+// metricsHandler 以 Prometheus 文本格式输出监控指标
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if metricsToken != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+metricsToken)) != 1 {
+		httpError(w, r, "未授权", http.StatusUnauthorized)
+		return
+	}
+	m := &metricsRegistry
+	m.Lock()
+	defer m.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	fmt.Fprintln(w, "# HELP hfs_http_requests_total 按路由与状态码统计的请求数")
+	fmt.Fprintln(w, "# TYPE hfs_http_requests_total counter")
+	keys := make([][2]string, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "hfs_http_requests_total{handler=%q,code=%q} %d\n", k[0], k[1], m.requests[k])
+	}
+
+	handlers := make([]string, 0, len(m.durations))
+	for h := range m.durations {
+		handlers = append(handlers, h)
+	}
+	sort.Strings(handlers)
+
+	fmt.Fprintln(w, "# HELP hfs_http_request_duration_seconds 请求处理耗时")
+	fmt.Fprintln(w, "# TYPE hfs_http_request_duration_seconds histogram")
+	for _, name := range handlers {
+		h := m.durations[name]
+		for i, b := range durationBuckets {
+			fmt.Fprintf(w, "hfs_http_request_duration_seconds_bucket{handler=%q,le=%q} %d\n", name, strconv.FormatFloat(b, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(w, "hfs_http_request_duration_seconds_bucket{handler=%q,le=\"+Inf\"} %d\n", name, h.count)
+		fmt.Fprintf(w, "hfs_http_request_duration_seconds_sum{handler=%q} %g\n", name, h.sum)
+		fmt.Fprintf(w, "hfs_http_request_duration_seconds_count{handler=%q} %d\n", name, h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP hfs_http_request_bytes_total 请求体接收字节数（/upload 即上传总量）")
+	fmt.Fprintln(w, "# TYPE hfs_http_request_bytes_total counter")
+	for _, name := range handlers {
+		fmt.Fprintf(w, "hfs_http_request_bytes_total{handler=%q} %d\n", name, m.reqBytes[name])
+	}
+	fmt.Fprintln(w, "# HELP hfs_http_response_bytes_total 响应体发送字节数（/download 即下载总量）")
+	fmt.Fprintln(w, "# TYPE hfs_http_response_bytes_total counter")
+	for _, name := range handlers {
+		fmt.Fprintf(w, "hfs_http_response_bytes_total{handler=%q} %d\n", name, m.respBytes[name])
+	}
+
+	fmt.Fprintln(w, "# HELP hfs_active_tokens 当前有效的登录token数")
+	fmt.Fprintln(w, "# TYPE hfs_active_tokens gauge")
+	fmt.Fprintf(w, "hfs_active_tokens %d\n", activeTokenCount())
+
+	fmt.Fprintln(w, "# HELP hfs_uptime_seconds 服务运行时长")
+	fmt.Fprintln(w, "# TYPE hfs_uptime_seconds gauge")
+	fmt.Fprintf(w, "hfs_uptime_seconds %d\n", int64(time.Since(startTime).Seconds()))
+}
+
+// 用户角色：viewer 只能浏览与下载，editor 还可上传/创建/重命名/移动/复制，admin 还可删除
+const (
+	roleViewer = "viewer"
+	roleEditor = "editor"
+	roleAdmin  = "admin"
+)
+
+var roleLevel = map[string]int{roleViewer: 1, roleEditor: 2, roleAdmin: 3}
+
+// userAccount 用户文件中的一个账户
+type userAccount struct {
+	Password string
+	Role     string
+	Home     string // 用户根目录（绝对路径），为空时使用 baseDir
+}
+
+// loadUsers 读取用户文件，每行格式为 username:password:role[:home]，# 开头为注释
+// home 为相对于 baseDir 的目录，用户只能访问该目录及其子目录
+func loadUsers(path string) (map[string]userAccount, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	accounts := make(map[string]userAccount)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 2 || fields[0] == "" || fields[1] == "" {
+			return nil, fmt.Errorf("第 %d 行格式错误，应为 username:password:role[:home]", i+1)
+		}
+		role := roleViewer
+		if len(fields) > 2 && fields[2] != "" {
+			role = fields[2]
+		}
+		if roleLevel[role] == 0 {
+			return nil, fmt.Errorf("第 %d 行角色无效: %s（可选 viewer、editor、admin）", i+1, role)
+		}
+		home := ""
+		if len(fields) > 3 && strings.Trim(fields[3], "/") != "" {
+			home, err = secureJoin(baseDir, fields[3])
+			if err != nil {
+				return nil, fmt.Errorf("第 %d 行用户目录无效: %s", i+1, fields[3])
+			}
+			if err := os.MkdirAll(home, 0755); err != nil {
+				return nil, fmt.Errorf("第 %d 行无法创建用户目录 %s: %v", i+1, home, err)
+			}
+		}
+		accounts[fields[0]] = userAccount{Password: fields[1], Role: role, Home: home}
+	}
+	return accounts, nil
+}
+
+// authEnabled 是否启用了登录认证（命令行用户或用户文件）
+func authEnabled() bool {
+	return (username != "" && password != "") || len(users) > 0
+}
+
+// unknownUserPassword 用户不存在时参与比较的占位密码
+const unknownUserPassword = "\x00hfs-unknown-user"
+
+// checkCredentials 校验用户名密码，返回用户角色。比较的是两边密码的 SHA-256 摘要，
+// 耗时与密码长度无关；用户不存在时与占位密码比较，执行同样的计算，无法通过耗时判断用户名是否存在
+func checkCredentials(user, pass string) (string, bool) {
+	account, ok := users[user]
+	stored := account.Password
+	if !ok {
+		stored = unknownUserPassword
+	}
+	got := sha256.Sum256([]byte(pass))
+	want := sha256.Sum256([]byte(stored))
+	if subtle.ConstantTimeCompare(got[:], want[:]) != 1 || !ok {
+		return "", false
+	}
+	return account.Role, true
+}
+
+// requestRole 返回请求对应的角色；未登录时为匿名角色
+func requestRole(r *http.Request) string {
+	if sess, ok := requestSession(r); ok {
+		return sess.Role
+	}
+	return anonRole
+}
+
+// resolveAnonRole 根据 -anonymous 参数确定匿名访问者的角色
+// 未指定时：没有任何账户则保持以往的完全开放（admin），否则要求登录
+func resolveAnonRole(mode string) (string, error) {
+	switch mode {
+	case "":
+		if authEnabled() {
+			return "", nil
+		}
+		return roleAdmin, nil
+	case "none":
+		if !authEnabled() {
+			return "", fmt.Errorf("-anonymous none 需要同时配置账户（-username/-password 或 -users）")
+		}
+		return "", nil
+	}
+	if roleLevel[mode] == 0 {
+		return "", fmt.Errorf("无效的 -anonymous: %s（可选 none、viewer、editor、admin）", mode)
+	}
+	return mode, nil
+}
+
+// hasRole 判断请求的角色是否不低于 min
+func hasRole(r *http.Request, min string) bool {
+	return roleLevel[requestRole(r)] >= roleLevel[min]
+}
+
+// requireRole 角色权限中间件，需放在 authHandler 之内，权限不足时返回403
+func requireRole(min string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasRole(r, min) {
+			httpError(w, r, fmt.Sprintf(tr(r, "权限不足：当前角色（%s）无权执行此操作，需要 %s 及以上"), requestRole(r), min), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// limitTransfers 上传/下载并发限制中间件，已达 -max-concurrent-transfers 时立即返回503并附带 Retry-After
+func limitTransfers(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if transferSlots == nil {
+			next(w, r)
+			return
+		}
+		select {
+		case transferSlots <- struct{}{}:
+			defer func() { <-transferSlots }()
+			next(w, r)
+		default:
+			w.Header().Set("Retry-After", "5")
+			httpError(w, r, "并发传输数已达上限，请稍后重试", http.StatusServiceUnavailable)
+		}
+	}
+}
+
+// requireListing 启用 -no-listing 时拒绝列出目录内容的请求，/api 下返回 JSON 错误
+func requireListing(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !noListing {
+			next(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			apiError(w, http.StatusForbidden, "listing_disabled", tr(r, "目录浏览已禁用"))
+			return
+		}
+		httpError(w, r, "目录浏览已禁用", http.StatusForbidden)
+	}
+}
+
+// baseDirKey 请求上下文中保存用户根目录的键
+type baseDirKey struct{}
+
+// sessionKey 请求上下文中保存已认证会话的键
+type sessionKey struct{}
+
+// withSession 将已认证的会话及其用户根目录写入请求上下文
+func withSession(r *http.Request, sess tokenSession) *http.Request {
+	ctx := context.WithValue(r.Context(), sessionKey{}, sess)
+	if home := users[sess.Username].Home; home != "" {
+		ctx = context.WithValue(ctx, baseDirKey{}, home)
+	}
+	return r.WithContext(ctx)
+}
+
+// withToken 将 token 对应的会话写入请求上下文
+func withToken(r *http.Request, token string) *http.Request {
+	if sess, ok := lookupToken(token); ok {
+		return withSession(r, sess)
+	}
+	return r
+}
+
+// requestSession 返回请求对应的会话：优先使用 authHandler 写入上下文的会话
+// （包括 Basic 认证的单次会话），否则按 cookie 或 Bearer token 查找
+func requestSession(r *http.Request) (tokenSession, bool) {
+	if sess, ok := r.Context().Value(sessionKey{}).(tokenSession); ok {
+		return sess, true
+	}
+	return lookupToken(requestToken(r))
+}
+
+// requestBase 返回请求可访问的根目录，所有路径解析都应以此为基准
+func requestBase(r *http.Request) string {
+	if home, ok := r.Context().Value(baseDirKey{}).(string); ok {
+		return home
+	}
+	return baseDir
+}
+
+// authHandler 基于token的认证中间件
+func authHandler(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 检查cookie中的token，续期后同步更新cookie过期时间
+		cookie, err := r.Cookie(cookieName)
+		if err == nil {
+			if renewed, ok := touchToken(cookie.Value); ok {
+				if !renewed.IsZero() {
+					setAuthCookie(w, cookie.Value, renewed)
+				}
+				next.ServeHTTP(w, withToken(r, cookie.Value))
+				return
+			}
+		}
+
+		// 检查Authorization header中的token
+		auth := r.Header.Get("Authorization")
+		if strings.HasPrefix(auth, "Bearer ") {
+			token := strings.TrimPrefix(auth, "Bearer ")
+			if _, ok := touchToken(token); ok {
+				next.ServeHTTP(w, withToken(r, token))
+				return
+			}
+		}
+
+		// 启用 -allow-basic-auth 时接受 HTTP Basic 认证，仅对本次请求有效，不签发 token
+		if allowBasicAuth {
+			if user, pass, ok := r.BasicAuth(); ok {
+				role, valid := checkCredentials(user, pass)
+				if !valid {
+					w.Header().Set("WWW-Authenticate", `Basic realm="hfs", charset="UTF-8"`)
+					httpError(w, r, "用户名或密码错误", http.StatusUnauthorized)
+					return
+				}
+				next.ServeHTTP(w, withSession(r, tokenSession{Username: user, Role: role}))
+				return
+			}
+		}
+
+		// 未认证但允许匿名访问，按匿名角色继续处理
+		if anonRole != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// 非浏览器客户端（如 wget）需要收到质询后才会发送 Basic 凭据
+		if allowBasicAuth && !strings.Contains(r.Header.Get("Accept"), "text/html") {
+			w.Header().Set("WWW-Authenticate", `Basic realm="hfs", charset="UTF-8"`)
+			httpError(w, r, "需要认证", http.StatusUnauthorized)
+			return
+		}
+
+		// 未认证：/api 下的接口返回 JSON 错误，页面重定向到登录页面
+		if strings.HasPrefix(r.URL.Path, "/api/") && r.URL.Path != "/api/login" {
+			apiError(w, http.StatusUnauthorized, "unauthorized", tr(r, "需要认证"))
+			return
+		}
+		if r.URL.Path != "/login" && r.URL.Path != "/api/login" {
+			http.Redirect(w, r, prefixURL("/login"), http.StatusFound)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// templateFuncs 页面模板使用的辅助函数，与语言相关的函数见 langFuncs
+var templateFuncs = template.FuncMap{
+	"url": prefixURL,
+	"sub": func(a, b int) int { return a - b },
+	"split": func(s, sep string) []string {
+		return strings.Split(s, sep)
+	},
+	"toggle": func(currentSort, currentOrder, target string) string {
+		if currentSort == target {
+			if currentOrder == "asc" {
+				return "desc"
+			}
+			return "asc"
+		}
+		return "asc"
+	},
+	"asset": assetURL,
+}
+
+// defaultLang 默认界面语言，也是消息目录的键所使用的语言
+const defaultLang = "zh-CN"
+
+// messages 界面文字与服务端提示的译文，按语言以中文原文为键；缺少的条目显示中文原文
+var messages = map[string]map[string]string{
+	"en": {
+		"简易网页文件管理器":    "Simple Web File Manager",
+		"登录":           "Log in",
+		"用户名:":         "Username:",
+		"密码:":          "Password:",
+		"记住登录状态 (30天)": "Remember me (30 days)",
+		"登录失败":         "Login failed",
+		"网络错误，请重试":     "Network error, please try again",
+		"退出登录":         "Log out",
+		"匿名访问（%s）":     "Anonymous (%s)",
+		"复制路径":         "Copy path",
+		"复制服务器绝对路径":    "Copy the absolute path on the server",
+		"复制相对于根目录的路径":  "Copy the path relative to the root",
+		"查找文件（输入名称筛选）": "Find files (filter by name)",
+		"全部类型":         "All types",
+		"图片":           "Images",
+		"视频":           "Videos",
+		"音频":           "Audio",
+		"文档":           "Documents",
+		"压缩包":          "Archives",
+		"其他":           "Other",
+		"上传文件":         "Upload",
+		"创建文件":         "New file",
+		"创建文件夹":        "New folder",
+		"刷新":           "Refresh",
+		"最近修改":         "Recent",
+		"多线程下载":        "Parallel download",
+		"2 线程":         "2 threads",
+		"4 线程":         "4 threads",
+		"8 线程":         "8 threads",
+		"粘贴到此处":        "Paste here",
+		"清空":           "Clear",
+		"确定":           "OK",
+		"取消":           "Cancel",
+		"自动滚动":         "Auto-scroll",
+		"关闭":           "Close",
+		"属性":           "Properties",
+		"读":            "Read",
+		"写":            "Write",
+		"执行":           "Execute",
+		"属主":           "Owner",
+		"属组":           "Group",
+		"修改权限":         "Change permissions",
+		"请输入文件名":       "Enter a file name",
+		"请输入文件夹名":      "Enter a folder name",
+		"请选择至少一个文件":    "Please select at least one file",
+		"文件上传成功":       "Upload complete",
+		"\n\n以下文件与已有文件内容相同": "\n\nThese files have the same content as existing files",
+		"（已使用硬链接节省空间）":      " (hard-linked to save space)",
+		"：\n":               ":\n",
+		"文件上传失败":            "Upload failed",
+		"已复制: ":             "Copied: ",
+		"请手动复制路径:":          "Copy the path manually:",
+		"获取最近修改的文件失败: ":     "Failed to load recent files: ",
+		"返回目录":              "Back to folder",
+		"最近修改的 ":            "The ",
+		" 个文件":              " most recently modified files",
+		"（目录过大，仅扫描了部分文件）":   " (the folder is too large, only part of it was scanned)",
+		"名称":         "Name",
+		"最后修改":       "Modified",
+		"大小":         "Size",
+		"所在目录":       "Folder",
+		"类型":         "Type",
+		"分类":         "Category",
+		"刷新文件列表失败":   "Failed to refresh the file list",
+		"文件创建成功":     "File created",
+		"文件创建失败: ":   "Failed to create file: ",
+		"文件夹创建成功":    "Folder created",
+		"文件夹创建失败: ":  "Failed to create folder: ",
+		"重命名成功":      "Renamed",
+		"重命名失败: ":    "Rename failed: ",
+		"下载失败":       "Download failed",
+		"分段响应不正确":    "Unexpected range response",
+		"删除成功":       "Deleted",
+		"删除失败: ":     "Delete failed: ",
+		"重命名":        "Rename",
+		"剪切":         "Cut",
+		"复制":         "Copy",
+		"实时查看":       "Follow",
+		"下载为 tar.gz": "Download as tar.gz",
+		"压缩":         "Compress",
+		"解压到此处":      "Extract here",
+		"删除":         "Delete",
+		"压缩文件名:":     "Archive name:",
+		"已压缩 ":       "Compressed ",
+		" 个文件到 ":     " files into ",
+		"已解压 ":       "Extracted ",
+		" 项":         " items",
+		"，跳过 ":       ", skipped ",
+		" 项：\n":      " items:\n",
+		"获取属性失败: ":   "Failed to load properties: ",
+		"文件夹":        "Folder",
+		"文件":         "File",
+		" 字节)":       " bytes)",
+		"修改时间":       "Modified",
+		"权限":         "Permissions",
+		"链接目标":       "Link target",
+		"实时查看: ":     "Following: ",
+		"\n--- 文件已被截断或替换，从头读取 ---\n": "\n--- file was truncated or replaced, reading from the start ---\n",
+		"\n--- 文件已被删除 ---\n":         "\n--- file was deleted ---\n",
+		"\n--- 无法查看该文件 ---\n":        "\n--- cannot follow this file ---\n",
+		"\n共 ":                       "\nTotal ",
+		" 个文件，":                      " files, ",
+		" 个文件夹":                      " folders",
+		"已剪切: ":                      "Cut: ",
+		"粘贴失败: ":                     "Paste failed: ",
+		"请输入新的名称":                    "Enter a new name",
+		"确定要删除 ":                     "Delete ",
+		" 吗？":                        "?",
+		"返回上级目录":                     "Up one level",
+		"%d 个文件, %d 个文件夹, 共 %s":      "%d files, %d folders, %s in total",
+		"刚刚":                         "just now",
+		"%d分钟前":                      "%d min ago",
+		"%d小时前":                      "%d h ago",
+		"%d天前":                       "%d days ago",
+		"%d个月前":                      "%d months ago",
+		"%d年前":                       "%d years ago",
+		"仅支持POST方法":                  "Only POST is supported",
+		"方法不允许":                      "Method not allowed",
+		"需要认证":                       "Authentication required",
+		"未授权":                        "Unauthorized",
+		"用户名或密码错误":                   "Invalid username or password",
+		"无效的请求格式":                    "Invalid request format",
+		"无效的路径":                      "Invalid path",
+		"无效的目录":                      "Invalid directory",
+		"无效的名称":                      "Invalid name",
+		"无效的文件名":                     "Invalid file name",
+		"无效的新名称":                     "Invalid new name",
+		"无效的旧名称":                     "Invalid old name",
+		"无效的目标目录":                    "Invalid destination folder",
+		"无效的类型":                      "Invalid type",
+		"无效的分类":                      "Invalid category",
+		"非法文件名":                      "Invalid file name",
+		"缺少参数":                       "Missing parameters",
+		"文件不存在":                      "File not found",
+		"源文件不存在":                     "Source not found",
+		"目录不存在":                      "Directory not found",
+		"目标目录不存在":                    "Destination folder not found",
+		"目标目录中已存在":                   "Destination already contains",
+		"文件已存在":                      "File already exists",
+		"已存在同名文件":                    "A file with the same name already exists",
+		"文件已被修改，请刷新后重试":              "The file has been modified, refresh and try again",
+		"无法读取目录":                     "Cannot read directory",
+		"无法打开文件":                     "Cannot open file",
+		"无法保存文件":                     "Cannot save file",
+		"无法下载文件夹":                    "Folders cannot be downloaded",
+		"无法创建文件":                     "Cannot create file",
+		"无法创建文件夹":                    "Cannot create folder",
+		"无法创建临时文件":                   "Cannot create temporary file",
+		"无法写入临时文件":                   "Cannot write temporary file",
+		"无法生成不重复的名称":                 "Cannot generate a unique name",
+		"文件内容超过大小限制":                 "File content exceeds the size limit",
+		"请求体过大或格式错误":                 "Request body too large or malformed",
+		"删除失败":                       "Delete failed",
+		"重命名失败":                      "Rename failed",
+		"移动失败":                       "Move failed",
+		"复制失败":                       "Copy failed",
+		"压缩失败":                       "Compression failed",
+		"解压失败":                       "Extraction failed",
+		"修改权限失败":                     "Failed to change permissions",
+		"分片写入失败":                     "Failed to write chunk",
+		"已存在同名文件夹":                   "A folder with the same name already exists",
+		"不能将文件夹重命名为已存在的文件":             "A folder cannot be renamed to an existing file",
+		"已存在同名文件夹，不能用文件覆盖":             "A folder with that name exists and cannot be replaced by a file",
+		" 已存在，是否覆盖？":                   " already exists. Overwrite?",
+		"只能调整普通文件的大小":                  "Only regular files can be resized",
+		"调整文件大小失败":                     "Failed to resize file",
+		"文件超过上传大小限制（%s）":               "File exceeds the upload size limit (%s)",
+		"只有 admin 可以注销其他用户的会话":         "Only admins can revoke other users' sessions",
+		"无效的 limit（1 到 %d）":            "Invalid limit (1 to %d)",
+		"禁止访问内网或本机地址":                  "Access to private or local addresses is not allowed",
+		"无效的 URL（仅支持 http 与 https）":    "Invalid URL (only http and https are supported)",
+		"下载远程文件失败":                     "Failed to fetch the remote file",
+		"仅支持HEAD和PUT方法":                "Only HEAD and PUT are supported",
+		"无效的 Content-Range":            "Invalid Content-Range",
+		"请求体长度与 Content-Range 不一致":     "Request body length does not match Content-Range",
+		"并发传输数已达上限，请稍后重试":              "Too many concurrent transfers, please retry later",
+		"目录浏览已禁用":                      "Directory listing is disabled",
+		"未知的操作类型":                      "Unknown operation",
+		"ops 不能为空":                     "ops must not be empty",
+		"单次最多 %d 项操作":                  "At most %d operations per request",
+		"路径不能包含空字节":                    "Path must not contain NUL bytes",
+		"页面不存在":                        "Page not found",
+		"返回首页":                         "Back to home",
+		"文件不存在，可能已被删除":                 "File not found, it may have been deleted",
+		"没有权限访问该文件":                    "Permission denied",
+		"无法读取文件":                       "Cannot read file",
+		"无法读取源文件":                      "Cannot read source file",
+		"文件已不存在，列表已刷新":                 "The file no longer exists; the list has been refreshed",
+		"无效的 sha256":                   "Invalid sha256",
+		"sha256 与已有上传会话不一致":            "sha256 does not match the existing upload session",
+		"上传校验失败":                       "Upload verification failed",
+		"无效的排序方式":                      "Invalid sort",
+		"无法保存目录排序":                     "Cannot save directory sort",
+		"已恢复默认排序":                      "Default sort restored",
+		"已保存此目录的排序":                    "Sort saved for this directory",
+		"保存此目录排序":                      "Save sort for this folder",
+		"保存排序失败: ":                     "Failed to save sort: ",
+		"请求ID":                         "Request ID",
+		"不允许上传到该目录":                    "Uploads are not allowed in this directory",
+		"不允许在该目录中创建":                   "Creating entries is not allowed in this directory",
+		"不允许上传该类型的文件":                  "File type is not allowed for upload",
+		"目标不是目录":                       "Target is not a directory",
+		"不允许清空根目录":                     "Emptying the root directory is not allowed",
+		"清空目录失败":                       "Failed to empty directory",
+		"确认令牌无效或已过期":                   "Confirmation token is invalid or expired",
+		"不允许该来源的跨域请求":                  "Cross-origin requests from this origin are not allowed",
+		"未指定搜索关键字":                     "No search query specified",
+		"移动到上级目录":                      "Move to parent folder",
+		"计算中...":                       "Computing...",
+		"未启用校验和":                       "Checksums are not enabled",
+		"目标是已存在的文件夹":                   "Target is an existing folder",
+		"上传超时":                         "Upload timed out",
+		"请求体过大":                        "Request body too large",
+		"移动成功":                         "Moved",
+		"复制成功":                         "Copied",
+		"权限已修改":                        "Permissions changed",
+		"只能压缩文件夹":                      "Only folders can be compressed",
+		"不能将压缩文件保存到被压缩的文件夹内":           "The archive cannot be saved inside the folder being compressed",
+		"只能查看普通文件":                     "Only regular files can be followed",
+		"不支持查看二进制文件":                   "Binary files cannot be followed",
+		"不支持事件流":                       "Event streams are not supported",
+		"未指定文件":                        "No file specified",
+		"未指定目录":                        "No folder specified",
+		"未指定要下载的条目":                    "No entries specified for download",
+		"上传会话不存在或已过期":                  "Upload session not found or expired",
+		"无权访问该上传会话":                    "Access to this upload session is denied",
+		"该上传已完成":                       "This upload is already complete",
+		"Windows 不支持修改 Unix 权限位":       "Unix permission bits are not supported on Windows",
+		"无效的权限，应为 0000 到 0777 之间的八进制数": "Invalid mode, expected an octal number between 0000 and 0777",
+		"无法统计目录":                       "Cannot measure directory",
+		"无法遍历目录":                       "Cannot walk directory",
+		"无效的 offset":                   "Invalid offset",
+		"无效的 limit":                    "Invalid limit",
+		"无效的 depth":                    "Invalid depth",
+		"无效的 uploadId":                 "Invalid uploadId",
+		"无效的 total":                    "Invalid total",
+		"无效的 size":                     "Invalid size",
+		"无效的 kb":                       "Invalid kb",
+		"total 与已有上传会话不一致":             "total does not match the existing upload session",
+		"offset 不连续，已接收 %d 字节":         "Non-contiguous offset, %d bytes received so far",
+		"工作目录不可访问":                     "Working directory is not accessible",
+		"不允许删除根目录":                     "Deleting the root directory is not allowed",
+		"权限不足：当前角色（%s）无权执行此操作，需要 %s 及以上": "Permission denied: role %s cannot perform this action, %s or higher is required",
+		"不允许修改该目录中的文件":                   "Modifying files is not allowed in this directory",
+	},
+}
+
+// requestLang 返回请求使用的界面语言：-lang 指定时固定使用，否则按 Accept-Language 的优先级选择
+func requestLang(r *http.Request) string {
+	if uiLang != "auto" {
+		return uiLang
+	}
+	best, bestQ := defaultLang, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if lang := matchLang(tag); lang != "" && q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+// matchLang 将语言标签（如 en-US、zh-Hans-CN）对应到受支持的界面语言，不支持时返回空
+func matchLang(tag string) string {
+	primary, _, _ := strings.Cut(strings.ToLower(tag), "-")
+	switch primary {
+	case "zh":
+		return defaultLang
+	case "en":
+		return "en"
+	}
+	return ""
+}
+
+// translate 翻译一条消息；找不到完整匹配时尝试翻译“前缀: 详情”中的前缀
+func translate(lang, msg string) string {
+	catalog := messages[lang]
+	if catalog == nil {
+		return msg
+	}
+	if t, ok := catalog[msg]; ok {
+		return t
+	}
+	for _, sep := range []string{": ", " "} {
+		if prefix, rest, ok := strings.Cut(msg, sep); ok {
+			if t, ok := catalog[prefix]; ok {
+				return t + sep + rest
+			}
+		}
+	}
+	return msg
+}
+
+// tr 按请求的界面语言翻译消息
+func tr(r *http.Request, msg string) string {
+	return translate(requestLang(r), msg)
+}
+
+// apiError 以 {"error":{"code":...,"message":...}} 的 JSON 格式返回 /api 下接口的错误，
+// code 为供程序判断的英文标识，message 为面向用户的说明
+func apiError(w http.ResponseWriter, status int, code, msg string) {
+	body := map[string]string{"code": code, "message": msg}
+	if id := w.Header().Get("X-Request-ID"); id != "" {
+		body["request_id"] = id
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": body,
+	})
+}
+
+// httpError 与 http.Error 相同，但按请求的界面语言翻译错误信息；服务端错误（5xx）附带请求ID便于反馈问题
+func httpError(w http.ResponseWriter, r *http.Request, msg string, code int) {
+	msg = tr(r, msg)
+	if id := w.Header().Get("X-Request-ID"); id != "" && code >= 500 {
+		msg += " [" + tr(r, "请求ID") + ": " + id + "]"
+	}
+	http.Error(w, msg, code)
+}
+
+// langMessages 返回提供给页面脚本的译文表，默认语言返回空表
+func langMessages(lang string) map[string]string {
+	if m := messages[lang]; m != nil {
+		return m
+	}
+	return map[string]string{}
+}
+
+// langFuncs 返回与界面语言相关的模板函数
+func langFuncs(lang string) template.FuncMap {
+	return template.FuncMap{
+		"tr": func(msg string) string { return translate(lang, msg) },
+		"timeAgo": func(t time.Time) string {
+			return timeAgo(lang, t)
+		},
+		"categoryLabel": func(c string) string {
+			return translate(lang, categoryLabels[c])
+		},
+	}
+}
+
+// formatTime 按 -timezone 与 -date-format 格式化显示时间
+func formatTime(t time.Time) string {
+	return t.In(displayLoc).Format(dateFormat)
+}
+
+// timeAgo 将时间渲染为相对当前的描述，如"刚刚"、"3分钟前"、"2天前"；
+// 未来时间（时钟偏差）超过一分钟时直接显示绝对时间
+func timeAgo(lang string, t time.Time) string {
+	d := time.Since(t)
+	if d < -time.Minute {
+		return formatTime(t)
+	}
+	switch {
+	case d < time.Minute:
+		return translate(lang, "刚刚")
+	case d < time.Hour:
+		return fmt.Sprintf(translate(lang, "%d分钟前"), int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf(translate(lang, "%d小时前"), int(d/time.Hour))
+	case d < 30*24*time.Hour:
+		return fmt.Sprintf(translate(lang, "%d天前"), int(d/(24*time.Hour)))
+	case d < 365*24*time.Hour:
+		return fmt.Sprintf(translate(lang, "%d个月前"), int(d/(30*24*time.Hour)))
+	default:
+		return fmt.Sprintf(translate(lang, "%d年前"), int(d/(365*24*time.Hour)))
+	}
+}
+
+// keyedLock 单个路径上的读写锁，refs 为正在等待或持有该锁的请求数
+type keyedLock struct {
+	sync.RWMutex
+	refs int
+}
+
+// keyedLocks 按路径分配读写锁：不同路径的操作可以并行，同一路径的操作互斥，
+// 锁在无人引用时从表中移除。加锁时同时对 baseDir 内的各级上级目录加读锁，
+// 因此删除或移动目录会等待其中正在进行的下载、上传完成
+type keyedLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+// acquire 取得路径对应的锁并增加引用计数
+func (k *keyedLocks) acquire(p string) *keyedLock {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	l, ok := k.locks[p]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[p] = l
+	}
+	l.refs++
+	return l
+}
+
+// release 减少引用计数，归零时删除该锁
+func (k *keyedLocks) release(p string, l *keyedLock) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	l.refs--
+	if l.refs == 0 {
+		delete(k.locks, p)
+	}
+}
+
+// Lock 对一个或多个路径加写锁，返回解锁函数
+func (k *keyedLocks) Lock(paths ...string) func() {
+	return k.lockAll(paths, true)
+}
+
+// RLock 对路径加读锁，返回解锁函数
+func (k *keyedLocks) RLock(p string) func() {
+	return k.lockAll([]string{p}, false)
+}
+
+// lockAll 对目标路径加读锁或写锁，并对其上级目录加读锁；
+// 所有锁按路径排序后依次获取以避免死锁
+func (k *keyedLocks) lockAll(paths []string, write bool) func() {
+	modes := make(map[string]bool) // 路径 -> 是否需要写锁
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		modes[p] = modes[p] || write
+		for d := filepath.Dir(p); d != p && isWithin(baseDir, d); p, d = d, filepath.Dir(d) {
+			if _, ok := modes[d]; !ok {
+				modes[d] = false
+			}
+		}
+	}
+	keys := make([]string, 0, len(modes))
+	for p := range modes {
+		keys = append(keys, p)
+	}
+	sort.Strings(keys)
+	held := make([]*keyedLock, len(keys))
+	for i, p := range keys {
+		held[i] = k.acquire(p)
+		if modes[p] {
+			held[i].Lock()
+		} else {
+			held[i].RLock()
+		}
+	}
+	return func() {
+		for i := len(keys) - 1; i >= 0; i-- {
+			if modes[keys[i]] {
+				held[i].Unlock()
+			} else {
+				held[i].RUnlock()
+			}
+			k.release(keys[i], held[i])
+		}
+	}
+}
+
+// readFileInfos 读取目录内容；符号链接会解析其目标以确定类型与大小，
+// 目标不存在的断开链接按大小为0的文件显示
+func readFileInfos(dir string) ([]FileInfo, error) {
+	unlock := pathLocks.RLock(dir)
+	entries, err := os.ReadDir(dir)
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []FileInfo
+	for _, entry := range entries {
+		if entry.Name() == dirSortFile {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		isSymlink := entry.Type()&fs.ModeSymlink != 0
+		linkTarget := ""
+		if isSymlink {
+			linkTarget, _ = os.Readlink(filepath.Join(dir, entry.Name()))
+			if target, err := os.Stat(filepath.Join(dir, entry.Name())); err == nil {
+				info = target
+			}
+		}
+		isDir := info.IsDir()
+		sizeStr := ""
+		rawSize := int64(0)
+		category := ""
+		if !isDir {
+			category = "other"
+		}
+		if !isDir && info.Mode().IsRegular() {
+			rawSize = info.Size()
+			sizeStr = calculateFileSize(rawSize)
+			category = fileCategory(filepath.Join(dir, entry.Name()))
+		}
+		files = append(files, FileInfo{
 			Name:       entry.Name(),
+			Ext:        fileExt(entry.Name(), isDir),
 			Size:       sizeStr,
 			RawSize:    rawSize,
-			UploadDate: info.ModTime().Format("2006-01-02 15:04:05"),
+			UploadDate: formatTime(info.ModTime()),
 			ModTime:    info.ModTime(),
-			IsDir:      entry.IsDir(),
+			IsDir:      isDir,
+			IsSymlink:  isSymlink,
+			LinkTarget: linkTarget,
+			Category:   category,
+		})
+	}
+	return files, nil
+}
+
+// categoryByExt 常见扩展名对应的分类
+var categoryByExt = map[string]string{
+	"jpg": "image", "jpeg": "image", "png": "image", "gif": "image", "bmp": "image", "webp": "image", "svg": "image", "ico": "image", "heic": "image", "tif": "image", "tiff": "image",
+	"mp4": "video", "mkv": "video", "avi": "video", "mov": "video", "wmv": "video", "flv": "video", "webm": "video", "m4v": "video", "ts": "video",
+	"mp3": "audio", "wav": "audio", "flac": "audio", "aac": "audio", "ogg": "audio", "m4a": "audio", "wma": "audio", "opus": "audio",
+	"pdf": "document", "doc": "document", "docx": "document", "xls": "document", "xlsx": "document", "ppt": "document", "pptx": "document",
+	"txt": "document", "md": "document", "csv": "document", "rtf": "document", "odt": "document", "ods": "document", "odp": "document", "epub": "document",
+	"zip": "archive", "rar": "archive", "7z": "archive", "tar": "archive", "gz": "archive", "tgz": "archive", "bz2": "archive", "xz": "archive", "zst": "archive", "iso": "archive",
+}
+
+// categoryLabels 分类在页面上显示的名称，同时用于校验 category 参数
+var categoryLabels = map[string]string{
+	"image":    "图片",
+	"video":    "视频",
+	"audio":    "音频",
+	"document": "文档",
+	"archive":  "压缩包",
+	"other":    "其他",
+}
+
+// fileCategory 判断文件的粗略分类：优先按扩展名，扩展名未知时才读取前 512 字节嗅探内容类型
+func fileCategory(path string) string {
+	ext := fileExt(filepath.Base(path), false)
+	if c, ok := categoryByExt[ext]; ok {
+		return c
+	}
+	if ext != "" {
+		if mt := mime.TypeByExtension("." + ext); mt != "" {
+			return categoryFromMIME(mt)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "other"
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(f, buf)
+	if n == 0 {
+		return "other"
+	}
+	return categoryFromMIME(http.DetectContentType(buf[:n]))
+}
+
+// categoryFromMIME 将 MIME 类型归入粗略分类
+func categoryFromMIME(mt string) string {
+	mt, _, _ = strings.Cut(mt, ";")
+	switch {
+	case strings.HasPrefix(mt, "image/"):
+		return "image"
+	case strings.HasPrefix(mt, "video/"):
+		return "video"
+	case strings.HasPrefix(mt, "audio/"):
+		return "audio"
+	case strings.HasPrefix(mt, "text/"), mt == "application/pdf", mt == "application/rtf",
+		strings.Contains(mt, "msword"), strings.Contains(mt, "officedocument"), strings.Contains(mt, "opendocument"):
+		return "document"
+	case mt == "application/zip", mt == "application/x-gzip", mt == "application/gzip", mt == "application/x-tar",
+		mt == "application/x-rar-compressed", mt == "application/vnd.rar", mt == "application/x-7z-compressed", mt == "application/x-bzip2", mt == "application/x-xz":
+		return "archive"
+	}
+	return "other"
+}
+
+// walkTree 递归遍历 root 下的所有条目（不含 root 本身），对每个条目调用 fn。
+// 未启用 -follow-symlinks 时符号链接仅作为条目回调，不会进入其指向的目录；
+// 启用时进入链接目录，并通过与祖先目录比较 os.SameFile（等价于比较 inode）跳过环路。
+// fn 返回 filepath.SkipDir 时跳过该目录，返回其它错误时终止遍历
+func walkTree(root string, fn func(path string, info os.FileInfo) error) error {
+	rootInfo, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	err = walkDir(root, []os.FileInfo{rootInfo}, fn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// walkDir 为 walkTree 的递归实现，ancestors 为当前路径上已访问的目录
+func walkDir(dir string, ancestors []os.FileInfo, fn func(path string, info os.FileInfo) error) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.Mode()&fs.ModeSymlink != 0 && followSymlinks {
+			target, err := os.Stat(path)
+			if err != nil {
+				continue // 断开的链接
+			}
+			info = target
+		}
+		if err := fn(path, info); err != nil {
+			if err == filepath.SkipDir {
+				continue
+			}
+			return err
+		}
+		if !info.IsDir() {
+			continue
+		}
+		loop := false
+		for _, a := range ancestors {
+			if os.SameFile(a, info) {
+				loop = true
+				break
+			}
+		}
+		if loop {
+			continue
+		}
+		if err := walkDir(path, append(ancestors, info), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileExt 返回小写扩展名（不含点）；目录和以点开头的隐藏文件名视为无扩展名
+func fileExt(name string, isDir bool) string {
+	if isDir {
+		return ""
+	}
+	ext := filepath.Ext(name)
+	if ext == name {
+		return ""
+	}
+	return strings.ToLower(strings.TrimPrefix(ext, "."))
+}
+
+// sortFiles 按排序字段与顺序对文件列表排序
+func sortFiles(files []FileInfo, sortType, order string) {
+	var less func(a, b FileInfo) bool
+	switch sortType {
+	case "time":
+		less = func(a, b FileInfo) bool { return a.ModTime.Before(b.ModTime) }
+	case "size":
+		less = func(a, b FileInfo) bool { return a.RawSize < b.RawSize }
+	case "type":
+		// 目录单独成组排在最前，文件按扩展名再按名称排序
+		less = func(a, b FileInfo) bool {
+			if a.IsDir != b.IsDir {
+				return a.IsDir
+			}
+			if a.Ext != b.Ext {
+				return a.Ext < b.Ext
+			}
+			return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+		}
+	default:
+		less = func(a, b FileInfo) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }
+	}
+	// 主排序键相同时依次按小写名称、原始名称比较，使顺序完全确定，分页时不会重复或遗漏
+	primary := less
+	less = func(a, b FileInfo) bool {
+		if primary(a, b) {
+			return true
+		}
+		if primary(b, a) {
+			return false
+		}
+		if la, lb := strings.ToLower(a.Name), strings.ToLower(b.Name); la != lb {
+			return la < lb
+		}
+		return a.Name < b.Name
+	}
+	if order == "desc" {
+		sort.SliceStable(files, func(i, j int) bool { return less(files[j], files[i]) })
+	} else {
+		sort.SliceStable(files, func(i, j int) bool { return less(files[i], files[j]) })
+	}
+}
+
+// parentDir 返回相对路径的上级目录，根目录的上级仍为根目录
+func parentDir(relDir string) string {
+	relDir = strings.Trim(relDir, "/")
+	if i := strings.LastIndex(relDir, "/"); i >= 0 {
+		return relDir[:i]
+	}
+	return ""
+}
+
+// sortCookie 记住用户上次选择的排序方式，值为 "sort:order"
+const sortCookie = "hfs_sort"
+
+// validSort 判断是否为支持的排序字段
+func validSort(sortType string) bool {
+	return sortType == "name" || sortType == "time" || sortType == "size" || sortType == "type"
+}
+
+// dirSortFile 目录内保存该目录默认排序的隐藏文件，列表中不显示
+const dirSortFile = ".hfs-sort"
+
+// dirSortConfig .hfs-sort 的内容
+type dirSortConfig struct {
+	Sort  string `json:"sort"`
+	Order string `json:"order,omitempty"`
+}
+
+// readDirSort 读取 dir 中 .hfs-sort 指定的排序，文件不存在或内容无效时返回 false
+func readDirSort(dir string) (dirSortConfig, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, dirSortFile))
+	if err != nil {
+		return dirSortConfig{}, false
+	}
+	var cfg dirSortConfig
+	if json.Unmarshal(data, &cfg) != nil || !validSort(cfg.Sort) {
+		return dirSortConfig{}, false
+	}
+	return cfg, true
+}
+
+// sortParams 读取 sort/order 参数；未指定时依次使用目录中的 .hfs-sort、Cookie 中记住的选择与
+// -default-sort/-default-order。未配置默认顺序时按时间排序默认降序，其余默认升序
+func sortParams(r *http.Request, dir string) (sortType, order string) {
+	sortType = r.URL.Query().Get("sort")
+	order = r.URL.Query().Get("order")
+	if sortType == "" {
+		if cfg, ok := readDirSort(dir); ok {
+			sortType, order = cfg.Sort, cfg.Order
+		} else if c, err := r.Cookie(sortCookie); err == nil {
+			sortType, order, _ = strings.Cut(c.Value, ":")
+		}
+	}
+	if !validSort(sortType) {
+		sortType = defaultSort
+	}
+	if order != "asc" && order != "desc" {
+		switch {
+		case defaultOrder != "":
+			order = defaultOrder
+		case sortType == "time":
+			order = "desc"
+		default:
+			order = "asc"
+		}
+	}
+	return sortType, order
+}
+
+// dirSortHandler 保存当前目录的默认排序到 .hfs-sort；sort 为空时删除该文件，恢复全局默认
+func dirSortHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "仅支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+	dir, err := joinRequestPath(r, r.FormValue("path"))
+	if err != nil {
+		httpError(w, r, "无效的路径", http.StatusBadRequest)
+		return
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		httpError(w, r, "目录不存在", http.StatusNotFound)
+		return
+	}
+	target := filepath.Join(dir, dirSortFile)
+	cfg := dirSortConfig{Sort: r.FormValue("sort"), Order: r.FormValue("order")}
+	defer pathLocks.Lock(target)()
+	if cfg.Sort == "" {
+		err = os.Remove(target)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		auditLog(r, "dir-sort", "", target, err)
+		if err != nil {
+			httpError(w, r, "无法保存目录排序: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, tr(r, "已恢复默认排序"))
+		return
+	}
+	if !validSort(cfg.Sort) || cfg.Order != "" && cfg.Order != "asc" && cfg.Order != "desc" {
+		httpError(w, r, "无效的排序方式", http.StatusBadRequest)
+		return
+	}
+	data, _ := json.Marshal(cfg)
+	err = writeFileAtomic(target, bytes.NewReader(data))
+	auditLog(r, "dir-sort", "", target, err)
+	if err != nil {
+		httpError(w, r, "无法保存目录排序: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, tr(r, "已保存此目录的排序"))
+}
+
+// rememberSort 在请求显式指定排序时写入 Cookie，之后不带参数访问时沿用
+func rememberSort(w http.ResponseWriter, r *http.Request, sortType, order string) {
+	if r.URL.Query().Get("sort") == "" {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sortCookie,
+		Value:    sortType + ":" + order,
+		Path:     cookiePath,
+		MaxAge:   365 * 24 * 3600,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// buildPageData 根据 URL 参数 path 与 sort/order 读取当前目录内容，生成页面数据；
+// 出错时已写入错误响应并返回 false
+func buildPageData(w http.ResponseWriter, r *http.Request) (PageData, bool) {
+	relDir, err := normalizeRelPath(r.URL.Query().Get("path"))
+	var currentDir string
+	if err == nil {
+		currentDir, err = joinRequestPath(r, relDir)
+	}
+	if err != nil {
+		httpError(w, r, "无效的目录", http.StatusBadRequest)
+		return PageData{}, false
+	}
+	sortType, order := sortParams(r, currentDir)
+	rememberSort(w, r, sortType, order)
+	lang := requestLang(r)
+
+	files, err := readFileInfos(currentDir)
+	if err != nil {
+		httpError(w, r, "无法读取目录", http.StatusInternalServerError)
+		return PageData{}, false
+	}
+
+	sortFiles(files, sortType, order)
+
+	category := r.URL.Query().Get("category")
+	if category != "" {
+		if _, ok := categoryLabels[category]; !ok {
+			httpError(w, r, "无效的分类", http.StatusBadRequest)
+			return PageData{}, false
+		}
+	}
+
+	breadcrumbs := buildBreadcrumbs(relDir)
+
+	// 绝对路径会暴露服务器目录结构，默认不提供
+	var absPath string
+	if showAbsPath {
+		if abs, err := filepath.Abs(currentDir); err == nil {
+			absPath = abs
+		}
+	}
+
+	// 统计当前目录直接包含的文件数、文件夹数与文件总大小（不递归）
+	var fileCount, dirCount int
+	var totalSize int64
+	for _, f := range files {
+		if f.IsDir {
+			dirCount++
+		} else {
+			fileCount++
+			totalSize += f.RawSize
+		}
+	}
+
+	// 按分类筛选时保留目录，便于继续浏览；统计信息仍针对整个目录
+	if category != "" {
+		var filtered []FileInfo
+		for _, f := range files {
+			if f.IsDir || f.Category == category {
+				filtered = append(filtered, f)
+			}
+		}
+		files = filtered
+	}
+
+	if checksums != nil {
+		for i := range files {
+			if !files[i].IsDir {
+				sum, regular := checksums.lookup(filepath.Join(currentDir, files[i].Name))
+				files[i].Checksum, files[i].ChecksumPending = sum, regular && sum == ""
+			}
+		}
+	}
+
+	return PageData{
+		Files:        files,
+		Breadcrumbs:  breadcrumbs,
+		ServeIndex:   serveIndex,
+		Title:        translate(lang, siteTitle),
+		LogoURL:      logoURL,
+		Lang:         lang,
+		Messages:     langMessages(lang),
+		ChecksumAlgo: checksumAlgo,
+		CurrentPath:  relDir,
+		Sort:         sortType,
+		Order:        order,
+		Username:     sessionUser(r),
+		Role:         requestRole(r),
+		CanLogin:     authEnabled() && sessionUser(r) == "",
+		CanEdit:      hasRole(r, roleEditor),
+		CanDelete:    hasRole(r, roleAdmin),
+		CanChmod:     hasRole(r, roleAdmin) && runtime.GOOS != "windows",
+		HasParent:    strings.Trim(relDir, "/") != "",
+		ParentPath:   parentDir(relDir),
+		FileCount:    fileCount,
+		DirCount:     dirCount,
+		TotalSize:    calculateFileSize(totalSize),
+		AbsPath:      absPath,
+		Category:     category,
+	}, true
+}
+
+// listEntry /api/list 返回的目录条目
+type listEntry struct {
+	Name       string    `json:"name"`
+	IsDir      bool      `json:"is_dir"`
+	IsSymlink  bool      `json:"is_symlink"`
+	LinkTarget string    `json:"link_target,omitempty"`
+	Size       int64     `json:"size"`
+	SizeHuman  string    `json:"size_human"`
+	ModTime    time.Time `json:"mod_time"`
+	Ext        string    `json:"ext,omitempty"`
+	Category   string    `json:"category,omitempty"`
+}
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// apiListHandler 以 JSON 分页返回目录内容：先对整个目录排序再按 offset/limit 截取，
+// 并返回 total 与 has_more，便于客户端实现无限滚动
+func apiListHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	relDir, err := normalizeRelPath(q.Get("path"))
+	var currentDir string
+	if err == nil {
+		currentDir, err = joinRequestPath(r, relDir)
+	}
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "invalid_path", tr(r, "无效的目录"))
+		return
+	}
+	offset, limit := 0, defaultListLimit
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			apiError(w, http.StatusBadRequest, "invalid_offset", tr(r, "无效的 offset"))
+			return
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxListLimit {
+			apiError(w, http.StatusBadRequest, "invalid_limit", fmt.Sprintf(tr(r, "无效的 limit（1 到 %d）"), maxListLimit))
+			return
+		}
+	}
+	category := q.Get("category")
+	if _, ok := categoryLabels[category]; category != "" && !ok {
+		apiError(w, http.StatusBadRequest, "invalid_category", tr(r, "无效的分类"))
+		return
+	}
+
+	files, err := readFileInfos(currentDir)
+	if err != nil {
+		apiFSError(w, r, err, "无法读取目录")
+		return
+	}
+	sortType, order := sortParams(r, currentDir)
+	sortFiles(files, sortType, order)
+	if category != "" {
+		var filtered []FileInfo
+		for _, f := range files {
+			if f.IsDir || f.Category == category {
+				filtered = append(filtered, f)
+			}
+		}
+		files = filtered
+	}
+
+	total := len(files)
+	end := min(offset+limit, total)
+	entries := []listEntry{}
+	for _, f := range files[min(offset, total):end] {
+		entries = append(entries, listEntry{
+			Name:       f.Name,
+			IsDir:      f.IsDir,
+			IsSymlink:  f.IsSymlink,
+			LinkTarget: f.LinkTarget,
+			Size:       f.RawSize,
+			SizeHuman:  f.Size,
+			ModTime:    f.ModTime,
+			Ext:        f.Ext,
+			Category:   f.Category,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":     relDir,
+		"sort":     sortType,
+		"order":    order,
+		"total":    total,
+		"offset":   offset,
+		"limit":    limit,
+		"has_more": end < total,
+		"files":    entries,
+	})
+}
+
+// buildBreadcrumbs 根据相对目录生成面包屑导航，首项为根目录
+func buildBreadcrumbs(relDir string) []Breadcrumb {
+	breadcrumbs := []Breadcrumb{{Name: "根目录", Path: ""}}
+	if relDir != "" {
+		parts := strings.Split(relDir, "/")
+		var cumulative string
+		for _, part := range parts {
+			if part == "" {
+				continue
+			}
+			if cumulative == "" {
+				cumulative = part
+			} else {
+				cumulative = cumulative + "/" + part
+			}
+			breadcrumbs = append(breadcrumbs, Breadcrumb{
+				Name: part,
+				Path: cumulative,
+			})
+		}
+	}
+	return breadcrumbs
+}
+
+// apiBreadcrumbsHandler 以 JSON 返回 path 对应的面包屑导航数据
+func apiBreadcrumbsHandler(w http.ResponseWriter, r *http.Request) {
+	relDir, err := normalizeRelPath(r.URL.Query().Get("path"))
+	if err == nil {
+		_, err = joinRequestPath(r, relDir)
+	}
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "invalid_path", tr(r, "无效的目录"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildBreadcrumbs(relDir))
+}
+
+// faviconHandler 浏览器会自动请求 /favicon.ico，页面已通过 <link rel="icon"> 指向 /static/favicon.svg，
+// 这里无需认证直接返回204，避免被重定向到登录页或产生404
+func faviconHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rootHandler 只有 "/" 交给 index 显示文件列表，其余未注册的路径返回404
+func rootHandler(index http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			notFoundHandler(w, r)
+			return
+		}
+		index(w, r)
+	}
+}
+
+// notFoundHandler 返回404：/api 下为 JSON，浏览器请求为简单页面，其余为纯文本
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		apiError(w, http.StatusNotFound, "not_found", tr(r, "页面不存在"))
+		return
+	}
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		httpError(w, r, "页面不存在", http.StatusNotFound)
+		return
+	}
+	lang := requestLang(r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	langTemplate(notFoundTmpl, lang).Execute(w, NotFoundPageData{Title: translate(lang, siteTitle), LogoURL: logoURL, Lang: lang, Path: r.URL.Path})
+}
+
+// indexHandler 根据 URL 参数 path 与 sort/order 读取当前目录内容，生成完整页面
+func indexHandler(w http.ResponseWriter, r *http.Request) {
+	if serveIndex && r.URL.Query().Get("browse") != "1" && serveDirIndex(w, r) {
+		return
+	}
+	if noListing {
+		httpError(w, r, "目录浏览已禁用", http.StatusForbidden)
+		return
+	}
+	data, ok := buildPageData(w, r)
+	if !ok {
+		return
+	}
+	tmpl := langTemplate(mainTemplate, data.Lang)
+	tmpl.Execute(w, data)
+	runtime.GC()
+}
+
+// serveDirIndex 在请求的目录中存在 index.html 时直接输出该文件，返回是否已处理
+func serveDirIndex(w http.ResponseWriter, r *http.Request) bool {
+	currentDir, err := joinRequestPath(r, r.URL.Query().Get("path"))
+	if err != nil {
+		return false
+	}
+	indexPath, err := secureJoin(currentDir, "index.html")
+	if err != nil {
+		return false
+	}
+	f, err := os.Open(indexPath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	defer pathLocks.RLock(indexPath)()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, r, "index.html", info.ModTime(), f)
+	return true
+}
+
+// listHandler 返回仅文件列表部分（用于 AJAX 局部刷新）
+func listHandler(w http.ResponseWriter, r *http.Request) {
+	data, ok := buildPageData(w, r)
+	if !ok {
+		return
+	}
+	tmpl := langTemplate(mainTemplate, data.Lang)
+	tmpl.ExecuteTemplate(w, "fileList", data)
+	runtime.GC()
+}
+
+// fileUploadHandler 保存上传的文件到指定目录
+func fileUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "仅支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+	clearReadDeadline(w)
+	idleBody(w, r)
+	if maxUploadSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	}
+	if err := r.ParseMultipartForm(multipartMemory); err != nil {
+		if isTimeout(err) {
+			httpError(w, r, "上传超时: "+uploadIdle.String(), http.StatusRequestTimeout)
+			return
+		}
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	relDir := r.URL.Query().Get("path")
+	targetDir, err := joinRequestPath(r, relDir)
+	if err != nil {
+		httpError(w, r, "无效的路径", http.StatusBadRequest)
+		return
+	}
+	if !uploadAllowed(r, targetDir) {
+		httpError(w, r, "不允许上传到该目录", http.StatusForbidden)
+		return
+	}
+	filesUploaded := r.MultipartForm.File["files[]"]
+	lastModified := r.MultipartForm.Value["lastModified[]"]
+	renameOnConflict := r.URL.Query().Get("conflict") == "rename"
+	var results []uploadResult
+	for i, fileHeader := range filesUploaded {
+		file, err := fileHeader.Open()
+		if err != nil {
+			httpError(w, r, "无法打开文件", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		if err := validateName(fileHeader.Filename); err != nil {
+			httpError(w, r, "非法文件名 "+fileHeader.Filename+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		targetPath, err := secureJoin(targetDir, fileHeader.Filename)
+		if err != nil {
+			httpError(w, r, "非法文件名", http.StatusBadRequest)
+			return
+		}
+		if err := checkPathLength(targetPath); err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var head []byte
+		if sniffExecutables {
+			if head, err = sniffHead(file); err != nil {
+				httpError(w, r, "无法读取文件", http.StatusBadRequest)
+				return
+			}
+		}
+		if uploadBlocked(fileHeader.Filename, head) {
+			httpError(w, r, "不允许上传该类型的文件: "+fileHeader.Filename, http.StatusUnsupportedMediaType)
+			return
+		}
+		var mtime time.Time
+		if i < len(lastModified) {
+			mtime, _ = parseClientMtime(lastModified[i])
+		}
+		// 只锁定正在写入的文件，其他路径上的上传与下载不受影响
+		var unlock func()
+		if renameOnConflict {
+			// conflict=rename：同名文件已存在时保存为 "name (1).ext" 等不冲突的名称
+			if targetPath, unlock, err = lockUniquePath(targetDir, fileHeader.Filename); err != nil {
+				httpError(w, r, err.Error(), http.StatusConflict)
+				return
+			}
+		} else {
+			unlock = pathLocks.Lock(targetPath)
+		}
+		if !checkIfMatch(r, targetPath) {
+			unlock()
+			httpError(w, r, "文件 "+fileHeader.Filename+" 已被修改，请刷新后重试", http.StatusPreconditionFailed)
+			return
+		}
+		result, err := saveUpload(r, targetPath, file, mtime)
+		unlock()
+		auditLog(r, "upload", "", targetPath, err)
+		if err != nil {
+			httpError(w, r, "无法保存文件", http.StatusInternalServerError)
+			return
+		}
+		results = append(results, result)
+	}
+	invalidateDirInfo(targetDir)
+	if dedupeMode != "off" || renameOnConflict {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": tr(r, "文件上传成功"),
+			"files":   results,
+		})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, tr(r, "文件上传成功"))
+}
+
+// uploadResult 单个上传文件的保存结果，启用 -dedupe 时随响应返回
+type uploadResult struct {
+	Name        string `json:"name"`
+	SHA256      string `json:"sha256,omitempty"`
+	Duplicate   bool   `json:"duplicate"`
+	DuplicateOf string `json:"duplicate_of,omitempty"` // 重复文件相对于当前用户根目录的路径
+	Linked      bool   `json:"linked"`
+}
+
+// saveUpload 将上传内容原子写入 targetPath（不会改写可能与其他文件共享的硬链接），
+// mtime 非零时设置为文件修改时间。启用 -dedupe 时写入的同时计算 SHA-256，
+// 内容与已有文件相同时按模式改为硬链接或仅在结果中提示；调用方需持有 targetPath 的写锁
+func saveUpload(r *http.Request, targetPath string, src io.Reader, mtime time.Time) (uploadResult, error) {
+	result := uploadResult{Name: filepath.Base(targetPath)}
+	if dedupeMode == "off" {
+		if err := writeFileAtomic(targetPath, src); err != nil {
+			return result, err
+		}
+		if !mtime.IsZero() {
+			os.Chtimes(targetPath, time.Now(), mtime)
+		}
+		return result, nil
+	}
+
+	h := sha256.New()
+	if err := writeFileAtomic(targetPath, io.TeeReader(src, h)); err != nil {
+		return result, err
+	}
+	result.SHA256 = hex.EncodeToString(h.Sum(nil))
+	// 只在当前用户可访问的范围内查找重复，避免泄露其他用户的文件内容
+	if existing, ok := lookupDuplicate(result.SHA256, targetPath, requestBase(r)); ok {
+		result.Duplicate = true
+		if rel, err := filepath.Rel(requestBase(r), existing); err == nil {
+			result.DuplicateOf = filepath.ToSlash(rel)
+		}
+		if dedupeMode == "link" && sameHomes(existing, targetPath) && replaceWithLink(existing, targetPath) == nil {
+			// 硬链接与原文件共享修改时间，不再改写
+			result.Linked = true
+			return result, nil
+		}
+	}
+	if !mtime.IsZero() {
+		os.Chtimes(targetPath, time.Now(), mtime)
+	}
+	indexFile(targetPath, result.SHA256)
+	return result, nil
+}
+
+// hashEntry 内容哈希索引中的一条记录，大小或修改时间变化后视为失效
+type hashEntry struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// indexFile 将文件加入内容哈希索引，同一路径的旧记录会被替换
+func indexFile(path, sum string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	entry := hashEntry{Path: path, Size: info.Size(), ModTime: info.ModTime()}
+	hashIndexMu.Lock()
+	defer hashIndexMu.Unlock()
+	for i, e := range hashIndex[sum] {
+		if e.Path == path {
+			hashIndex[sum][i] = entry
+			return
+		}
+	}
+	hashIndex[sum] = append(hashIndex[sum], entry)
+}
+
+// lookupDuplicate 在 within 目录内查找内容哈希相同且仍未变化的其他文件，失效的记录会被删除
+func lookupDuplicate(sum, exclude, within string) (string, bool) {
+	hashIndexMu.Lock()
+	defer hashIndexMu.Unlock()
+	entries := hashIndex[sum][:0]
+	found := ""
+	for _, entry := range hashIndex[sum] {
+		info, err := os.Stat(entry.Path)
+		if err != nil || !info.Mode().IsRegular() || info.Size() != entry.Size || !info.ModTime().Equal(entry.ModTime) {
+			continue
+		}
+		entries = append(entries, entry)
+		if found == "" && entry.Path != exclude && isWithin(within, entry.Path) {
+			found = entry.Path
+		}
+	}
+	if len(entries) == 0 {
+		delete(hashIndex, sum)
+	} else {
+		hashIndex[sum] = entries
+	}
+	return found, found != ""
+}
+
+// sameHomes 报告 a 与 b 是否对所有用户的根目录都同时可见或同时不可见；
+// 硬链接共享权限与内容，只在这种情况下才能以硬链接去重，否则一个用户的改动会影响另一个用户
+func sameHomes(a, b string) bool {
+	for _, acc := range users {
+		home := acc.Home
+		if home == "" {
+			home = baseDir
+		}
+		if isWithin(home, a) != isWithin(home, b) {
+			return false
+		}
+	}
+	return true
+}
+
+// replaceWithLink 用指向 existing 的硬链接替换 target；
+// 文件系统不支持硬链接或跨设备时返回错误，target 保持为普通文件
+func replaceWithLink(existing, target string) error {
+	tmp := filepath.Join(filepath.Dir(target), fmt.Sprintf(".hfs-link-%d", time.Now().UnixNano()))
+	if err := os.Link(existing, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// buildHashIndex 启动时在后台计算 baseDir 下已有文件的内容哈希
+func buildHashIndex(root string) {
+	walkTree(root, func(path string, info os.FileInfo) error {
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".hfs-") {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return nil
+		}
+		indexFile(path, hex.EncodeToString(h.Sum(nil)))
+		return nil
+	})
+}
+
+// checksumCacheMax 校验和缓存的最大条目数，超出时随机淘汰旧条目
+const checksumCacheMax = 100000
+
+// checksumEntry 一个文件的校验和，大小或修改时间变化后视为失效
+type checksumEntry struct {
+	Size    int64
+	ModTime time.Time
+	Sum     string // 为空表示已排队、尚未算好
+}
+
+// checksumCache 启用 -checksum 时在后台计算文件校验和并按路径缓存。
+// 由固定数量的 worker 依次处理队列，避免大量文件同时读取占满磁盘
+type checksumCache struct {
+	mu      sync.Mutex
+	entries map[string]checksumEntry
+	queue   chan string
+}
+
+// newChecksumCache 创建缓存并启动 workers 个后台计算 goroutine
+func newChecksumCache(workers int) *checksumCache {
+	c := &checksumCache{entries: make(map[string]checksumEntry), queue: make(chan string, 10000)}
+	for i := 0; i < workers; i++ {
+		go c.worker()
+	}
+	return c
+}
+
+// lookup 返回 path 已算好的校验和；尚未算好时加入计算队列并返回空字符串。
+// regular 为 false 表示 path 不是普通文件，不计算校验和
+func (c *checksumCache) lookup(path string) (sum string, regular bool) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[path]; ok && e.Size == info.Size() && e.ModTime.Equal(info.ModTime()) {
+		return e.Sum, true
+	}
+	if len(c.entries) >= checksumCacheMax {
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	select {
+	case c.queue <- path:
+		c.entries[path] = checksumEntry{Size: info.Size(), ModTime: info.ModTime()}
+	default:
+		// 队列已满，下次查询时再排队
+	}
+	return "", true
+}
+
+// worker 从队列中取出文件计算校验和；计算期间文件发生变化时丢弃结果，由下次查询重新排队
+func (c *checksumCache) worker() {
+	for path := range c.queue {
+		before, err := os.Stat(path)
+		if err != nil {
+			c.forget(path)
+			continue
+		}
+		sum, err := fileChecksum(path)
+		after, statErr := os.Stat(path)
+		if err != nil || statErr != nil || after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+			c.forget(path)
+			continue
+		}
+		c.mu.Lock()
+		c.entries[path] = checksumEntry{Size: before.Size(), ModTime: before.ModTime(), Sum: sum}
+		c.mu.Unlock()
+	}
+}
+
+// forget 删除 path 的缓存条目
+func (c *checksumCache) forget(path string) {
+	c.mu.Lock()
+	delete(c.entries, path)
+	c.mu.Unlock()
+}
+
+// fileChecksum 按 -checksum 指定的算法计算文件内容的十六进制摘要
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var h hash.Hash
+	if checksumAlgo == "md5" {
+		h = md5.New()
+	} else {
+		h = sha256.New()
+	}
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checksumStatusHandler 返回 path 目录中各文件已算好的校验和，尚未算好的文件名列在 pending 中；
+// 页面列表中存在“计算中...”时轮询该接口
+func checksumStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if checksums == nil {
+		httpError(w, r, "未启用校验和", http.StatusNotFound)
+		return
+	}
+	dir, err := joinRequestPath(r, r.URL.Query().Get("path"))
+	if err != nil {
+		httpError(w, r, "无效的目录", http.StatusBadRequest)
+		return
+	}
+	files, err := readFileInfos(dir)
+	if err != nil {
+		fsError(w, r, err, "无法读取目录")
+		return
+	}
+	sums := make(map[string]string)
+	pending := []string{}
+	for _, f := range files {
+		if f.IsDir {
+			continue
+		}
+		sum, regular := checksums.lookup(filepath.Join(dir, f.Name))
+		switch {
+		case !regular:
+		case sum == "":
+			pending = append(pending, f.Name)
+		default:
+			sums[f.Name] = sum
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"algorithm": checksumAlgo,
+		"checksums": sums,
+		"pending":   pending,
+	})
+}
+
+// parseClientMtime 解析浏览器提供的 lastModified（毫秒时间戳），
+// 仅接受 1970 年之后且不超过当前时间一天的值
+func parseClientMtime(v string) (time.Time, bool) {
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ms <= 0 {
+		return time.Time{}, false
+	}
+	mtime := time.UnixMilli(ms)
+	if mtime.After(time.Now().Add(24 * time.Hour)) {
+		return time.Time{}, false
+	}
+	return mtime, true
+}
+
+// uploadTTL 分片上传会话无新分片到达多久后过期并清理临时文件
+const uploadTTL = time.Hour
+
+// chunkUpload 进行中的分片上传会话，分片按顺序追加到临时文件，收齐后移动到目标位置
+type chunkUpload struct {
+	mu       sync.Mutex
+	ID       string
+	Owner    string
+	Dir      string // 目标目录（绝对路径）
+	Name     string
+	Total    int64
+	Received int64
+	Chunks   int
+	TempPath string
+	SHA256   string // 客户端声明的整个文件的 sha256，收齐后校验
+	Done     bool
+	Updated  time.Time
+}
+
+// status 返回会话的进度信息，调用方需持有 mu
+func (u *chunkUpload) status() map[string]interface{} {
+	return map[string]interface{}{
+		"upload_id": u.ID,
+		"name":      u.Name,
+		"received":  u.Received,
+		"total":     u.Total,
+		"chunks":    u.Chunks,
+		"done":      u.Done,
+	}
+}
+
+// validUploadID 上传ID由客户端生成，仅允许字母、数字、- 与 _，便于断点续传时复用
+func validUploadID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// expireUploads 清理超过 uploadTTL 未更新的上传会话及其临时文件
+func expireUploads() {
+	uploadsMu.Lock()
+	defer uploadsMu.Unlock()
+	for id, u := range uploads {
+		u.mu.Lock()
+		if time.Since(u.Updated) > uploadTTL {
+			if !u.Done {
+				os.Remove(u.TempPath)
+			}
+			delete(uploads, id)
+		}
+		u.mu.Unlock()
+	}
+}
+
+// uploadChunkHandler 接收一个分片，参数 uploadId、path、name、offset、total 通过查询字符串传递，
+// 请求体为分片原始数据。offset 必须等于已接收字节数，中断后可通过 /upload-status 查询进度继续上传
+func uploadChunkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "仅支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+	clearReadDeadline(w)
+	idleBody(w, r)
+	q := r.URL.Query()
+	id := q.Get("uploadId")
+	if !validUploadID(id) {
+		httpError(w, r, "无效的 uploadId", http.StatusBadRequest)
+		return
+	}
+	offset, err := strconv.ParseInt(q.Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		httpError(w, r, "无效的 offset", http.StatusBadRequest)
+		return
+	}
+	total, err := strconv.ParseInt(q.Get("total"), 10, 64)
+	if err != nil || total < 0 {
+		httpError(w, r, "无效的 total", http.StatusBadRequest)
+		return
+	}
+	if maxUploadSize > 0 && total > maxUploadSize {
+		http.Error(w, fmt.Sprintf(tr(r, "文件超过上传大小限制（%s）"), calculateFileSize(maxUploadSize)), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	u, ok := openUpload(w, r, id, r.URL.Query().Get("path"), r.URL.Query().Get("name"), total)
+	if !ok {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.appendChunk(w, r, offset, total, r.Body, false) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u.status())
+}
+
+// executableMagic 可执行文件与脚本的文件头：ELF、Windows PE（MZ）与 #! 脚本
+var executableMagic = [][]byte{[]byte("\x7fELF"), []byte("MZ"), []byte("#!")}
+
+// uploadBlocked 报告名为 name、以 head 开头的文件是否被 -block-extensions 或 -block-executables 禁止上传
+func uploadBlocked(name string, head []byte) bool {
+	if blockedExts[fileExt(name, false)] {
+		return true
+	}
+	if sniffExecutables {
+		for _, magic := range executableMagic {
+			if bytes.HasPrefix(head, magic) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sniffHead 读取 f 开头最多 4 字节用于识别文件类型，读取后把位置恢复到开头
+func sniffHead(f io.ReadSeeker) ([]byte, error) {
+	head := make([]byte, 4)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// uploadAllowed 报告是否允许在 dir 中创建或修改文件：未配置 -upload-allow 时不限制，
+// 否则 dir 必须位于某个允许的前缀之内
+func uploadAllowed(r *http.Request, dir string) bool {
+	if len(uploadAllow) == 0 {
+		return true
+	}
+	base := requestBase(r)
+	for _, prefix := range uploadAllow {
+		if isWithin(filepath.Join(base, prefix), dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// openUpload 取出 id 对应的上传会话，不存在时按 relDir/name 新建并创建临时文件；失败时已写入错误响应
+func openUpload(w http.ResponseWriter, r *http.Request, id, relDir, name string, total int64) (*chunkUpload, bool) {
+	uploadsMu.Lock()
+	defer uploadsMu.Unlock()
+	if u, exists := uploads[id]; exists {
+		return u, true
+	}
+	if err := validateName(name); err != nil {
+		httpError(w, r, "非法文件名 "+name+": "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	targetDir, err := joinRequestPath(r, relDir)
+	if err != nil {
+		httpError(w, r, "无效的路径", http.StatusBadRequest)
+		return nil, false
+	}
+	if !uploadAllowed(r, targetDir) {
+		httpError(w, r, "不允许上传到该目录", http.StatusForbidden)
+		return nil, false
+	}
+	if err := checkPathLength(filepath.Join(targetDir, name)); err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if uploadBlocked(name, nil) {
+		httpError(w, r, "不允许上传该类型的文件: "+name, http.StatusUnsupportedMediaType)
+		return nil, false
+	}
+	tmp, err := os.CreateTemp(uploadTempDir, "hfs-upload-*")
+	if err != nil {
+		httpError(w, r, "无法创建临时文件", http.StatusInternalServerError)
+		return nil, false
+	}
+	tmp.Close()
+	u := &chunkUpload{ID: id, Owner: requestUser(r), Dir: targetDir, Name: name, Total: total, TempPath: tmp.Name(), Updated: time.Now()}
+	uploads[id] = u
+	return u, true
+}
+
+// appendChunk 校验 offset/total 后把 body 追加到临时文件，收齐后写入目标位置，调用方需持有 mu。
+// keepPartial 为 true 时写入中断也保留已收到的数据，供 /upload-resume 从断点继续；失败时已写入错误响应
+func (u *chunkUpload) appendChunk(w http.ResponseWriter, r *http.Request, offset, total int64, body io.Reader, keepPartial bool) bool {
+	if u.Owner != requestUser(r) {
+		httpError(w, r, "无权访问该上传会话", http.StatusForbidden)
+		return false
+	}
+	if u.Done {
+		httpError(w, r, "该上传已完成", http.StatusConflict)
+		return false
+	}
+	if total != u.Total {
+		httpError(w, r, "total 与已有上传会话不一致", http.StatusBadRequest)
+		return false
+	}
+	if sum := strings.ToLower(r.URL.Query().Get("sha256")); sum != "" {
+		if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+			httpError(w, r, "无效的 sha256", http.StatusBadRequest)
+			return false
+		}
+		if u.SHA256 != "" && u.SHA256 != sum {
+			httpError(w, r, "sha256 与已有上传会话不一致", http.StatusBadRequest)
+			return false
+		}
+		u.SHA256 = sum
+	}
+	if offset != u.Received {
+		httpError(w, r, fmt.Sprintf(tr(r, "offset 不连续，已接收 %d 字节"), u.Received), http.StatusConflict)
+		return false
+	}
+
+	f, err := os.OpenFile(u.TempPath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		httpError(w, r, "无法写入临时文件", http.StatusInternalServerError)
+		return false
+	}
+	n, err := io.Copy(f, io.LimitReader(body, u.Total-u.Received+1))
+	f.Close()
+	if err == nil && u.Received+n > u.Total {
+		err = fmt.Errorf("分片数据超出 total")
+		keepPartial = false
+	}
+	if err != nil {
+		if keepPartial {
+			u.Received += n
+			u.Updated = time.Now()
+		} else {
+			// 丢弃本次写入的不完整数据，客户端可从原 offset 重试
+			os.Truncate(u.TempPath, u.Received)
+		}
+		if isTimeout(err) {
+			httpError(w, r, "上传超时: "+uploadIdle.String(), http.StatusRequestTimeout)
+			return false
+		}
+		httpError(w, r, "分片写入失败: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	u.Received += n
+	u.Chunks++
+	u.Updated = time.Now()
+
+	if u.Received == u.Total {
+		if err := u.verify(); err != nil {
+			// 内容已损坏，清空已接收的数据，客户端需从 offset 0 重新上传
+			os.Truncate(u.TempPath, 0)
+			u.Received, u.Chunks = 0, 0
+			httpError(w, r, "上传校验失败: "+err.Error(), http.StatusBadRequest)
+			return false
+		}
+		if sniffExecutables {
+			if blocked, err := u.blocked(); err != nil || blocked {
+				os.Truncate(u.TempPath, 0)
+				u.Received, u.Chunks = 0, 0
+				httpError(w, r, "不允许上传该类型的文件: "+u.Name, http.StatusUnsupportedMediaType)
+				return false
+			}
+		}
+		targetPath, err := secureJoin(u.Dir, u.Name)
+		if err == nil {
+			unlock := pathLocks.Lock(targetPath)
+			var src *os.File
+			if src, err = os.Open(u.TempPath); err == nil {
+				_, err = saveUpload(r, targetPath, src, time.Time{})
+				src.Close()
+			}
+			unlock()
+		}
+		auditLog(r, "upload", "", targetPath, err)
+		if err != nil {
+			httpError(w, r, "无法保存文件", http.StatusInternalServerError)
+			return false
+		}
+		os.Remove(u.TempPath)
+		u.Done = true
+		invalidateDirInfo(u.Dir)
+	}
+	return true
+}
+
+// verify 在写入目标前确认临时文件的大小与 total 一致，并在客户端提供 sha256 时校验内容，调用方需持有 mu
+func (u *chunkUpload) verify() error {
+	f, err := os.Open(u.TempPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if n != u.Total {
+		return fmt.Errorf("临时文件为 %d 字节，应为 %d 字节", n, u.Total)
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); u.SHA256 != "" && actual != u.SHA256 {
+		return fmt.Errorf("sha256 不匹配，期望 %s，实际 %s", u.SHA256, actual)
+	}
+	return nil
+}
+
+// blocked 按临时文件开头的内容判断是否为禁止上传的可执行文件，调用方需持有 mu
+func (u *chunkUpload) blocked() (bool, error) {
+	f, err := os.Open(u.TempPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	head, err := sniffHead(f)
+	if err != nil {
+		return false, err
+	}
+	return uploadBlocked(u.Name, head), nil
+}
+
+// resumeUploadID 按用户与目标文件生成固定的会话ID，同一用户对同一文件的中断上传总能找回
+func resumeUploadID(r *http.Request, relDir, name string) string {
+	rel, _ := normalizeRelPath(relDir)
+	sum := sha256.Sum256([]byte(requestUser(r) + "\x00" + rel + "\x00" + name))
+	return "resume-" + hex.EncodeToString(sum[:20])
+}
+
+// discardResumeUpload 丢弃已完成的续传会话；从 0 开始且大小不同的上传视为重新上传，同样丢弃旧会话
+func discardResumeUpload(id string, start, total int64) {
+	uploadsMu.Lock()
+	defer uploadsMu.Unlock()
+	u, ok := uploads[id]
+	if !ok {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.Done || start == 0 && total != u.Total {
+		if !u.Done {
+			os.Remove(u.TempPath)
+		}
+		delete(uploads, id)
+	}
+}
+
+// parseContentRange 解析请求头 Content-Range: bytes start-end/total
+func parseContentRange(v string) (start, end, total int64, err error) {
+	spec, ok := strings.CutPrefix(v, "bytes ")
+	rng, size, ok2 := strings.Cut(spec, "/")
+	first, last, ok3 := strings.Cut(rng, "-")
+	if !ok || !ok2 || !ok3 {
+		return 0, 0, 0, fmt.Errorf("格式应为 bytes start-end/total")
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err == nil {
+		if end, err = strconv.ParseInt(last, 10, 64); err == nil {
+			total, err = strconv.ParseInt(size, 10, 64)
+		}
+	}
+	if err != nil || start < 0 || end < start || end >= total {
+		return 0, 0, 0, fmt.Errorf("范围无效")
+	}
+	return start, end, total, nil
+}
+
+// uploadResumeHandler 可断点续传的单文件上传：HEAD 通过 Upload-Offset 头返回已保存的字节数，
+// PUT 携带 Content-Range 从该位置继续追加，中断时已收到的数据会保留
+func uploadResumeHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	relDir, name := q.Get("path"), q.Get("name")
+	id := resumeUploadID(r, relDir, name)
+	switch r.Method {
+	case http.MethodHead:
+		uploadsMu.Lock()
+		u, ok := uploads[id]
+		uploadsMu.Unlock()
+		w.Header().Set("Cache-Control", "no-store")
+		if !ok {
+			w.Header().Set("Upload-Offset", "0")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		if u.Owner != requestUser(r) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.Received, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(u.Total, 10))
+		if u.Done {
+			w.Header().Set("Upload-Complete", "1")
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPut:
+		clearReadDeadline(w)
+		idleBody(w, r)
+		start, end, total, err := parseContentRange(r.Header.Get("Content-Range"))
+		if err != nil {
+			httpError(w, r, "无效的 Content-Range: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.ContentLength >= 0 && r.ContentLength != end-start+1 {
+			httpError(w, r, "请求体长度与 Content-Range 不一致", http.StatusBadRequest)
+			return
+		}
+		if maxUploadSize > 0 && total > maxUploadSize {
+			http.Error(w, fmt.Sprintf(tr(r, "文件超过上传大小限制（%s）"), calculateFileSize(maxUploadSize)), http.StatusRequestEntityTooLarge)
+			return
+		}
+		discardResumeUpload(id, start, total)
+		u, ok := openUpload(w, r, id, relDir, name, total)
+		if !ok {
+			return
+		}
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		if start != u.Received {
+			w.Header().Set("Upload-Offset", strconv.FormatInt(u.Received, 10))
+		}
+		if !u.appendChunk(w, r, start, total, io.LimitReader(r.Body, end-start+1), true) {
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.Received, 10))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(u.status())
+	default:
+		httpError(w, r, "仅支持HEAD和PUT方法", http.StatusMethodNotAllowed)
+	}
+}
+
+// uploadStatusHandler 返回分片上传的进度（已接收字节数、总大小、分片数、是否完成）
+func uploadStatusHandler(w http.ResponseWriter, r *http.Request) {
+	uploadsMu.Lock()
+	u, ok := uploads[r.URL.Query().Get("uploadId")]
+	uploadsMu.Unlock()
+	if !ok {
+		httpError(w, r, "上传会话不存在或已过期", http.StatusNotFound)
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.Owner != requestUser(r) {
+		httpError(w, r, "无权访问该上传会话", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(u.status())
+}
+
+// clearReadDeadline 取消上传类请求的读超时，-read-timeout 只约束普通请求
+func clearReadDeadline(w http.ResponseWriter) {
+	http.NewResponseController(w).SetReadDeadline(time.Time{})
+}
+
+// idleBody 为上传请求体设置空闲超时：每次读取前把读截止时间推后 -upload-idle-timeout，
+// 连续这么久收不到数据时读取返回超时错误，由各上传处理的错误分支中断并清理临时文件
+func idleBody(w http.ResponseWriter, r *http.Request) {
+	if uploadIdle <= 0 {
+		return
+	}
+	r.Body = &idleReader{ReadCloser: r.Body, rc: http.NewResponseController(w), timeout: uploadIdle}
+}
+
+// idleReader 每次读取前推后连接的读截止时间
+type idleReader struct {
+	io.ReadCloser
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (b *idleReader) Read(p []byte) (int, error) {
+	b.rc.SetReadDeadline(time.Now().Add(b.timeout))
+	return b.ReadCloser.Read(p)
+}
+
+// idleWriter 为下载响应设置空闲超时：每次写入前把写截止时间推后 -download-idle-timeout，
+// 客户端连续这么久不接收数据时写入失败，连接随之关闭
+func idleWriter(w http.ResponseWriter) http.ResponseWriter {
+	if downloadIdle <= 0 {
+		return w
+	}
+	return &idleResponseWriter{ResponseWriter: w, rc: http.NewResponseController(w), timeout: downloadIdle}
+}
+
+// idleCopyChunk 是 ReadFrom 每次推后截止时间后最多发送的字节数
+const idleCopyChunk = 1 << 20
+
+// idleResponseWriter 每次写入前推后连接的写截止时间
+type idleResponseWriter struct {
+	http.ResponseWriter
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (iw *idleResponseWriter) Write(p []byte) (int, error) {
+	iw.rc.SetWriteDeadline(time.Now().Add(iw.timeout))
+	return iw.ResponseWriter.Write(p)
+}
+
+// ReadFrom 分段发送文件内容，每段之前推后截止时间，同时保留底层连接的 sendfile 优化
+func (iw *idleResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	var n int64
+	for {
+		iw.rc.SetWriteDeadline(time.Now().Add(iw.timeout))
+		m, err := io.CopyN(iw.ResponseWriter, src, idleCopyChunk)
+		n += m
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// Unwrap 让 http.ResponseController 能找到底层连接
+func (iw *idleResponseWriter) Unwrap() http.ResponseWriter {
+	return iw.ResponseWriter
+}
+
+// isTimeout 判断错误是否为连接读写超时
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// clearWriteDeadline 取消下载、事件流等长时间响应的写超时，-write-timeout 只约束普通请求
+func clearWriteDeadline(w http.ResponseWriter) {
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+}
+
+// fsError 按文件系统错误的类别返回对应状态码：不存在 404、无权限 403、其余 500，
+// 并通过 X-Error-Code 头（not_found、permission_denied、io_error）告知页面脚本
+func fsError(w http.ResponseWriter, r *http.Request, err error, action string) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		w.Header().Set("X-Error-Code", "not_found")
+		httpError(w, r, "文件不存在，可能已被删除", http.StatusNotFound)
+	case errors.Is(err, fs.ErrPermission):
+		w.Header().Set("X-Error-Code", "permission_denied")
+		httpError(w, r, "没有权限访问该文件", http.StatusForbidden)
+	default:
+		w.Header().Set("X-Error-Code", "io_error")
+		httpError(w, r, action+": "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// apiFSError 与 fsError 相同的分类，以 /api 的 JSON 错误格式返回；
+// 路径中的某一级不是目录（ENOTDIR）同样视为不存在
+func apiFSError(w http.ResponseWriter, r *http.Request, err error, action string) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, syscall.ENOTDIR):
+		apiError(w, http.StatusNotFound, "not_found", tr(r, "文件不存在，可能已被删除"))
+	case errors.Is(err, fs.ErrPermission):
+		apiError(w, http.StatusForbidden, "permission_denied", tr(r, "没有权限访问该文件"))
+	default:
+		apiError(w, http.StatusInternalServerError, "io_error", tr(r, action)+": "+err.Error())
+	}
+}
+
+// contentDisposition 生成 Content-Disposition 头：filename 为仅含 ASCII 的兼容名称，
+// filename* 按 RFC 5987 携带 UTF-8 编码的原始文件名
+func contentDisposition(kind, name string) string {
+	fallback := strings.Map(func(c rune) rune {
+		if c < 0x20 || c > 0x7e || c == '"' || c == '\\' {
+			return '_'
+		}
+		return c
+	}, name)
+	return kind + "; filename=\"" + fallback + "\"; filename*=UTF-8''" + url.PathEscape(name)
+}
+
+// fileDownloadHandler 处理文件下载请求，支持断点续传和多线程下载
+func fileDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	clearWriteDeadline(w)
+	w = idleWriter(w)
+	fileName := r.URL.Query().Get("file")
+	relDir := r.URL.Query().Get("path")
+	// file 也可以是包含目录的完整相对路径（如 a/b/c.txt），此时拆分出目录部分并拼接到 path 之后
+	if strings.ContainsAny(fileName, "/\\") {
+		full, err := normalizeRelPath(fileName)
+		if err != nil {
+			httpError(w, r, "无效的文件名", http.StatusBadRequest)
+			return
+		}
+		dir, name := path.Split(full)
+		relDir, fileName = relDir+"/"+dir, name
+	}
+	if fileName == "" || fileName == "." || fileName == ".." {
+		httpError(w, r, "未指定文件", http.StatusBadRequest)
+		return
+	}
+	targetDir, err := joinRequestPath(r, relDir)
+	if err != nil {
+		httpError(w, r, "无效的路径", http.StatusBadRequest)
+		return
+	}
+	targetPath, err := secureJoin(targetDir, fileName)
+	if err != nil {
+		httpError(w, r, "无效的文件名", http.StatusBadRequest)
+		return
+	}
+	// 读锁允许同一文件被并发下载，但会等待正在进行的覆盖写入
+	unlock := pathLocks.RLock(targetPath)
+	defer unlock()
+	info, err := os.Stat(targetPath)
+	if err != nil {
+		fsError(w, r, err, "无法读取文件")
+		return
+	}
+	if info.IsDir() {
+		httpError(w, r, "无法下载文件夹", http.StatusBadRequest)
+		return
+	}
+
+	f, err := os.Open(targetPath)
+	if err != nil {
+		fsError(w, r, err, "无法打开文件")
+		return
+	}
+	defer f.Close()
+
+	// 设置缓存校验与下载方式的响应头，Range/条件请求由 http.ServeContent 处理
+	w.Header().Set("ETag", fileETag(info))
+	if r.URL.Query().Get("disposition") == "inline" {
+		// 在浏览器中直接打开：按扩展名推断类型，并以沙箱方式禁止其中的脚本
+		contentType := mime.TypeByExtension(filepath.Ext(info.Name()))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", contentDisposition("inline", info.Name()))
+		w.Header().Set("Content-Security-Policy", "sandbox")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", contentDisposition("attachment", info.Name()))
+	}
+	if gzipTypes[fileExt(info.Name(), false)] {
+		w.Header().Add("Vary", "Accept-Encoding")
+		// 分段请求与即时压缩无法对应，仅在没有 Range 时压缩
+		if r.Header.Get("Range") == "" && acceptsGzip(r) {
+			serveGzip(w, r, f, info)
+			return
+		}
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// filePreviewHandler 以 inline 方式返回文件供浏览器直接显示或播放，等同于 /download?disposition=inline；
+// 由 http.ServeContent 处理 Range，音视频可以拖动进度
+func filePreviewHandler(w http.ResponseWriter, r *http.Request) {
+	r = r.Clone(r.Context())
+	q := r.URL.Query()
+	q.Set("disposition", "inline")
+	r.URL.RawQuery = q.Encode()
+	fileDownloadHandler(w, r)
+}
+
+// acceptsGzip 判断客户端的 Accept-Encoding 是否接受 gzip（q=0 视为拒绝）
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(coding) != "gzip" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			v, err := strconv.ParseFloat(q, 64)
+			return err == nil && v > 0
+		}
+		return true
+	}
+	return false
+}
+
+// serveGzip 以 gzip 编码返回整个文件。压缩后的内容与原文件语义相同，沿用同一个弱 ETag，
+// 由 Vary: Accept-Encoding 区分缓存；长度未知，因此不设置 Content-Length
+func serveGzip(w http.ResponseWriter, r *http.Request, f *os.File, info os.FileInfo) {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etagListMatches(inm, fileETag(info)) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !info.ModTime().Truncate(time.Second).After(ims) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	if r.Method == http.MethodHead {
+		return
+	}
+	gz := gzip.NewWriter(w)
+	io.Copy(gz, f)
+	gz.Close()
+}
+
+// fileETag 根据文件大小与修改时间生成弱 ETag
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf(`W/"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+// checkIfMatch 校验 If-Match 请求头，用于避免覆盖他人的修改：未提供时直接通过，
+// 提供时要求目标存在且 ETag 与其中之一相同（"*" 匹配任意已存在的文件）。
+// 本服务只生成弱 ETag，因此比较时忽略 W/ 前缀。调用方应持有 path 的写锁
+func checkIfMatch(r *http.Request, path string) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return true
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return etagListMatches(ifMatch, fileETag(info))
+}
+
+// etagListMatches 判断 If-Match/If-None-Match 中的 ETag 列表是否包含 etag（弱比较，"*" 匹配任意值）
+func etagListMatches(list, etag string) bool {
+	current := strings.TrimPrefix(etag, "W/")
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == current {
+			return true
+		}
+	}
+	return false
+}
+
+// fileDeleteHandler 删除指定文件或目录（支持递归删除）
+func fileDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	fileName := r.URL.Query().Get("file")
+	relDir := r.URL.Query().Get("path")
+	if fileName == "" {
+		httpError(w, r, "未指定文件", http.StatusBadRequest)
+		return
+	}
+	if err := validateName(fileName); err != nil {
+		httpError(w, r, "无效的文件名: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	targetDir, err := joinRequestPath(r, relDir)
+	if err != nil {
+		httpError(w, r, "无效的路径", http.StatusBadRequest)
+		return
+	}
+	targetPath, err := secureJoin(targetDir, fileName)
+	if err != nil {
+		httpError(w, r, "无效的文件名", http.StatusBadRequest)
+		return
+	}
+	if filepath.Clean(targetPath) == filepath.Clean(requestBase(r)) {
+		httpError(w, r, "不允许删除根目录", http.StatusForbidden)
+		return
+	}
+	unlock := pathLocks.Lock(targetPath)
+	// RemoveAll 对不存在的路径不报错，先确认目标仍然存在
+	if _, err = os.Lstat(targetPath); err == nil {
+		err = os.RemoveAll(targetPath)
+	}
+	unlock()
+	auditLog(r, "delete", targetPath, "", err)
+	if err != nil {
+		fsError(w, r, err, "删除失败")
+		return
+	}
+	invalidateDirInfo(targetPath)
+	if r.Header.Get("X-Requested-With") == "XMLHttpRequest" {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, tr(r, "删除成功"))
+	} else {
+		http.Redirect(w, r, prefixURL("/?path="+relDir), http.StatusFound)
+	}
+}
+
+// emptyDirTokenTTL 清空目录确认令牌的有效期
+const emptyDirTokenTTL = 2 * time.Minute
+
+// emptyDirToken 清空目录的确认令牌，只能由申请者对同一目录使用一次
+type emptyDirToken struct {
+	User    string
+	Dir     string
+	Expires time.Time
+}
+
+// emptyDirHandler 删除目录中的全部内容但保留目录本身。第一次请求不带 token，
+// 返回将被删除的条目数与确认令牌；携带该令牌再次请求才会真正删除
+func emptyDirHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "仅支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+	relDir := r.FormValue("path")
+	targetDir, err := joinRequestPath(r, relDir)
+	if err != nil {
+		httpError(w, r, "无效的路径", http.StatusBadRequest)
+		return
+	}
+	if !allowEmptyRoot && filepath.Clean(targetDir) == filepath.Clean(requestBase(r)) {
+		httpError(w, r, "不允许清空根目录", http.StatusForbidden)
+		return
+	}
+	info, err := os.Stat(targetDir)
+	if err != nil {
+		fsError(w, r, err, "清空目录失败")
+		return
+	}
+	if !info.IsDir() {
+		httpError(w, r, "目标不是目录", http.StatusBadRequest)
+		return
+	}
+
+	token := r.FormValue("token")
+	if token == "" {
+		entries, err := os.ReadDir(targetDir)
+		if err != nil {
+			fsError(w, r, err, "清空目录失败")
+			return
+		}
+		token = generateToken()
+		now := time.Now()
+		emptyDirTokensMu.Lock()
+		for k, t := range emptyDirTokens {
+			if now.After(t.Expires) {
+				delete(emptyDirTokens, k)
+			}
+		}
+		emptyDirTokens[token] = emptyDirToken{User: requestUser(r), Dir: targetDir, Expires: now.Add(emptyDirTokenTTL)}
+		emptyDirTokensMu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"path":       relDir,
+			"entries":    len(entries),
+			"token":      token,
+			"expires_in": int(emptyDirTokenTTL.Seconds()),
+		})
+		return
+	}
+	emptyDirTokensMu.Lock()
+	t, ok := emptyDirTokens[token]
+	delete(emptyDirTokens, token)
+	emptyDirTokensMu.Unlock()
+	if !ok || time.Now().After(t.Expires) || t.User != requestUser(r) || t.Dir != targetDir {
+		httpError(w, r, "确认令牌无效或已过期", http.StatusForbidden)
+		return
+	}
+
+	// 整个过程持有目录写锁，目录内的上传、下载与其他修改都会等待
+	unlock := pathLocks.Lock(targetDir)
+	removedFiles, removedDirs, failed, err := emptyDir(targetDir)
+	unlock()
+	auditLog(r, "empty-dir", targetDir, "", err)
+	invalidateDirInfo(targetDir)
+	if err != nil && removedFiles+removedDirs == 0 {
+		fsError(w, r, err, "清空目录失败")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":          relDir,
+		"removed_files": removedFiles,
+		"removed_dirs":  removedDirs,
+		"failed":        failed,
+	})
+}
+
+// emptyDir 删除 dir 中的全部条目，返回删除的文件数、目录数（含子目录）与删除失败的条目名；
+// 统计时不跟随符号链接，链接本身按文件计数。调用方需持有 dir 的写锁
+func emptyDir(dir string) (files, dirs int, failed []string, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	failed = []string{}
+	for _, entry := range entries {
+		p := filepath.Join(dir, entry.Name())
+		var f, d int
+		filepath.WalkDir(p, func(_ string, e fs.DirEntry, walkErr error) error {
+			if walkErr == nil && e.IsDir() {
+				d++
+			} else if walkErr == nil {
+				f++
+			}
+			return nil
+		})
+		if rmErr := os.RemoveAll(p); rmErr != nil {
+			failed = append(failed, entry.Name())
+			err = rmErr
+			continue
+		}
+		files += f
+		dirs += d
+	}
+	return files, dirs, failed, err
+}
+
+// writeFileAtomic 先写入同目录下的临时文件再重命名到 path，避免留下写了一半的文件
+func writeFileAtomic(path string, src io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".hfs-tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	// CreateTemp 固定使用 0600，改为与 os.Create 一致的权限
+	os.Chmod(tmpName, 0644)
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+// resizeFile 将 path 调整为 size 字节，path 不存在时创建新文件。通常直接 os.Truncate 原地调整
+// （支持时扩展部分为稀疏文件）；文件与其他路径共享 inode（如 -dedupe link 产生的硬链接）时改用 resizeFileAtomic，
+// 避免同时改动其他文件
+func resizeFile(path string, size int64) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		err = f.Truncate(size)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if linkCount(info) > 1 {
+		return resizeFileAtomic(path, size)
+	}
+	return os.Truncate(path, size)
+}
+
+// linkCount 通过 info.Sys() 中的 Nlink 字段获取硬链接数（Unix 的 syscall.Stat_t），无法获取时返回 1
+func linkCount(info os.FileInfo) uint64 {
+	v := reflect.ValueOf(info.Sys())
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return 1
+	}
+	if n := v.FieldByName("Nlink"); n.IsValid() && n.CanUint() {
+		return n.Uint()
+	}
+	return 1
+}
+
+// resizeFileAtomic 将 path 调整为 size 字节：把原有内容的前 size 字节复制到临时文件并扩展到 size
+// 后替换原文件，不会改写与其共享 inode 的硬链接
+func resizeFileAtomic(path string, size int64) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".hfs-tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	mode := os.FileMode(0644)
+	if src, err := os.Open(path); err == nil {
+		if info, err := src.Stat(); err == nil {
+			mode = info.Mode().Perm()
+		}
+		_, err = io.Copy(tmp, io.LimitReader(src, size))
+		src.Close()
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpName)
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	err = tmp.Truncate(size)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		os.Chmod(tmpName, mode)
+		err = os.Rename(tmpName, path)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+	}
+	return err
+}
+
+// createHandler 根据参数在当前目录中创建新文件或文件夹，文件可通过 content 字段附带初始内容
+func createHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "仅支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+	clearReadDeadline(w)
+	idleBody(w, r)
+	if maxUploadSize > 0 {
+		// 额外预留表单其它字段与编码膨胀的空间，content 本身的大小在下方单独校验
+		r.Body = http.MaxBytesReader(w, r.Body, 3*maxUploadSize+(1<<20))
+	}
+	if err := r.ParseForm(); err != nil {
+		httpError(w, r, "请求体过大或格式错误", http.StatusRequestEntityTooLarge)
+		return
+	}
+	typ := r.FormValue("type")
+	name := r.FormValue("name")
+	relDir := r.FormValue("path")
+	content, hasContent := r.PostForm["content"]
+	// recursive=true 时文件夹名称可为 a/b/c 形式，逐级校验
+	recursive := typ == "folder" && r.FormValue("recursive") == "true"
+	if recursive {
+		name = strings.Trim(name, "/")
+		for _, part := range strings.Split(name, "/") {
+			if err := validateName(part); err != nil {
+				httpError(w, r, "无效的名称: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	} else if err := validateName(name); err != nil {
+		httpError(w, r, "无效的名称: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	targetDir, err := joinRequestPath(r, relDir)
+	if err != nil {
+		httpError(w, r, "无效的路径", http.StatusBadRequest)
+		return
+	}
+	targetPath, err := secureJoin(targetDir, name)
+	if err != nil {
+		httpError(w, r, "无效的名称", http.StatusBadRequest)
+		return
+	}
+	if !uploadAllowed(r, filepath.Dir(targetPath)) {
+		httpError(w, r, "不允许在该目录中创建", http.StatusForbidden)
+		return
+	}
+	if err := checkPathLength(targetPath); err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer pathLocks.Lock(targetPath)()
+	switch typ {
+	case "file":
+		if _, err := os.Stat(targetPath); err == nil {
+			httpError(w, r, "文件已存在", http.StatusBadRequest)
+			return
+		}
+		if hasContent {
+			data := strings.Join(content, "")
+			if maxUploadSize > 0 && int64(len(data)) > maxUploadSize {
+				httpError(w, r, "文件内容超过大小限制", http.StatusRequestEntityTooLarge)
+				return
+			}
+			err = writeFileAtomic(targetPath, strings.NewReader(data))
+		} else {
+			var f *os.File
+			if f, err = os.Create(targetPath); err == nil {
+				f.Close()
+			}
+		}
+		auditLog(r, "create", "", targetPath, err)
+		if err != nil {
+			httpError(w, r, "无法创建文件: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		invalidateDirInfo(targetPath)
+		fmt.Fprint(w, tr(r, "文件创建成功"))
+	case "folder":
+		if recursive {
+			if info, err := os.Stat(targetPath); err == nil && !info.IsDir() {
+				httpError(w, r, "已存在同名文件", http.StatusConflict)
+				return
+			}
+			err := os.MkdirAll(targetPath, 0755)
+			auditLog(r, "mkdir", "", targetPath, err)
+			if err != nil {
+				httpError(w, r, "无法创建文件夹: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			invalidateDirInfo(targetPath)
+			fmt.Fprint(w, tr(r, "文件夹创建成功"))
+			return
+		}
+		err := os.Mkdir(targetPath, 0755)
+		auditLog(r, "mkdir", "", targetPath, err)
+		if err != nil {
+			httpError(w, r, "无法创建文件夹: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		invalidateDirInfo(targetPath)
+		fmt.Fprint(w, tr(r, "文件夹创建成功"))
+	default:
+		httpError(w, r, "无效的类型", http.StatusBadRequest)
+	}
+}
+
+// renameHandler 重命名指定的文件或目录
+func renameHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "仅支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	oldName := r.FormValue("old")
+	newName := r.FormValue("new")
+	relDir := r.FormValue("path")
+	if oldName == "" || newName == "" {
+		httpError(w, r, "缺少参数", http.StatusBadRequest)
+		return
+	}
+	if err := validateName(newName); err != nil {
+		httpError(w, r, "无效的新名称: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	targetDir, err := joinRequestPath(r, relDir)
+	if err != nil {
+		httpError(w, r, "无效的路径", http.StatusBadRequest)
+		return
+	}
+	oldPath, err := secureJoin(targetDir, oldName)
+	if err != nil {
+		httpError(w, r, "无效的旧名称", http.StatusBadRequest)
+		return
+	}
+	newPath, err := secureJoin(targetDir, newName)
+	if err != nil {
+		httpError(w, r, "无效的新名称", http.StatusBadRequest)
+		return
+	}
+	if err := checkTreeLength(oldPath, newPath); err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer pathLocks.Lock(oldPath, newPath)()
+	if !checkIfMatch(r, oldPath) {
+		httpError(w, r, "文件已被修改，请刷新后重试", http.StatusPreconditionFailed)
+		return
+	}
+	oldInfo, err := os.Lstat(oldPath)
+	if err != nil {
+		fsError(w, r, err, "无法读取源文件")
+		return
+	}
+	if newPath == oldPath {
+		fmt.Fprint(w, tr(r, "重命名成功"))
+		return
+	}
+	// os.Rename 会直接覆盖已存在的文件，这里先检查目标；仅大小写不同（同一文件）时照常重命名
+	if newInfo, err := os.Lstat(newPath); err == nil && !os.SameFile(oldInfo, newInfo) {
+		switch {
+		case oldInfo.IsDir() && newInfo.IsDir():
+			httpError(w, r, "已存在同名文件夹", http.StatusConflict)
+			return
+		case oldInfo.IsDir():
+			httpError(w, r, "不能将文件夹重命名为已存在的文件", http.StatusConflict)
+			return
+		case newInfo.IsDir():
+			httpError(w, r, "已存在同名文件夹，不能用文件覆盖", http.StatusConflict)
+			return
+		case r.FormValue("overwrite") != "true":
+			httpError(w, r, "已存在同名文件", http.StatusConflict)
+			return
+		}
+	}
+	err = renamePath(oldPath, newPath)
+	auditLog(r, "rename", oldPath, newPath, err)
+	if err != nil {
+		fsError(w, r, err, "重命名失败")
+		return
+	}
+	invalidateDirInfo(oldPath)
+	invalidateDirInfo(newPath)
+	fmt.Fprint(w, tr(r, "重命名成功"))
+}
+
+// dirStats 目录递归统计结果
+type dirStats struct {
+	TotalSize int64 `json:"total_size"`
+	FileCount int   `json:"file_count"`
+	DirCount  int   `json:"dir_count"`
+}
+
+// dirInfoEntry 缓存的目录统计，modTime 为统计时目录自身的修改时间
+type dirInfoEntry struct {
+	modTime time.Time
+	stats   dirStats
+}
+
+var (
+	dirInfoCache = make(map[string]dirInfoEntry)
+	dirInfoMu    sync.Mutex
+)
+
+// invalidateDirInfo 在 p 发生变更后清除 p 及其所有上级目录的统计缓存，并通知文件名索引更新
+func invalidateDirInfo(p string) {
+	searchIndex.queue(p)
+	dirInfoMu.Lock()
+	defer dirInfoMu.Unlock()
+	for key := range dirInfoCache {
+		if isWithin(key, p) {
+			delete(dirInfoCache, key)
+		}
+	}
+}
+
+// computeDirStats 递归统计目录大小与条目数，结果按目录路径与修改时间缓存
+func computeDirStats(dir string, modTime time.Time) (dirStats, error) {
+	dirInfoMu.Lock()
+	entry, ok := dirInfoCache[dir]
+	dirInfoMu.Unlock()
+	if ok && entry.modTime.Equal(modTime) {
+		return entry.stats, nil
+	}
+
+	var stats dirStats
+	err := walkTree(dir, func(path string, info os.FileInfo) error {
+		if info.IsDir() {
+			stats.DirCount++
+		} else if info.Mode().IsRegular() {
+			stats.FileCount++
+			stats.TotalSize += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return dirStats{}, err
+	}
+
+	dirInfoMu.Lock()
+	dirInfoCache[dir] = dirInfoEntry{modTime: modTime, stats: stats}
+	dirInfoMu.Unlock()
+	return stats, nil
+}
+
+// dirInfoHandler 返回目录的递归总大小、文件数与子目录数（JSON）
+func dirInfoHandler(w http.ResponseWriter, r *http.Request) {
+	relDir := r.URL.Query().Get("path")
+	targetDir, err := joinRequestPath(r, relDir)
+	if err != nil {
+		httpError(w, r, "无效的目录", http.StatusBadRequest)
+		return
+	}
+	info, err := os.Stat(targetDir)
+	if err != nil || !info.IsDir() {
+		httpError(w, r, "目录不存在", http.StatusNotFound)
+		return
+	}
+	stats, err := computeDirStats(targetDir, info.ModTime())
+	if err != nil {
+		httpError(w, r, "无法统计目录: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"total_size":       stats.TotalSize,
+		"total_size_human": calculateFileSize(stats.TotalSize),
+		"file_count":       stats.FileCount,
+		"dir_count":        stats.DirCount,
+	})
+}
+
+// 最近修改文件查询的遍历上限，避免在超大目录树上长时间阻塞
+const (
+	recentScanLimit   = 100000
+	recentScanTimeout = 5 * time.Second
+)
+
+// errScanStop 遍历达到上限时用于提前结束 walkTree
+var errScanStop = errors.New("遍历已达上限")
+
+// recentFile 最近修改文件列表中的一项，Path 为相对于用户根目录的路径
+type recentFile struct {
+	Path    string    `json:"path"`
+	Name    string    `json:"name"`
+	Size    string    `json:"size"`
+	RawSize int64     `json:"raw_size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// recentHandler 遍历 path 下的整个子树，按修改时间倒序返回最近修改的 limit 个文件（默认 50，最多 1000）。
+// 扫描条目数或耗时超过上限时返回已收集的结果，并将 truncated 置为 true
+func recentHandler(w http.ResponseWriter, r *http.Request) {
+	base := requestBase(r)
+	root, err := joinRequestPath(r, r.URL.Query().Get("path"))
+	if err != nil {
+		httpError(w, r, "无效的目录", http.StatusBadRequest)
+		return
+	}
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			httpError(w, r, "无效的 limit", http.StatusBadRequest)
+			return
+		}
+		if n > 1000 {
+			n = 1000
+		}
+		limit = n
+	}
+
+	var files []recentFile
+	scanned := 0
+	deadline := time.Now().Add(recentScanTimeout)
+	err = walkTree(root, func(path string, info os.FileInfo) error {
+		scanned++
+		if scanned > recentScanLimit || time.Now().After(deadline) {
+			return errScanStop
+		}
+		if r.Context().Err() != nil {
+			return r.Context().Err()
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".hfs-") {
+			return nil
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return nil
+		}
+		files = append(files, recentFile{
+			Path:    filepath.ToSlash(rel),
+			Name:    info.Name(),
+			Size:    calculateFileSize(info.Size()),
+			RawSize: info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	truncated := err == errScanStop
+	if err != nil && !truncated {
+		httpError(w, r, "无法遍历目录: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].ModTime.After(files[j].ModTime)
+	})
+	if len(files) > limit {
+		files = files[:limit]
+	}
+	if files == nil {
+		files = []recentFile{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files":     files,
+		"truncated": truncated,
+	})
+}
+
+// 文件名搜索实时遍历时的扫描上限
+const (
+	searchScanLimit   = 100000
+	searchScanTimeout = 5 * time.Second
+)
+
+// searchResult 文件名搜索结果中的一项，Path 为相对于用户根目录的路径
+type searchResult struct {
+	Path  string `json:"path"`
+	Name  string `json:"name"`
+	IsDir bool   `json:"is_dir"`
+}
+
+// searchHandler 在 path 子树中按文件名（不区分大小写的子串）搜索，按路径排序返回最多 limit 项（默认 100，最多 1000）。
+// 启用 -index 且索引已就绪时直接查询内存索引，否则实时遍历；结果超过 limit 或遍历达到上限时 truncated 为 true
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	query := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	if query == "" {
+		httpError(w, r, "未指定搜索关键字", http.StatusBadRequest)
+		return
+	}
+	base := requestBase(r)
+	root, err := joinRequestPath(r, r.URL.Query().Get("path"))
+	if err != nil {
+		httpError(w, r, "无效的目录", http.StatusBadRequest)
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			httpError(w, r, "无效的 limit", http.StatusBadRequest)
+			return
+		}
+		if n > 1000 {
+			n = 1000
+		}
+		limit = n
+	}
+
+	source := "index"
+	paths, ok := searchIndex.lookup(root, query)
+	truncated := false
+	if !ok {
+		source = "walk"
+		paths = nil
+		scanned := 0
+		deadline := time.Now().Add(searchScanTimeout)
+		err = walkTree(root, func(path string, info os.FileInfo) error {
+			scanned++
+			if scanned > searchScanLimit || time.Now().After(deadline) {
+				return errScanStop
+			}
+			if r.Context().Err() != nil {
+				return r.Context().Err()
+			}
+			if indexSkip(info.Name()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.Contains(strings.ToLower(info.Name()), query) {
+				paths = append(paths, indexedPath{path, info.IsDir()})
+			}
+			return nil
 		})
+		truncated = err == errScanStop
+		if err != nil && !truncated {
+			httpError(w, r, "无法遍历目录: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	sort.Slice(paths, func(i, j int) bool { return paths[i].path < paths[j].path })
+	if len(paths) > limit {
+		paths = paths[:limit]
+		truncated = true
+	}
+	results := []searchResult{}
+	for _, p := range paths {
+		rel, err := filepath.Rel(base, p.path)
+		if err != nil {
+			continue
+		}
+		results = append(results, searchResult{Path: filepath.ToSlash(rel), Name: filepath.Base(p.path), IsDir: p.isDir})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"query":     query,
+		"source":    source,
+		"results":   results,
+		"truncated": truncated,
+	})
+}
+
+// indexSkip 报告文件名是否不参与搜索：目录排序配置与上传、写入过程中的临时文件
+func indexSkip(name string) bool {
+	return name == dirSortFile || strings.HasPrefix(name, ".hfs-")
+}
+
+// indexedPath 搜索命中的绝对路径
+type indexedPath struct {
+	path  string
+	isDir bool
+}
+
+// indexEntry 文件名索引中的一项
+type indexEntry struct {
+	lower string // 小写文件名
+	isDir bool
+}
+
+// nameIndex 启用 -index 时的内存文件名索引，键为绝对路径。启动时在后台建立；
+// 服务端自身的写操作经 invalidateDirInfo、/events 轮询发现的外部修改经 dirWatch 排队增量更新，不再定期重新遍历。
+// 所有更新都在同一个后台 goroutine 中顺序执行；尚未建好或条目数超过 -index-max-entries 时为冷状态，搜索改为实时遍历。
+// 只有更新队列溢出导致变冷时才整体重建
+type nameIndex struct {
+	mu      sync.RWMutex
+	ready   bool
+	entries map[string]indexEntry
+	updates chan string
+	rebuild chan struct{}
+}
+
+// newNameIndex 创建索引并启动后台维护
+func newNameIndex() *nameIndex {
+	ix := &nameIndex{updates: make(chan string, 1024), rebuild: make(chan struct{}, 1)}
+	go ix.run()
+	return ix
+}
+
+// run 建立初始索引后依次处理增量更新与重建请求
+func (ix *nameIndex) run() {
+	ix.build()
+	for {
+		select {
+		case p := <-ix.updates:
+			ix.refresh(p)
+		case <-ix.rebuild:
+			ix.build()
+		}
+	}
+}
+
+// queue 登记 p 发生了变化；队列已满时改为整体重建，期间索引为冷状态
+func (ix *nameIndex) queue(p string) {
+	if ix == nil {
+		return
+	}
+	select {
+	case ix.updates <- p:
+	default:
+		ix.mu.Lock()
+		ix.ready, ix.entries = false, nil
+		ix.mu.Unlock()
+		select {
+		case ix.rebuild <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// build 重新遍历 baseDir 建立完整索引
+func (ix *nameIndex) build() {
+	found, ok := indexTree(baseDir, false, indexMaxEntries)
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if !ok {
+		ix.ready, ix.entries = false, nil
+		return
+	}
+	ix.ready, ix.entries = true, found
+}
+
+// refresh 同步 p 的变化：已索引的目录只比较其直接子项，新增的子项与其他路径按整棵子树重新索引，
+// 这样上传到大目录时无需重新遍历其中未变化的子目录
+func (ix *nameIndex) refresh(p string) {
+	ix.mu.RLock()
+	if !ix.ready {
+		ix.mu.RUnlock()
+		return
+	}
+	root := filepath.Clean(baseDir)
+	// 递归创建等操作只通知最深的路径，从尚未索引的最上层目录开始补齐
+	for parent := filepath.Dir(p); p != root && parent != root && isWithin(root, parent); parent = filepath.Dir(p) {
+		if _, known := ix.entries[parent]; known {
+			break
+		}
+		p = parent
+	}
+	old, indexed := ix.entries[p]
+	if p == root {
+		old, indexed = indexEntry{isDir: true}, true
+	}
+	children := make(map[string]indexEntry)
+	if indexed && old.isDir {
+		for k, e := range ix.entries {
+			if filepath.Dir(k) == p && k != p {
+				children[k] = e
+			}
+		}
+	}
+	ix.mu.RUnlock()
+
+	var removed []string // 需要连同子树移除的路径
+	added := make(map[string]indexEntry)
+	ok := true
+	if info, err := os.Stat(p); indexed && old.isDir && err == nil && info.IsDir() {
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return
+		}
+		present := make(map[string]bool)
+		for _, entry := range entries {
+			if indexSkip(entry.Name()) {
+				continue
+			}
+			child := filepath.Join(p, entry.Name())
+			present[child] = true
+			if _, known := children[child]; known {
+				continue
+			}
+			found, childOK := indexTree(child, true, indexMaxEntries)
+			ok = ok && childOK
+			for k, e := range found {
+				added[k] = e
+			}
+		}
+		for k := range children {
+			if !present[k] {
+				removed = append(removed, k)
+			}
+		}
+	} else {
+		removed = append(removed, p)
+		added, ok = indexTree(p, true, indexMaxEntries)
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if !ix.ready {
+		return
+	}
+	for k := range ix.entries {
+		for _, rm := range removed {
+			if isWithin(rm, k) {
+				delete(ix.entries, k)
+				break
+			}
+		}
+	}
+	if !ok || len(ix.entries)+len(added) > indexMaxEntries {
+		ix.ready, ix.entries = false, nil
+		return
+	}
+	for k, e := range added {
+		ix.entries[k] = e
+	}
+}
+
+// lookup 在索引中查找 root 子树内文件名包含 query（已小写）的条目；索引未启用或为冷状态时 ok 为 false
+func (ix *nameIndex) lookup(root, query string) (paths []indexedPath, ok bool) {
+	if ix == nil {
+		return nil, false
+	}
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	if !ix.ready {
+		return nil, false
+	}
+	for k, e := range ix.entries {
+		if k != root && strings.Contains(e.lower, query) && isWithin(root, k) {
+			paths = append(paths, indexedPath{k, e.isDir})
+		}
+	}
+	return paths, true
+}
+
+// indexTree 遍历 root 收集文件名，includeRoot 为 true 时包含 root 本身；root 不存在时返回空结果，
+// 条目数超过 limit 或遍历出错时 ok 为 false
+func indexTree(root string, includeRoot bool, limit int) (found map[string]indexEntry, ok bool) {
+	found = make(map[string]indexEntry)
+	info, err := os.Stat(root)
+	if err != nil {
+		return found, errors.Is(err, fs.ErrNotExist)
+	}
+	if includeRoot {
+		if indexSkip(info.Name()) {
+			return found, true
+		}
+		found[root] = indexEntry{strings.ToLower(filepath.Base(root)), info.IsDir()}
+	}
+	if !info.IsDir() {
+		return found, true
+	}
+	err = walkTree(root, func(path string, info os.FileInfo) error {
+		if indexSkip(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if len(found) >= limit {
+			return errScanStop
+		}
+		found[path] = indexEntry{strings.ToLower(info.Name()), info.IsDir()}
+		return nil
+	})
+	return found, err == nil
+}
+
+// treeScanTimeout、maxTreeDepth /api/v1/tree 单次遍历的耗时与深度上限
+const (
+	treeScanTimeout = 10 * time.Second
+	maxTreeDepth    = 64
+)
+
+// treeNodeLimit /api/v1/tree 单次返回的节点数上限
+var treeNodeLimit = 50000
+
+// treeNode 目录树中的一个条目，只有展开了的目录才带有 children
+type treeNode struct {
+	Name     string      `json:"name"`
+	IsDir    bool        `json:"is_dir"`
+	Size     int64       `json:"size"`
+	ModTime  time.Time   `json:"mod_time"`
+	Children []*treeNode `json:"children,omitempty"`
+}
+
+// apiTreeHandler 以嵌套 JSON 返回 path 下深度不超过 depth（默认与最大值均为 64）的整棵目录树。
+// 节点数或耗时超过上限时返回已收集的部分并将 truncated 置为 true；符号链接环路由 walkTree 跳过
+func apiTreeHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	root, err := joinRequestPath(r, q.Get("path"))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "invalid_path", tr(r, "无效的目录"))
+		return
+	}
+	depth := maxTreeDepth
+	if v := q.Get("depth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			apiError(w, http.StatusBadRequest, "invalid_depth", tr(r, "无效的 depth"))
+			return
+		}
+		if n < depth {
+			depth = n
+		}
+	}
+	info, err := os.Stat(root)
+	if err != nil || !info.IsDir() {
+		apiError(w, http.StatusNotFound, "not_found", tr(r, "目录不存在"))
+		return
+	}
+
+	rel, _ := normalizeRelPath(q.Get("path"))
+	top := &treeNode{Name: path.Base(rel), IsDir: true, ModTime: info.ModTime(), Children: []*treeNode{}}
+	if rel == "" {
+		top.Name = ""
+	}
+	dirs := map[string]*treeNode{root: top}
+	nodes := 0
+	deadline := time.Now().Add(treeScanTimeout)
+	err = walkTree(root, func(path string, info os.FileInfo) error {
+		if r.Context().Err() != nil {
+			return r.Context().Err()
+		}
+		if strings.HasPrefix(info.Name(), ".hfs-") {
+			return nil
+		}
+		if nodes >= treeNodeLimit || time.Now().After(deadline) {
+			return errScanStop
+		}
+		parent := dirs[filepath.Dir(path)]
+		if parent == nil {
+			return nil
+		}
+		node := &treeNode{Name: info.Name(), IsDir: info.IsDir(), ModTime: info.ModTime()}
+		if !info.IsDir() {
+			node.Size = info.Size()
+		}
+		parent.Children = append(parent.Children, node)
+		nodes++
+		if info.IsDir() {
+			rel, _ := filepath.Rel(root, path)
+			if strings.Count(rel, string(filepath.Separator))+1 >= depth {
+				return filepath.SkipDir
+			}
+			node.Children = []*treeNode{}
+			dirs[path] = node
+		}
+		return nil
+	})
+	truncated := err == errScanStop
+	if err != nil && !truncated {
+		apiError(w, http.StatusInternalServerError, "walk_failed", tr(r, "无法遍历目录")+": "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":      rel,
+		"depth":     depth,
+		"nodes":     nodes,
+		"truncated": truncated,
+		"tree":      top,
+	})
+}
+
+// dirSignature 计算目录直接子项（名称、大小、修改时间）的摘要，用于检测变化
+func dirSignature(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%v\n", entry.Name(), info.Size(), info.ModTime().UnixNano(), info.IsDir())
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// dirWatch 同一目录的所有事件流订阅者共用的轮询，最后一个订阅者离开后停止
+type dirWatch struct {
+	subs map[chan string]struct{}
+}
+
+var (
+	dirWatches   = make(map[string]*dirWatch)
+	dirWatchesMu sync.Mutex
+)
+
+// watchDir 订阅 dir 的变化，sig 为调用方刚计算的目录摘要（仅在新建轮询时使用）。
+// 返回的通道收到 "change" 或 "gone"（目录已不可读，之后不再有事件），取消函数用于退订
+func watchDir(dir, sig string) (<-chan string, func()) {
+	ch := make(chan string, 1)
+	dirWatchesMu.Lock()
+	dw := dirWatches[dir]
+	if dw == nil {
+		dw = &dirWatch{subs: make(map[chan string]struct{})}
+		dirWatches[dir] = dw
+		go dw.poll(dir, sig)
+	}
+	dw.subs[ch] = struct{}{}
+	dirWatchesMu.Unlock()
+	return ch, func() {
+		dirWatchesMu.Lock()
+		delete(dw.subs, ch)
+		dirWatchesMu.Unlock()
+	}
+}
+
+// poll 定时比较目录摘要并通知所有订阅者；通道已有未读事件时合并为一次
+func (dw *dirWatch) poll(dir, last string) {
+	ticker := time.NewTicker(dirPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		dirWatchesMu.Lock()
+		if len(dw.subs) == 0 {
+			delete(dirWatches, dir)
+			dirWatchesMu.Unlock()
+			return
+		}
+		dirWatchesMu.Unlock()
+
+		event := ""
+		sig, err := dirSignature(dir)
+		if err != nil {
+			event = "gone"
+		} else if sig != last {
+			event = "change"
+			last = sig
+		}
+		if event == "" {
+			continue
+		}
+		// 服务外部的修改不经过 invalidateDirInfo，借轮询结果同步文件名索引
+		searchIndex.queue(dir)
+		dirWatchesMu.Lock()
+		for ch := range dw.subs {
+			select {
+			case ch <- event:
+			default:
+			}
+		}
+		if event == "gone" {
+			delete(dirWatches, dir)
+			dirWatchesMu.Unlock()
+			return
+		}
+		dirWatchesMu.Unlock()
+	}
+}
+
+// eventsHandler 以 Server-Sent Events 推送目录内容变化。
+// 为保持无外部依赖（不使用 fsnotify），每隔 -events-interval 比较一次目录摘要检测变化，
+// 同一目录的订阅者共用一个轮询；客户端断开后退订
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	clearWriteDeadline(w)
+	relDir := r.URL.Query().Get("path")
+	targetDir, err := joinRequestPath(r, relDir)
+	if err != nil {
+		httpError(w, r, "无效的目录", http.StatusBadRequest)
+		return
+	}
+	sig, err := dirSignature(targetDir)
+	if err != nil {
+		httpError(w, r, "无法读取目录", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, r, "不支持事件流", http.StatusInternalServerError)
+		return
+	}
+	events, stop := watchDir(targetDir, sig)
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(25 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			// 注释行保持连接，避免被代理判定为空闲
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case event := <-events:
+			fmt.Fprintf(w, "event: %s\ndata: {}\n\n", event)
+			flusher.Flush()
+			if event == "gone" {
+				return
+			}
+		}
+	}
+}
+
+// tailHandler 以 Server-Sent Events 实时推送文本文件新追加的内容：先发送末尾 kb KB（默认 16，最多 1024），
+// 之后每 500ms 检查文件是否增长。只发送完整的行，文件被截断（如日志轮转）时从头重新读取
+func tailHandler(w http.ResponseWriter, r *http.Request) {
+	clearWriteDeadline(w)
+	fileName := r.URL.Query().Get("file")
+	if fileName == "" {
+		httpError(w, r, "未指定文件", http.StatusBadRequest)
+		return
+	}
+	targetDir, err := joinRequestPath(r, r.URL.Query().Get("path"))
+	if err != nil {
+		httpError(w, r, "无效的路径", http.StatusBadRequest)
+		return
+	}
+	targetPath, err := secureJoin(targetDir, fileName)
+	if err != nil {
+		httpError(w, r, "无效的文件名", http.StatusBadRequest)
+		return
+	}
+	kb := int64(16)
+	if v := r.URL.Query().Get("kb"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			httpError(w, r, "无效的 kb", http.StatusBadRequest)
+			return
+		}
+		kb = min(n, 1024)
+	}
+
+	f, err := os.Open(targetPath)
+	if err != nil {
+		httpError(w, r, "文件不存在", http.StatusNotFound)
+		return
+	}
+	defer func() { f.Close() }()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		httpError(w, r, "只能查看普通文件", http.StatusBadRequest)
+		return
+	}
+	head := make([]byte, 512)
+	n, _ := f.ReadAt(head, 0)
+	if n > 0 && !strings.HasPrefix(http.DetectContentType(head[:n]), "text/") {
+		httpError(w, r, "不支持查看二进制文件", http.StatusUnsupportedMediaType)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, r, "不支持事件流", http.StatusInternalServerError)
+		return
+	}
+
+	offset := max(info.Size()-kb<<10, 0)
+	skipPartial := offset > 0 // 从中间开始时丢弃第一行的残余部分
+	var pending []byte
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	// send 读取 offset 之后新增的数据，以 append 事件发送其中完整的行
+	opened := info
+	send := func() bool {
+		info, err := os.Stat(targetPath)
+		if err != nil {
+			fmt.Fprint(w, "event: gone\ndata: {}\n\n")
+			flusher.Flush()
+			return false
+		}
+		if !os.SameFile(opened, info) {
+			// 文件被替换（轮转后重新创建），改为读取新文件
+			nf, err := os.Open(targetPath)
+			if err != nil {
+				return true
+			}
+			f.Close()
+			f, opened = nf, info
+			offset, pending, skipPartial = 0, nil, false
+			fmt.Fprint(w, "event: truncate\ndata: {}\n\n")
+		} else if info.Size() < offset {
+			offset, pending, skipPartial = 0, nil, false
+			fmt.Fprint(w, "event: truncate\ndata: {}\n\n")
+		}
+		buf := make([]byte, 64<<10)
+		for offset < info.Size() {
+			n, err := f.ReadAt(buf, offset)
+			offset += int64(n)
+			pending = append(pending, buf[:n]...)
+			if err != nil && err != io.EOF {
+				break
+			}
+			if n == 0 {
+				break
+			}
+		}
+		if skipPartial {
+			i := bytes.IndexByte(pending, '\n')
+			if i < 0 {
+				return true
+			}
+			pending, skipPartial = pending[i+1:], false
+		}
+		// 超长的行不再等待换行符，避免缓冲无限增长
+		end := bytes.LastIndexByte(pending, '\n') + 1
+		if end == 0 && len(pending) > 64<<10 {
+			end = len(pending)
+		}
+		if end > 0 {
+			data, _ := json.Marshal(map[string]string{"text": string(pending[:end])})
+			fmt.Fprintf(w, "event: append\ndata: %s\n\n", data)
+			pending = append([]byte(nil), pending[end:]...)
+		}
+		flusher.Flush()
+		return true
+	}
+	if !send() {
+		return
+	}
+
+	poll := time.NewTicker(500 * time.Millisecond)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(25 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case <-poll.C:
+			if !send() {
+				return
+			}
+		}
+	}
+}
+
+// fileOwner 通过 info.Sys() 中的 Uid/Gid 字段获取属主与属组（Unix 的 syscall.Stat_t），
+// Windows 等平台没有这些字段时 ok 为 false
+func fileOwner(info os.FileInfo) (uid, gid string, ok bool) {
+	v := reflect.ValueOf(info.Sys())
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return "", "", false
+	}
+	u, g := v.FieldByName("Uid"), v.FieldByName("Gid")
+	if !u.IsValid() || !g.IsValid() || !u.CanUint() || !g.CanUint() {
+		return "", "", false
+	}
+	return strconv.FormatUint(u.Uint(), 10), strconv.FormatUint(g.Uint(), 10), true
+}
+
+// statHandler 以 JSON 返回文件或目录的详细属性，符号链接同时给出链接目标与目标的属性
+func statHandler(w http.ResponseWriter, r *http.Request) {
+	fileName := r.URL.Query().Get("file")
+	if fileName == "" {
+		httpError(w, r, "未指定文件", http.StatusBadRequest)
+		return
+	}
+	targetDir, err := joinRequestPath(r, r.URL.Query().Get("path"))
+	if err != nil {
+		httpError(w, r, "无效的路径", http.StatusBadRequest)
+		return
+	}
+	targetPath, err := secureJoin(targetDir, fileName)
+	if err != nil {
+		httpError(w, r, "无效的文件名", http.StatusBadRequest)
+		return
+	}
+	info, err := os.Lstat(targetPath)
+	if err != nil {
+		httpError(w, r, "文件不存在", http.StatusNotFound)
+		return
+	}
+	result := map[string]interface{}{
+		"name":       info.Name(),
+		"is_symlink": info.Mode()&fs.ModeSymlink != 0,
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		result["link_target"], _ = os.Readlink(targetPath)
+		if target, err := os.Stat(targetPath); err == nil {
+			info = target
+		}
+	}
+	result["size"] = info.Size()
+	result["size_human"] = calculateFileSize(info.Size())
+	result["mod_time"] = info.ModTime()
+	result["mode"] = info.Mode().String()
+	result["perm"] = fmt.Sprintf("%04o", info.Mode().Perm())
+	result["is_dir"] = info.IsDir()
+	if uid, gid, ok := fileOwner(info); ok {
+		result["uid"], result["gid"] = uid, gid
+		if u, err := user.LookupId(uid); err == nil {
+			result["owner"] = u.Username
+		}
+		if g, err := user.LookupGroupId(gid); err == nil {
+			result["group"] = g.Name
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(result)
+}
+
+// chmodHandler 修改文件或目录的权限位，mode 为八进制字符串（如 0755），仅允许 0000–0777
+func chmodHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "仅支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+	if runtime.GOOS == "windows" {
+		httpError(w, r, "Windows 不支持修改 Unix 权限位", http.StatusNotImplemented)
+		return
+	}
+	r.ParseForm()
+	fileName := r.FormValue("file")
+	if fileName == "" {
+		httpError(w, r, "未指定文件", http.StatusBadRequest)
+		return
+	}
+	mode, err := strconv.ParseUint(r.FormValue("mode"), 8, 32)
+	if err != nil || mode > 0777 {
+		httpError(w, r, "无效的权限，应为 0000 到 0777 之间的八进制数", http.StatusBadRequest)
+		return
+	}
+	targetDir, err := joinRequestPath(r, r.FormValue("path"))
+	if err != nil {
+		httpError(w, r, "无效的路径", http.StatusBadRequest)
+		return
+	}
+	targetPath, err := secureJoin(targetDir, fileName)
+	if err != nil {
+		httpError(w, r, "无效的文件名", http.StatusBadRequest)
+		return
+	}
+	defer pathLocks.Lock(targetPath)()
+	if _, err := os.Lstat(targetPath); err != nil {
+		httpError(w, r, "文件不存在", http.StatusNotFound)
+		return
+	}
+	err = os.Chmod(targetPath, os.FileMode(mode))
+	auditLog(r, "chmod", targetPath, fmt.Sprintf("%04o", mode), err)
+	if err != nil {
+		httpError(w, r, "修改权限失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, tr(r, "权限已修改"))
+}
+
+// truncateHandler 创建指定大小的文件或调整已有文件的大小（支持时为稀疏文件），
+// 大小不得超过 -max-upload-size。由 resizeFile 原地调整，不影响指向原文件的其他硬链接
+func truncateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "仅支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	fileName := r.FormValue("file")
+	if err := validateName(fileName); err != nil {
+		httpError(w, r, "无效的文件名: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	size, err := strconv.ParseInt(r.FormValue("size"), 10, 64)
+	if err != nil || size < 0 {
+		httpError(w, r, "无效的 size", http.StatusBadRequest)
+		return
+	}
+	if maxUploadSize > 0 && size > maxUploadSize {
+		http.Error(w, fmt.Sprintf(tr(r, "文件超过上传大小限制（%s）"), calculateFileSize(maxUploadSize)), http.StatusRequestEntityTooLarge)
+		return
+	}
+	targetDir, err := joinRequestPath(r, r.FormValue("path"))
+	if err != nil {
+		httpError(w, r, "无效的路径", http.StatusBadRequest)
+		return
+	}
+	targetPath, err := secureJoin(targetDir, fileName)
+	if err != nil {
+		httpError(w, r, "无效的文件名", http.StatusBadRequest)
+		return
+	}
+	if !uploadAllowed(r, targetDir) {
+		httpError(w, r, "不允许修改该目录中的文件", http.StatusForbidden)
+		return
+	}
+	if err := checkPathLength(targetPath); err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer pathLocks.Lock(targetPath)()
+	if info, err := os.Stat(targetPath); err == nil && !info.Mode().IsRegular() {
+		httpError(w, r, "只能调整普通文件的大小", http.StatusBadRequest)
+		return
+	}
+	err = resizeFile(targetPath, size)
+	auditLog(r, "truncate", targetPath, strconv.FormatInt(size, 10), err)
+	if err != nil {
+		httpError(w, r, "调整文件大小失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	invalidateDirInfo(targetPath)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":       fileName,
+		"size":       size,
+		"size_human": calculateFileSize(size),
+	})
+}
+
+// errPrivateAddress 远程地址位于内网等受限网段
+var errPrivateAddress = errors.New("禁止访问内网或本机地址")
+
+// blockedFetchIP 判断 /fetch-url 是否应拒绝连接该地址
+func blockedFetchIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// fetchClient 返回 /fetch-url 使用的 HTTP 客户端；未启用 -allow-private-fetch 时在建立连接前
+// 检查实际连接的 IP，DNS 解析结果与重定向后的地址同样受限
+func fetchClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			if allowPrivateFetch {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || blockedFetchIP(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		},
 	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+	return &http.Client{Timeout: fetchTimeout, Transport: transport}
+}
 
-	switch sortType {
-	case "name":
-		if order == "asc" {
-			sort.Slice(files, func(i, j int) bool {
-				return strings.ToLower(files[i].Name) < strings.ToLower(files[j].Name)
-			})
-		} else {
-			sort.Slice(files, func(i, j int) bool {
-				return strings.ToLower(files[i].Name) > strings.ToLower(files[j].Name)
-			})
-		}
-	case "time":
-		if order == "asc" {
-			sort.Slice(files, func(i, j int) bool {
-				return files[i].ModTime.Before(files[j].ModTime)
-			})
-		} else {
-			sort.Slice(files, func(i, j int) bool {
-				return files[i].ModTime.After(files[j].ModTime)
-			})
+// fetchFileName 推断远程文件的保存名称：优先使用响应的 Content-Disposition，其次为 URL 路径的最后一段，
+// 都没有时按 Content-Type 取扩展名
+func fetchFileName(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		return filepath.Base(params["filename"])
+	}
+	if base := path.Base(resp.Request.URL.Path); base != "/" && base != "." {
+		return base
+	}
+	name := "download"
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+			name += exts[0]
 		}
-	case "size":
-		if order == "asc" {
-			sort.Slice(files, func(i, j int) bool {
-				return files[i].RawSize < files[j].RawSize
-			})
-		} else {
-			sort.Slice(files, func(i, j int) bool {
-				return files[i].RawSize > files[j].RawSize
-			})
+	}
+	return name
+}
+
+// fetchURLHandler 将远程 URL 的内容下载到 path 目录中，name 为空时自动推断文件名。
+// 仅支持 http/https，大小不超过 -max-upload-size，先写入临时文件再重命名，目标已存在时返回 409
+func fetchURLHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "仅支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	u, err := url.Parse(r.FormValue("url"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		httpError(w, r, "无效的 URL（仅支持 http 与 https）", http.StatusBadRequest)
+		return
+	}
+	destDir, err := joinRequestPath(r, r.FormValue("path"))
+	if err != nil {
+		httpError(w, r, "无效的路径", http.StatusBadRequest)
+		return
+	}
+	if !uploadAllowed(r, destDir) {
+		httpError(w, r, "不允许上传到该目录", http.StatusForbidden)
+		return
+	}
+	clearReadDeadline(w)
+	clearWriteDeadline(w)
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, u.String(), nil)
+	if err != nil {
+		httpError(w, r, "无效的 URL（仅支持 http 与 https）", http.StatusBadRequest)
+		return
+	}
+	resp, err := fetchClient().Do(req)
+	if err != nil {
+		if errors.Is(err, errPrivateAddress) {
+			httpError(w, r, errPrivateAddress.Error(), http.StatusForbidden)
+			return
 		}
+		httpError(w, r, "下载远程文件失败: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		httpError(w, r, "下载远程文件失败: "+resp.Status, http.StatusBadGateway)
+		return
+	}
+	if maxUploadSize > 0 && resp.ContentLength > maxUploadSize {
+		http.Error(w, fmt.Sprintf(tr(r, "文件超过上传大小限制（%s）"), calculateFileSize(maxUploadSize)), http.StatusRequestEntityTooLarge)
+		return
 	}
 
-	breadcrumbs := []Breadcrumb{{Name: "根目录", Path: ""}}
-	if relDir != "" {
-		parts := strings.Split(relDir, "/")
-		var cumulative string
+	name := r.FormValue("name")
+	if name == "" {
+		name = fetchFileName(resp)
+	}
+	if err := validateName(name); err != nil {
+		httpError(w, r, "无效的文件名: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	targetPath, err := secureJoin(destDir, name)
+	if err != nil {
+		httpError(w, r, "无效的文件名", http.StatusBadRequest)
+		return
+	}
+	if err := checkPathLength(targetPath); err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmp, err := os.CreateTemp(destDir, ".hfs-tmp-*")
+	if err != nil {
+		httpError(w, r, "无法创建临时文件", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	body := io.Reader(resp.Body)
+	if maxUploadSize > 0 {
+		body = io.LimitReader(resp.Body, maxUploadSize+1)
+	}
+	n, err := io.Copy(tmp, body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		httpError(w, r, "下载远程文件失败: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	if maxUploadSize > 0 && n > maxUploadSize {
+		http.Error(w, fmt.Sprintf(tr(r, "文件超过上传大小限制（%s）"), calculateFileSize(maxUploadSize)), http.StatusRequestEntityTooLarge)
+		return
+	}
+	os.Chmod(tmp.Name(), 0644)
+
+	defer pathLocks.Lock(targetPath)()
+	if _, err := os.Lstat(targetPath); err == nil {
+		httpError(w, r, "目标目录中已存在 "+name, http.StatusConflict)
+		return
+	}
+	err = os.Rename(tmp.Name(), targetPath)
+	auditLog(r, "fetch", u.Redacted(), targetPath, err)
+	if err != nil {
+		httpError(w, r, "无法保存文件", http.StatusInternalServerError)
+		return
+	}
+	invalidateDirInfo(targetPath)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":       name,
+		"size":       n,
+		"size_human": calculateFileSize(n),
+	})
+}
+
+// skippedEntry 解压时被跳过的条目及原因
+type skippedEntry struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// extractZip 将 zip 文件解压到 destDir。绝对路径、含 .. 的条目、非法文件名与符号链接会被跳过，
+// 每个条目的目标路径再经 secureJoin 校验以防止 zip-slip；已存在的文件不会被覆盖。
+// 设置了 -max-upload-size 时解压后的总大小不得超过该限制
+func extractZip(zipPath, destDir string) (extracted []string, skipped []skippedEntry, err error) {
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zr.Close()
+
+	var total int64
+	for _, f := range zr.File {
+		name := strings.ReplaceAll(f.Name, "\\", "/")
+		skip := func(reason string) { skipped = append(skipped, skippedEntry{Name: f.Name, Reason: reason}) }
+		if strings.HasPrefix(name, "/") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+			skip("绝对路径")
+			continue
+		}
+		parts := strings.Split(strings.Trim(name, "/"), "/")
+		invalid := ""
 		for _, part := range parts {
-			if part == "" {
+			if part == ".." {
+				invalid = "路径包含 .."
+				break
+			}
+			if err := validateName(part); err != nil {
+				invalid = "非法文件名: " + err.Error()
+				break
+			}
+		}
+		if invalid != "" {
+			skip(invalid)
+			continue
+		}
+		target, err := secureJoin(destDir, filepath.Join(parts...))
+		if err != nil {
+			skip("路径越界")
+			continue
+		}
+		if err := checkPathLength(target); err != nil {
+			skip(err.Error())
+			continue
+		}
+		mode := f.Mode()
+		if mode&fs.ModeSymlink != 0 {
+			skip("不解压符号链接")
+			continue
+		}
+		if mode.IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				skip(err.Error())
 				continue
 			}
-			if cumulative == "" {
-				cumulative = part
+			extracted = append(extracted, strings.Join(parts, "/")+"/")
+			continue
+		}
+		if maxUploadSize > 0 && total+int64(f.UncompressedSize64) > maxUploadSize {
+			return extracted, skipped, fmt.Errorf("解压后的大小超过上传大小限制（%s）", calculateFileSize(maxUploadSize))
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			skip(err.Error())
+			continue
+		}
+		n, err := extractZipEntry(f, target)
+		total += n
+		if err != nil {
+			if os.IsExist(err) {
+				skip("文件已存在")
 			} else {
-				cumulative = cumulative + "/" + part
+				skip(err.Error())
 			}
-			breadcrumbs = append(breadcrumbs, Breadcrumb{
-				Name: part,
-				Path: cumulative,
-			})
+			continue
 		}
+		extracted = append(extracted, strings.Join(parts, "/"))
 	}
+	return extracted, skipped, nil
+}
 
-	data := PageData{
-		Files:       files,
-		Breadcrumbs: breadcrumbs,
-		CurrentPath: relDir,
-		Sort:        sortType,
-		Order:       order,
-		Username:    username,
+// extractZipEntry 将单个 zip 条目写入 target（不覆盖已有文件），写入量以条目声明的大小为上限
+func extractZipEntry(f *zip.File, target string) (int64, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, io.LimitReader(rc, int64(f.UncompressedSize64)))
+	if cerr := out.Close(); err == nil {
+		err = cerr
 	}
+	if err != nil {
+		os.Remove(target)
+		return n, err
+	}
+	os.Chtimes(target, time.Now(), f.Modified)
+	return n, nil
+}
 
-	funcMap := template.FuncMap{
-		"sub": func(a, b int) int { return a - b },
-		"split": func(s, sep string) []string {
-			return strings.Split(s, sep)
-		},
-		"toggle": func(currentSort, currentOrder, target string) string {
-			if currentSort == target {
-				if currentOrder == "asc" {
-					return "desc"
-				}
-				return "asc"
-			}
-			return "asc"
-		},
+// extractHandler 将 path 下的 zip 文件 file 解压到 dest 目录（默认为 zip 所在目录），返回 JSON 摘要
+func extractHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "仅支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	fileName := r.FormValue("file")
+	if fileName == "" {
+		httpError(w, r, "未指定文件", http.StatusBadRequest)
+		return
+	}
+	relDir := r.FormValue("path")
+	srcDir, err := joinRequestPath(r, relDir)
+	if err != nil {
+		httpError(w, r, "无效的路径", http.StatusBadRequest)
+		return
+	}
+	zipPath, err := secureJoin(srcDir, fileName)
+	if err != nil {
+		httpError(w, r, "无效的文件名", http.StatusBadRequest)
+		return
+	}
+	destRel := relDir
+	if r.Form.Has("dest") {
+		destRel = r.FormValue("dest")
+	}
+	destDir, err := joinRequestPath(r, destRel)
+	if err != nil {
+		httpError(w, r, "无效的目标目录", http.StatusBadRequest)
+		return
+	}
+	if info, err := os.Stat(destDir); err != nil || !info.IsDir() {
+		httpError(w, r, "目标目录不存在", http.StatusBadRequest)
+		return
+	}
+	if !uploadAllowed(r, destDir) {
+		httpError(w, r, "不允许在该目录中创建", http.StatusForbidden)
+		return
 	}
 
-	tmpl := template.Must(template.New("main").Funcs(funcMap).Parse(combinedTemplate))
-	tmpl.Execute(w, data)
-	runtime.GC()
+	unlock := pathLocks.Lock(destDir)
+	extracted, skipped, err := extractZip(zipPath, destDir)
+	unlock()
+	auditLog(r, "extract", zipPath, destDir, err)
+	invalidateDirInfo(destDir)
+	if err != nil && len(extracted) == 0 {
+		httpError(w, r, "解压失败: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if extracted == nil {
+		extracted = []string{}
+	}
+	if skipped == nil {
+		skipped = []skippedEntry{}
+	}
+	result := map[string]interface{}{
+		"extracted": extracted,
+		"skipped":   skipped,
+	}
+	if err != nil {
+		result["error"] = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
 }
 
-// listHandler 返回仅文件列表部分（用于 AJAX 局部刷新）
-func listHandler(w http.ResponseWriter, r *http.Request) {
-	relDir := r.URL.Query().Get("path")
-	sortType := r.URL.Query().Get("sort")
-	if sortType != "time" && sortType != "size" {
-		sortType = "name"
+// compressDir 将目录 src 打包为 zip 写入 w，条目以目录名为顶层；符号链接（未启用 -follow-symlinks 时）等非普通文件会被忽略
+func compressDir(src string, w io.Writer) (int, error) {
+	zw := zip.NewWriter(w)
+	parent := filepath.Dir(src)
+	count := 0
+	add := func(path string, info os.FileInfo) error {
+		rel, err := filepath.Rel(parent, path)
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+			_, err = zw.CreateHeader(header)
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		header.Method = zip.Deflate
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.Copy(entry, f); err != nil {
+			return err
+		}
+		count++
+		return nil
 	}
-	order := r.URL.Query().Get("order")
-	if order != "asc" && order != "desc" {
-		if sortType == "time" {
-			order = "desc"
-		} else {
-			order = "asc"
+	if err := walkArchive(src, add); err != nil {
+		return 0, err
+	}
+	return count, zw.Close()
+}
+
+// walkArchive 依次对 root 自身（跟随符号链接）及其下所有条目调用 fn，供打包 zip/tar 使用；root 可以是文件
+func walkArchive(root string, fn func(path string, info os.FileInfo) error) error {
+	rootInfo, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if err := fn(root, rootInfo); err != nil || !rootInfo.IsDir() {
+		return err
+	}
+	return walkTree(root, fn)
+}
+
+// writeTarGz 将 dir 下的 names 条目（文件或目录，递归）以 tar.gz 格式写入 w，保留权限、修改时间与符号链接
+func writeTarGz(w io.Writer, dir string, names []string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	add := func(path string, info os.FileInfo) error {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		} else if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
 		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	}
+	for _, name := range names {
+		if err := walkArchive(filepath.Join(dir, name), add); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// downloadTarHandler 将 path 目录下的一个或多个 name 条目流式打包为 tar.gz 下载
+func downloadTarHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	names := q["name"]
+	if len(names) == 0 {
+		httpError(w, r, "未指定要下载的条目", http.StatusBadRequest)
+		return
+	}
+	dir, err := joinRequestPath(r, q.Get("path"))
+	if err != nil {
+		httpError(w, r, "无效的路径", http.StatusBadRequest)
+		return
+	}
+	for _, name := range names {
+		p, err := secureJoin(dir, name)
+		if err != nil || filepath.Dir(p) != dir {
+			httpError(w, r, "无效的名称: "+name, http.StatusBadRequest)
+			return
+		}
+		if _, err := os.Lstat(p); err != nil {
+			httpError(w, r, "文件不存在: "+name, http.StatusNotFound)
+			return
+		}
+	}
+	archiveName := filepath.Base(dir) + ".tar.gz"
+	if len(names) == 1 {
+		archiveName = names[0] + ".tar.gz"
+	}
+	clearWriteDeadline(w)
+	w = idleWriter(w)
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", archiveName))
+	// 响应头已发出，打包中途出错只能中断传输并记录日志
+	if err := writeTarGz(w, dir, names); err != nil {
+		fmt.Printf("打包 %s 失败: %v\n", relToBase(dir), err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// compressHandler 将 path 下的目录 name 压缩为 dest 目录（默认同目录）中的 output（默认 name.zip），
+// 先写入临时文件再重命名；输出位置不能位于被压缩的目录内，同名文件已存在时返回 409
+func compressHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "仅支持POST方法", http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	name := r.FormValue("name")
+	if name == "" {
+		httpError(w, r, "未指定目录", http.StatusBadRequest)
+		return
+	}
+	relDir := r.FormValue("path")
+	srcDir, err := joinRequestPath(r, relDir)
+	if err != nil {
+		httpError(w, r, "无效的路径", http.StatusBadRequest)
+		return
+	}
+	srcPath, err := secureJoin(srcDir, name)
+	if err != nil {
+		httpError(w, r, "无效的名称", http.StatusBadRequest)
+		return
+	}
+	if info, err := os.Stat(srcPath); err != nil || !info.IsDir() {
+		httpError(w, r, "只能压缩文件夹", http.StatusBadRequest)
+		return
+	}
+	destRel := relDir
+	if r.Form.Has("dest") {
+		destRel = r.FormValue("dest")
+	}
+	destDir, err := joinRequestPath(r, destRel)
+	if err != nil {
+		httpError(w, r, "无效的目标目录", http.StatusBadRequest)
+		return
+	}
+	if isWithin(srcPath, destDir) {
+		httpError(w, r, "不能将压缩文件保存到被压缩的文件夹内", http.StatusBadRequest)
+		return
+	}
+	if !uploadAllowed(r, destDir) {
+		httpError(w, r, "不允许在该目录中创建", http.StatusForbidden)
+		return
+	}
+	output := r.FormValue("output")
+	if output == "" {
+		output = filepath.Base(srcPath) + ".zip"
+	} else if !strings.HasSuffix(strings.ToLower(output), ".zip") {
+		output += ".zip"
+	}
+	if err := validateName(output); err != nil {
+		httpError(w, r, "无效的文件名: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	outPath, err := secureJoin(destDir, output)
+	if err != nil {
+		httpError(w, r, "无效的文件名", http.StatusBadRequest)
+		return
+	}
+	if err := checkPathLength(outPath); err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	defer pathLocks.Lock(outPath)()
+	if _, err := os.Lstat(outPath); err == nil {
+		httpError(w, r, "目标目录中已存在 "+output, http.StatusConflict)
+		return
+	}
+	tmp, err := os.CreateTemp(destDir, ".hfs-tmp-*")
+	if err != nil {
+		httpError(w, r, "无法创建临时文件", http.StatusInternalServerError)
+		return
+	}
+	count, err := compressDir(srcPath, tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		os.Chmod(tmp.Name(), 0644)
+		err = os.Rename(tmp.Name(), outPath)
 	}
-	currentDir, err := secureJoin(baseDir, relDir)
+	auditLog(r, "compress", srcPath, outPath, err)
 	if err != nil {
-		http.Error(w, "无效的目录", http.StatusBadRequest)
+		os.Remove(tmp.Name())
+		httpError(w, r, "压缩失败: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	invalidateDirInfo(outPath)
+	info, _ := os.Stat(outPath)
+	var size int64
+	if info != nil {
+		size = info.Size()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":       output,
+		"files":      count,
+		"size":       size,
+		"size_human": calculateFileSize(size),
+	})
+}
 
-	dirMu.Lock()
-	entries, err := os.ReadDir(currentDir)
-	dirMu.Unlock()
+// copyPath 递归复制文件或目录；未启用 -follow-symlinks 时符号链接按链接本身复制，
+// 启用时进入链接目录，指向正在复制的祖先目录或已创建的目标目录的链接会被跳过，避免环路无限复制。
+// 启用 -copy-preserve 时复制后保留源文件与目录的权限位和修改时间（不受 umask 影响）
+func copyPath(src, dst string) error {
+	return copyTree(src, dst, nil)
+}
+
+// copyTree 为 copyPath 的递归实现，ancestors 为当前路径上的源目录与对应创建的目标目录
+func copyTree(src, dst string, ancestors []os.FileInfo) error {
+	info, err := os.Lstat(src)
 	if err != nil {
-		http.Error(w, "无法读取目录", http.StatusInternalServerError)
-		return
+		return err
 	}
-
-	var files []FileInfo
-	for _, entry := range entries {
-		info, err := entry.Info()
-		if err != nil {
-			continue
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if !followSymlinks {
+			target, err := os.Readlink(src)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dst)
 		}
-		sizeStr := ""
-		rawSize := int64(0)
-		if !entry.IsDir() {
-			rawSize = info.Size()
-			sizeStr = calculateFileSize(rawSize)
+		if info, err = os.Stat(src); err != nil {
+			return err
 		}
-		files = append(files, FileInfo{
-			Name:       entry.Name(),
-			Size:       sizeStr,
-			RawSize:    rawSize,
-			UploadDate: info.ModTime().Format("2006-01-02 15:04:05"),
-			ModTime:    info.ModTime(),
-			IsDir:      entry.IsDir(),
-		})
 	}
-
-	switch sortType {
-	case "name":
-		if order == "asc" {
-			sort.Slice(files, func(i, j int) bool {
-				return strings.ToLower(files[i].Name) < strings.ToLower(files[j].Name)
-			})
-		} else {
-			sort.Slice(files, func(i, j int) bool {
-				return strings.ToLower(files[i].Name) > strings.ToLower(files[j].Name)
-			})
+	if info.IsDir() {
+		for _, a := range ancestors {
+			if os.SameFile(a, info) {
+				return nil
+			}
 		}
-	case "time":
-		if order == "asc" {
-			sort.Slice(files, func(i, j int) bool {
-				return files[i].ModTime.Before(files[j].ModTime)
-			})
-		} else {
-			sort.Slice(files, func(i, j int) bool {
-				return files[i].ModTime.After(files[j].ModTime)
-			})
+		perm := info.Mode().Perm()
+		if copyPreserve {
+			// 先以可写权限创建，复制完子项后再设置源目录的权限，避免只读目录无法写入
+			perm = 0700
 		}
-	case "size":
-		if order == "asc" {
-			sort.Slice(files, func(i, j int) bool {
-				return files[i].RawSize < files[j].RawSize
-			})
-		} else {
-			sort.Slice(files, func(i, j int) bool {
-				return files[i].RawSize > files[j].RawSize
-			})
+		if err := os.Mkdir(dst, perm); err != nil {
+			return err
 		}
-	}
-
-	breadcrumbs := []Breadcrumb{{Name: "根目录", Path: ""}}
-	if relDir != "" {
-		parts := strings.Split(relDir, "/")
-		var cumulative string
-		for _, part := range parts {
-			if part == "" {
-				continue
-			}
-			if cumulative == "" {
-				cumulative = part
-			} else {
-				cumulative = cumulative + "/" + part
+		dstInfo, err := os.Stat(dst)
+		if err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		sub := append(append([]os.FileInfo{}, ancestors...), info, dstInfo)
+		for _, entry := range entries {
+			if err := copyTree(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name()), sub); err != nil {
+				return err
 			}
-			breadcrumbs = append(breadcrumbs, Breadcrumb{
-				Name: part,
-				Path: cumulative,
-			})
 		}
+		// 创建子项会更新目录的修改时间，因此在最后设置
+		return preserveAttrs(dst, info)
 	}
-
-	data := PageData{
-		Files:       files,
-		Breadcrumbs: breadcrumbs,
-		CurrentPath: relDir,
-		Sort:        sortType,
-		Order:       order,
+	in, err := os.Open(src)
+	if err != nil {
+		return err
 	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return preserveAttrs(dst, info)
+}
 
-	funcMap := template.FuncMap{
-		"sub": func(a, b int) int { return a - b },
-		"split": func(s, sep string) []string {
-			return strings.Split(s, sep)
-		},
-		"toggle": func(currentSort, currentOrder, target string) string {
-			if currentSort == target {
-				if currentOrder == "asc" {
-					return "desc"
-				}
-				return "asc"
-			}
-			return "asc"
-		},
+// renamePath 与 os.Rename 相同，但源与目标位于不同文件系统（EXDEV，如 bind mount）时改用 moveAcrossDevices
+func renamePath(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
 	}
+	return moveAcrossDevices(src, dst)
+}
 
-	tmpl := template.Must(template.New("main").Funcs(funcMap).Parse(combinedTemplate))
-	tmpl.ExecuteTemplate(w, "fileList", data)
-	runtime.GC()
+// moveAcrossDevices 先把 src 复制到目标目录中的临时名称（按 -copy-preserve 保留权限与修改时间），
+// 再重命名到 dst，最后删除源。目标只会在复制完整后出现；删除源失败时返回错误，此时源与目标同时存在
+func moveAcrossDevices(src, dst string) error {
+	tmp := filepath.Join(filepath.Dir(dst), fmt.Sprintf(".hfs-tmp-%d", time.Now().UnixNano()))
+	if err := copyPath(src, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	return os.RemoveAll(src)
 }
 
-// fileUploadHandler 保存上传的文件到指定目录
-func fileUploadHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "仅支持POST方法", http.StatusMethodNotAllowed)
-		return
+// preserveAttrs 启用 -copy-preserve 时将 info 的权限位与修改时间应用到 dst
+func preserveAttrs(dst string, info os.FileInfo) error {
+	if !copyPreserve {
+		return nil
 	}
-	if err := r.ParseMultipartForm(10 << 20); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
+		return err
 	}
-	relDir := r.URL.Query().Get("path")
-	targetDir, err := secureJoin(baseDir, relDir)
-	if err != nil {
-		http.Error(w, "无效的路径", http.StatusBadRequest)
-		return
+	return os.Chtimes(dst, time.Now(), info.ModTime())
+}
+
+// maxUniqueNameTries uniqueName 最多尝试的编号
+const maxUniqueNameTries = 1000
+
+// uniqueName 返回 dir 中尚未被占用的名称：依次尝试 name、"name (1).ext"、"name (2).ext"……
+// 编号插在扩展名之前。只做检查不加锁，需要据此创建文件时使用 lockUniquePath
+func uniqueName(dir, name string) (string, error) {
+	ext := filepath.Ext(name)
+	if ext == name {
+		// .bashrc 这类以点开头且没有其他点的名称视为没有扩展名
+		ext = ""
 	}
-	filesUploaded := r.MultipartForm.File["files[]"]
-	dirMu.Lock()
-	defer dirMu.Unlock()
-	for _, fileHeader := range filesUploaded {
-		file, err := fileHeader.Open()
-		if err != nil {
-			http.Error(w, "无法打开文件", http.StatusBadRequest)
-			return
+	stem := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 1; i <= maxUniqueNameTries; i++ {
+		if _, err := os.Lstat(filepath.Join(dir, candidate)); os.IsNotExist(err) {
+			return candidate, nil
 		}
-		defer file.Close()
-		targetPath, err := secureJoin(targetDir, fileHeader.Filename)
+		candidate = fmt.Sprintf("%s (%d)%s", stem, i, ext)
+	}
+	return "", fmt.Errorf("无法生成不重复的名称")
+}
+
+// lockUniquePath 通过 uniqueName 选出 dir 中不冲突的路径并加写锁（连同 also 中的路径），
+// 加锁后再次确认该路径仍未被占用，避免与并发的同名上传、复制竞争
+func lockUniquePath(dir, name string, also ...string) (string, func(), error) {
+	for attempt := 0; attempt < maxUniqueNameTries; attempt++ {
+		candidate, err := uniqueName(dir, name)
 		if err != nil {
-			http.Error(w, "非法文件名", http.StatusBadRequest)
-			return
+			return "", nil, err
 		}
-		out, err := os.Create(targetPath)
-		if err != nil {
-			http.Error(w, "无法创建文件", http.StatusInternalServerError)
-			return
+		p := filepath.Join(dir, candidate)
+		if err := checkPathLength(p); err != nil {
+			return "", nil, err
 		}
-		_, err = io.Copy(out, file)
-		out.Close()
-		if err != nil {
-			http.Error(w, "无法保存文件", http.StatusInternalServerError)
-			return
+		unlock := pathLocks.Lock(append([]string{p}, also...)...)
+		if _, err := os.Lstat(p); os.IsNotExist(err) {
+			return p, unlock, nil
 		}
+		unlock()
 	}
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, "文件上传成功")
+	return "", nil, fmt.Errorf("无法生成不重复的名称")
 }
 
-// fileDownloadHandler 处理文件下载请求，支持断点续传和多线程下载
-func fileDownloadHandler(w http.ResponseWriter, r *http.Request) {
-	fileName := r.URL.Query().Get("file")
-	relDir := r.URL.Query().Get("path")
-	if fileName == "" {
-		http.Error(w, "未指定文件", http.StatusBadRequest)
-		return
+// transferTarget 解析移动/复制请求：path 下的 name 移动或复制到 dest 目录
+func transferTarget(r *http.Request) (srcPath, destDir, name string, err error) {
+	name = r.FormValue("name")
+	if err := validateName(name); err != nil {
+		return "", "", "", err
 	}
-	targetDir, err := secureJoin(baseDir, relDir)
+	srcDir, err := joinRequestPath(r, r.FormValue("path"))
 	if err != nil {
-		http.Error(w, "无效的路径", http.StatusBadRequest)
-		return
+		return "", "", "", fmt.Errorf("无效的源路径")
 	}
-	targetPath, err := secureJoin(targetDir, fileName)
+	srcPath, err = secureJoin(srcDir, name)
+	if err != nil {
+		return "", "", "", fmt.Errorf("无效的源路径")
+	}
+	destDir, err = joinRequestPath(r, r.FormValue("dest"))
 	if err != nil {
-		http.Error(w, "无效的文件名", http.StatusBadRequest)
+		return "", "", "", fmt.Errorf("无效的目标路径")
+	}
+	if info, err := os.Stat(destDir); err != nil || !info.IsDir() {
+		return "", "", "", fmt.Errorf("目标目录不存在")
+	}
+	// 不能把目录移动或复制到它自身或其子目录中
+	if isWithin(srcPath, destDir) {
+		return "", "", "", fmt.Errorf("不能移动或复制到自身或其子目录")
+	}
+	if err := checkTreeLength(srcPath, filepath.Join(destDir, name)); err != nil {
+		return "", "", "", err
+	}
+	return srcPath, destDir, name, nil
+}
+
+// moveHandler 将文件或目录移动到另一个目录，目标已存在同名条目时返回409
+func moveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "仅支持POST方法", http.StatusMethodNotAllowed)
 		return
 	}
-	info, err := os.Stat(targetPath)
+	r.ParseForm()
+	srcPath, destDir, name, err := transferTarget(r)
 	if err != nil {
-		http.Error(w, "文件不存在", http.StatusNotFound)
+		httpError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if info.IsDir() {
-		http.Error(w, "无法下载文件夹", http.StatusBadRequest)
+	if !uploadAllowed(r, destDir) {
+		httpError(w, r, "不允许在该目录中创建", http.StatusForbidden)
 		return
 	}
-
-	f, err := os.Open(targetPath)
+	destPath := filepath.Join(destDir, name)
+	defer pathLocks.Lock(srcPath, destPath)()
+	if _, err := os.Lstat(srcPath); err != nil {
+		httpError(w, r, "源文件不存在", http.StatusNotFound)
+		return
+	}
+	if destPath == srcPath {
+		// 移动到原目录视为无操作
+		fmt.Fprint(w, tr(r, "移动成功"))
+		return
+	}
+	if _, err := os.Lstat(destPath); err == nil {
+		httpError(w, r, "目标目录中已存在 "+name, http.StatusConflict)
+		return
+	}
+	err = renamePath(srcPath, destPath)
+	auditLog(r, "move", srcPath, destPath, err)
 	if err != nil {
-		http.Error(w, "无法打开文件", http.StatusInternalServerError)
+		httpError(w, r, "移动失败: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer f.Close()
-
-	fileSize := info.Size()
-
-	// 设置支持断点续传的响应头
-	w.Header().Set("Accept-Ranges", "bytes")
-	w.Header().Set("Content-Disposition", "attachment; filename=\""+info.Name()+"\"")
-	w.Header().Set("Content-Type", "application/octet-stream")
+	invalidateDirInfo(srcPath)
+	invalidateDirInfo(destPath)
+	fmt.Fprint(w, tr(r, "移动成功"))
+}
 
-	// 检查是否有Range请求头（断点续传）
-	rangeHeader := r.Header.Get("Range")
-	if rangeHeader == "" {
-		// 完整文件下载
-		w.Header().Set("Content-Length", strconv.FormatInt(fileSize, 10))
-		w.WriteHeader(http.StatusOK)
-		io.Copy(w, f)
+// copyHandler 将文件或目录复制到另一个目录，目标已存在同名条目时自动添加 " (n)" 后缀
+func copyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "仅支持POST方法", http.StatusMethodNotAllowed)
 		return
 	}
-
-	// 解析Range请求头
-	ranges, err := parseRange(rangeHeader, fileSize)
+	r.ParseForm()
+	srcPath, destDir, name, err := transferTarget(r)
 	if err != nil {
-		http.Error(w, "无效的Range请求", http.StatusRequestedRangeNotSatisfiable)
+		httpError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	// 目前只支持单个范围请求（多线程下载时客户端会发送多个单范围请求）
-	if len(ranges) != 1 {
-		http.Error(w, "不支持多范围请求", http.StatusRequestedRangeNotSatisfiable)
+	if !uploadAllowed(r, destDir) {
+		httpError(w, r, "不允许在该目录中创建", http.StatusForbidden)
 		return
 	}
+	if _, err := os.Lstat(srcPath); err != nil {
+		httpError(w, r, "源文件不存在", http.StatusNotFound)
+		return
+	}
+	destPath, unlock, err := lockUniquePath(destDir, name, srcPath)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusConflict)
+		return
+	}
+	defer unlock()
+	destName := filepath.Base(destPath)
+	err = copyPath(srcPath, destPath)
+	auditLog(r, "copy", srcPath, destPath, err)
+	if err != nil {
+		os.RemoveAll(destPath)
+		httpError(w, r, "复制失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	invalidateDirInfo(destPath)
+	fmt.Fprint(w, tr(r, "复制成功: "+destName))
+}
+
+// sessionUser 返回请求对应的登录用户名，匿名访问时为空
+func sessionUser(r *http.Request) string {
+	if sess, ok := requestSession(r); ok {
+		return sess.Username
+	}
+	return ""
+}
 
-	start := ranges[0].start
-	end := ranges[0].end
-	contentLength := end - start + 1
+// requestUser 返回请求对应的登录用户名，未启用认证或无法识别时为 anonymous
+func requestUser(r *http.Request) string {
+	if sess, ok := requestSession(r); ok && sess.Username != "" {
+		return sess.Username
+	}
+	return "anonymous"
+}
 
-	// 设置部分内容响应头
-	w.Header().Set("Content-Length", strconv.FormatInt(contentLength, 10))
-	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, fileSize))
-	w.WriteHeader(http.StatusPartialContent)
+// relToBase 将绝对路径转换为相对于 baseDir 的路径，用于日志与响应
+func relToBase(p string) string {
+	if p == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(baseDir, p)
+	if err != nil {
+		return p
+	}
+	return filepath.ToSlash(rel)
+}
 
-	// 定位到指定位置并传输指定范围的数据
-	_, err = f.Seek(start, 0)
+// auditLog 向 -audit-log 追加一行 JSON 审计记录；未配置时不记录
+func auditLog(r *http.Request, action, source, target string, opErr error) {
+	if auditFile == nil {
+		return
+	}
+	record := map[string]string{
+		"time":       time.Now().Format(time.RFC3339),
+		"user":       requestUser(r),
+		"remote":     r.RemoteAddr,
+		"action":     action,
+		"request_id": requestID(r),
+		"result":     "ok",
+	}
+	if source != "" {
+		record["source"] = relToBase(source)
+	}
+	if target != "" {
+		record["target"] = relToBase(target)
+	}
+	if opErr != nil {
+		record["result"] = "error"
+		record["error"] = opErr.Error()
+	}
+	line, err := json.Marshal(record)
 	if err != nil {
-		http.Error(w, "文件定位失败", http.StatusInternalServerError)
 		return
 	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	// 每条记录单次写入且不经缓冲，崩溃时不会丢失已返回的操作
+	auditFile.Write(append(line, '\n'))
+}
+
+// calculateFileSize 根据文件大小返回合理单位表示，-size-units 决定使用 1024（KiB）或 1000（kB）进制
+func calculateFileSize(size int64) string {
+	if size < 0 {
+		size = 0
+	}
+	base := 1024.0
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	if sizeUnits == "si" {
+		base = 1000.0
+		units = []string{"B", "kB", "MB", "GB", "TB", "PB"}
+	}
+	if float64(size) < base {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	unitIndex := 0
+	for value >= base && unitIndex < len(units)-1 {
+		value /= base
+		unitIndex++
+	}
+	return fmt.Sprintf("%.2f %s", value, units[unitIndex])
+}
 
-	// 限制读取长度
-	limitedReader := io.LimitReader(f, contentLength)
-	io.Copy(w, limitedReader)
+// setAuthCookie 以 HttpOnly/SameSite 方式写入认证cookie，启用TLS时附加 Secure
+func setAuthCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    token,
+		Expires:  expires,
+		Path:     cookiePath,
+		HttpOnly: true,
+		Secure:   tlsEnabled,
+		SameSite: http.SameSiteLaxMode,
+	})
 }
 
-// Range表示一个字节范围
-type Range struct {
-	start, end int64
+// loginHandler 显示登录页面
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	lang := requestLang(r)
+	tmpl := langTemplate(loginTemplate, lang)
+	tmpl.Execute(w, LoginPageData{Title: translate(lang, siteTitle), LogoURL: logoURL, Lang: lang, Messages: langMessages(lang)})
 }
 
-// parseRange 解析HTTP Range头
-func parseRange(rangeHeader string, fileSize int64) ([]Range, error) {
-	if !strings.HasPrefix(rangeHeader, "bytes=") {
-		return nil, fmt.Errorf("无效的Range格式")
+// maxLoginBodySize 登录请求体的大小上限
+const maxLoginBodySize = 4 << 10
+
+// apiLoginHandler 处理登录API请求
+func apiLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		apiError(w, http.StatusMethodNotAllowed, "method_not_allowed", tr(r, "方法不允许"))
+		return
 	}
 
-	rangeSpec := rangeHeader[6:] // 去掉"bytes="前缀
-	ranges := []Range{}
+	// 解析请求体
+	var loginReq struct {
+		Username   string `json:"username"`
+		Password   string `json:"password"`
+		RememberMe bool   `json:"remember_me"`
+	}
 
-	// 分割多个范围（用逗号分隔）
-	for _, rangeStr := range strings.Split(rangeSpec, ",") {
-		rangeStr = strings.TrimSpace(rangeStr)
-		if rangeStr == "" {
-			continue
+	// 登录请求体很小，限制大小以免被超大请求占用内存；未知字段视为格式错误
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLoginBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&loginReq); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apiError(w, http.StatusBadRequest, "request_too_large", tr(r, "请求体过大"))
+			return
 		}
+		apiError(w, http.StatusBadRequest, "invalid_request", tr(r, "无效的请求格式")+": "+err.Error())
+		return
+	}
 
-		var start, end int64
-		var err error
+	// 验证用户名密码
+	role, ok := checkCredentials(loginReq.Username, loginReq.Password)
+	if !ok {
+		apiError(w, http.StatusUnauthorized, "invalid_credentials", tr(r, "用户名或密码错误"))
+		return
+	}
 
-		if strings.Contains(rangeStr, "-") {
-			parts := strings.SplitN(rangeStr, "-", 2)
-			startStr, endStr := parts[0], parts[1]
+	// 生成token
+	token := generateToken()
 
-			if startStr == "" {
-				// 后缀范围：-500 表示最后500字节
-				if endStr == "" {
-					return nil, fmt.Errorf("无效的范围格式")
-				}
-				suffix, parseErr := strconv.ParseInt(endStr, 10, 64)
-				if parseErr != nil || suffix <= 0 {
-					return nil, fmt.Errorf("无效的后缀长度")
-				}
-				start = fileSize - suffix
-				if start < 0 {
-					start = 0
-				}
-				end = fileSize - 1
-			} else if endStr == "" {
-				// 前缀范围：500- 表示从500字节到文件末尾
-				start, err = strconv.ParseInt(startStr, 10, 64)
-				if err != nil || start < 0 {
-					return nil, fmt.Errorf("无效的起始位置")
-				}
-				end = fileSize - 1
-			} else {
-				// 完整范围：0-499
-				start, err = strconv.ParseInt(startStr, 10, 64)
-				if err != nil || start < 0 {
-					return nil, fmt.Errorf("无效的起始位置")
-				}
-				end, err = strconv.ParseInt(endStr, 10, 64)
+	// 设置token过期时间
+	duration := sessIdle // 默认1天，活动时滑动续期
+	if loginReq.RememberMe {
+		duration = 30 * 24 * time.Hour // 记住登录状态30天
+	}
 
-				if err != nil || end < start {
-					return nil, fmt.Errorf("无效的结束位置")
-				}
-			}
-		} else {
-			return nil, fmt.Errorf("无效的范围格式")
-		}
+	addToken(token, loginReq.Username, role, duration)
+	expiresAt := time.Now().Add(duration)
+	setAuthCookie(w, token, expiresAt)
+	w.Header().Set("Content-Type", "application/json")
 
-		// 验证范围有效性
-		if start >= fileSize {
-			return nil, fmt.Errorf("起始位置超出文件大小")
-		}
-		if end >= fileSize {
-			end = fileSize - 1
+	// 返回token信息（供使用 Authorization 头的客户端使用）
+	tokenInfo := TokenInfo{
+		Token:     token,
+		ExpiresAt: expiresAt,
+	}
+
+	json.NewEncoder(w).Encode(tokenInfo)
+}
+
+// apiSessionHandler 返回当前请求的认证状态，未认证时返回401而不是重定向
+func apiSessionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	// 未启用认证时所有访问者都视为已认证
+	if !authEnabled() {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"authenticated":  true,
+			"auth_required":  false,
+			"anonymous_role": anonRole,
+		})
+		return
+	}
+
+	sess, ok := lookupToken(requestToken(r))
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"authenticated":  false,
+			"anonymous_role": anonRole,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"authenticated": true,
+		"username":      sess.Username,
+		"role":          sess.Role,
+		"expires_at":    sess.ExpiresAt,
+	})
+}
+
+// listenAddr 校验 -bind 与 -port 并返回监听地址，bind 为空时监听所有网卡
+func listenAddr(bind string, port int) (string, error) {
+	if port < 1 || port > 65535 {
+		return "", fmt.Errorf("无效的端口: %d", port)
+	}
+	if bind != "" && net.ParseIP(bind) == nil {
+		if _, err := net.LookupHost(bind); err != nil {
+			return "", fmt.Errorf("无效的 -bind 地址 %s: %v", bind, err)
 		}
+	}
+	return net.JoinHostPort(bind, strconv.Itoa(port)), nil
+}
 
-		ranges = append(ranges, Range{start: start, end: end})
+// checkBaseDir 启动时确认工作目录是目录且可写，避免到第一次上传时才失败
+func checkBaseDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("无法访问工作目录 %s: %v", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("工作目录 %s 不是目录", dir)
+	}
+	f, err := os.CreateTemp(dir, ".hfs-write-test-*")
+	if err != nil {
+		return fmt.Errorf("工作目录 %s 不可写: %v", dir, err)
 	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
+
+// baseDirReachable 检查工作目录是否存在且为目录
+func baseDirReachable() bool {
+	info, err := os.Stat(baseDir)
+	return err == nil && info.IsDir()
+}
+
+// healthzHandler 存活探针，不经过认证
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         "ok",
+		"uptime":         time.Since(startTime).Round(time.Second).String(),
+		"base_dir_ready": baseDirReachable(),
+	})
+}
 
-	if len(ranges) == 0 {
-		return nil, fmt.Errorf("没有有效的范围")
+// readyzHandler 就绪探针，工作目录不可访问时返回503
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !baseDirReachable() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "unavailable",
+			"error":  tr(r, "工作目录不可访问"),
+		})
+		return
 	}
-
-	return ranges, nil
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+	})
 }
 
-// fileDeleteHandler 删除指定文件或目录（支持递归删除）
-func fileDeleteHandler(w http.ResponseWriter, r *http.Request) {
-	fileName := r.URL.Query().Get("file")
-	relDir := r.URL.Query().Get("path")
-	if fileName == "" {
-		http.Error(w, "未指定文件", http.StatusBadRequest)
+// apiLogoutAllHandler 注销当前用户的所有会话；admin 可通过 user 参数注销指定用户的所有会话
+func apiLogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, http.StatusMethodNotAllowed, "method_not_allowed", tr(r, "方法不允许"))
 		return
 	}
-	targetDir, err := secureJoin(baseDir, relDir)
-	if err != nil {
-		http.Error(w, "无效的路径", http.StatusBadRequest)
+	sess, ok := requestSession(r)
+	if !ok || sess.Username == "" {
+		apiError(w, http.StatusUnauthorized, "unauthorized", tr(r, "需要认证"))
 		return
 	}
-	targetPath, err := secureJoin(targetDir, fileName)
-	if err != nil {
-		http.Error(w, "无效的文件名", http.StatusBadRequest)
+	target := r.FormValue("user")
+	if target == "" {
+		target = sess.Username
+	}
+	if target != sess.Username && !hasRole(r, roleAdmin) {
+		apiError(w, http.StatusForbidden, "forbidden", tr(r, "只有 admin 可以注销其他用户的会话"))
 		return
 	}
-	dirMu.Lock()
-	err = os.RemoveAll(targetPath)
-	dirMu.Unlock()
-	if err != nil {
-		http.Error(w, "删除失败: "+err.Error(), http.StatusInternalServerError)
+	n := revokeUserTokens(target)
+	auditLog(r, "logout-all", target, strconv.Itoa(n), nil)
+	if target == sess.Username {
+		setAuthCookie(w, "", time.Unix(0, 0))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user":    target,
+		"revoked": n,
+	})
+}
+
+// apiFilesHandler 处理 /api/v1/files/<相对路径> 上的 REST 操作：PUT 写入文件，DELETE 删除文件或目录
+func apiFilesHandler(w http.ResponseWriter, r *http.Request) {
+	var min string
+	switch r.Method {
+	case http.MethodPut:
+		min = roleEditor
+	case http.MethodDelete:
+		min = roleAdmin
+	default:
+		w.Header().Set("Allow", "PUT, DELETE")
+		apiError(w, http.StatusMethodNotAllowed, "method_not_allowed", tr(r, "方法不允许"))
 		return
 	}
-	if r.Header.Get("X-Requested-With") == "XMLHttpRequest" {
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprint(w, "删除成功")
+	if !hasRole(r, min) {
+		apiError(w, http.StatusForbidden, "forbidden", fmt.Sprintf(tr(r, "权限不足：当前角色（%s）无权执行此操作，需要 %s 及以上"), requestRole(r), min))
+		return
+	}
+	rel, err := normalizeRelPath(strings.TrimPrefix(r.URL.Path, "/api/v1/files/"))
+	if err != nil || rel == "" {
+		apiError(w, http.StatusBadRequest, "invalid_path", tr(r, "无效的路径"))
+		return
+	}
+	if r.Method == http.MethodDelete {
+		apiDeleteFile(w, r, rel)
 	} else {
-		http.Redirect(w, r, "/?path="+relDir, http.StatusFound)
+		apiPutFile(w, r, rel)
 	}
 }
 
-// createHandler 根据参数在当前目录中创建新文件或文件夹
-func createHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "仅支持POST方法", http.StatusMethodNotAllowed)
+// apiDeleteFile 删除 rel 指向的文件或目录（目录递归删除），成功返回 204，不存在返回 404
+func apiDeleteFile(w http.ResponseWriter, r *http.Request, rel string) {
+	targetPath, err := joinRequestPath(r, rel)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "invalid_path", tr(r, "无效的路径"))
 		return
 	}
-	r.ParseForm()
-	typ := r.FormValue("type")
-	name := r.FormValue("name")
-	relDir := r.FormValue("path")
-	if name == "" {
-		http.Error(w, "名称不能为空", http.StatusBadRequest)
+	unlock := pathLocks.Lock(targetPath)
+	// RemoveAll 对不存在的路径不报错，先确认目标仍然存在
+	if _, err = os.Lstat(targetPath); err == nil {
+		err = os.RemoveAll(targetPath)
+	}
+	unlock()
+	if errors.Is(err, fs.ErrNotExist) {
+		apiFSError(w, r, err, "删除失败")
 		return
 	}
-	targetDir, err := secureJoin(baseDir, relDir)
+	auditLog(r, "delete", targetPath, "", err)
 	if err != nil {
-		http.Error(w, "无效的路径", http.StatusBadRequest)
+		apiFSError(w, r, err, "删除失败")
 		return
 	}
-	targetPath, err := secureJoin(targetDir, name)
+	invalidateDirInfo(targetPath)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiPutFile 以请求体作为内容写入 rel（可用 curl -T 上传），先写入同目录的临时文件再重命名到目标位置。
+// 新建返回 201，覆盖已有文件返回 204；查询参数 parents=true 或请求头 X-Create-Parents: true 时自动创建缺少的上级目录
+func apiPutFile(w http.ResponseWriter, r *http.Request, rel string) {
+	for _, part := range strings.Split(rel, "/") {
+		if err := validateName(part); err != nil {
+			apiError(w, http.StatusBadRequest, "invalid_path", tr(r, "无效的名称")+": "+err.Error())
+			return
+		}
+	}
+	// 上级目录可能尚不存在，整体解析完整路径再取其目录部分
+	targetPath, err := joinRequestPath(r, rel)
 	if err != nil {
-		http.Error(w, "无效的名称", http.StatusBadRequest)
+		apiError(w, http.StatusBadRequest, "invalid_path", tr(r, "无效的路径"))
 		return
 	}
-	dirMu.Lock()
-	defer dirMu.Unlock()
-	switch typ {
-	case "file":
-		if _, err := os.Stat(targetPath); err == nil {
-			http.Error(w, "文件已存在", http.StatusBadRequest)
+	targetDir, name := filepath.Dir(targetPath), path.Base(rel)
+	if !uploadAllowed(r, targetDir) {
+		apiError(w, http.StatusForbidden, "forbidden", tr(r, "不允许上传到该目录"))
+		return
+	}
+	if err := checkPathLength(targetPath); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid_path", tr(r, err.Error()))
+		return
+	}
+	if maxUploadSize > 0 && r.ContentLength > maxUploadSize {
+		apiError(w, http.StatusRequestEntityTooLarge, "request_too_large", tr(r, "请求体过大"))
+		return
+	}
+
+	clearReadDeadline(w)
+	idleBody(w, r)
+	body := io.Reader(r.Body)
+	if maxUploadSize > 0 {
+		body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	}
+	var head []byte
+	if sniffExecutables {
+		br := bufio.NewReader(body)
+		head, _ = br.Peek(4)
+		body = br
+	}
+	if uploadBlocked(name, head) {
+		apiError(w, http.StatusUnsupportedMediaType, "blocked_type", tr(r, "不允许上传该类型的文件")+": "+name)
+		return
+	}
+
+	if info, err := os.Stat(targetDir); err != nil {
+		createParents := r.URL.Query().Get("parents") == "true" || r.Header.Get("X-Create-Parents") == "true"
+		if !errors.Is(err, fs.ErrNotExist) || !createParents {
+			apiError(w, http.StatusNotFound, "not_found", tr(r, "目标目录不存在"))
 			return
 		}
-		f, err := os.Create(targetPath)
+		unlock := pathLocks.Lock(targetDir)
+		err = os.MkdirAll(targetDir, 0755)
+		unlock()
+		auditLog(r, "mkdir", "", targetDir, err)
 		if err != nil {
-			http.Error(w, "无法创建文件: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		f.Close()
-		fmt.Fprint(w, "文件创建成功")
-	case "folder":
-		if err := os.Mkdir(targetPath, 0755); err != nil {
-			http.Error(w, "无法创建文件夹: "+err.Error(), http.StatusInternalServerError)
+			apiError(w, http.StatusInternalServerError, "io_error", tr(r, "无法创建文件夹")+": "+err.Error())
 			return
 		}
-		fmt.Fprint(w, "文件夹创建成功")
-	default:
-		http.Error(w, "无效的类型", http.StatusBadRequest)
+	} else if !info.IsDir() {
+		apiError(w, http.StatusConflict, "conflict", tr(r, "目标不是目录"))
+		return
 	}
-}
 
-// renameHandler 重命名指定的文件或目录
-func renameHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "仅支持POST方法", http.StatusMethodNotAllowed)
+	unlock := pathLocks.Lock(targetPath)
+	existing, statErr := os.Stat(targetPath)
+	if statErr == nil && existing.IsDir() {
+		unlock()
+		apiError(w, http.StatusConflict, "conflict", tr(r, "目标是已存在的文件夹"))
 		return
 	}
-	r.ParseForm()
-	oldName := r.FormValue("old")
-	newName := r.FormValue("new")
-	relDir := r.FormValue("path")
-	if oldName == "" || newName == "" {
-		http.Error(w, "缺少参数", http.StatusBadRequest)
+	if !checkIfMatch(r, targetPath) {
+		unlock()
+		apiError(w, http.StatusPreconditionFailed, "precondition_failed", tr(r, "文件已被修改，请刷新后重试"))
 		return
 	}
-	oldPath, err := secureJoin(baseDir, filepath.Join(relDir, oldName))
+	_, err = saveUpload(r, targetPath, body, time.Time{})
+	unlock()
+	auditLog(r, "upload", "", targetPath, err)
 	if err != nil {
-		http.Error(w, "无效的旧名称", http.StatusBadRequest)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apiError(w, http.StatusRequestEntityTooLarge, "request_too_large", tr(r, "请求体过大"))
+			return
+		}
+		if isTimeout(err) {
+			apiError(w, http.StatusRequestTimeout, "timeout", tr(r, "上传超时")+": "+uploadIdle.String())
+			return
+		}
+		apiError(w, http.StatusInternalServerError, "io_error", tr(r, "无法保存文件")+": "+err.Error())
 		return
 	}
-	newPath, err := secureJoin(baseDir, filepath.Join(relDir, newName))
-	if err != nil {
-		http.Error(w, "无效的新名称", http.StatusBadRequest)
-		return
+	invalidateDirInfo(targetPath)
+	if info, err := os.Stat(targetPath); err == nil {
+		w.Header().Set("ETag", fileETag(info))
 	}
-	dirMu.Lock()
-	defer dirMu.Unlock()
-	if err := os.Rename(oldPath, newPath); err != nil {
-		http.Error(w, "重命名失败: "+err.Error(), http.StatusInternalServerError)
+	if statErr == nil {
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	fmt.Fprint(w, "重命名成功")
+	w.Header().Set("Location", prefixURL("/download?file="+url.QueryEscape(rel)))
+	w.WriteHeader(http.StatusCreated)
 }
 
-// calculateFileSize 根据文件大小返回合理单位表示
-func calculateFileSize(size int64) string {
-	const (
-		KB = 1024.0
-		MB = 1024 * KB
-		GB = 1024 * MB
-		TB = 1024 * GB
-	)
-	units := []string{"B", "KB", "MB", "GB", "TB"}
-	value := float64(size)
-	unitIndex := 0
-	for value >= 1024 && unitIndex < len(units)-1 {
-		value /= 1024
-		unitIndex++
-	}
-	return fmt.Sprintf("%.2f %s", value, units[unitIndex])
+// maxBatchBodySize、maxBatchOps 批量操作请求体大小与单次操作数上限
+const (
+	maxBatchBodySize = 1 << 20
+	maxBatchOps      = 1000
+)
+
+// batchOp 批量操作中的一项，参数与对应的单项接口一致
+type batchOp struct {
+	Op        string `json:"op"` // mkdir、move、copy、rename、delete
+	Path      string `json:"path"`
+	Name      string `json:"name"`
+	Dest      string `json:"dest"`
+	Old       string `json:"old"`
+	New       string `json:"new"`
+	Recursive bool   `json:"recursive"`
+	Overwrite bool   `json:"overwrite"`
 }
 
-// loginHandler 显示登录页面
-func loginHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	fmt.Fprint(w, loginTemplate)
+// batchResult 单项操作的执行结果
+type batchResult struct {
+	Op      string `json:"op"`
+	OK      bool   `json:"ok"`
+	Status  int    `json:"status"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
 }
 
-// apiLoginHandler 处理登录API请求
-func apiLoginHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
-		return
-	}
+// batchRecorder 记录内部子请求的响应，供批量操作汇总结果
+type batchRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
 
-	w.Header().Set("Content-Type", "application/json")
+func (b *batchRecorder) Header() http.Header { return b.header }
 
-	// 解析请求体
-	var loginReq struct {
-		Username   string `json:"username"`
-		Password   string `json:"password"`
-		RememberMe bool   `json:"remember_me"`
+func (b *batchRecorder) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
 	}
+	return b.body.Write(p)
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&loginReq); err != nil {
-		http.Error(w, `{"error":"无效的请求格式"}`, http.StatusBadRequest)
-		return
+func (b *batchRecorder) WriteHeader(code int) {
+	if b.status == 0 {
+		b.status = code
 	}
+}
 
-	// 验证用户名密码
-	if loginReq.Username != username || loginReq.Password != password {
-		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprint(w, `{"error":"用户名或密码错误"}`)
-		return
+// runBatchOp 将一项操作转换为对应单项接口的内部请求执行，
+// 因此路径校验（secureJoin）、路径锁、角色权限与审计日志都与单独调用时相同
+func runBatchOp(r *http.Request, op batchOp) batchResult {
+	form := url.Values{"path": {op.Path}}
+	var handler http.HandlerFunc
+	switch op.Op {
+	case "mkdir":
+		form.Set("type", "folder")
+		form.Set("name", op.Name)
+		if op.Recursive {
+			form.Set("recursive", "true")
+		}
+		handler = requireRole(roleEditor, createHandler)
+	case "move", "copy":
+		form.Set("name", op.Name)
+		form.Set("dest", op.Dest)
+		handler = requireRole(roleEditor, moveHandler)
+		if op.Op == "copy" {
+			handler = requireRole(roleEditor, copyHandler)
+		}
+	case "rename":
+		form.Set("old", op.Old)
+		form.Set("new", op.New)
+		if op.Overwrite {
+			form.Set("overwrite", "true")
+		}
+		handler = requireRole(roleEditor, renameHandler)
+	case "delete":
+		form.Set("file", op.Name)
+		handler = requireRole(roleAdmin, fileDeleteHandler)
+	default:
+		return batchResult{Op: op.Op, Status: http.StatusBadRequest, Error: tr(r, "未知的操作类型: "+op.Op)}
+	}
+	// 名称在转交前统一校验："."、".." 等会指向所在目录本身或其上级
+	names := []string{op.Name}
+	if op.Op == "rename" {
+		names = []string{op.Old, op.New}
+	} else if op.Op == "mkdir" && op.Recursive {
+		names = strings.Split(op.Name, "/")
+	}
+	for _, name := range names {
+		if err := validateName(name); err != nil {
+			return batchResult{Op: op.Op, Status: http.StatusBadRequest, Error: tr(r, "非法文件名 "+name+": "+err.Error())}
+		}
 	}
 
-	// 生成token
-	token := generateToken()
-
-	// 设置token过期时间
-	duration := 24 * time.Hour // 默认1天
-	if loginReq.RememberMe {
-		duration = 30 * 24 * time.Hour // 记住登录状态30天
+	sub := r.Clone(r.Context())
+	sub.Method = http.MethodPost
+	sub.URL = &url.URL{Path: "/" + op.Op}
+	sub.Header.Del("If-Match")
+	sub.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	sub.Header.Set("X-Requested-With", "XMLHttpRequest")
+	if op.Op == "delete" {
+		// 删除接口从查询字符串读取参数
+		sub.Method = http.MethodGet
+		sub.URL.RawQuery = form.Encode()
+		sub.Body, sub.ContentLength = http.NoBody, 0
+	} else {
+		body := form.Encode()
+		sub.Body, sub.ContentLength = io.NopCloser(strings.NewReader(body)), int64(len(body))
 	}
+	sub.Form, sub.PostForm, sub.MultipartForm = nil, nil, nil
 
-	addToken(token, duration)
+	rec := &batchRecorder{header: make(http.Header)}
+	handler(rec, sub)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	res := batchResult{Op: op.Op, Status: rec.status, OK: rec.status < 300}
+	if res.OK {
+		res.Message = strings.TrimSpace(rec.body.String())
+	} else {
+		res.Error = strings.TrimSpace(rec.body.String())
+	}
+	return res
+}
 
-	// 返回token信息
-	tokenInfo := TokenInfo{
-		Token:     token,
-		ExpiresAt: time.Now().Add(duration),
+// apiBatchHandler 按顺序执行一组文件操作并返回每项的结果；
+// 默认遇到失败即停止，continue_on_error 为 true 时继续执行后续操作
+func apiBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, http.StatusMethodNotAllowed, "method_not_allowed", tr(r, "方法不允许"))
+		return
+	}
+	if !hasRole(r, roleEditor) {
+		apiError(w, http.StatusForbidden, "forbidden", fmt.Sprintf(tr(r, "权限不足：当前角色（%s）无权执行此操作，需要 %s 及以上"), requestRole(r), roleEditor))
+		return
+	}
+	var req struct {
+		Ops             []batchOp `json:"ops"`
+		ContinueOnError bool      `json:"continue_on_error"`
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apiError(w, http.StatusRequestEntityTooLarge, "request_too_large", tr(r, "请求体过大"))
+			return
+		}
+		apiError(w, http.StatusBadRequest, "invalid_request", tr(r, "无效的请求格式")+": "+err.Error())
+		return
+	}
+	if len(req.Ops) == 0 {
+		apiError(w, http.StatusBadRequest, "invalid_request", tr(r, "ops 不能为空"))
+		return
+	}
+	if len(req.Ops) > maxBatchOps {
+		apiError(w, http.StatusBadRequest, "too_many_ops", fmt.Sprintf(tr(r, "单次最多 %d 项操作"), maxBatchOps))
+		return
 	}
 
-	json.NewEncoder(w).Encode(tokenInfo)
+	results := make([]batchResult, 0, len(req.Ops))
+	failed := 0
+	for _, op := range req.Ops {
+		res := runBatchOp(r, op)
+		results = append(results, res)
+		if !res.OK {
+			failed++
+			if !req.ContinueOnError {
+				break
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results":   results,
+		"total":     len(req.Ops),
+		"succeeded": len(results) - failed,
+		"failed":    failed,
+		"skipped":   len(req.Ops) - len(results),
+	})
 }
 
 // logoutHandler 处理登出请求
 func logoutHandler(w http.ResponseWriter, r *http.Request) {
 	// 获取token
-	cookie, err := r.Cookie("auth_token")
+	cookie, err := r.Cookie(cookieName)
 	if err == nil {
 		// 删除token
 		tokenMu.Lock()
@@ -1732,97 +6844,387 @@ func logoutHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 清除cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "auth_token",
-		Value:    "",
-		Expires:  time.Unix(0, 0),
-		Path:     "/",
-		HttpOnly: true,
-	})
+	setAuthCookie(w, "", time.Unix(0, 0))
 
 	// 重定向到登录页面
-	http.Redirect(w, r, "/login", http.StatusFound)
+	http.Redirect(w, r, prefixURL("/login"), http.StatusFound)
+}
+
+// newRouter 注册所有路由，-metrics 等开关在调用时读取
+func newRouter() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// 登录相关路由（不需要认证）
+	mux.HandleFunc("/login", loginHandler)
+	mux.HandleFunc("/api/login", apiLoginHandler)
+	mux.HandleFunc("/api/session", apiSessionHandler)
+	mux.HandleFunc("/logout", logoutHandler)
+	mux.HandleFunc("/api/logout-all", authHandler(apiLogoutAllHandler))
+
+	// 健康检查路由（不需要认证）
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+
+	// 监控指标（-metrics 启用；设置 -metrics-token 时使用独立的 Bearer 认证）
+	if metricsOn {
+		if metricsToken != "" {
+			mux.HandleFunc("/metrics", metricsHandler)
+		} else {
+			mux.HandleFunc("/metrics", authHandler(requireRole(roleAdmin, metricsHandler)))
+		}
+	}
+
+	mux.HandleFunc("/static/", staticHandler)
+	mux.HandleFunc("/favicon.ico", faviconHandler)
+
+	// 文件管理相关路由（需要认证）
+	mux.HandleFunc("/", rootHandler(authHandler(indexHandler)))
+	mux.HandleFunc("/list", authHandler(requireListing(listHandler)))
+	mux.HandleFunc("/upload", authHandler(requireRole(roleEditor, limitTransfers(fileUploadHandler))))
+	mux.HandleFunc("/upload-chunk", authHandler(requireRole(roleEditor, limitTransfers(uploadChunkHandler))))
+	mux.HandleFunc("/upload-status", authHandler(uploadStatusHandler))
+	mux.HandleFunc("/upload-resume", authHandler(requireRole(roleEditor, limitTransfers(uploadResumeHandler))))
+	mux.HandleFunc("/download", authHandler(limitTransfers(fileDownloadHandler)))
+	mux.HandleFunc("/preview", authHandler(limitTransfers(filePreviewHandler)))
+	mux.HandleFunc("/download-tar", authHandler(requireListing(limitTransfers(downloadTarHandler))))
+	mux.HandleFunc("/delete", authHandler(requireRole(roleAdmin, fileDeleteHandler)))
+	mux.HandleFunc("/empty-dir", authHandler(requireRole(roleAdmin, emptyDirHandler)))
+	mux.HandleFunc("/create", authHandler(requireRole(roleEditor, createHandler)))
+	mux.HandleFunc("/rename", authHandler(requireRole(roleEditor, renameHandler)))
+	mux.HandleFunc("/move", authHandler(requireRole(roleEditor, moveHandler)))
+	mux.HandleFunc("/copy", authHandler(requireRole(roleEditor, copyHandler)))
+	mux.HandleFunc("/extract", authHandler(requireRole(roleEditor, extractHandler)))
+	mux.HandleFunc("/compress", authHandler(requireRole(roleEditor, compressHandler)))
+	mux.HandleFunc("/dirinfo", authHandler(dirInfoHandler))
+	mux.HandleFunc("/stat", authHandler(statHandler))
+	mux.HandleFunc("/chmod", authHandler(requireRole(roleAdmin, chmodHandler)))
+	mux.HandleFunc("/fetch-url", authHandler(requireRole(roleAdmin, fetchURLHandler)))
+	mux.HandleFunc("/truncate", authHandler(requireRole(roleAdmin, truncateHandler)))
+	mux.HandleFunc("/dir-sort", authHandler(requireRole(roleEditor, dirSortHandler)))
+	mux.HandleFunc("/recent", authHandler(requireListing(recentHandler)))
+	mux.HandleFunc("/checksum-status", authHandler(requireListing(checksumStatusHandler)))
+	mux.HandleFunc("/search", authHandler(requireListing(searchHandler)))
+	mux.HandleFunc("/api/breadcrumbs", authHandler(apiBreadcrumbsHandler))
+	mux.HandleFunc("/api/v1/batch", authHandler(apiBatchHandler))
+	mux.HandleFunc("/api/v1/files/", authHandler(limitTransfers(apiFilesHandler)))
+	mux.HandleFunc("/api/v1/tree", authHandler(requireListing(apiTreeHandler)))
+	mux.HandleFunc("/api/list", authHandler(requireListing(apiListHandler)))
+	mux.HandleFunc("/events", authHandler(requireListing(eventsHandler)))
+	mux.HandleFunc("/tail", authHandler(tailHandler))
+	return mux
+}
+
+// newHandler 为路由加上请求ID、安全响应头、访问日志、路径前缀、CORS 与监控指标等中间件
+func newHandler(mux *http.ServeMux) http.Handler {
+	return requestIDMiddleware(securityHeaders(accessLogMiddleware(stripPathPrefix(corsMiddleware(metricsMiddleware(mux))))))
 }
 
 func main() {
 	port := flag.Int("port", 8080, "HTTP服务器端口")
+	bind := flag.String("bind", "", "监听的地址（IP 或主机名），默认监听所有网卡")
 	dirFlag := flag.String("dir", ".", "操作的目录，默认为当前目录")
 	flag.StringVar(&username, "username", "", "基本认证用户名（可选）")
 	flag.StringVar(&password, "password", "", "基本认证密码（可选）")
+	anonymous := flag.String("anonymous", "", "匿名访问者的角色：none、viewer、editor、admin（默认无账户时为 admin，否则为 none）")
+	flag.BoolVar(&allowBasicAuth, "allow-basic-auth", false, "允许使用 HTTP Basic 认证（每个请求都携带密码，建议仅在 HTTPS 下使用）")
+	usersFile := flag.String("users", "", "用户文件路径，每行 username:password:role（role 为 viewer/editor/admin）")
 	flag.BoolVar(&tlsEnabled, "tls", true, "启用TLS/HTTPS")
 	flag.StringVar(&certFile, "cert", "", "TLS证书文件路径")
 	flag.StringVar(&keyFile, "key", "", "TLS私钥文件路径")
+	flag.StringVar(&tlsMinVer, "tls-min-version", "1.2", "TLS最低版本（1.2 或 1.3）")
+	flag.Var(&certHosts, "cert-host", "自签名证书额外包含的域名或IP（可重复指定）")
+	flag.StringVar(&pathPrefix, "path-prefix", "", "反向代理挂载的路径前缀，如 /files，所有路由与页面链接都会带上该前缀")
+	flag.StringVar(&cookieName, "cookie-name", "auth_token", "认证 cookie 的名称，同一域名下运行多个实例时可用于区分")
+	flag.StringVar(&cookiePath, "cookie-path", "", "cookie 的 Path，默认为路径前缀加 /")
+	flag.Var(&corsOrigins, "cors-origin", "允许跨域访问 /api/ 接口的来源，如 https://app.example.com（可重复指定），* 表示任意来源且不允许携带凭据")
+	flag.Var(&uploadAllow, "upload-allow", "只允许上传、创建到该目录（相对用户根目录）及其子目录，可重复指定；未指定时不限制")
+	flag.BoolVar(&hsts, "hsts", false, "启用TLS时发送 Strict-Transport-Security 响应头")
+	flag.DurationVar(&sessIdle, "session-idle", 24*time.Hour, "会话空闲超时，期间有访问则自动续期")
+	readHeaderTimeout := flag.Duration("read-header-timeout", 10*time.Second, "读取请求头的超时时间，防止慢速连接长期占用")
+	readTimeout := flag.Duration("read-timeout", 0, "读取整个请求的超时时间（上传请求不受限制），0 表示不限制")
+	writeTimeout := flag.Duration("write-timeout", 0, "写出响应的超时时间（下载与事件流不受限制），0 表示不限制")
+	idleTimeout := flag.Duration("idle-timeout", 120*time.Second, "keep-alive 连接的空闲超时时间")
+	flag.DurationVar(&uploadIdle, "upload-idle-timeout", 5*time.Minute, "上传连续这么久收不到数据即中断并清理临时文件，0 表示不限制")
+	flag.DurationVar(&downloadIdle, "download-idle-timeout", 5*time.Minute, "下载时客户端连续这么久不接收数据即断开，0 表示不限制")
+	flag.DurationVar(&sessMaxAge, "session-max-age", 90*24*time.Hour, "会话自登录起的最长有效期（续期上限）")
+	timezone := flag.String("timezone", "", "显示时间使用的时区（IANA 名称，如 Asia/Shanghai），默认为系统时区")
+	flag.StringVar(&dateFormat, "date-format", "2006-01-02 15:04:05", "显示时间的格式（Go 时间格式）")
+	flag.StringVar(&dedupeMode, "dedupe", "off", "上传去重：off 关闭，warn 在响应中提示重复，link 以硬链接替代重复内容")
+	blockFlag := flag.String("block-extensions", "", "禁止上传的扩展名（逗号分隔，不区分大小写），如 exe,sh,bat")
+	flag.BoolVar(&sniffExecutables, "block-executables", false, "按文件头识别 ELF、PE 可执行文件与 #! 脚本并拒绝上传")
+	gzipFlag := flag.String("gzip-types", "txt,log,csv,tsv,json,xml,html,htm,css,js,md,yaml,yml,ini,conf,svg", "下载时按需 gzip 压缩的扩展名（逗号分隔），为空表示不压缩")
+	flag.DurationVar(&fetchTimeout, "fetch-timeout", 10*time.Minute, "/fetch-url 下载远程文件的超时时间")
+	flag.BoolVar(&allowPrivateFetch, "allow-private-fetch", false, "允许 /fetch-url 访问回环、内网与链路本地地址（默认禁止，防止 SSRF）")
+	flag.StringVar(&defaultSort, "default-sort", "name", "未指定排序时的默认排序字段：name、time、size、type")
+	flag.StringVar(&defaultOrder, "default-order", "", "未指定顺序时的默认排序顺序：asc 或 desc，默认按时间排序为 desc、其余为 asc")
+	flag.StringVar(&uiLang, "lang", "auto", "界面语言：auto（按浏览器 Accept-Language 选择）、zh-CN 或 en")
+	flag.StringVar(&siteTitle, "title", "简易网页文件管理器", "页面标题，显示在浏览器标签页与页面顶部")
+	flag.StringVar(&logoURL, "logo-url", "", "显示在标题旁的图标地址（可选）")
+	flag.BoolVar(&serveIndex, "serve-index", false, "目录中存在 index.html 时直接显示该页面而不是文件列表（加 ?browse=1 仍可进入管理界面）")
+	flag.BoolVar(&showAbsPath, "show-abs-path", false, "在页面中提供当前目录的服务器绝对路径（复制路径时使用）")
+	flag.BoolVar(&followSymlinks, "follow-symlinks", false, "递归操作（统计、打包、搜索等）时进入符号链接指向的目录")
+	flag.BoolVar(&metricsOn, "metrics", false, "启用 /metrics 监控指标（Prometheus 文本格式）")
+	flag.StringVar(&metricsToken, "metrics-token", "", "访问 /metrics 使用的独立 Bearer token，为空时沿用登录认证")
+	flag.IntVar(&maxNameLength, "max-name-length", maxNameLength, "写入时单个文件名或目录名的最大字节数")
+	flag.IntVar(&maxPathLength, "max-path-length", maxPathLength, "写入时目标完整路径的最大字节数")
+	templatesDir := flag.String("templates-dir", "", "自定义模板目录，其中的 main.html、login.html、app.css、app.js、login.css、login.js 覆盖内嵌的默认文件")
+	flag.BoolVar(&allowEmptyRoot, "allow-empty-root", false, "允许通过 /empty-dir 清空根目录")
+	indexFlag := flag.Bool("index", false, "启用内存文件名索引加速 /search，未建好或超过上限时退回实时遍历")
+	flag.IntVar(&indexMaxEntries, "index-max-entries", 200000, "文件名索引的最大条目数，超过时停用索引")
+	flag.DurationVar(&dirPollInterval, "events-interval", time.Second, "/events 轮询目录变化的间隔，越短刷新越及时，但每个被订阅的目录每次都要读取一遍")
+	flag.StringVar(&checksumAlgo, "checksum", "", "在文件列表中显示后台计算的校验和：md5 或 sha256，为空表示不显示")
+	checksumWorkers := flag.Int("checksum-workers", 2, "同时计算校验和的文件数")
+	flag.BoolVar(&copyPreserve, "copy-preserve", true, "复制与跨设备移动时保留文件和目录的权限位与修改时间")
+	flag.BoolVar(&noListing, "no-listing", false, "禁止浏览目录（页面与列表接口返回403），仍可通过明确路径下载文件")
+	maxTransfers := flag.Int("max-concurrent-transfers", 0, "同时进行的上传/下载请求数上限，超出时返回503，0 表示不限制")
+	maxUploadMB := flag.Int64("max-upload-size", 0, "单次上传/创建内容的大小上限（MB），0 表示不限制")
+	uploadMemMB := flag.Int64("upload-memory", 10, "表单上传在内存中缓存的大小（MB），超出部分写入临时文件")
+	flag.StringVar(&uploadTempDir, "upload-temp-dir", "", "上传临时文件目录（表单上传与分片上传），默认为系统临时目录")
+	flag.StringVar(&sizeUnits, "size-units", "binary", "文件大小单位：binary（1024 进制，KiB/MiB）或 si（1000 进制，kB/MB）")
+	auditPath := flag.String("audit-log", "", "审计日志文件路径（JSON Lines），记录所有文件修改操作")
+	logPath := flag.String("log-file", "", "访问日志文件路径（Combined Log Format），为空时不记录")
+	logMaxSize := flag.Int("log-max-size", 100, "访问日志超过该大小（MB）时轮转，0 表示不按大小轮转")
+	logInterval := flag.Duration("log-rotate-interval", 0, "访问日志按时间轮转的间隔（如 24h），0 表示不按时间轮转")
+	logBackups := flag.Int("log-backups", 7, "访问日志保留的轮转备份数")
+	logCompress := flag.Bool("log-compress", false, "使用 gzip 压缩轮转后的访问日志")
+	flag.StringVar(&stateDir, "state-dir", defaultStateDir(), "运行状态目录（以 0700 权限创建），默认遵循 $XDG_STATE_HOME 或 %LOCALAPPDATA%，否则为 ~/.hfs")
+	flag.StringVar(&certCache, "cert-cache-dir", "", "自签名证书缓存目录，默认为状态目录下的 certs")
+	flag.BoolVar(&regenCert, "regenerate-cert", false, "忽略缓存，强制重新生成自签名证书")
 	flag.Parse()
 	baseDir = *dirFlag
+	maxUploadSize = *maxUploadMB << 20
+	multipartMemory = *uploadMemMB << 20
+	gzipTypes = make(map[string]bool)
+	for _, ext := range strings.Split(*gzipFlag, ",") {
+		if ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), ".")); ext != "" {
+			gzipTypes[ext] = true
+		}
+	}
+	blockedExts = make(map[string]bool)
+	for _, ext := range strings.Split(*blockFlag, ",") {
+		if ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), ".")); ext != "" {
+			blockedExts[ext] = true
+		}
+	}
+	if *auditPath != "" {
+		f, err := os.OpenFile(*auditPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			fmt.Printf("无法打开审计日志 %s: %v\n", *auditPath, err)
+			return
+		}
+		defer f.Close()
+		auditFile = f
+	}
+	if pathPrefix = strings.TrimRight(pathPrefix, "/"); pathPrefix != "" && !strings.HasPrefix(pathPrefix, "/") {
+		pathPrefix = "/" + pathPrefix
+	}
+	if cookiePath == "" {
+		cookiePath = pathPrefix + "/"
+	}
+	if cookieName == "" || strings.ContainsAny(cookieName, " \t;,=\"") {
+		fmt.Printf("无效的 -cookie-name: %q\n", cookieName)
+		return
+	}
+	if dirPollInterval <= 0 {
+		fmt.Printf("无效的 -events-interval: %s\n", dirPollInterval)
+		return
+	}
+	for i, o := range corsOrigins {
+		corsOrigins[i] = strings.TrimSuffix(o, "/")
+	}
+	for i, p := range uploadAllow {
+		rel, err := normalizeRelPath(p)
+		if err != nil || !isWithin("/", filepath.Join("/", rel)) {
+			fmt.Printf("无效的 -upload-allow: %s\n", p)
+			return
+		}
+		uploadAllow[i] = rel
+	}
+	if uploadTempDir != "" {
+		if err := checkTempDir(uploadTempDir); err != nil {
+			fmt.Printf("上传临时目录 %s 不可用: %v\n", uploadTempDir, err)
+			return
+		}
+		// mime/multipart 总是在 os.TempDir() 中创建临时文件，通过环境变量让它使用指定目录
+		os.Setenv("TMPDIR", uploadTempDir)
+		if runtime.GOOS == "windows" {
+			os.Setenv("TMP", uploadTempDir)
+		}
+	}
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		fmt.Printf("无法创建状态目录 %s: %v\n", stateDir, err)
+		return
+	}
+	if certCache == "" {
+		certCache = filepath.Join(stateDir, "certs")
+	}
+	if *logPath != "" {
+		if *logMaxSize < 0 || *logBackups < 0 || *logInterval < 0 {
+			fmt.Println("无效的访问日志轮转参数：-log-max-size、-log-backups、-log-rotate-interval 不能为负数")
+			return
+		}
+		lw, err := newRotatingWriter(*logPath, int64(*logMaxSize)<<20, *logInterval, *logBackups, *logCompress)
+		if err != nil {
+			fmt.Printf("无法打开访问日志 %s: %v\n", *logPath, err)
+			return
+		}
+		accessLog = lw
+	}
+	addr, err := listenAddr(*bind, *port)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if *templatesDir != "" {
+		if info, err := os.Stat(*templatesDir); err != nil || !info.IsDir() {
+			fmt.Printf("模板目录 %s 不存在，使用内嵌的默认模板\n", *templatesDir)
+			*templatesDir = ""
+		}
+	}
+	if err := loadTemplates(*templatesDir); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if *maxTransfers < 0 {
+		fmt.Printf("无效的 -max-concurrent-transfers: %d\n", *maxTransfers)
+		return
+	}
+	if *maxTransfers > 0 {
+		transferSlots = make(chan struct{}, *maxTransfers)
+	}
+	if dedupeMode != "off" && dedupeMode != "warn" && dedupeMode != "link" {
+		fmt.Printf("无效的 -dedupe: %s（可选 off、warn、link）\n", dedupeMode)
+		return
+	}
+	if !validSort(defaultSort) {
+		fmt.Printf("无效的 -default-sort: %s（可选 name、time、size、type）\n", defaultSort)
+		return
+	}
+	if defaultOrder != "" && defaultOrder != "asc" && defaultOrder != "desc" {
+		fmt.Printf("无效的 -default-order: %s（可选 asc 或 desc）\n", defaultOrder)
+		return
+	}
+	if uiLang != "auto" && uiLang != defaultLang && messages[uiLang] == nil {
+		fmt.Printf("无效的 -lang: %s（可选 auto、zh-CN、en）\n", uiLang)
+		return
+	}
+	if sizeUnits != "binary" && sizeUnits != "si" {
+		fmt.Printf("无效的 -size-units: %s（可选 binary 或 si）\n", sizeUnits)
+		return
+	}
+	if *timezone != "" {
+		loc, err := time.LoadLocation(*timezone)
+		if err != nil {
+			fmt.Printf("无效的时区 %s: %v\n", *timezone, err)
+			return
+		}
+		displayLoc = loc
+	}
 	if _, err := os.Stat(baseDir); os.IsNotExist(err) {
 		if err := os.MkdirAll(baseDir, 0755); err != nil {
 			fmt.Printf("无法创建目录 %s: %v\n", baseDir, err)
 			return
 		}
 	}
-	// 登录相关路由（不需要认证）
-	http.HandleFunc("/login", loginHandler)
-	http.HandleFunc("/api/login", apiLoginHandler)
-	http.HandleFunc("/logout", logoutHandler)
+	if err := checkBaseDir(baseDir); err != nil {
+		fmt.Println(err)
+		return
+	}
+	users = make(map[string]userAccount)
+	if *usersFile != "" {
+		loaded, err := loadUsers(*usersFile)
+		if err != nil {
+			fmt.Printf("无法加载用户文件 %s: %v\n", *usersFile, err)
+			return
+		}
+		users = loaded
+	}
+	if username != "" && password != "" {
+		// 命令行指定的用户始终为管理员
+		users[username] = userAccount{Password: password, Role: roleAdmin}
+	}
+	role, err := resolveAnonRole(*anonymous)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	anonRole = role
+	if anonRole != "" {
+		fmt.Printf("匿名访问已开启，匿名角色: %s\n", anonRole)
+	}
+	if allowBasicAuth && !tlsEnabled {
+		fmt.Println("警告: 已启用 HTTP Basic 认证但未启用 TLS，密码将以明文传输")
+	}
+	if dedupeMode != "off" {
+		go buildHashIndex(baseDir)
+	}
+	switch checksumAlgo {
+	case "":
+	case "md5", "sha256":
+		checksums = newChecksumCache(max(*checksumWorkers, 1))
+	default:
+		fmt.Printf("无效的 -checksum: %s（可选 md5、sha256）\n", checksumAlgo)
+		return
+	}
+	if *indexFlag {
+		searchIndex = newNameIndex()
+	}
 
-	// 文件管理相关路由（需要认证）
-	http.HandleFunc("/", authHandler(indexHandler))
-	http.HandleFunc("/list", authHandler(listHandler))
-	http.HandleFunc("/upload", authHandler(fileUploadHandler))
-	http.HandleFunc("/download", authHandler(fileDownloadHandler))
-	http.HandleFunc("/delete", authHandler(fileDeleteHandler))
-	http.HandleFunc("/create", authHandler(createHandler))
-	http.HandleFunc("/rename", authHandler(renameHandler))
-	addr := fmt.Sprintf(":%d", *port)
+	// 定期清理过期的分片上传会话
+	go func() {
+		for range time.Tick(time.Minute) {
+			expireUploads()
+		}
+	}()
+
+	// 访问地址提示：监听所有网卡时使用 localhost，否则使用指定的地址
+	visitHost := "localhost"
+	if ip := net.ParseIP(*bind); *bind != "" && (ip == nil || !ip.IsUnspecified()) {
+		visitHost = *bind
+	}
+	visitAddr := net.JoinHostPort(visitHost, strconv.Itoa(*port))
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           newHandler(newRouter()),
+		ReadHeaderTimeout: *readHeaderTimeout,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+	}
 
 	if tlsEnabled {
+		var certs []tls.Certificate
 		// 检查是否提供了证书和密钥文件
 		if certFile == "" || keyFile == "" {
-			fmt.Println("未提供证书文件，正在生成自签名证书...")
-
-			// 生成自签名证书
-			certPEM, keyPEM, err := generateSelfSignedCert()
-			if err != nil {
-				fmt.Printf("生成自签名证书失败: %v\n", err)
-				return
-			}
-
-			// 从内存中加载证书
-			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			cert, err := loadOrGenerateCert(certCache, certHosts, regenCert)
 			if err != nil {
-				fmt.Printf("加载证书失败: %v\n", err)
+				fmt.Println(err)
 				return
 			}
+			certs = append(certs, cert)
+			// 证书已放入 TLSConfig，ListenAndServeTLS 不再读取文件
+			certFile, keyFile = "", ""
+		}
 
-			// 创建TLS配置
-			tlsConfig := &tls.Config{
-				Certificates: []tls.Certificate{cert},
-			}
+		// 创建TLS配置
+		tlsConfig, err := newTLSConfig(certs...)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
 
-			// 创建HTTPS服务器
-			server := &http.Server{
-				Addr:      addr,
-				TLSConfig: tlsConfig,
-			}
+		// 创建HTTPS服务器，TLSConfig 的 NextProtos 包含 h2，ListenAndServeTLS 会自动启用 HTTP/2
+		server.TLSConfig = tlsConfig
 
-			fmt.Println("自签名证书生成完成")
-			fmt.Printf("HTTPS服务器启动在 %s 端口, 工作目录: %s\n", addr, baseDir)
-			fmt.Printf("访问地址: https://localhost:%d\n", *port)
-			if err := server.ListenAndServeTLS("", ""); err != nil {
-				fmt.Printf("HTTPS服务器启动失败: %v\n", err)
-			}
-		} else {
-			// 使用提供的证书文件
-			fmt.Printf("HTTPS服务器启动在 %s 端口, 工作目录: %s\n", addr, baseDir)
-			fmt.Printf("访问地址: https://localhost:%d\n", *port)
-			if err := http.ListenAndServeTLS(addr, certFile, keyFile, nil); err != nil {
-				fmt.Printf("HTTPS服务器启动失败: %v\n", err)
-			}
+		fmt.Printf("HTTPS服务器启动在 %s 端口, 工作目录: %s\n", addr, baseDir)
+		fmt.Printf("访问地址: https://%s\n", visitAddr)
+		if err := server.ListenAndServeTLS(certFile, keyFile); err != nil {
+			fmt.Printf("HTTPS服务器启动失败: %v\n", err)
 		}
 	} else {
 		fmt.Printf("HTTP服务器启动在 %s 端口, 工作目录: %s\n", addr, baseDir)
-		fmt.Printf("访问地址: http://localhost:%d\n", *port)
-		if err := http.ListenAndServe(addr, nil); err != nil {
+		fmt.Printf("访问地址: http://%s\n", visitAddr)
+		if err := server.ListenAndServe(); err != nil {
 			fmt.Printf("HTTP服务器启动失败: %v\n", err)
 		}
 	}
@@ -0,0 +1,4923 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	if err := loadTemplates(""); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// setupTest 把全局配置恢复为命令行参数的默认值，并以新的临时目录作为工作目录，返回该目录
+func setupTest(t *testing.T) string {
+	t.Helper()
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseDir = dir
+	username, password = "", ""
+	users = make(map[string]userAccount)
+	anonRole = roleAdmin
+	allowBasicAuth = false
+	tokenMu.Lock()
+	tokens = nil
+	tokenMu.Unlock()
+	tlsEnabled, hsts = false, false
+	tlsMinVer = "1.2"
+	corsOrigins, uploadAllow = nil, nil
+	sessIdle, sessMaxAge = 24*time.Hour, 90*24*time.Hour
+	displayLoc, dateFormat = time.Local, "2006-01-02 15:04:05"
+	followSymlinks = false
+	metricsOn, metricsToken = false, ""
+	maxUploadSize, multipartMemory, uploadTempDir = 0, 10<<20, ""
+	maxNameLength, maxPathLength = 255, 4096
+	sizeUnits = "binary"
+	auditFile, accessLog = nil, nil
+	showAbsPath, serveIndex = false, false
+	siteTitle, logoURL, uiLang = "简易网页文件管理器", "", "auto"
+	defaultSort, defaultOrder = "name", ""
+	fetchTimeout, allowPrivateFetch = 10*time.Minute, false
+	gzipTypes = make(map[string]bool)
+	for _, ext := range strings.Split("txt,log,csv,tsv,json,xml,html,htm,css,js,md,yaml,yml,ini,conf,svg", ",") {
+		gzipTypes[ext] = true
+	}
+	blockedExts, sniffExecutables = map[string]bool{}, false
+	dedupeMode = "off"
+	hashIndexMu.Lock()
+	hashIndex = make(map[string][]hashEntry)
+	hashIndexMu.Unlock()
+	checksumAlgo, checksums = "", nil
+	uploadsMu.Lock()
+	uploads = make(map[string]*chunkUpload)
+	uploadsMu.Unlock()
+	transferSlots, noListing = nil, false
+	copyPreserve = true
+	uploadIdle, downloadIdle = 5*time.Minute, 5*time.Minute
+	pathPrefix, cookieName, cookiePath = "", "auth_token", "/"
+	searchIndex, indexMaxEntries = nil, 200000
+	allowEmptyRoot = false
+	// 缩短 /events 的轮询间隔，让事件测试更快完成
+	dirPollInterval = 50 * time.Millisecond
+	return dir
+}
+
+// testHandler 返回带完整中间件与路由的处理器，需在设置好全局配置后调用
+func testHandler() http.Handler {
+	return newHandler(newRouter())
+}
+
+// serve 通过 h 处理一个请求并返回记录的响应
+func serve(h http.Handler, method, target string, body io.Reader) *httptest.ResponseRecorder {
+	return serveReq(h, httptest.NewRequest(method, target, body))
+}
+
+// serveReq 通过 h 处理已构造好的请求
+func serveReq(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// addTestUser 添加账户并关闭匿名访问，home 为相对于工作目录的用户根目录（可为空）
+func addTestUser(t *testing.T, name, role, home string) {
+	t.Helper()
+	account := userAccount{Password: "pw", Role: role}
+	if home != "" {
+		account.Home = filepath.Join(baseDir, home)
+		if err := os.MkdirAll(account.Home, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	users[name] = account
+	anonRole = ""
+}
+
+// loginRequest 以 JSON 调用 /api/login
+func loginRequest(h http.Handler, user, pass string, remember bool) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{"username": user, "password": pass, "remember_me": remember})
+	return serve(h, "POST", "/api/login", bytes.NewReader(body))
+}
+
+// login 登录并返回 token
+func login(t *testing.T, h http.Handler, user string) string {
+	t.Helper()
+	rec := loginRequest(h, user, "pw", false)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("登录 %s 失败: %d %s", user, rec.Code, rec.Body)
+	}
+	var info TokenInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	return info.Token
+}
+
+// authed 构造携带 Bearer token 的请求
+func authed(token, method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+// postForm 以 application/x-www-form-urlencoded 提交表单
+func postForm(h http.Handler, target string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return serveReq(h, req)
+}
+
+// uploadRequest 构造 /upload 的 multipart 请求，files 依次为文件名与内容，fields 为附加的表单字段
+func uploadRequest(t *testing.T, target string, fields map[string]string, files ...string) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	for i := 0; i+1 < len(files); i += 2 {
+		fw, err := mw.CreateFormFile("files[]", files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(fw, files[i+1])
+	}
+	mw.Close()
+	req := httptest.NewRequest("POST", target, &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+// writeTestFile 在 dir 下创建文件（包括缺失的上级目录）
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// testCert 生成供 TLS 测试使用的自签名证书
+func testCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	certPEM, keyPEM, err := generateSelfSignedCert(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestTLSRejectsOldVersions(t *testing.T) {
+	tlsMinVer = "1.2"
+	cfg, err := newTLSConfig(testCert(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.TLS = cfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	for _, v := range []uint16{tls.VersionTLS10, tls.VersionTLS11} {
+		conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{
+			InsecureSkipVerify: true,
+			MinVersion:         v,
+			MaxVersion:         v,
+		})
+		if err == nil {
+			conn.Close()
+			t.Errorf("TLS 版本 %s 的握手应被拒绝", tls.VersionName(v))
+		}
+	}
+
+	conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("TLS 1.2+ 握手失败: %v", err)
+	}
+	if v := conn.ConnectionState().Version; v < tls.VersionTLS12 {
+		t.Errorf("协商的版本为 %s", tls.VersionName(v))
+	}
+	conn.Close()
+}
+
+func TestTLSMinVersion13(t *testing.T) {
+	tlsMinVer = "1.3"
+	defer func() { tlsMinVer = "1.2" }()
+	cfg, err := newTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS13 {
+		t.Errorf("MinVersion = %x", cfg.MinVersion)
+	}
+	tlsMinVer = "1.0"
+	if _, err := newTLSConfig(); err == nil {
+		t.Error("-tls-min-version 1.0 应报错")
+	}
+}
+
+func TestSelfSignedCertSAN(t *testing.T) {
+	certPEM, _, err := generateSelfSignedCert([]string{"files.example.com", "192.0.2.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []string{"files.example.com", "192.0.2.10", "localhost", "127.0.0.1"} {
+		if err := leaf.VerifyHostname(h); err != nil {
+			t.Errorf("证书不包含 %s: %v", h, err)
+		}
+	}
+	found := false
+	for _, ip := range leaf.IPAddresses {
+		if ip.Equal(net.ParseIP("192.0.2.10")) {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("IP SAN 未写入 IPAddresses")
+	}
+	if leaf.SerialNumber.Cmp(big.NewInt(1)) == 0 {
+		t.Error("序列号应为随机值")
+	}
+}
+
+func TestCertCacheReused(t *testing.T) {
+	dir := t.TempDir()
+	first, err := loadOrGenerateCert(dir, []string{"files.example.com"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := loadOrGenerateCert(dir, []string{"files.example.com"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Certificate[0], second.Certificate[0]) {
+		t.Error("第二次启动没有复用缓存的证书")
+	}
+
+	// 缓存证书未覆盖新要求的 SAN 或指定 -regenerate-cert 时重新生成
+	third, err := loadOrGenerateCert(dir, []string{"other.example.com"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first.Certificate[0], third.Certificate[0]) {
+		t.Error("新增 SAN 后应重新生成证书")
+	}
+	forced, err := loadOrGenerateCert(dir, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(third.Certificate[0], forced.Certificate[0]) {
+		t.Error("-regenerate-cert 应强制生成新证书")
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	setupTest(t)
+	rec := serve(testHandler(), "GET", "/", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("首页返回 %d", rec.Code)
+	}
+	want := map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"X-Frame-Options":        "SAMEORIGIN",
+		"Referrer-Policy":        "no-referrer",
+	}
+	for k, v := range want {
+		if got := rec.Header().Get(k); got != v {
+			t.Errorf("%s = %q, 期望 %q", k, got, v)
+		}
+	}
+	if rec.Header().Get("Strict-Transport-Security") != "" {
+		t.Error("未启用 TLS 时不应发送 HSTS")
+	}
+
+	tlsEnabled, hsts = true, true
+	rec = serve(testHandler(), "GET", "/", nil)
+	if rec.Header().Get("Strict-Transport-Security") == "" {
+		t.Error("-hsts 且启用 TLS 时应发送 HSTS")
+	}
+}
+
+func TestAuthCookieAttributes(t *testing.T) {
+	setupTest(t)
+	addTestUser(t, "alice", roleViewer, "")
+	tlsEnabled = true
+	h := testHandler()
+
+	for _, remember := range []bool{false, true} {
+		start := time.Now()
+		rec := loginRequest(h, "alice", "pw", remember)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("登录失败: %d", rec.Code)
+		}
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("期望一个 Set-Cookie，实际 %d", len(cookies))
+		}
+		c := cookies[0]
+		if c.Name != "auth_token" || c.Value == "" {
+			t.Errorf("cookie = %s=%q", c.Name, c.Value)
+		}
+		if !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode || c.Path != "/" {
+			t.Errorf("cookie 属性不正确: %s", rec.Header().Get("Set-Cookie"))
+		}
+		want := sessIdle
+		if remember {
+			want = 30 * 24 * time.Hour
+		}
+		if d := c.Expires.Sub(start); d < want-time.Minute || d > want+time.Minute {
+			t.Errorf("remember=%v 时 Expires 相差 %v，期望约 %v", remember, d, want)
+		}
+	}
+
+	// 退出登录以相同属性清除 cookie
+	rec := serve(h, "GET", "/logout", nil)
+	c := rec.Result().Cookies()[0]
+	if c.Value != "" || !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode || c.Expires.After(time.Now()) {
+		t.Errorf("退出登录的 cookie 不正确: %s", rec.Header().Get("Set-Cookie"))
+	}
+
+	// 未启用 TLS 时不设置 Secure，否则浏览器不会在 HTTP 下回传 cookie
+	tlsEnabled = false
+	rec = loginRequest(h, "alice", "pw", false)
+	if rec.Result().Cookies()[0].Secure {
+		t.Error("未启用 TLS 时 cookie 不应带 Secure")
+	}
+}
+
+func TestSlidingExpiration(t *testing.T) {
+	setupTest(t)
+	addTestUser(t, "alice", roleViewer, "")
+	sessIdle, sessMaxAge = time.Hour, 3*time.Hour
+	h := testHandler()
+	token := login(t, h, "alice")
+
+	// 剩余有效期不足一半时，一次访问把过期时间续到一个完整周期之后
+	tokenMu.Lock()
+	tokens[token].ExpiresAt = time.Now().Add(10 * time.Minute)
+	tokenMu.Unlock()
+	if rec := serveReq(h, authed(token, "GET", "/list", nil)); rec.Code != http.StatusOK {
+		t.Fatalf("访问失败: %d", rec.Code)
+	}
+	sess, _ := lookupToken(token)
+	if d := time.Until(sess.ExpiresAt); d < 59*time.Minute {
+		t.Errorf("访问后剩余有效期 %v，期望约 1h", d)
+	}
+
+	// 续期不能超过签发时间加 -session-max-age
+	issued := time.Now().Add(-170 * time.Minute)
+	tokenMu.Lock()
+	tokens[token].IssuedAt = issued
+	tokens[token].ExpiresAt = time.Now().Add(5 * time.Minute)
+	tokenMu.Unlock()
+	serveReq(h, authed(token, "GET", "/list", nil))
+	sess, _ = lookupToken(token)
+	if limit := issued.Add(sessMaxAge); sess.ExpiresAt.After(limit) {
+		t.Errorf("过期时间 %v 超过了上限 %v", sess.ExpiresAt, limit)
+	}
+
+	// 到达上限后 token 失效
+	tokenMu.Lock()
+	tokens[token].ExpiresAt = time.Now().Add(-time.Second)
+	tokenMu.Unlock()
+	if rec := serveReq(h, authed(token, "GET", "/api/list", nil)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("过期 token 访问返回 %d，期望 401", rec.Code)
+	}
+}
+
+func TestSlidingExpirationCookieRenewed(t *testing.T) {
+	setupTest(t)
+	addTestUser(t, "alice", roleViewer, "")
+	sessIdle = time.Hour
+	h := testHandler()
+	token := login(t, h, "alice")
+	tokenMu.Lock()
+	tokens[token].ExpiresAt = time.Now().Add(10 * time.Minute)
+	tokenMu.Unlock()
+
+	req := httptest.NewRequest("GET", "/list", nil)
+	req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+	rec := serveReq(h, req)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || time.Until(cookies[0].Expires) < 59*time.Minute {
+		t.Errorf("续期后应同步更新 cookie 过期时间: %v", rec.Header().Values("Set-Cookie"))
+	}
+}
+
+func TestSessionEndpoint(t *testing.T) {
+	setupTest(t)
+	addTestUser(t, "alice", roleEditor, "")
+	h := testHandler()
+	token := login(t, h, "alice")
+
+	decode := func(rec *httptest.ResponseRecorder) map[string]interface{} {
+		t.Helper()
+		var m map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &m); err != nil {
+			t.Fatalf("响应不是 JSON: %s", rec.Body)
+		}
+		return m
+	}
+
+	rec := serveReq(h, authed(token, "GET", "/api/session", nil))
+	m := decode(rec)
+	if rec.Code != http.StatusOK || m["authenticated"] != true || m["username"] != "alice" || m["role"] != roleEditor || m["expires_at"] == nil {
+		t.Errorf("有效 token: %d %v", rec.Code, m)
+	}
+
+	// 缺少 token：401 且不重定向
+	rec = serve(h, "GET", "/api/session", nil)
+	if m := decode(rec); rec.Code != http.StatusUnauthorized || m["authenticated"] != false || rec.Header().Get("Location") != "" {
+		t.Errorf("缺少 token: %d %v", rec.Code, m)
+	}
+
+	tokenMu.Lock()
+	tokens[token].ExpiresAt = time.Now().Add(-time.Minute)
+	tokenMu.Unlock()
+	rec = serveReq(h, authed(token, "GET", "/api/session", nil))
+	if m := decode(rec); rec.Code != http.StatusUnauthorized || m["authenticated"] != false {
+		t.Errorf("过期 token: %d %v", rec.Code, m)
+	}
+}
+
+func TestHealthChecksBypassAuth(t *testing.T) {
+	dir := setupTest(t)
+	addTestUser(t, "alice", roleViewer, "")
+	h := testHandler()
+
+	for _, p := range []string{"/healthz", "/readyz"} {
+		rec := serve(h, "GET", p, nil)
+		var m map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &m)
+		if rec.Code != http.StatusOK || m["status"] != "ok" {
+			t.Errorf("%s 未带 token 返回 %d %s", p, rec.Code, rec.Body)
+		}
+	}
+	// 登录保护的页面仍然重定向
+	if rec := serve(h, "GET", "/", nil); rec.Code != http.StatusFound {
+		t.Errorf("未登录访问首页返回 %d", rec.Code)
+	}
+
+	// 工作目录不可访问时 /readyz 返回 503，/healthz 仍为 200
+	baseDir = filepath.Join(dir, "missing")
+	if rec := serve(h, "GET", "/readyz", nil); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("工作目录缺失时 /readyz 返回 %d", rec.Code)
+	}
+	if rec := serve(h, "GET", "/healthz", nil); rec.Code != http.StatusOK {
+		t.Errorf("工作目录缺失时 /healthz 返回 %d", rec.Code)
+	}
+}
+
+func TestUploadPreservesMtime(t *testing.T) {
+	dir := setupTest(t)
+	h := testHandler()
+	old := time.Date(2019, 6, 1, 12, 30, 0, 0, time.UTC)
+	req := uploadRequest(t, "/upload?path=", map[string]string{
+		"lastModified[]": strconv.FormatInt(old.UnixMilli(), 10),
+	}, "photo.jpg", "jpeg data")
+	if rec := serveReq(h, req); rec.Code != http.StatusOK {
+		t.Fatalf("上传失败: %d %s", rec.Code, rec.Body)
+	}
+	info, err := os.Stat(filepath.Join(dir, "photo.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(old) {
+		t.Errorf("mtime = %v，期望 %v", info.ModTime(), old)
+	}
+
+	// 未提供或超出合理范围时使用当前时间
+	future := time.Now().Add(48 * time.Hour)
+	req = uploadRequest(t, "/upload?path=", map[string]string{
+		"lastModified[]": strconv.FormatInt(future.UnixMilli(), 10),
+	}, "new.txt", "x")
+	serveReq(h, req)
+	info, _ = os.Stat(filepath.Join(dir, "new.txt"))
+	if d := time.Since(info.ModTime()); d < 0 || d > time.Minute {
+		t.Errorf("未来时间应被忽略，mtime = %v", info.ModTime())
+	}
+
+	// 按时间排序使用保留下来的 mtime：新文件在前
+	files, err := readFileInfos(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortFiles(files, "time", "desc")
+	if files[0].Name != "new.txt" || files[1].Name != "photo.jpg" {
+		t.Errorf("按时间排序结果: %s, %s", files[0].Name, files[1].Name)
+	}
+}
+
+func TestFormatTimeZones(t *testing.T) {
+	setupTest(t)
+	known := time.Date(2024, 3, 1, 23, 30, 0, 0, time.UTC)
+	displayLoc = time.UTC
+	if got := formatTime(known); got != "2024-03-01 23:30:00" {
+		t.Errorf("UTC: %s", got)
+	}
+	loc, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip("系统缺少时区数据:", err)
+	}
+	displayLoc = loc
+	if got := formatTime(known); got != "2024-03-02 07:30:00" {
+		t.Errorf("Asia/Shanghai: %s", got)
+	}
+	dateFormat = "02/01/2006"
+	if got := formatTime(known); got != "02/03/2024" {
+		t.Errorf("自定义格式: %s", got)
+	}
+}
+
+func TestListingDateFormatWithoutSpace(t *testing.T) {
+	dir := setupTest(t)
+	p := writeTestFile(t, dir, "a.txt", "a")
+	known := time.Date(2024, 3, 1, 23, 30, 0, 0, time.UTC)
+	os.Chtimes(p, known, known)
+	displayLoc, dateFormat = time.UTC, "2006/01/02"
+	rec := serve(testHandler(), "GET", "/", nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "2024/03/01") {
+		t.Errorf("不含空格的日期格式未正确渲染: %d", rec.Code)
+	}
+}
+
+func TestTimeAgoBuckets(t *testing.T) {
+	setupTest(t)
+	now := time.Now()
+	tests := []struct {
+		ago  time.Duration
+		want string
+	}{
+		{0, "刚刚"},
+		{59 * time.Second, "刚刚"},
+		{61 * time.Second, "1分钟前"},
+		{59*time.Minute + 59*time.Second, "59分钟前"},
+		{time.Hour + time.Second, "1小时前"},
+		{23*time.Hour + 59*time.Minute, "23小时前"},
+		{24*time.Hour + time.Minute, "1天前"},
+		{29 * 24 * time.Hour, "29天前"},
+		{31 * 24 * time.Hour, "1个月前"},
+		{400 * 24 * time.Hour, "1年前"},
+		// 略晚于当前时间（时钟偏差）仍显示为刚刚
+		{-30 * time.Second, "刚刚"},
+	}
+	for _, tt := range tests {
+		if got := timeAgo(defaultLang, now.Add(-tt.ago)); got != tt.want {
+			t.Errorf("timeAgo(-%v) = %q，期望 %q", tt.ago, got, tt.want)
+		}
+	}
+	if got := timeAgo("en", now.Add(-5*time.Minute)); got != "5 min ago" {
+		t.Errorf("英文: %q", got)
+	}
+
+	// 明显在未来的时间显示为绝对时间
+	future := now.Add(3 * time.Hour)
+	if got := timeAgo(defaultLang, future); got != formatTime(future) {
+		t.Errorf("未来时间: %q", got)
+	}
+}
+
+func TestSortByType(t *testing.T) {
+	dir := setupTest(t)
+	for _, name := range []string{"b.txt", "README", "a.tar.gz", "archive.zip", "notes.TXT", ".bashrc", "c.gz"} {
+		writeTestFile(t, dir, name, "x")
+	}
+	os.Mkdir(filepath.Join(dir, "zdir"), 0755)
+	os.Mkdir(filepath.Join(dir, "adir.d"), 0755)
+
+	files, err := readFileInfos(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exts := map[string]string{}
+	for _, f := range files {
+		exts[f.Name] = f.Ext
+	}
+	for name, want := range map[string]string{"README": "", ".bashrc": "", "a.tar.gz": "gz", "notes.TXT": "txt", "adir.d": ""} {
+		if exts[name] != want {
+			t.Errorf("%s 的扩展名为 %q，期望 %q", name, exts[name], want)
+		}
+	}
+
+	sortFiles(files, "type", "asc")
+	var got []string
+	for _, f := range files {
+		got = append(got, f.Name)
+	}
+	// 目录成组在前；无扩展名的文件在前；同扩展名按名称（不区分大小写）
+	want := []string{"adir.d", "zdir", ".bashrc", "README", "a.tar.gz", "c.gz", "b.txt", "notes.TXT", "archive.zip"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("按类型排序:\n得到 %v\n期望 %v", got, want)
+	}
+
+	rec := serve(testHandler(), "GET", "/?sort=type", nil)
+	if !strings.Contains(rec.Body.String(), "sort=type") {
+		t.Error("表头缺少按类型排序的链接")
+	}
+}
+
+func TestSymlinkLoop(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "sub/a.txt", "hello")
+	if err := os.Symlink("..", filepath.Join(dir, "sub", "up")); err != nil {
+		t.Skip("无法创建符号链接:", err)
+	}
+
+	files, err := readFileInfos(filepath.Join(dir, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if f.Name == "up" && (!f.IsSymlink || f.LinkTarget != ".." || !f.IsDir) {
+			t.Errorf("符号链接信息不正确: %+v", f)
+		}
+	}
+
+	for _, follow := range []bool{false, true} {
+		followSymlinks = follow
+		done := make(chan int)
+		go func() {
+			n := 0
+			walkTree(dir, func(path string, info os.FileInfo) error {
+				n++
+				return nil
+			})
+			done <- n
+		}()
+		select {
+		case n := <-done:
+			// sub、sub/a.txt、sub/up；跟随链接时 up 指向根目录，已在祖先中，不再进入
+			if n != 3 {
+				t.Errorf("follow=%v 时遍历到 %d 项", follow, n)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("follow=%v 时遍历未结束，循环未被打断", follow)
+		}
+	}
+
+	// 递归统计与打包同样能结束
+	followSymlinks = true
+	h := testHandler()
+	if rec := serve(h, "GET", "/dirinfo?path=&name=sub", nil); rec.Code != http.StatusOK {
+		t.Errorf("/dirinfo 返回 %d %s", rec.Code, rec.Body)
+	}
+	var buf bytes.Buffer
+	if _, err := compressDir(filepath.Join(dir, "sub"), &buf); err != nil {
+		t.Errorf("打包失败: %v", err)
+	}
+
+	// 删除包含链接的目录不会删除链接指向的内容
+	writeTestFile(t, dir, "keep.txt", "keep")
+	if rec := serve(h, "GET", "/delete?path=&file=sub", nil); rec.Code >= 400 {
+		t.Fatalf("删除返回 %d %s", rec.Code, rec.Body)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "sub")); !os.IsNotExist(err) {
+		t.Error("目录未被删除")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "keep.txt")); err != nil {
+		t.Error("删除目录时跟随了符号链接")
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	setupTest(t)
+	good := []string{"a.txt", "报告.pdf", ".hidden", "name with spaces.md", "icon.png", "COM10", strings.Repeat("a", 255)}
+	for _, name := range good {
+		if err := validateName(name); err != nil {
+			t.Errorf("validateName(%q) = %v，期望通过", name, err)
+		}
+	}
+	bad := []string{"", "   ", ".", "..", "a/b", `a\b`, "a\x00b", "tab\tname", "trail.", "trail ", "CON", "prn.txt", "Aux", "nul.tar.gz", "COM1", "lpt9.log", "a:b", "a*b", "a?b", `a"b`, "a<b", "a|b", strings.Repeat("a", 256)}
+	for _, name := range bad {
+		if err := validateName(name); err == nil {
+			t.Errorf("validateName(%q) 应被拒绝", name)
+		}
+	}
+}
+
+func TestRejectBadNamesOnWrite(t *testing.T) {
+	dir := setupTest(t)
+	h := testHandler()
+	for _, name := range []string{"CON", "a/b", "x.", "..", "bad\x01"} {
+		rec := postForm(h, "/create", url.Values{"type": {"file"}, "name": {name}, "path": {""}})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("创建 %q 返回 %d，期望 400", name, rec.Code)
+		}
+	}
+	// multipart 解析时已去掉文件名中的目录部分，这里只检查其余的非法名称
+	for _, name := range []string{"CON", "x.", "bad\x01"} {
+		rec := serveReq(h, uploadRequest(t, "/upload?path=", nil, name, "x"))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("上传 %q 返回 %d，期望 400", name, rec.Code)
+		}
+	}
+	writeTestFile(t, dir, "ok.txt", "x")
+	if rec := postForm(h, "/rename", url.Values{"path": {""}, "old": {"ok.txt"}, "new": {"PRN.txt"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("重命名为保留名称返回 %d", rec.Code)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("工作目录中出现了意外的文件: %v", entries)
+	}
+}
+
+func TestSecureJoin(t *testing.T) {
+	dir := setupTest(t)
+	outside := t.TempDir()
+	writeTestFile(t, outside, "secret.txt", "secret")
+	writeTestFile(t, dir, "..foo/a.txt", "sibling")
+	writeTestFile(t, dir, "a/b.txt", "b")
+	if err := os.Symlink(outside, filepath.Join(dir, "escape")); err != nil {
+		t.Skip("无法创建符号链接:", err)
+	}
+	os.Symlink(filepath.Join(outside, "new.txt"), filepath.Join(dir, "dangling"))
+	os.Symlink("a", filepath.Join(dir, "inside"))
+
+	allowed := []string{"", ".", "a", "a/b.txt", "..foo", "..foo/a.txt", "a/../a/b.txt", "a/./b.txt", "...", "inside/b.txt", "a/not-yet-created.txt"}
+	for _, rel := range allowed {
+		if _, err := secureJoin(dir, rel); err != nil {
+			t.Errorf("secureJoin(%q) = %v，期望允许", rel, err)
+		}
+	}
+	rejected := []string{"..", "../", "a/../..", "a/../../b", "../" + filepath.Base(dir) + "x", "escape", "escape/secret.txt", "dangling", "escape/new-dir/x"}
+	for _, rel := range rejected {
+		if p, err := secureJoin(dir, rel); err == nil {
+			t.Errorf("secureJoin(%q) = %s，期望拒绝", rel, p)
+		}
+	}
+
+	// 通过 HTTP 同样无法借助链接读取或写入工作目录之外的文件
+	h := testHandler()
+	if rec := serve(h, "GET", "/download?path=escape&file=secret.txt", nil); rec.Code < 400 || strings.Contains(rec.Body.String(), "secret") {
+		t.Errorf("经符号链接下载返回 %d", rec.Code)
+	}
+	serveReq(h, uploadRequest(t, "/upload?path=escape", nil, "x.txt", "x"))
+	if _, err := os.Stat(filepath.Join(outside, "x.txt")); err == nil {
+		t.Error("经符号链接上传写到了工作目录之外")
+	}
+
+	// -follow-symlinks 时允许指向外部的链接，但词法上的 .. 仍被拒绝
+	followSymlinks = true
+	if _, err := secureJoin(dir, "escape/secret.txt"); err != nil {
+		t.Errorf("-follow-symlinks 时应允许链接: %v", err)
+	}
+	if _, err := secureJoin(dir, "a/../../b"); err == nil {
+		t.Error("-follow-symlinks 时仍应拒绝 ..")
+	}
+}
+
+func TestIsWithin(t *testing.T) {
+	base := filepath.FromSlash("/srv/files")
+	tests := map[string]bool{
+		"/srv/files":           true,
+		"/srv/files/a":         true,
+		"/srv/files/..foo":     true,
+		"/srv/files/a/..b":     true,
+		"/srv":                 false,
+		"/srv/files2":          false,
+		"/srv/files/../other":  false,
+		"/srv/filesx/..":       false,
+		"/etc/passwd":          false,
+		"/srv/files/a/../../x": false,
+	}
+	for target, want := range tests {
+		if got := isWithin(base, filepath.FromSlash(target)); got != want {
+			t.Errorf("isWithin(%s) = %v，期望 %v", target, got, want)
+		}
+	}
+}
+
+func TestDirInfo(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "a.txt", "aaa")
+	writeTestFile(t, dir, "sub/b.txt", "bbbbb")
+	writeTestFile(t, dir, "sub/deep/c.txt", "ccccccc")
+	os.Mkdir(filepath.Join(dir, "empty"), 0755)
+	h := testHandler()
+
+	stats := func(rel string) map[string]interface{} {
+		t.Helper()
+		rec := serve(h, "GET", "/dirinfo?path="+rel, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("/dirinfo?path=%s 返回 %d %s", rel, rec.Code, rec.Body)
+		}
+		var m map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &m)
+		return m
+	}
+	m := stats("")
+	if m["total_size"] != 15.0 || m["file_count"] != 3.0 || m["dir_count"] != 3.0 {
+		t.Errorf("根目录统计: %v", m)
+	}
+	m = stats("sub")
+	if m["total_size"] != 12.0 || m["file_count"] != 2.0 || m["dir_count"] != 1.0 {
+		t.Errorf("sub 统计: %v", m)
+	}
+
+	// 深层目录中的修改使祖先目录的缓存失效
+	serveReq(h, uploadRequest(t, "/upload?path=sub/deep", nil, "d.txt", "dd"))
+	if m := stats(""); m["total_size"] != 17.0 || m["file_count"] != 4.0 {
+		t.Errorf("上传后根目录统计未更新: %v", m)
+	}
+
+	if rec := serve(h, "GET", "/dirinfo?path=missing", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("不存在的目录返回 %d", rec.Code)
+	}
+	if rec := serve(h, "GET", "/dirinfo?path=../", nil); rec.Code == http.StatusOK {
+		t.Error("越界路径应被拒绝")
+	}
+}
+
+func TestMetricsScrape(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "a.txt", "hello")
+	addTestUser(t, "boss", roleAdmin, "")
+	addTestUser(t, "alice", roleViewer, "")
+	metricsOn = true
+	m := &metricsRegistry
+	m.Lock()
+	m.requests = make(map[[2]string]uint64)
+	m.durations = make(map[string]*histogram)
+	m.reqBytes = make(map[string]int64)
+	m.respBytes = make(map[string]int64)
+	m.Unlock()
+	h := testHandler()
+	token := login(t, h, "boss")
+	serveReq(h, authed(token, "GET", "/download?file=a.txt", nil))
+
+	rec := serveReq(h, authed(token, "GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/metrics 返回 %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %s", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`hfs_http_requests_total{handler="/download",code="200"} 1`,
+		`hfs_http_request_duration_seconds_count{handler="/download"} 1`,
+		`hfs_http_request_duration_seconds_bucket{handler="/download",le="+Inf"} 1`,
+		`hfs_http_response_bytes_total{handler="/download"} 5`,
+		"hfs_active_tokens 1",
+		"# TYPE hfs_uptime_seconds gauge",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("指标中缺少 %s", want)
+		}
+	}
+
+	// 非管理员无权查看
+	if rec := serveReq(h, authed(login(t, h, "alice"), "GET", "/metrics", nil)); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer 访问 /metrics 返回 %d", rec.Code)
+	}
+
+	// 配置 -metrics-token 后使用独立的 token，登录 token 不再有效
+	metricsToken = "scrape-secret"
+	h = testHandler()
+	if rec := serveReq(h, authed("scrape-secret", "GET", "/metrics", nil)); rec.Code != http.StatusOK {
+		t.Errorf("使用 -metrics-token 返回 %d", rec.Code)
+	}
+	for _, bad := range []string{token, "scrape-secre", "scrape-secretx", ""} {
+		if rec := serveReq(h, authed(bad, "GET", "/metrics", nil)); rec.Code != http.StatusUnauthorized {
+			t.Errorf("token %q 返回 %d，期望 401", bad, rec.Code)
+		}
+	}
+}
+
+// sseEvents 读取事件流中的 event 行，连接关闭后关闭通道
+func sseEvents(body io.Reader) <-chan string {
+	ch := make(chan string, 16)
+	go func() {
+		defer close(ch)
+		sc := bufio.NewScanner(body)
+		for sc.Scan() {
+			if ev, ok := strings.CutPrefix(sc.Text(), "event: "); ok {
+				ch <- ev
+			}
+		}
+	}()
+	return ch
+}
+
+func TestEventsSharedWatch(t *testing.T) {
+	dir := setupTest(t)
+	srv := httptest.NewServer(testHandler())
+	defer srv.Close()
+
+	var streams []<-chan string
+	var bodies []io.Closer
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(srv.URL + "/events?path=")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("Content-Type = %s", ct)
+		}
+		streams = append(streams, sseEvents(resp.Body))
+		bodies = append(bodies, resp.Body)
+	}
+
+	// 两个订阅者共用同一个轮询
+	dirWatchesMu.Lock()
+	subs := len(dirWatches[dir].subs)
+	dirWatchesMu.Unlock()
+	if subs != 2 {
+		t.Errorf("订阅者 %d，期望 2", subs)
+	}
+
+	writeTestFile(t, dir, "new.txt", "x")
+	for i, ch := range streams {
+		select {
+		case ev := <-ch:
+			if ev != "change" {
+				t.Errorf("订阅者 %d 收到 %q", i, ev)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("订阅者 %d 没有收到创建文件的事件", i)
+		}
+	}
+
+	// 所有客户端断开后轮询停止
+	for _, b := range bodies {
+		b.Close()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		dirWatchesMu.Lock()
+		_, running := dirWatches[dir]
+		dirWatchesMu.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("客户端断开后轮询仍在运行")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestEventsDirGone(t *testing.T) {
+	dir := setupTest(t)
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	srv := httptest.NewServer(testHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events?path=sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	ch := sseEvents(resp.Body)
+	os.RemoveAll(filepath.Join(dir, "sub"))
+	select {
+	case ev := <-ch:
+		if ev != "gone" {
+			t.Errorf("目录删除后收到 %q", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("目录删除后没有收到 gone 事件")
+	}
+
+	if rec := serve(testHandler(), "GET", "/events?path=../", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("越界路径返回 %d", rec.Code)
+	}
+}
+
+func TestDownloadConditional(t *testing.T) {
+	dir := setupTest(t)
+	p := writeTestFile(t, dir, "data.bin", "0123456789")
+	mtime := time.Date(2023, 5, 6, 7, 8, 9, 0, time.UTC)
+	os.Chtimes(p, mtime, mtime)
+	h := testHandler()
+
+	rec := serve(h, "GET", "/download?file=data.bin", nil)
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" || !strings.HasPrefix(etag, "W/") {
+		t.Fatalf("首次下载: %d ETag=%q", rec.Code, etag)
+	}
+	if lm := rec.Header().Get("Last-Modified"); lm != mtime.Format(http.TimeFormat) {
+		t.Errorf("Last-Modified = %q", lm)
+	}
+
+	req := httptest.NewRequest("GET", "/download?file=data.bin", nil)
+	req.Header.Set("If-None-Match", etag)
+	if rec := serveReq(h, req); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("If-None-Match 匹配时返回 %d", rec.Code)
+	}
+	req = httptest.NewRequest("GET", "/download?file=data.bin", nil)
+	req.Header.Set("If-Modified-Since", mtime.Format(http.TimeFormat))
+	if rec := serveReq(h, req); rec.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since 未变化时返回 %d", rec.Code)
+	}
+	req = httptest.NewRequest("GET", "/download?file=data.bin", nil)
+	req.Header.Set("If-Modified-Since", mtime.Add(-time.Hour).Format(http.TimeFormat))
+	if rec := serveReq(h, req); rec.Code != http.StatusOK {
+		t.Errorf("文件较新时 If-Modified-Since 返回 %d", rec.Code)
+	}
+
+	// If-Range 与当前版本一致时返回分段，不一致时返回完整内容
+	req = httptest.NewRequest("GET", "/download?file=data.bin", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	req.Header.Set("If-Range", mtime.Format(http.TimeFormat))
+	if rec := serveReq(h, req); rec.Code != http.StatusPartialContent || rec.Body.String() != "234" {
+		t.Errorf("If-Range 匹配: %d %q", rec.Code, rec.Body)
+	}
+	req = httptest.NewRequest("GET", "/download?file=data.bin", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	req.Header.Set("If-Range", mtime.Add(-time.Hour).Format(http.TimeFormat))
+	if rec := serveReq(h, req); rec.Code != http.StatusOK || rec.Body.String() != "0123456789" {
+		t.Errorf("If-Range 过期: %d %q", rec.Code, rec.Body)
+	}
+
+	// 文件变化后 ETag 随之改变
+	os.WriteFile(p, []byte("changed content"), 0644)
+	req = httptest.NewRequest("GET", "/download?file=data.bin", nil)
+	req.Header.Set("If-None-Match", etag)
+	if rec := serveReq(h, req); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("文件修改后仍返回 %d ETag=%s", rec.Code, rec.Header().Get("ETag"))
+	}
+}
+
+func TestDownloadRanges(t *testing.T) {
+	dir := setupTest(t)
+	content := "abcdefghijklmnopqrstuvwxyz"
+	writeTestFile(t, dir, "letters.bin", content)
+	h := testHandler()
+
+	get := func(rng string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/download?file=letters.bin", nil)
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		return serveReq(h, req)
+	}
+
+	rec := get("")
+	if rec.Code != http.StatusOK || rec.Body.String() != content || rec.Header().Get("Accept-Ranges") != "bytes" || rec.Header().Get("Content-Length") != "26" {
+		t.Errorf("完整下载: %d %q %v", rec.Code, rec.Body, rec.Header())
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment;") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	singles := map[string]struct{ body, cr string }{
+		"bytes=0-4":   {"abcde", "bytes 0-4/26"},
+		"bytes=20-":   {"uvwxyz", "bytes 20-25/26"},
+		"bytes=-3":    {"xyz", "bytes 23-25/26"},
+		"bytes=25-99": {"z", "bytes 25-25/26"},
+	}
+	for rng, want := range singles {
+		rec := get(rng)
+		if rec.Code != http.StatusPartialContent || rec.Body.String() != want.body || rec.Header().Get("Content-Range") != want.cr {
+			t.Errorf("%s: %d %q Content-Range=%q", rng, rec.Code, rec.Body, rec.Header().Get("Content-Range"))
+		}
+	}
+
+	// 多段请求返回 multipart/byteranges
+	rec = get("bytes=0-1,24-25")
+	if rec.Code != http.StatusPartialContent || !strings.HasPrefix(rec.Header().Get("Content-Type"), "multipart/byteranges") {
+		t.Errorf("多段: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	if rec := get("bytes=30-40"); rec.Code != http.StatusRequestedRangeNotSatisfiable || rec.Header().Get("Content-Range") != "bytes */26" {
+		t.Errorf("越界: %d Content-Range=%q，期望 416", rec.Code, rec.Header().Get("Content-Range"))
+	}
+	for _, rng := range []string{"bytes=5-2", "items=0-3"} {
+		if rec := get(rng); rec.Code != http.StatusRequestedRangeNotSatisfiable {
+			t.Errorf("%s: %d，期望 416", rng, rec.Code)
+		}
+	}
+}
+
+func TestDownloadDisposition(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "page.html", "<script>alert(1)</script>")
+	writeTestFile(t, dir, "image.png", "\x89PNG")
+	writeTestFile(t, dir, "blob.unknownext", "?")
+	h := testHandler()
+
+	tests := []struct {
+		target, disposition, contentType string
+	}{
+		{"/download?file=image.png", "attachment", "application/octet-stream"},
+		{"/download?file=image.png&disposition=attachment", "attachment", "application/octet-stream"},
+		{"/download?file=image.png&disposition=inline", "inline", "image/png"},
+		{"/download?file=page.html&disposition=inline", "inline", "text/html; charset=utf-8"},
+		{"/download?file=blob.unknownext&disposition=inline", "inline", "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		rec := serve(h, "GET", tt.target, nil)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s 返回 %d", tt.target, rec.Code)
+			continue
+		}
+		if cd := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, tt.disposition+";") {
+			t.Errorf("%s: Content-Disposition = %q", tt.target, cd)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("%s: Content-Type = %q，期望 %q", tt.target, ct, tt.contentType)
+		}
+	}
+	// 内联打开的 HTML 在沙箱中渲染，其中的脚本无法访问本站
+	rec := serve(h, "GET", "/download?file=page.html&disposition=inline", nil)
+	if csp := rec.Header().Get("Content-Security-Policy"); csp != "sandbox" {
+		t.Errorf("内联 HTML 缺少 CSP sandbox: %q", csp)
+	}
+}
+
+func TestSequentialRangesCoverFile(t *testing.T) {
+	dir := setupTest(t)
+	data := make([]byte, 100000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	writeTestFile(t, dir, "big.bin", string(data))
+	h := testHandler()
+
+	// 模拟页面的分段下载：按固定大小依次请求，并按 Content-Range 拼接
+	const segment = 30000
+	var got []byte
+	for start := 0; start < len(data); start += segment {
+		end := min(start+segment, len(data)) - 1
+		req := httptest.NewRequest("GET", "/download?file=big.bin", nil)
+		req.Header.Set("Range", "bytes="+strconv.Itoa(start)+"-"+strconv.Itoa(end))
+		rec := serveReq(h, req)
+		if rec.Code != http.StatusPartialContent {
+			t.Fatalf("分段 %d-%d 返回 %d", start, end, rec.Code)
+		}
+		want := "bytes " + strconv.Itoa(start) + "-" + strconv.Itoa(end) + "/" + strconv.Itoa(len(data))
+		if cr := rec.Header().Get("Content-Range"); cr != want {
+			t.Fatalf("Content-Range = %q，期望 %q", cr, want)
+		}
+		if rec.Body.Len() != end-start+1 {
+			t.Fatalf("分段 %d-%d 长度 %d", start, end, rec.Body.Len())
+		}
+		got = append(got, rec.Body.Bytes()...)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("拼接后的内容与原文件不一致")
+	}
+}
+
+func TestCreateFileWithContent(t *testing.T) {
+	dir := setupTest(t)
+	h := testHandler()
+	content := "第一行\nsecond line\n"
+	rec := postForm(h, "/create", url.Values{"type": {"file"}, "name": {"notes.txt"}, "path": {""}, "content": {content}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("创建返回 %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(h, "GET", "/download?file=notes.txt", nil); rec.Body.String() != content {
+		t.Errorf("读回内容 %q", rec.Body)
+	}
+
+	// 不带 content 时创建空文件
+	postForm(h, "/create", url.Values{"type": {"file"}, "name": {"empty.txt"}, "path": {""}})
+	if info, err := os.Stat(filepath.Join(dir, "empty.txt")); err != nil || info.Size() != 0 {
+		t.Errorf("空文件: %v %v", info, err)
+	}
+
+	// 已存在时不覆盖
+	rec = postForm(h, "/create", url.Values{"type": {"file"}, "name": {"notes.txt"}, "path": {""}, "content": {"x"}})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("重复创建返回 %d", rec.Code)
+	}
+
+	// 内容受 -max-upload-size 限制
+	maxUploadSize = 8
+	rec = postForm(h, "/create", url.Values{"type": {"file"}, "name": {"big.txt"}, "path": {""}, "content": {"0123456789"}})
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("超出大小限制返回 %d", rec.Code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "big.txt")); err == nil {
+		t.Error("超出大小限制的文件不应被创建")
+	}
+}
+
+func TestCreateNestedFolders(t *testing.T) {
+	dir := setupTest(t)
+	h := testHandler()
+	mkdir := func(name string, recursive bool) *httptest.ResponseRecorder {
+		form := url.Values{"type": {"folder"}, "name": {name}, "path": {""}}
+		if recursive {
+			form.Set("recursive", "true")
+		}
+		return postForm(h, "/create", form)
+	}
+
+	if rec := mkdir("a/b/c", true); rec.Code != http.StatusOK {
+		t.Fatalf("递归创建返回 %d %s", rec.Code, rec.Body)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "a", "b", "c")); err != nil || !info.IsDir() {
+		t.Error("a/b/c 未被创建")
+	}
+	// 递归创建已存在的目录不报错
+	if rec := mkdir("a/b", true); rec.Code != http.StatusOK {
+		t.Errorf("递归创建已存在目录返回 %d", rec.Code)
+	}
+	// 默认保持单级创建语义
+	if rec := mkdir("x/y", false); rec.Code != http.StatusBadRequest {
+		t.Errorf("未指定 recursive 时创建 x/y 返回 %d", rec.Code)
+	}
+	if rec := mkdir("a", false); rec.Code == http.StatusOK {
+		t.Error("单级创建已存在的目录应报错")
+	}
+
+	// 末级已是文件
+	writeTestFile(t, dir, "a/file", "x")
+	if rec := mkdir("a/file", true); rec.Code != http.StatusConflict {
+		t.Errorf("末级为文件时返回 %d，期望 409", rec.Code)
+	}
+
+	outside := filepath.Dir(dir)
+	for _, name := range []string{"../escape", "a/../../escape", "a/./b", "a//..//..", "/abs/path/../.."} {
+		rec := mkdir(name, true)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("递归创建 %q 返回 %d，期望 400", name, rec.Code)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outside, "escape")); err == nil {
+		t.Error("在工作目录之外创建了目录")
+	}
+}
+
+func TestParentRow(t *testing.T) {
+	dir := setupTest(t)
+	os.MkdirAll(filepath.Join(dir, "a", "b c", "d"), 0755)
+	h := testHandler()
+
+	body := serve(h, "GET", "/?path=a/b%20c/d&sort=time&order=desc", nil).Body.String()
+	want := `href="/?path=a%2fb%20c&sort=time&order=desc"`
+	if !strings.Contains(body, `class="parent-row"`) || !strings.Contains(body, want) {
+		t.Errorf("嵌套目录的上级链接不正确，期望包含 %s", want)
+	}
+	body = serve(h, "GET", "/?path=a", nil).Body.String()
+	if !strings.Contains(body, `href="/?path=&sort=name&order=asc"`) {
+		t.Error("一级目录的上级链接应指向根目录")
+	}
+	if body := serve(h, "GET", "/", nil).Body.String(); strings.Contains(body, `class="parent-row"`) {
+		t.Error("根目录不应显示上级目录行")
+	}
+
+	for rel, want := range map[string]string{"": "", "a": "", "a/b": "a", "/a/b/c/": "a/b"} {
+		if got := parentDir(rel); got != want {
+			t.Errorf("parentDir(%q) = %q，期望 %q", rel, got, want)
+		}
+	}
+}
+
+func TestDirectorySummary(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "a.bin", strings.Repeat("a", 1024))
+	writeTestFile(t, dir, "b.bin", strings.Repeat("b", 512))
+	writeTestFile(t, dir, "c.bin", "")
+	// 子目录中的内容不计入当前目录
+	writeTestFile(t, dir, "sub/deep.bin", strings.Repeat("d", 4096))
+	os.Mkdir(filepath.Join(dir, "empty"), 0755)
+
+	data, ok := buildPageData(httptest.NewRecorder(), httptest.NewRequest("GET", "/?path=", nil))
+	if !ok {
+		t.Fatal("buildPageData 失败")
+	}
+	if data.FileCount != 3 || data.DirCount != 2 || data.TotalSize != "1.50 KiB" {
+		t.Errorf("统计为 %d 个文件, %d 个文件夹, %s", data.FileCount, data.DirCount, data.TotalSize)
+	}
+	body := serve(testHandler(), "GET", "/", nil).Body.String()
+	if !strings.Contains(body, "3 个文件, 2 个文件夹, 共 1.50 KiB") {
+		t.Error("页面中缺少目录统计")
+	}
+}
+
+func TestCalculateFileSize(t *testing.T) {
+	setupTest(t)
+	tests := []struct {
+		units string
+		size  int64
+		want  string
+	}{
+		{"binary", -5, "0 B"},
+		{"binary", 0, "0 B"},
+		{"binary", 1, "1 B"},
+		{"binary", 512, "512 B"},
+		{"binary", 1000, "1000 B"},
+		{"binary", 1023, "1023 B"},
+		{"binary", 1024, "1.00 KiB"},
+		{"binary", 1536, "1.50 KiB"},
+		{"binary", 1 << 20, "1.00 MiB"},
+		{"binary", 5 << 30, "5.00 GiB"},
+		{"si", 0, "0 B"},
+		{"si", 999, "999 B"},
+		{"si", 1000, "1.00 kB"},
+		{"si", 1023, "1.02 kB"},
+		{"si", 1024, "1.02 kB"},
+		{"si", 1000000, "1.00 MB"},
+		{"si", 2500000000, "2.50 GB"},
+	}
+	for _, tt := range tests {
+		sizeUnits = tt.units
+		if got := calculateFileSize(tt.size); got != tt.want {
+			t.Errorf("%s calculateFileSize(%d) = %q，期望 %q", tt.units, tt.size, got, tt.want)
+		}
+	}
+}
+
+// openTestAudit 把审计日志写到临时文件，返回读取全部记录的函数
+func openTestAudit(t *testing.T) func() []map[string]string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "audit.jsonl")
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		auditFile = nil
+	})
+	auditFile = f
+	return func() []map[string]string {
+		data, _ := os.ReadFile(p)
+		var records []map[string]string
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			if line == "" {
+				continue
+			}
+			var rec map[string]string
+			if err := json.Unmarshal([]byte(line), &rec); err != nil {
+				t.Fatalf("审计记录不是 JSON: %s", line)
+			}
+			records = append(records, rec)
+		}
+		return records
+	}
+}
+
+func TestAuditDelete(t *testing.T) {
+	dir := setupTest(t)
+	addTestUser(t, "boss", roleAdmin, "")
+	writeTestFile(t, dir, "docs/old.txt", "x")
+	records := openTestAudit(t)
+	h := testHandler()
+	token := login(t, h, "boss")
+
+	req := authed(token, "GET", "/delete?path=docs&file=old.txt", nil)
+	req.Header.Set("X-Request-ID", "audit-test-1")
+	serveReq(h, req)
+	serveReq(h, authed(token, "GET", "/delete?path=docs&file=missing.txt", nil))
+
+	got := records()
+	if len(got) != 2 {
+		t.Fatalf("期望 2 条审计记录，实际 %d: %v", len(got), got)
+	}
+	rec := got[0]
+	if rec["action"] != "delete" || rec["user"] != "boss" || rec["source"] != "docs/old.txt" || rec["result"] != "ok" || rec["request_id"] != "audit-test-1" {
+		t.Errorf("删除记录不正确: %v", rec)
+	}
+	if _, err := time.Parse(time.RFC3339, rec["time"]); err != nil {
+		t.Errorf("时间格式不正确: %q", rec["time"])
+	}
+	if got[1]["result"] != "error" || got[1]["error"] == "" {
+		t.Errorf("失败的删除应记录错误: %v", got[1])
+	}
+}
+
+func TestCheckCredentials(t *testing.T) {
+	setupTest(t)
+	addTestUser(t, "alice", roleEditor, "")
+	if role, ok := checkCredentials("alice", "pw"); !ok || role != roleEditor {
+		t.Errorf("正确密码: %q %v", role, ok)
+	}
+	for _, c := range [][2]string{{"alice", "pw2"}, {"alice", "p"}, {"alice", ""}, {"bob", "pw"}, {"bob", ""}, {"", ""}, {"bob", unknownUserPassword}} {
+		if _, ok := checkCredentials(c[0], c[1]); ok {
+			t.Errorf("checkCredentials(%q, %q) 应失败", c[0], c[1])
+		}
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "a.txt", "a")
+	addTestUser(t, "viewer", roleViewer, "")
+	addTestUser(t, "editor", roleEditor, "")
+	addTestUser(t, "admin", roleAdmin, "")
+	h := testHandler()
+	tokens := map[string]string{}
+	for _, u := range []string{"viewer", "editor", "admin"} {
+		tokens[u] = login(t, h, u)
+	}
+
+	// 各接口所需的最低角色；参数不全时允许的角色会得到 400 等，但不会是 403
+	endpoints := []struct {
+		method, target, min string
+	}{
+		{"GET", "/list?path=", roleViewer},
+		{"GET", "/download?file=a.txt", roleViewer},
+		{"GET", "/stat?file=a.txt", roleViewer},
+		{"GET", "/dirinfo?path=", roleViewer},
+		{"GET", "/api/list?path=", roleViewer},
+		{"POST", "/upload?path=", roleEditor},
+		{"POST", "/upload-chunk", roleEditor},
+		{"PUT", "/upload-resume", roleEditor},
+		{"POST", "/create", roleEditor},
+		{"POST", "/rename", roleEditor},
+		{"POST", "/move", roleEditor},
+		{"POST", "/copy", roleEditor},
+		{"POST", "/extract", roleEditor},
+		{"POST", "/compress", roleEditor},
+		{"POST", "/dir-sort", roleEditor},
+		{"PUT", "/api/v1/files/new.txt", roleEditor},
+		{"GET", "/delete?path=&file=missing.txt", roleAdmin},
+		{"POST", "/empty-dir?path=missing", roleAdmin},
+		{"POST", "/chmod", roleAdmin},
+		{"POST", "/fetch-url", roleAdmin},
+		{"POST", "/truncate", roleAdmin},
+		{"DELETE", "/api/v1/files/missing.txt", roleAdmin},
+	}
+	for _, ep := range endpoints {
+		for user, token := range tokens {
+			rec := serveReq(h, authed(token, ep.method, ep.target, strings.NewReader("")))
+			allowed := roleLevel[user] >= roleLevel[ep.min]
+			if allowed && rec.Code == http.StatusForbidden {
+				t.Errorf("%s %s: %s 被拒绝: %s", ep.method, ep.target, user, rec.Body)
+			}
+			if !allowed && rec.Code != http.StatusForbidden {
+				t.Errorf("%s %s: %s 返回 %d，期望 403", ep.method, ep.target, user, rec.Code)
+			}
+		}
+		// 未登录时不能访问任何文件接口
+		if rec := serve(h, ep.method, ep.target, strings.NewReader("")); rec.Code != http.StatusFound && rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s: 未登录返回 %d", ep.method, ep.target, rec.Code)
+		}
+	}
+
+	// 页面按角色隐藏无权使用的操作
+	for user, want := range map[string][2]bool{"viewer": {false, false}, "editor": {true, false}, "admin": {true, true}} {
+		req := authed(tokens[user], "GET", "/", nil)
+		data, _ := buildPageData(httptest.NewRecorder(), withToken(req, tokens[user]))
+		if data.Role != user || data.CanEdit != want[0] || data.CanDelete != want[1] {
+			t.Errorf("%s: Role=%s CanEdit=%v CanDelete=%v", user, data.Role, data.CanEdit, data.CanDelete)
+		}
+	}
+}
+
+func TestUserHomeIsolation(t *testing.T) {
+	dir := setupTest(t)
+	addTestUser(t, "alice", roleAdmin, "home/alice")
+	addTestUser(t, "bob", roleAdmin, "home/bob")
+	writeTestFile(t, dir, "home/alice/mine.txt", "alice")
+	writeTestFile(t, dir, "home/bob/secret.txt", "bob-secret")
+	h := testHandler()
+	alice := login(t, h, "alice")
+
+	rec := serveReq(h, authed(alice, "GET", "/api/list?path=", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "mine.txt") || strings.Contains(rec.Body.String(), "secret.txt") {
+		t.Errorf("alice 的根目录列表: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := serveReq(h, authed(alice, "GET", "/search?q=mine", nil)); !strings.Contains(rec.Body.String(), "mine.txt") {
+		t.Errorf("alice 搜索自己的文件: %d %s", rec.Code, rec.Body)
+	}
+
+	// 各种指向 bob 目录的请求都不能返回其内容
+	for _, target := range []string{
+		"/api/list?path=../bob",
+		"/api/list?path=..%2Fbob",
+		"/api/list?path=..\\bob",
+		"/list?path=../bob",
+		"/?path=../bob",
+		"/download?path=../bob&file=secret.txt",
+		"/download?file=../bob/secret.txt",
+		"/download?path=..&file=bob/secret.txt",
+		"/stat?path=../bob&file=secret.txt",
+		"/dirinfo?path=../bob",
+		"/api/v1/tree?path=..",
+		"/search?q=secret",
+		"/recent",
+		"/download-tar?path=..&name=bob",
+	} {
+		rec := serveReq(h, authed(alice, "GET", target, nil))
+		if body := rec.Body.String(); strings.Contains(body, "secret.txt") || strings.Contains(body, "bob-secret") {
+			t.Errorf("%s 泄露了 bob 的文件: %d", target, rec.Code)
+		}
+	}
+
+	// 写操作同样限制在自己的目录中
+	rec = serveReq(h, authed(alice, "GET", "/delete?path=../bob&file=secret.txt", nil))
+	if _, err := os.Stat(filepath.Join(dir, "home/bob/secret.txt")); err != nil {
+		t.Errorf("alice 删除了 bob 的文件: %d", rec.Code)
+	}
+	form := url.Values{"path": {""}, "name": {"mine.txt"}, "dest": {"../bob"}}
+	req := authed(alice, "POST", "/copy", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	serveReq(h, req)
+	if _, err := os.Stat(filepath.Join(dir, "home/bob/mine.txt")); err == nil {
+		t.Error("alice 把文件复制到了 bob 的目录")
+	}
+
+	// 用户文件中的 home 字段
+	usersFile := writeTestFile(t, t.TempDir(), "users.txt", "carol:pw:viewer:home/carol\ndave:pw\n")
+	loaded, err := loadUsers(usersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded["carol"].Home != filepath.Join(dir, "home", "carol") || loaded["dave"].Home != "" || loaded["dave"].Role != roleViewer {
+		t.Errorf("loadUsers: %+v", loaded)
+	}
+	bad := writeTestFile(t, t.TempDir(), "bad.txt", "eve:pw:viewer:../outside\n")
+	if _, err := loadUsers(bad); err == nil {
+		t.Error("用户目录越出工作目录时应报错")
+	}
+}
+
+func TestAnonymousMode(t *testing.T) {
+	dir := setupTest(t)
+	addTestUser(t, "boss", roleAdmin, "")
+	writeTestFile(t, dir, "a.txt", "a")
+
+	for _, tt := range []struct {
+		role                     string
+		list, upload, del, login bool
+	}{
+		{"", false, false, false, false},
+		{roleViewer, true, false, false, true},
+		{roleEditor, true, true, false, true},
+		{roleAdmin, true, true, true, true},
+	} {
+		anonRole = tt.role
+		h := testHandler()
+		allowed := func(rec *httptest.ResponseRecorder) bool {
+			return rec.Code < 300
+		}
+		if got := allowed(serve(h, "GET", "/download?file=a.txt", nil)); got != tt.list {
+			t.Errorf("匿名角色 %q 下载: %v", tt.role, got)
+		}
+		if got := allowed(serveReq(h, uploadRequest(t, "/upload?path=", nil, "up-"+tt.role+".txt", "x"))); got != tt.upload {
+			t.Errorf("匿名角色 %q 上传: %v", tt.role, got)
+		}
+		writeTestFile(t, dir, "del.txt", "x")
+		rec := serve(h, "GET", "/delete?path=&file=del.txt", nil)
+		_, err := os.Stat(filepath.Join(dir, "del.txt"))
+		if got := err != nil; got != tt.del {
+			t.Errorf("匿名角色 %q 删除: %v (%d)", tt.role, got, rec.Code)
+		}
+		if tt.role == "" {
+			continue
+		}
+		// 页面隐藏无权使用的按钮，并在配置了账户时提供登录入口
+		data, _ := buildPageData(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		if data.Role != tt.role || data.CanEdit != tt.upload || data.CanDelete != tt.del || data.CanLogin != tt.login {
+			t.Errorf("匿名角色 %q 的页面数据: %+v", tt.role, data)
+		}
+	}
+}
+
+func TestResolveAnonRole(t *testing.T) {
+	setupTest(t)
+	tests := []struct {
+		mode, want string
+		accounts   bool
+		fail       bool
+	}{
+		{"", roleAdmin, false, false},
+		{"", "", true, false},
+		{"none", "", true, false},
+		{"none", "", false, true},
+		{"viewer", roleViewer, true, false},
+		{"editor", roleEditor, false, false},
+		{"root", "", false, true},
+	}
+	for _, tt := range tests {
+		users = make(map[string]userAccount)
+		if tt.accounts {
+			users["boss"] = userAccount{Password: "pw", Role: roleAdmin}
+		}
+		got, err := resolveAnonRole(tt.mode)
+		if (err != nil) != tt.fail || got != tt.want {
+			t.Errorf("resolveAnonRole(%q) accounts=%v = %q, %v", tt.mode, tt.accounts, got, err)
+		}
+	}
+}
+
+// lockedWithin 在 d 内能否取得 lock 返回的锁，取得后立即释放
+func lockedWithin(lock func() func(), d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		lock()()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func TestKeyedLocks(t *testing.T) {
+	dir := setupTest(t)
+	a := filepath.Join(dir, "a", "x.bin")
+	b := filepath.Join(dir, "b", "y.bin")
+
+	unlock := pathLocks.Lock(a)
+	if !lockedWithin(func() func() { return pathLocks.Lock(b) }, time.Second) {
+		t.Error("不同目录的写锁互相阻塞")
+	}
+	if lockedWithin(func() func() { return pathLocks.RLock(a) }, 100*time.Millisecond) {
+		t.Error("同一路径的读锁没有等待写锁")
+	}
+	// 删除上级目录需要等待其中的写入完成
+	if lockedWithin(func() func() { return pathLocks.Lock(filepath.Join(dir, "a")) }, 100*time.Millisecond) {
+		t.Error("上级目录的写锁没有等待子路径的写入")
+	}
+	unlock()
+
+	r1 := pathLocks.RLock(a)
+	if !lockedWithin(func() func() { return pathLocks.RLock(a) }, time.Second) {
+		t.Error("同一文件的读锁不能并发")
+	}
+	r1()
+
+	// 等待上面超时的 goroutine 取得并释放锁后，锁表应被清空
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		pathLocks.mu.Lock()
+		n := len(pathLocks.locks)
+		pathLocks.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("释放后锁表仍有 %d 项", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConcurrentUploadsDifferentDirs(t *testing.T) {
+	dir := setupTest(t)
+	os.Mkdir(filepath.Join(dir, "a"), 0755)
+	os.Mkdir(filepath.Join(dir, "b"), 0755)
+	srv := httptest.NewServer(testHandler())
+	defer srv.Close()
+
+	// 第一个上传只发送一部分数据后停住，期间持有 a/slow.bin 的写锁
+	pr, pw := io.Pipe()
+	slowDone := make(chan int)
+	go func() {
+		req, _ := http.NewRequest("PUT", srv.URL+"/api/v1/files/a/slow.bin", pr)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			slowDone <- 0
+			return
+		}
+		resp.Body.Close()
+		slowDone <- resp.StatusCode
+	}()
+	pw.Write([]byte("first half "))
+
+	fast := make(chan int)
+	go func() {
+		req, _ := http.NewRequest("PUT", srv.URL+"/api/v1/files/b/fast.bin", strings.NewReader("fast"))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fast <- 0
+			return
+		}
+		resp.Body.Close()
+		fast <- resp.StatusCode
+	}()
+	select {
+	case code := <-fast:
+		if code != http.StatusCreated {
+			t.Errorf("另一目录的上传返回 %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("另一目录的上传被正在进行的上传阻塞")
+	}
+
+	pw.Write([]byte("second half"))
+	pw.Close()
+	if code := <-slowDone; code != http.StatusCreated {
+		t.Errorf("慢速上传返回 %d", code)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "a", "slow.bin")); string(data) != "first half second half" {
+		t.Errorf("慢速上传内容 %q", data)
+	}
+}
+
+func TestDeleteDuringSlowDownload(t *testing.T) {
+	dir := setupTest(t)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<20) // 16 MiB，远大于套接字缓冲区
+	writeTestFile(t, dir, "big.bin", string(data))
+	downloadIdle = 0
+	srv := httptest.NewServer(testHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/download?file=big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	head := make([]byte, 1024)
+	if _, err := io.ReadFull(resp.Body, head); err != nil {
+		t.Fatal(err)
+	}
+
+	// 下载尚未读完时发起删除：删除应等待下载结束
+	deleted := make(chan int)
+	go func() {
+		req, _ := http.NewRequest("GET", srv.URL+"/delete?path=&file=big.bin", nil)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			deleted <- 0
+			return
+		}
+		resp.Body.Close()
+		deleted <- resp.StatusCode
+	}()
+	select {
+	case <-deleted:
+		t.Fatal("下载进行中删除没有等待")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	rest, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(append(head, rest...), data) {
+		t.Error("下载内容在删除期间被破坏")
+	}
+	select {
+	case code := <-deleted:
+		if code != http.StatusOK {
+			t.Errorf("删除返回 %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("下载结束后删除仍未完成")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "big.bin")); !os.IsNotExist(err) {
+		t.Error("文件未被删除")
+	}
+}
+
+func TestUploadStatusAcrossChunks(t *testing.T) {
+	dir := setupTest(t)
+	h := testHandler()
+	status := func() map[string]interface{} {
+		t.Helper()
+		rec := serve(h, "GET", "/upload-status?uploadId=up-1", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("upload-status 返回 %d: %s", rec.Code, rec.Body)
+		}
+		var s map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &s)
+		return s
+	}
+	chunk := func(offset int, data string) {
+		t.Helper()
+		target := "/upload-chunk?uploadId=up-1&path=&name=c.txt&total=10&offset=" + strconv.Itoa(offset)
+		if rec := serve(h, "POST", target, strings.NewReader(data)); rec.Code != http.StatusOK {
+			t.Fatalf("分片 offset=%d 返回 %d: %s", offset, rec.Code, rec.Body)
+		}
+	}
+
+	if rec := serve(h, "GET", "/upload-status?uploadId=up-1", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("会话不存在时返回 %d", rec.Code)
+	}
+	chunk(0, "hello")
+	if s := status(); s["received"] != 5.0 || s["total"] != 10.0 || s["chunks"] != 1.0 || s["done"] != false {
+		t.Errorf("第一个分片后状态为 %v", s)
+	}
+	chunk(5, "world")
+	if s := status(); s["received"] != 10.0 || s["chunks"] != 2.0 || s["done"] != true {
+		t.Errorf("第二个分片后状态为 %v", s)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "c.txt")); string(b) != "helloworld" {
+		t.Errorf("合并后的内容为 %q", b)
+	}
+}
+
+func TestExpireUploads(t *testing.T) {
+	setupTest(t)
+	h := testHandler()
+	if rec := serve(h, "POST", "/upload-chunk?uploadId=stale&path=&name=s.txt&total=10&offset=0", strings.NewReader("abc")); rec.Code != http.StatusOK {
+		t.Fatalf("分片返回 %d: %s", rec.Code, rec.Body)
+	}
+	uploadsMu.Lock()
+	u := uploads["stale"]
+	uploadsMu.Unlock()
+	u.mu.Lock()
+	u.Updated = time.Now().Add(-uploadTTL - time.Minute)
+	tmp := u.TempPath
+	u.mu.Unlock()
+
+	expireUploads()
+	if rec := serve(h, "GET", "/upload-status?uploadId=stale", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("过期会话仍可查询: %d", rec.Code)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Error("过期会话的临时文件未清理")
+	}
+}
+
+func TestPageDataPathFields(t *testing.T) {
+	dir := setupTest(t)
+	os.MkdirAll(filepath.Join(dir, "a", "b"), 0755)
+
+	for _, show := range []bool{false, true} {
+		showAbsPath = show
+		rec := httptest.NewRecorder()
+		data, ok := buildPageData(rec, httptest.NewRequest("GET", "/?path=a/b", nil))
+		if !ok {
+			t.Fatalf("buildPageData 失败: %d %s", rec.Code, rec.Body)
+		}
+		if data.CurrentPath != "a/b" || !data.HasParent || data.ParentPath != "a" {
+			t.Errorf("CurrentPath=%q HasParent=%v ParentPath=%q", data.CurrentPath, data.HasParent, data.ParentPath)
+		}
+		want := []Breadcrumb{{"根目录", ""}, {"a", "a"}, {"b", "a/b"}}
+		if len(data.Breadcrumbs) != len(want) {
+			t.Fatalf("Breadcrumbs = %v", data.Breadcrumbs)
+		}
+		for i := range want {
+			if data.Breadcrumbs[i] != want[i] {
+				t.Errorf("Breadcrumbs[%d] = %v，期望 %v", i, data.Breadcrumbs[i], want[i])
+			}
+		}
+		wantAbs := ""
+		if show {
+			wantAbs = filepath.Join(dir, "a", "b")
+		}
+		if data.AbsPath != wantAbs {
+			t.Errorf("showAbsPath=%v 时 AbsPath = %q，期望 %q", show, data.AbsPath, wantAbs)
+		}
+	}
+}
+
+// uploadResults 上传一个文件并解析响应中的 files 列表
+func uploadResults(t *testing.T, h http.Handler, token, target, name, content string) []uploadResult {
+	t.Helper()
+	req := uploadRequest(t, target, nil, name, content)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := serveReq(h, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("上传 %s 返回 %d: %s", name, rec.Code, rec.Body)
+	}
+	var resp struct{ Files []uploadResult }
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("响应不是 JSON: %s", rec.Body)
+	}
+	return resp.Files
+}
+
+func TestUploadDedupe(t *testing.T) {
+	dir := setupTest(t)
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	h := testHandler()
+
+	dedupeMode = "link"
+	if r := uploadResults(t, h, "", "/upload?path=", "a.txt", "same bytes"); r[0].Duplicate {
+		t.Errorf("首次上传被标记为重复: %+v", r[0])
+	}
+	r := uploadResults(t, h, "", "/upload?path=sub", "b.txt", "same bytes")
+	if !r[0].Duplicate || !r[0].Linked || r[0].DuplicateOf != "a.txt" {
+		t.Errorf("第二次上传结果为 %+v", r[0])
+	}
+	a, _ := os.Stat(filepath.Join(dir, "a.txt"))
+	b, _ := os.Stat(filepath.Join(dir, "sub", "b.txt"))
+	if !os.SameFile(a, b) {
+		t.Error("link 模式下重复内容未以硬链接保存")
+	}
+
+	dedupeMode = "warn"
+	r = uploadResults(t, h, "", "/upload?path=", "c.txt", "same bytes")
+	if !r[0].Duplicate || r[0].Linked {
+		t.Errorf("warn 模式结果为 %+v", r[0])
+	}
+	c, _ := os.Stat(filepath.Join(dir, "c.txt"))
+	if os.SameFile(a, c) {
+		t.Error("warn 模式不应建立硬链接")
+	}
+}
+
+func TestUploadDedupeAcrossUsers(t *testing.T) {
+	dir := setupTest(t)
+	addTestUser(t, "alice", roleEditor, "alice")
+	addTestUser(t, "bob", roleEditor, "bob")
+	h := testHandler()
+	dedupeMode = "link"
+
+	uploadResults(t, h, login(t, h, "alice"), "/upload?path=", "secret.txt", "alice private data")
+	r := uploadResults(t, h, login(t, h, "bob"), "/upload?path=", "guess.txt", "alice private data")
+	if r[0].Duplicate || r[0].Linked || r[0].DuplicateOf != "" {
+		t.Errorf("去重泄露了其他用户的文件: %+v", r[0])
+	}
+	a, _ := os.Stat(filepath.Join(dir, "alice", "secret.txt"))
+	b, _ := os.Stat(filepath.Join(dir, "bob", "guess.txt"))
+	if os.SameFile(a, b) {
+		t.Error("跨用户根目录建立了硬链接")
+	}
+}
+
+func TestSameHomes(t *testing.T) {
+	dir := setupTest(t)
+	addTestUser(t, "admin", roleAdmin, "")
+	addTestUser(t, "alice", roleEditor, "alice")
+	if !sameHomes(filepath.Join(dir, "alice", "x"), filepath.Join(dir, "alice", "y")) {
+		t.Error("同一用户根目录内的文件应可硬链接")
+	}
+	// 管理员能同时看到两处，但 alice 只能看到其中一处
+	if sameHomes(filepath.Join(dir, "x"), filepath.Join(dir, "alice", "x")) {
+		t.Error("不应在 alice 根目录内外之间建立硬链接")
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	dir := setupTest(t)
+	p := writeTestFile(t, dir, "doc.txt", "v1")
+	h := testHandler()
+
+	etag := serve(h, "HEAD", "/download?file=doc.txt", nil).Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("/download 未返回 ETag")
+	}
+	req := uploadRequest(t, "/upload?path=", nil, "doc.txt", "version 2")
+	req.Header.Set("If-Match", etag)
+	if rec := serveReq(h, req); rec.Code != http.StatusOK {
+		t.Fatalf("ETag 匹配时上传返回 %d: %s", rec.Code, rec.Body)
+	}
+	// 文件已被改写，旧 ETag 失效
+	req = uploadRequest(t, "/upload?path=", nil, "doc.txt", "v3")
+	req.Header.Set("If-Match", etag)
+	if rec := serveReq(h, req); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("ETag 过期时上传返回 %d", rec.Code)
+	}
+	if b, _ := os.ReadFile(p); string(b) != "version 2" {
+		t.Errorf("冲突的上传改写了文件: %q", b)
+	}
+
+	rename := func(etag string) int {
+		req := httptest.NewRequest("POST", "/rename", strings.NewReader(url.Values{"old": {"doc.txt"}, "new": {"doc2.txt"}, "path": {""}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("If-Match", etag)
+		return serveReq(h, req).Code
+	}
+	if code := rename(etag); code != http.StatusPreconditionFailed {
+		t.Errorf("ETag 过期时重命名返回 %d", code)
+	}
+	info, _ := os.Stat(p)
+	if code := rename(fileETag(info)); code != http.StatusOK {
+		t.Errorf("ETag 匹配时重命名返回 %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "doc2.txt")); err != nil {
+		t.Error("重命名未生效")
+	}
+}
+
+func TestRecentFiles(t *testing.T) {
+	dir := setupTest(t)
+	now := time.Now()
+	for i, name := range []string{"old.txt", "a/mid.txt", "a/b/new.txt", "a/b/older.txt"} {
+		p := writeTestFile(t, dir, name, "x")
+		mtime := now.Add(-time.Duration([]int{30, 20, 10, 40}[i]) * time.Minute)
+		os.Chtimes(p, mtime, mtime)
+	}
+	h := testHandler()
+
+	var resp struct {
+		Files     []recentFile
+		Truncated bool
+	}
+	rec := serve(h, "GET", "/recent?limit=3", nil)
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("响应不是 JSON: %d %s", rec.Code, rec.Body)
+	}
+	var got []string
+	for _, f := range resp.Files {
+		got = append(got, f.Path)
+	}
+	if strings.Join(got, ",") != "a/b/new.txt,a/mid.txt,old.txt" || resp.Truncated {
+		t.Errorf("最近文件为 %v（truncated=%v）", got, resp.Truncated)
+	}
+
+	rec = serve(h, "GET", "/recent?path=a/b", nil)
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp.Files) != 2 || resp.Files[0].Path != "a/b/new.txt" {
+		t.Errorf("子目录最近文件为 %+v", resp.Files)
+	}
+	if rec := serve(h, "GET", "/recent?limit=0", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("limit=0 返回 %d", rec.Code)
+	}
+}
+
+func TestFileCategory(t *testing.T) {
+	dir := setupTest(t)
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 16)
+	cases := []struct{ name, content, want string }{
+		{"photo.JPG", "not really a jpeg", "image"},
+		{"clip.mkv", "", "video"},
+		{"report.pdf", "", "document"},
+		{"backup.tar.gz", "", "archive"},
+		{"noext", png, "image"},
+		{"README", "plain text content\n", "document"},
+		{"blob", "\x00\x01\x02\x03", "other"},
+		{"empty", "", "other"},
+	}
+	for _, c := range cases {
+		p := writeTestFile(t, dir, c.name, c.content)
+		if got := fileCategory(p); got != c.want {
+			t.Errorf("fileCategory(%q) = %q，期望 %q", c.name, got, c.want)
+		}
+	}
+
+	h := testHandler()
+	rec := serve(h, "GET", "/list?category=image", nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "photo.JPG") || strings.Contains(rec.Body.String(), "report.pdf") {
+		t.Errorf("按分类筛选结果不正确: %d", rec.Code)
+	}
+	if rec := serve(h, "GET", "/list?category=bogus", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("无效分类返回 %d", rec.Code)
+	}
+}
+
+func TestBreadcrumbs(t *testing.T) {
+	setupTest(t)
+	want := []Breadcrumb{{"根目录", ""}, {"x", "x"}, {"y", "x/y"}, {"z", "x/y/z"}}
+	got := buildBreadcrumbs("x/y/z")
+	if len(got) != len(want) {
+		t.Fatalf("buildBreadcrumbs = %v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("[%d] = %v，期望 %v", i, got[i], want[i])
+		}
+	}
+	if got := buildBreadcrumbs(""); len(got) != 1 || got[0].Path != "" {
+		t.Errorf("根目录面包屑为 %v", got)
+	}
+
+	h := testHandler()
+	rec := serve(h, "GET", "/api/breadcrumbs?path=x/y/z", nil)
+	var api []Breadcrumb
+	if err := json.Unmarshal(rec.Body.Bytes(), &api); err != nil || len(api) != 4 || api[3] != want[3] {
+		t.Errorf("/api/breadcrumbs 返回 %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(h, "GET", "/api/breadcrumbs?path=../etc", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("越界路径返回 %d", rec.Code)
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	cases := []struct {
+		bind string
+		port int
+		want string
+	}{
+		{"", 8080, ":8080"},
+		{"127.0.0.1", 80, "127.0.0.1:80"},
+		{"::1", 443, "[::1]:443"},
+	}
+	for _, c := range cases {
+		if got, err := listenAddr(c.bind, c.port); err != nil || got != c.want {
+			t.Errorf("listenAddr(%q, %d) = %q, %v，期望 %q", c.bind, c.port, got, err, c.want)
+		}
+	}
+	for _, port := range []int{0, 65536} {
+		if _, err := listenAddr("", port); err == nil {
+			t.Errorf("端口 %d 应被拒绝", port)
+		}
+	}
+	if _, err := listenAddr("no-such-host.invalid", 80); err == nil {
+		t.Error("无法解析的 -bind 应被拒绝")
+	}
+}
+
+func TestBindInterface(t *testing.T) {
+	// 先取得一个空闲端口，再按 -bind 127.0.0.1 监听
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	addr, err := listenAddr("127.0.0.1", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if ip := ln.Addr().(*net.TCPAddr).IP; !ip.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("监听在 %s，期望 127.0.0.1", ip)
+	}
+}
+
+func TestCheckBaseDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := checkBaseDir(dir); err != nil {
+		t.Errorf("可写目录检查失败: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Error("检查后留下了临时文件")
+	}
+	if err := checkBaseDir(filepath.Join(dir, "missing")); err == nil {
+		t.Error("不存在的目录应报错")
+	}
+	file := writeTestFile(t, dir, "file", "x")
+	if err := checkBaseDir(file); err == nil || !strings.Contains(err.Error(), "不是目录") {
+		t.Errorf("文件作为工作目录时返回 %v", err)
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("root 不受目录权限限制")
+	}
+	ro := filepath.Join(dir, "ro")
+	os.Mkdir(ro, 0555)
+	defer os.Chmod(ro, 0755)
+	if err := checkBaseDir(ro); err == nil || !strings.Contains(err.Error(), "不可写") {
+		t.Errorf("只读目录返回 %v", err)
+	}
+}
+
+func TestStalledHeaderDropped(t *testing.T) {
+	setupTest(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 与 main 中相同的构造方式，只把 -read-header-timeout 缩短
+	server := &http.Server{Handler: testHandler(), ReadHeaderTimeout: 200 * time.Millisecond}
+	go server.Serve(ln)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// 只发送部分请求头，之后不再发送
+	io.WriteString(conn, "GET /healthz HTTP/1.1\r\nHost: localhost\r\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	b, err := io.ReadAll(conn)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("请求头超时后连接仍未关闭")
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("连接在 %v 后即被关闭，早于 ReadHeaderTimeout", elapsed)
+	}
+	if len(b) > 0 && !strings.HasPrefix(string(b), "HTTP/1.1 408") {
+		t.Errorf("超时后返回了 %q", b)
+	}
+}
+
+func TestDownloadGzip(t *testing.T) {
+	dir := setupTest(t)
+	text := strings.Repeat("hello gzip\n", 200)
+	writeTestFile(t, dir, "notes.txt", text)
+	writeTestFile(t, dir, "bundle.zip", text)
+	h := testHandler()
+
+	get := func(target string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		return serveReq(h, req)
+	}
+
+	rec := get("/download?file=notes.txt")
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf(".txt 未压缩: %v", rec.Header())
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(zr); string(b) != text {
+		t.Error("解压后的内容与原文件不一致")
+	}
+
+	if rec := get("/download?file=bundle.zip"); rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != text {
+		t.Error(".zip 不应被压缩")
+	}
+	if rec := get("/download?file=notes.txt", "Range", "bytes=0-4"); rec.Code != http.StatusPartialContent || rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("带 Range 的请求返回 %d，Content-Encoding=%q", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+	if rec := get("/download?file=notes.txt", "Accept-Encoding", "gzip;q=0"); rec.Header().Get("Content-Encoding") != "" {
+		t.Error("gzip;q=0 时不应压缩")
+	}
+}
+
+func TestTailAppendedLines(t *testing.T) {
+	dir := setupTest(t)
+	p := writeTestFile(t, dir, "app.log", "old line\n")
+	srv := httptest.NewServer(testHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/tail?file=app.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/tail 返回 %d", resp.StatusCode)
+	}
+	texts := make(chan string, 16)
+	go func() {
+		defer close(texts)
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if data, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+				var v struct{ Text string }
+				if json.Unmarshal([]byte(data), &v) == nil && v.Text != "" {
+					texts <- v.Text
+				}
+			}
+		}
+	}()
+	next := func() string {
+		t.Helper()
+		select {
+		case s := <-texts:
+			return s
+		case <-time.After(5 * time.Second):
+			t.Fatal("未收到追加的内容")
+			return ""
+		}
+	}
+
+	if got := next(); got != "old line\n" {
+		t.Errorf("初始内容为 %q", got)
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 不完整的行要等换行符到达后才发送
+	io.WriteString(f, "first new\nsecond ")
+	if got := next(); got != "first new\n" {
+		t.Errorf("追加后收到 %q", got)
+	}
+	io.WriteString(f, "half\n")
+	f.Close()
+	if got := next(); got != "second half\n" {
+		t.Errorf("补全行后收到 %q", got)
+	}
+
+	writeTestFile(t, dir, "bin.dat", "\x00\x01\x02binary")
+	if resp, err := http.Get(srv.URL + "/tail?file=bin.dat"); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnsupportedMediaType {
+			t.Errorf("二进制文件返回 %d", resp.StatusCode)
+		}
+	}
+}
+
+func TestStat(t *testing.T) {
+	dir := setupTest(t)
+	p := writeTestFile(t, dir, "f.txt", "hello")
+	os.Chmod(p, 0640)
+	os.Mkdir(filepath.Join(dir, "d"), 0755)
+	os.Chmod(filepath.Join(dir, "d"), 0755)
+	h := testHandler()
+
+	stat := func(name string) map[string]interface{} {
+		t.Helper()
+		rec := serve(h, "GET", "/stat?file="+name, nil)
+		var v map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+			t.Fatalf("/stat?file=%s 返回 %d %s", name, rec.Code, rec.Body)
+		}
+		return v
+	}
+	f := stat("f.txt")
+	if f["name"] != "f.txt" || f["size"] != 5.0 || f["is_dir"] != false || f["is_symlink"] != false || f["perm"] != "0640" {
+		t.Errorf("文件属性为 %v", f)
+	}
+	if _, ok := f["mod_time"]; !ok {
+		t.Error("缺少 mod_time")
+	}
+	if _, ok := f["uid"]; !ok && runtime.GOOS != "windows" {
+		t.Error("Unix 上应给出 uid")
+	}
+	d := stat("d")
+	if d["is_dir"] != true || d["perm"] != "0755" {
+		t.Errorf("目录属性为 %v", d)
+	}
+	if rec := serve(h, "GET", "/stat?file=missing", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("不存在的文件返回 %d", rec.Code)
+	}
+}
+
+func TestChmod(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows 不支持 Unix 权限位")
+	}
+	dir := setupTest(t)
+	p := writeTestFile(t, dir, "f.txt", "x")
+	h := testHandler()
+
+	if rec := postForm(h, "/chmod", url.Values{"file": {"f.txt"}, "mode": {"0600"}}); rec.Code != http.StatusOK {
+		t.Fatalf("chmod 返回 %d: %s", rec.Code, rec.Body)
+	}
+	if info, _ := os.Stat(p); info.Mode().Perm() != 0600 {
+		t.Errorf("权限为 %o", info.Mode().Perm())
+	}
+	if rec := serve(h, "GET", "/stat?file=f.txt", nil); !strings.Contains(rec.Body.String(), `"perm":"0600"`) {
+		t.Errorf("/stat 读回的权限不正确: %s", rec.Body)
+	}
+	for _, mode := range []string{"0800", "1777", "rw", ""} {
+		if rec := postForm(h, "/chmod", url.Values{"file": {"f.txt"}, "mode": {mode}}); rec.Code != http.StatusBadRequest {
+			t.Errorf("mode=%q 返回 %d", mode, rec.Code)
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "visible.txt", "x")
+	addTestUser(t, "alice", roleViewer, "")
+	h := testHandler()
+
+	list := func(user, pass string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/list", nil)
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		return serveReq(h, req)
+	}
+
+	if rec := list("alice", "pw"); rec.Code != http.StatusFound {
+		t.Errorf("未启用 -allow-basic-auth 时返回 %d，应忽略 Basic 凭据并跳转登录", rec.Code)
+	}
+
+	allowBasicAuth = true
+	rec := list("alice", "pw")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "visible.txt") {
+		t.Errorf("Basic 认证访问 /list 返回 %d", rec.Code)
+	}
+	if len(rec.Result().Cookies()) != 0 {
+		t.Error("Basic 认证不应签发 cookie")
+	}
+	if rec := list("alice", "wrong"); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("密码错误返回 %d", rec.Code)
+	}
+	if rec := list("", ""); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("未携带凭据的非浏览器请求返回 %d，应质询", rec.Code)
+	}
+}
+
+// writeTestZip 在 path 创建 zip 文件，entries 为依次排列的条目名与内容
+func writeTestZip(t *testing.T, path string, entries ...string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for i := 0; i+1 < len(entries); i += 2 {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: entries[i], Method: zip.Deflate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, entries[i+1])
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+}
+
+func TestExtractZipSlip(t *testing.T) {
+	dir := setupTest(t)
+	os.Mkdir(filepath.Join(dir, "dest"), 0755)
+	writeTestZip(t, filepath.Join(dir, "evil.zip"),
+		"../escape.txt", "pwned",
+		"/abs.txt", "pwned",
+		"ok/../../../escape2.txt", "pwned",
+		`..\escape3.txt`, "pwned",
+		"ok/good.txt", "fine",
+	)
+	// 指向目录外的符号链接条目
+	f, _ := os.OpenFile(filepath.Join(dir, "link.zip"), os.O_CREATE|os.O_WRONLY, 0644)
+	zw := zip.NewWriter(f)
+	hdr := &zip.FileHeader{Name: "link"}
+	hdr.SetMode(os.ModeSymlink | 0777)
+	w, _ := zw.CreateHeader(hdr)
+	io.WriteString(w, "../../../etc")
+	zw.Close()
+	f.Close()
+	h := testHandler()
+
+	rec := postForm(h, "/extract", url.Values{"file": {"evil.zip"}, "dest": {"dest"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/extract 返回 %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Extracted []string
+		Skipped   []skippedEntry
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp.Skipped) != 4 {
+		t.Errorf("跳过的条目为 %+v", resp.Skipped)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "dest", "ok", "good.txt")); string(b) != "fine" {
+		t.Error("正常条目未解压")
+	}
+	for _, p := range []string{
+		filepath.Join(dir, "escape.txt"),
+		filepath.Join(filepath.Dir(dir), "escape.txt"),
+		filepath.Join(dir, "escape2.txt"),
+		filepath.Join(filepath.Dir(dir), "escape2.txt"),
+		filepath.Join(dir, "escape3.txt"),
+		"/abs.txt",
+	} {
+		if _, err := os.Stat(p); err == nil {
+			t.Errorf("zip-slip 写出了 %s", p)
+		}
+	}
+
+	rec = postForm(h, "/extract", url.Values{"file": {"link.zip"}, "dest": {"dest"}})
+	if _, err := os.Lstat(filepath.Join(dir, "dest", "link")); err == nil {
+		t.Error("符号链接条目被解压")
+	}
+}
+
+func TestCompressExtractRoundTrip(t *testing.T) {
+	dir := setupTest(t)
+	want := map[string]string{
+		"proj/readme.md":      "# hi",
+		"proj/src/main.go":    "package main",
+		"proj/src/deep/x.bin": "\x00\x01\x02",
+		"proj/empty/.keep":    "",
+		"proj/空格 名称/文件.txt":   "中文",
+	}
+	for name, content := range want {
+		writeTestFile(t, dir, name, content)
+	}
+	os.Mkdir(filepath.Join(dir, "out"), 0755)
+	h := testHandler()
+
+	rec := postForm(h, "/compress", url.Values{"name": {"proj"}, "dest": {""}, "output": {"bundle"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/compress 返回 %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Name string
+		Size int64
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	info, err := os.Stat(filepath.Join(dir, "bundle.zip"))
+	if err != nil || resp.Name != "bundle.zip" || resp.Size != info.Size() {
+		t.Fatalf("压缩结果 %+v，文件 %v", resp, err)
+	}
+
+	if rec := postForm(h, "/extract", url.Values{"file": {"bundle.zip"}, "dest": {"out"}}); rec.Code != http.StatusOK {
+		t.Fatalf("/extract 返回 %d: %s", rec.Code, rec.Body)
+	}
+	// 压缩包以文件夹本身为顶层目录
+	for name, content := range want {
+		if b, err := os.ReadFile(filepath.Join(dir, "out", filepath.FromSlash(name))); err != nil || string(b) != content {
+			t.Errorf("%s 解压后为 %q, %v", name, b, err)
+		}
+	}
+
+	if rec := postForm(h, "/compress", url.Values{"name": {"proj"}, "dest": {"proj/src"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("输出到被压缩目录内返回 %d", rec.Code)
+	}
+	if rec := postForm(h, "/compress", url.Values{"name": {"proj"}, "output": {"bundle.zip"}}); rec.Code != http.StatusConflict {
+		t.Errorf("输出文件已存在时返回 %d", rec.Code)
+	}
+}
+
+func TestServeIndex(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "site/index.html", "<h1>landing page</h1>")
+	writeTestFile(t, dir, "plain/file.txt", "x")
+	h := testHandler()
+
+	if rec := serve(h, "GET", "/?path=site", nil); strings.Contains(rec.Body.String(), "landing page") {
+		t.Error("未启用 -serve-index 时不应输出 index.html")
+	}
+
+	serveIndex = true
+	rec := serve(h, "GET", "/?path=site", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "<h1>landing page</h1>" {
+		t.Errorf("含 index.html 的目录返回 %d %q", rec.Code, rec.Body)
+	}
+	if rec := serve(h, "GET", "/?path=site&browse=1", nil); strings.Contains(rec.Body.String(), "<h1>landing page</h1>") || !strings.Contains(rec.Body.String(), "index.html") {
+		t.Error("browse=1 时应显示管理界面")
+	}
+	if rec := serve(h, "GET", "/?path=plain", nil); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "file.txt") {
+		t.Errorf("不含 index.html 的目录返回 %d", rec.Code)
+	}
+}
+
+func TestContentDispositionUnicode(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "报告.pdf", "%PDF")
+	h := testHandler()
+
+	cd := serve(h, "GET", "/download?file="+url.QueryEscape("报告.pdf"), nil).Header().Get("Content-Disposition")
+	if !strings.Contains(cd, `filename="__.pdf"`) {
+		t.Errorf("缺少 ASCII 回退的 filename 参数: %s", cd)
+	}
+	if !strings.Contains(cd, "filename*=UTF-8''%E6%8A%A5%E5%91%8A.pdf") {
+		t.Errorf("缺少 RFC 5987 编码的 filename* 参数: %s", cd)
+	}
+	if _, params, err := mime.ParseMediaType(cd); err != nil || params["filename"] != "报告.pdf" {
+		t.Errorf("解析后的文件名为 %q, %v", params["filename"], err)
+	}
+	if got := contentDisposition("attachment", `a"b\c.txt`); !strings.Contains(got, `filename="a_b_c.txt"`) {
+		t.Errorf("引号与反斜杠未替换: %s", got)
+	}
+}
+
+func TestFilenameXSS(t *testing.T) {
+	dir := setupTest(t)
+	h := testHandler()
+	// multipart 会去掉文件名中的目录部分，末尾的 // 被丢弃，实际保存为 "'); alert(1);"
+	if rec := serveReq(h, uploadRequest(t, "/upload?path=", nil, "'); alert(1);//", "x")); rec.Code != http.StatusOK {
+		t.Fatalf("上传返回 %d: %s", rec.Code, rec.Body)
+	}
+	writeTestFile(t, dir, `<img src=x onerror=alert(1)>`, "x")
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Fatalf("目录内容为 %v", entries)
+	}
+
+	for _, target := range []string{"/", "/list"} {
+		body := serve(h, "GET", target, nil).Body.String()
+		if strings.Contains(body, "'); alert(1);") || strings.Contains(body, "<img src=x") {
+			t.Errorf("%s 中文件名未转义", target)
+		}
+		if !strings.Contains(body, `data-name="&#39;); alert(1);"`) || !strings.Contains(body, `data-name="&lt;img src=x onerror=alert(1)&gt;"`) {
+			t.Errorf("%s 中缺少转义后的 data-name 属性", target)
+		}
+	}
+}
+
+func TestLengthLimits(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "src.txt", "x")
+	writeTestFile(t, dir, "tree/a/b/c.txt", "x")
+	h := testHandler()
+	long := strings.Repeat("n", 256)
+
+	checks := []struct {
+		desc string
+		rec  *httptest.ResponseRecorder
+	}{
+		{"创建", postForm(h, "/create", url.Values{"type": {"file"}, "name": {long}, "path": {""}})},
+		{"上传", serveReq(h, uploadRequest(t, "/upload?path=", nil, long, "x"))},
+		{"重命名", postForm(h, "/rename", url.Values{"old": {"src.txt"}, "new": {long}, "path": {""}})},
+	}
+	for _, c := range checks {
+		if c.rec.Code != http.StatusBadRequest || !strings.Contains(c.rec.Body.String(), "过长") {
+			t.Errorf("%s超长名称返回 %d %s", c.desc, c.rec.Code, c.rec.Body)
+		}
+	}
+	if rec := postForm(h, "/create", url.Values{"type": {"file"}, "name": {strings.Repeat("n", 255)}, "path": {""}}); rec.Code != http.StatusOK {
+		t.Errorf("255 字节的名称返回 %d", rec.Code)
+	}
+
+	// 总路径长度：每一级都不超限，但整体超过 -max-path-length
+	maxPathLength = len(dir) + 40
+	deep := strings.Repeat("abcdefghi/", 5) + "leaf"
+	if rec := postForm(h, "/create", url.Values{"type": {"folder"}, "name": {deep}, "path": {""}, "recursive": {"true"}}); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "过长") {
+		t.Errorf("超长嵌套路径返回 %d", rec.Code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "abcdefghi")); err == nil {
+		t.Error("超长路径被拒绝前已创建了目录")
+	}
+	// 复制整个目录时检查其中每个条目的最终路径
+	os.MkdirAll(filepath.Join(dir, "dest", strings.Repeat("d", 30)), 0755)
+	if rec := postForm(h, "/copy", url.Values{"name": {"tree"}, "path": {""}, "dest": {"dest/" + strings.Repeat("d", 30)}}); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "过长") {
+		t.Errorf("复制后路径超长返回 %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestDownloadTarGz(t *testing.T) {
+	dir := setupTest(t)
+	p := writeTestFile(t, dir, "docs/a.txt", "alpha")
+	os.Chmod(p, 0600)
+	mtime := time.Date(2023, 5, 6, 7, 8, 9, 0, time.UTC)
+	os.Chtimes(p, mtime, mtime)
+	writeTestFile(t, dir, "docs/sub/b.txt", "beta")
+	writeTestFile(t, dir, "c.txt", "gamma")
+	h := testHandler()
+
+	rec := serve(h, "GET", "/download-tar?path=&name=docs&name=c.txt", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("/download-tar 返回 %d %v", rec.Code, rec.Header())
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	tarReader := tar.NewReader(zr)
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			got[hdr.Name] = "<dir>"
+			continue
+		}
+		b, _ := io.ReadAll(tarReader)
+		got[hdr.Name] = string(b)
+		if hdr.Name == "docs/a.txt" && (hdr.FileInfo().Mode().Perm() != 0600 || !hdr.ModTime.Equal(mtime)) {
+			t.Errorf("docs/a.txt 的权限 %o、修改时间 %v 未保留", hdr.FileInfo().Mode().Perm(), hdr.ModTime)
+		}
+	}
+	want := map[string]string{"docs/": "<dir>", "docs/a.txt": "alpha", "docs/sub/": "<dir>", "docs/sub/b.txt": "beta", "c.txt": "gamma"}
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("压缩包中 %s 为 %q，期望 %q（全部条目 %v）", name, got[name], content, got)
+		}
+	}
+
+	if rec := serve(h, "GET", "/download-tar?path=&name=../etc", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("越界名称返回 %d", rec.Code)
+	}
+	if rec := serve(h, "GET", "/download-tar?path=&name=missing", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("不存在的条目返回 %d", rec.Code)
+	}
+}
+
+func TestCustomTitle(t *testing.T) {
+	setupTest(t)
+	h := testHandler()
+	if body := serve(h, "GET", "/", nil).Body.String(); !strings.Contains(body, "<title>简易网页文件管理器</title>") {
+		t.Error("默认标题未渲染")
+	}
+
+	siteTitle, logoURL = "Team <Files>", "/static/logo.png"
+	body := serve(h, "GET", "/", nil).Body.String()
+	if !strings.Contains(body, "<title>Team &lt;Files&gt;</title>") || !strings.Contains(body, `<img class="logo" src="/static/logo.png" alt="">Team &lt;Files&gt;</h1>`) {
+		t.Error("主页面未渲染自定义标题与图标")
+	}
+	addTestUser(t, "alice", roleViewer, "")
+	if body := serve(h, "GET", "/login", nil).Body.String(); !strings.Contains(body, "Team &lt;Files&gt;</title>") {
+		t.Error("登录页未渲染自定义标题")
+	}
+}
+
+func TestIndexEnglish(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "a.txt", "x")
+	h := testHandler()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9,zh;q=0.5")
+	body := serveReq(h, req).Body.String()
+	if !strings.Contains(body, `<html lang="en"`) || !strings.Contains(body, ">Upload</button>") || !strings.Contains(body, ">New folder</button>") {
+		t.Error("英文页面未使用英文界面文字")
+	}
+	if strings.Contains(body, ">上传文件</button>") {
+		t.Error("英文页面仍包含中文按钮")
+	}
+
+	// -lang 优先于 Accept-Language
+	uiLang = "zh"
+	if body := serveReq(h, req).Body.String(); !strings.Contains(body, ">上传文件</button>") {
+		t.Error("-lang zh 未生效")
+	}
+}
+
+func TestErrorMessagesTranslated(t *testing.T) {
+	setupTest(t)
+	h := testHandler()
+	cases := []struct{ target, want string }{
+		{"/api/list?offset=-1", "Invalid offset"},
+		{"/api/list?limit=x", "Invalid limit"},
+		{"/api/v1/tree?depth=x", "Invalid depth"},
+		{"/upload-chunk?uploadId=bad!id", "Invalid uploadId"},
+		{"/upload-chunk?uploadId=u1&offset=0&total=-1", "Invalid total"},
+		{"/tail?file=a.txt&kb=-1", "Invalid kb"},
+	}
+	for _, c := range cases {
+		method := "GET"
+		if strings.HasPrefix(c.target, "/upload-chunk") {
+			method = "POST"
+		}
+		req := httptest.NewRequest(method, c.target, nil)
+		req.Header.Set("Accept-Language", "en")
+		if body := serveReq(h, req).Body.String(); !strings.Contains(body, c.want) {
+			t.Errorf("%s 返回 %q，期望包含 %q", c.target, body, c.want)
+		}
+	}
+	if got := translate("en", "权限不足：当前角色（%s）无权执行此操作，需要 %s 及以上"); !strings.HasPrefix(got, "Permission denied") {
+		t.Errorf("权限不足未翻译: %q", got)
+	}
+
+	baseDir = filepath.Join(baseDir, "missing")
+	req := httptest.NewRequest("GET", "/readyz", nil)
+	req.Header.Set("Accept-Language", "en")
+	if body := serveReq(h, req).Body.String(); !strings.Contains(body, "Working directory is not accessible") {
+		t.Errorf("/readyz 返回 %q", body)
+	}
+}
+
+func TestRenameCollisions(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "a.txt", "A")
+	writeTestFile(t, dir, "b.txt", "B")
+	writeTestFile(t, dir, "d1/x", "")
+	writeTestFile(t, dir, "d2/y", "")
+	h := testHandler()
+	rename := func(oldName, newName string, overwrite bool) *httptest.ResponseRecorder {
+		form := url.Values{"old": {oldName}, "new": {newName}, "path": {""}}
+		if overwrite {
+			form.Set("overwrite", "true")
+		}
+		return postForm(h, "/rename", form)
+	}
+
+	cases := []struct {
+		oldName, newName, want string
+	}{
+		{"a.txt", "b.txt", "已存在同名文件"},
+		{"d1", "d2", "已存在同名文件夹"},
+		{"d1", "a.txt", "不能将文件夹重命名为已存在的文件"},
+		{"a.txt", "d1", "已存在同名文件夹，不能用文件覆盖"},
+	}
+	for _, c := range cases {
+		rec := rename(c.oldName, c.newName, false)
+		if rec.Code != http.StatusConflict || strings.TrimSpace(rec.Body.String()) != c.want {
+			t.Errorf("%s -> %s 返回 %d %q", c.oldName, c.newName, rec.Code, rec.Body)
+		}
+	}
+	// overwrite=true 也不能跨类型覆盖
+	if rec := rename("a.txt", "d1", true); rec.Code != http.StatusConflict {
+		t.Errorf("overwrite 用文件覆盖文件夹返回 %d", rec.Code)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "b.txt")); string(b) != "B" {
+		t.Error("冲突的重命名改写了目标")
+	}
+
+	if rec := rename("a.txt", "b.txt", true); rec.Code != http.StatusOK {
+		t.Errorf("overwrite=true 返回 %d", rec.Code)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "b.txt")); string(b) != "A" {
+		t.Error("overwrite=true 未覆盖目标文件")
+	}
+	// 仅大小写不同时照常重命名
+	if rec := rename("b.txt", "B.txt", false); rec.Code != http.StatusOK {
+		t.Errorf("仅改变大小写返回 %d", rec.Code)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	dir := setupTest(t)
+	h := testHandler()
+
+	if rec := postForm(h, "/truncate", url.Values{"file": {"blank.img"}, "size": {"1048576"}}); rec.Code != http.StatusOK {
+		t.Fatalf("/truncate 返回 %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serve(h, "GET", "/stat?file=blank.img", nil); !strings.Contains(rec.Body.String(), `"size":1048576`) {
+		t.Errorf("/stat 报告的大小不正确: %s", rec.Body)
+	}
+
+	// 调整大小保留原有内容与权限
+	p := writeTestFile(t, dir, "data.txt", "0123456789")
+	os.Chmod(p, 0600)
+	postForm(h, "/truncate", url.Values{"file": {"data.txt"}, "size": {"4"}})
+	if b, _ := os.ReadFile(p); string(b) != "0123" {
+		t.Errorf("缩小后内容为 %q", b)
+	}
+	postForm(h, "/truncate", url.Values{"file": {"data.txt"}, "size": {"6"}})
+	if b, _ := os.ReadFile(p); string(b) != "0123\x00\x00" {
+		t.Errorf("扩大后内容为 %q", b)
+	}
+	if info, _ := os.Stat(p); info.Mode().Perm() != 0600 {
+		t.Errorf("权限变为 %o", info.Mode().Perm())
+	}
+
+	// 没有其他硬链接的文件原地调整，inode 保持不变
+	before, _ := os.Stat(p)
+	postForm(h, "/truncate", url.Values{"file": {"data.txt"}, "size": {"2"}})
+	if after, _ := os.Stat(p); !os.SameFile(before, after) || after.Size() != 2 {
+		t.Error("未共享的文件应原地截断")
+	}
+
+	// 硬链接（如 -dedupe link 产生的）不受影响
+	shared := writeTestFile(t, dir, "shared.txt", "shared content")
+	link := filepath.Join(dir, "link.txt")
+	if err := os.Link(shared, link); err != nil {
+		t.Skip("文件系统不支持硬链接")
+	}
+	postForm(h, "/truncate", url.Values{"file": {"link.txt"}, "size": {"0"}})
+	if b, _ := os.ReadFile(shared); string(b) != "shared content" {
+		t.Errorf("截断硬链接改写了另一个文件: %q", b)
+	}
+	if info, _ := os.Stat(link); info.Size() != 0 {
+		t.Errorf("目标大小为 %d", info.Size())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 4 {
+		t.Errorf("留下了临时文件: %v", entries)
+	}
+
+	maxUploadSize = 100
+	if rec := postForm(h, "/truncate", url.Values{"file": {"big"}, "size": {"101"}}); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("超过上传大小限制返回 %d", rec.Code)
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	dir := setupTest(t)
+	check := func(name, want string) {
+		t.Helper()
+		if got, err := uniqueName(dir, name); err != nil || got != want {
+			t.Errorf("uniqueName(%q) = %q, %v，期望 %q", name, got, err, want)
+		}
+	}
+
+	check("report.pdf", "report.pdf")
+	writeTestFile(t, dir, "report.pdf", "")
+	check("report.pdf", "report (1).pdf")
+	writeTestFile(t, dir, "README", "")
+	check("README", "README (1)")
+	writeTestFile(t, dir, "archive.tar.gz", "")
+	check("archive.tar.gz", "archive.tar (1).gz")
+	writeTestFile(t, dir, ".bashrc", "")
+	check(".bashrc", ".bashrc (1)")
+	writeTestFile(t, dir, ".config.json", "")
+	check(".config.json", ".config (1).json")
+
+	for i := 1; i <= 50; i++ {
+		writeTestFile(t, dir, fmt.Sprintf("report (%d).pdf", i), "")
+	}
+	check("report.pdf", "report (51).pdf")
+	// 中间空出的编号会被复用
+	os.Remove(filepath.Join(dir, "report (7).pdf"))
+	check("report.pdf", "report (7).pdf")
+
+	// 超过上限时报错
+	writeTestFile(t, dir, "many", "")
+	for i := 1; i <= maxUniqueNameTries; i++ {
+		writeTestFile(t, dir, fmt.Sprintf("many (%d)", i), "")
+	}
+	if _, err := uniqueName(dir, "many"); err == nil {
+		t.Error("编号用尽时应返回错误")
+	}
+
+	// 上传与复制使用同一规则
+	h := testHandler()
+	rec := serveReq(h, uploadRequest(t, "/upload?path=&conflict=rename", nil, "README", "x"))
+	if !strings.Contains(rec.Body.String(), `"name":"README (1)"`) {
+		t.Errorf("conflict=rename 上传返回 %s", rec.Body)
+	}
+	if rec := postForm(h, "/copy", url.Values{"name": {"README"}, "path": {""}, "dest": {""}}); !strings.Contains(rec.Body.String(), "README (2)") {
+		t.Errorf("复制到同一目录返回 %s", rec.Body)
+	}
+}
+
+// apiErrorBody 解析 {error:{code,message}} 形式的错误响应
+func apiErrorBody(t *testing.T, rec *httptest.ResponseRecorder) (code, message string) {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("错误响应的 Content-Type 为 %q", ct)
+	}
+	var v struct {
+		Error struct{ Code, Message string }
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+		t.Fatalf("错误响应不是 JSON: %s", rec.Body)
+	}
+	return v.Error.Code, v.Error.Message
+}
+
+func TestAPIErrorShape(t *testing.T) {
+	setupTest(t)
+	addTestUser(t, "alice", roleViewer, "")
+	h := testHandler()
+
+	rec := loginRequest(h, "alice", "wrong", false)
+	if code, msg := apiErrorBody(t, rec); rec.Code != http.StatusUnauthorized || code != "invalid_credentials" || msg == "" {
+		t.Errorf("登录失败返回 %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(h, "GET", "/api/list", nil)
+	if code, _ := apiErrorBody(t, rec); rec.Code != http.StatusUnauthorized || code != "unauthorized" {
+		t.Errorf("未认证返回 %d %s", rec.Code, rec.Body)
+	}
+
+	token := login(t, h, "alice")
+	rec = serveReq(h, authed(token, "GET", "/api/list?path=../../etc", nil))
+	if code, _ := apiErrorBody(t, rec); rec.Code != http.StatusBadRequest || code != "invalid_path" {
+		t.Errorf("越界路径返回 %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestLoginBodyLimits(t *testing.T) {
+	setupTest(t)
+	addTestUser(t, "alice", roleViewer, "")
+	h := testHandler()
+
+	padding := strings.Repeat("x", maxLoginBodySize)
+	rec := serve(h, "POST", "/api/login", strings.NewReader(`{"username":"alice","password":"pw","pad":"`+padding+`"}`))
+	if code, _ := apiErrorBody(t, rec); rec.Code != http.StatusBadRequest || code != "request_too_large" {
+		t.Errorf("超大请求体返回 %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(h, "POST", "/api/login", strings.NewReader(`{"username":"alice","password":"pw","admin":true}`))
+	if code, msg := apiErrorBody(t, rec); rec.Code != http.StatusBadRequest || code != "invalid_request" || !strings.Contains(msg, "admin") {
+		t.Errorf("含未知字段的请求体返回 %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(h, "POST", "/api/login", strings.NewReader(`{"username":`))
+	if code, _ := apiErrorBody(t, rec); rec.Code != http.StatusBadRequest || code != "invalid_request" {
+		t.Errorf("格式错误的请求体返回 %d %s", rec.Code, rec.Body)
+	}
+	if rec := loginRequest(h, "alice", "pw", false); rec.Code != http.StatusOK {
+		t.Errorf("正常登录返回 %d", rec.Code)
+	}
+}
+
+func TestLogoutAll(t *testing.T) {
+	setupTest(t)
+	addTestUser(t, "alice", roleEditor, "")
+	addTestUser(t, "bob", roleViewer, "")
+	addTestUser(t, "root", roleAdmin, "")
+	h := testHandler()
+
+	t1, t2 := login(t, h, "alice"), login(t, h, "alice")
+	bobToken := login(t, h, "bob")
+	rec := serveReq(h, authed(t1, "POST", "/api/logout-all", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"revoked":2`) {
+		t.Fatalf("logout-all 返回 %d %s", rec.Code, rec.Body)
+	}
+	for _, token := range []string{t1, t2} {
+		if rec := serveReq(h, authed(token, "GET", "/api/session", nil)); rec.Code != http.StatusUnauthorized {
+			t.Errorf("注销后 token 仍有效: %d", rec.Code)
+		}
+	}
+	if rec := serveReq(h, authed(bobToken, "GET", "/api/session", nil)); rec.Code != http.StatusOK {
+		t.Error("其他用户的会话被注销")
+	}
+
+	// 非 admin 不能注销他人，admin 可以
+	t3 := login(t, h, "alice")
+	if rec := serveReq(h, authed(t3, "POST", "/api/logout-all?user=bob", nil)); rec.Code != http.StatusForbidden {
+		t.Errorf("非 admin 注销他人返回 %d", rec.Code)
+	}
+	rec = serveReq(h, authed(login(t, h, "root"), "POST", "/api/logout-all?user=bob", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"revoked":1`) {
+		t.Errorf("admin 注销 bob 返回 %d %s", rec.Code, rec.Body)
+	}
+	if rec := serveReq(h, authed(bobToken, "GET", "/api/session", nil)); rec.Code != http.StatusUnauthorized {
+		t.Error("admin 注销后 bob 的 token 仍有效")
+	}
+}
+
+func TestAPIListPaging(t *testing.T) {
+	dir := setupTest(t)
+	// 相同的修改时间与大小，只能靠名称打破平局
+	mtime := time.Now().Add(-time.Hour)
+	for i := 0; i < 23; i++ {
+		p := writeTestFile(t, dir, fmt.Sprintf("f%02d", (i*7)%23), "same")
+		os.Chtimes(p, mtime, mtime)
+	}
+	h := testHandler()
+
+	for _, sortType := range []string{"name", "time", "size", "type"} {
+		seen := map[string]bool{}
+		var names []string
+		for offset := 0; ; offset += 5 {
+			rec := serve(h, "GET", fmt.Sprintf("/api/list?sort=%s&offset=%d&limit=5", sortType, offset), nil)
+			var page struct {
+				Total   int
+				HasMore bool `json:"has_more"`
+				Files   []listEntry
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || page.Total != 23 {
+				t.Fatalf("sort=%s offset=%d 返回 %d %s", sortType, offset, rec.Code, rec.Body)
+			}
+			for _, f := range page.Files {
+				if seen[f.Name] {
+					t.Errorf("sort=%s 第 %d 项 %s 重复出现", sortType, offset, f.Name)
+				}
+				seen[f.Name] = true
+				names = append(names, f.Name)
+			}
+			if !page.HasMore {
+				break
+			}
+		}
+		if len(names) != 23 {
+			t.Errorf("sort=%s 共取得 %d 项，期望 23", sortType, len(names))
+		}
+	}
+}
+
+func TestAPIListErrors(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "file.txt", "x")
+	h := testHandler()
+
+	for _, target := range []string{"/api/list?path=missing", "/api/list?path=file.txt"} {
+		rec := serve(h, "GET", target, nil)
+		if code, _ := apiErrorBody(t, rec); rec.Code != http.StatusNotFound || code != "not_found" {
+			t.Errorf("%s 返回 %d %s", target, rec.Code, rec.Body)
+		}
+	}
+	rec := httptest.NewRecorder()
+	apiFSError(rec, httptest.NewRequest("GET", "/api/list", nil), &os.PathError{Op: "open", Path: dir, Err: syscall.EACCES}, "无法读取目录")
+	if code, _ := apiErrorBody(t, rec); rec.Code != http.StatusForbidden || code != "permission_denied" {
+		t.Errorf("EACCES 映射为 %d %s", rec.Code, code)
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("root 不受目录权限限制")
+	}
+	locked := filepath.Join(dir, "locked")
+	os.Mkdir(locked, 0000)
+	defer os.Chmod(locked, 0755)
+	rec = serve(h, "GET", "/api/list?path=locked", nil)
+	if code, _ := apiErrorBody(t, rec); rec.Code != http.StatusForbidden || code != "permission_denied" {
+		t.Errorf("无权限的目录返回 %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestDefaultSortAndCookie(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "a.txt", "ccc")
+	writeTestFile(t, dir, "b.txt", "a")
+	writeTestFile(t, dir, "c.txt", "bb")
+	h := testHandler()
+
+	order := func(req *http.Request) string {
+		t.Helper()
+		var page struct{ Files []listEntry }
+		json.Unmarshal(serveReq(h, req).Body.Bytes(), &page)
+		var names []string
+		for _, f := range page.Files {
+			names = append(names, f.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	if got := order(httptest.NewRequest("GET", "/api/list", nil)); got != "a.txt,b.txt,c.txt" {
+		t.Errorf("默认按名称排序为 %s", got)
+	}
+	defaultSort = "size"
+	if got := order(httptest.NewRequest("GET", "/api/list", nil)); got != "b.txt,c.txt,a.txt" {
+		t.Errorf("-default-sort size 时为 %s", got)
+	}
+	defaultOrder = "desc"
+	if got := order(httptest.NewRequest("GET", "/api/list", nil)); got != "a.txt,c.txt,b.txt" {
+		t.Errorf("-default-order desc 时为 %s", got)
+	}
+
+	// 显式指定排序时记入 Cookie，之后不带参数的请求沿用 Cookie 而不是服务器默认值
+	rec := serve(h, "GET", "/?sort=name&order=desc", nil)
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sortCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value != "name:desc" {
+		t.Fatalf("未记住排序选择: %v", rec.Result().Cookies())
+	}
+	req := httptest.NewRequest("GET", "/api/list", nil)
+	req.AddCookie(cookie)
+	if got := order(req); got != "c.txt,b.txt,a.txt" {
+		t.Errorf("Cookie 覆盖默认排序后为 %s", got)
+	}
+}
+
+func TestFetchURL(t *testing.T) {
+	dir := setupTest(t)
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/report":
+			w.Header().Set("Content-Disposition", `attachment; filename="季度报告.csv"`)
+			io.WriteString(w, "a,b\n1,2\n")
+		case "/big":
+			io.WriteString(w, strings.Repeat("x", 200))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer stub.Close()
+	h := testHandler()
+	fetch := func(u string, extra ...string) *httptest.ResponseRecorder {
+		form := url.Values{"url": {u}, "path": {""}}
+		for i := 0; i+1 < len(extra); i += 2 {
+			form.Set(extra[i], extra[i+1])
+		}
+		return postForm(h, "/fetch-url", form)
+	}
+
+	// 默认禁止访问本机与内网地址
+	for _, u := range []string{stub.URL + "/report", "http://169.254.169.254/latest/meta-data/", "http://[::1]:1/", "http://10.0.0.1:1/"} {
+		if rec := fetch(u); rec.Code != http.StatusForbidden {
+			t.Errorf("%s 返回 %d %s", u, rec.Code, rec.Body)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("被拒绝的请求留下了文件: %v", entries)
+	}
+
+	allowPrivateFetch = true
+	rec := fetch(stub.URL + "/report")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("fetch-url 返回 %d %s", rec.Code, rec.Body)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "季度报告.csv")); string(b) != "a,b\n1,2\n" {
+		t.Errorf("保存的内容为 %q", b)
+	}
+	if rec := fetch(stub.URL+"/report", "name", "季度报告.csv"); rec.Code != http.StatusConflict {
+		t.Errorf("目标已存在时返回 %d", rec.Code)
+	}
+	if rec := fetch(stub.URL + "/missing"); rec.Code != http.StatusBadGateway {
+		t.Errorf("远程 404 返回 %d", rec.Code)
+	}
+	maxUploadSize = 100
+	if rec := fetch(stub.URL + "/big"); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("超过大小限制返回 %d", rec.Code)
+	}
+	if rec := fetch("file:///etc/passwd"); rec.Code != http.StatusBadRequest {
+		t.Errorf("file:// 返回 %d", rec.Code)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("留下了临时文件: %v", entries)
+	}
+}
+
+func TestBlockedFetchIP(t *testing.T) {
+	for ip, want := range map[string]bool{
+		"127.0.0.1":       true,
+		"169.254.169.254": true,
+		"10.1.2.3":        true,
+		"192.168.0.1":     true,
+		"::1":             true,
+		"fe80::1":         true,
+		"0.0.0.0":         true,
+		"8.8.8.8":         false,
+		"2606:4700::1111": false,
+	} {
+		if got := blockedFetchIP(net.ParseIP(ip)); got != want {
+			t.Errorf("blockedFetchIP(%s) = %v", ip, got)
+		}
+	}
+}
+
+// failingReader 读出 data 后返回 err，模拟中途断开的上传
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if len(f.data) == 0 {
+		return 0, f.err
+	}
+	n := copy(p, f.data)
+	f.data = f.data[n:]
+	return n, nil
+}
+
+func TestUploadResume(t *testing.T) {
+	dir := setupTest(t)
+	h := testHandler()
+	content := "0123456789abcdefghij"
+	target := "/upload-resume?path=&name=r.bin"
+	put := func(rng string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", target, body)
+		req.Header.Set("Content-Range", rng)
+		return serveReq(h, req)
+	}
+	offset := func() string {
+		return serve(h, "HEAD", target, nil).Header().Get("Upload-Offset")
+	}
+
+	if got := offset(); got != "0" {
+		t.Errorf("尚未上传时 Upload-Offset = %q", got)
+	}
+	// 声明发送整个文件，但只送达 7 字节后连接中断
+	put("bytes 0-19/20", &failingReader{data: []byte(content[:7]), err: io.ErrUnexpectedEOF})
+	if got := offset(); got != "7" {
+		t.Fatalf("中断后 Upload-Offset = %q，期望 7", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "r.bin")); err == nil {
+		t.Error("未完成的上传出现在目标位置")
+	}
+
+	if rec := put("bytes 3-19/20", strings.NewReader(content[3:])); rec.Code != http.StatusConflict || rec.Header().Get("Upload-Offset") != "7" {
+		t.Errorf("offset 不一致时返回 %d，Upload-Offset=%q", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+	if rec := put("bytes 7-20/21", strings.NewReader(content[7:]+"!")); rec.Code != http.StatusBadRequest {
+		t.Errorf("总大小不一致时返回 %d", rec.Code)
+	}
+	if rec := put("bytes 7-19/20", strings.NewReader(content[7:])); rec.Code != http.StatusOK {
+		t.Fatalf("续传返回 %d %s", rec.Code, rec.Body)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "r.bin")); string(b) != content {
+		t.Errorf("续传后的内容为 %q", b)
+	}
+	if rec := serve(h, "HEAD", target, nil); rec.Header().Get("Upload-Complete") != "1" {
+		t.Error("完成后未报告 Upload-Complete")
+	}
+}
+
+func TestTransferLimit(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "big.bin", strings.Repeat("z", 16<<20))
+	transferSlots = make(chan struct{}, 2)
+	downloadIdle = 0
+	srv := httptest.NewServer(testHandler())
+	defer srv.Close()
+
+	// 两个不读取响应体的下载占满名额
+	var open []*http.Response
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(srv.URL + "/download?file=big.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		open = append(open, resp)
+	}
+	resp, err := http.Get(srv.URL + "/download?file=big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("第三个传输返回 %d，Retry-After=%q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	// 列表等元数据请求不受限制
+	if resp, err := http.Get(srv.URL + "/list"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("名额占满时 /list 不可用: %v", err)
+	} else {
+		resp.Body.Close()
+	}
+
+	// 一个下载结束后名额释放（处理函数返回前客户端可能已读完，稍作等待）
+	io.Copy(io.Discard, open[0].Body)
+	open[0].Body.Close()
+	code := 0
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		resp, err = http.Get(srv.URL + "/download?file=big.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if code = resp.StatusCode; code != http.StatusServiceUnavailable {
+			break
+		}
+	}
+	if code != http.StatusOK {
+		t.Errorf("名额释放后返回 %d", code)
+	}
+	open[1].Body.Close()
+}
+
+func TestNoListing(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "docs/known.txt", "known")
+	noListing = true
+	h := testHandler()
+
+	for _, target := range []string{"/", "/?path=docs", "/list", "/api/list", "/api/v1/tree", "/recent", "/search?q=known", "/download-tar?path=&name=docs"} {
+		if rec := serve(h, "GET", target, nil); rec.Code != http.StatusForbidden {
+			t.Errorf("%s 返回 %d", target, rec.Code)
+		}
+	}
+	rec := serve(h, "GET", "/download?path=docs&file=known.txt", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "known" {
+		t.Errorf("已知文件下载返回 %d", rec.Code)
+	}
+	if rec := serve(h, "GET", "/preview?file=docs/known.txt", nil); rec.Code != http.StatusOK {
+		t.Errorf("已知文件预览返回 %d", rec.Code)
+	}
+}
+
+// runBatch 调用 /api/v1/batch 并解析响应
+func runBatch(t *testing.T, h http.Handler, body string) (results []batchResult, succeeded, failed, skipped int) {
+	t.Helper()
+	rec := serve(h, "POST", "/api/v1/batch", strings.NewReader(body))
+	var v struct {
+		Results                    []batchResult
+		Succeeded, Failed, Skipped int
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+		t.Fatalf("/api/v1/batch 返回 %d %s", rec.Code, rec.Body)
+	}
+	return v.Results, v.Succeeded, v.Failed, v.Skipped
+}
+
+func TestBatchMixed(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "x.txt", "x")
+	h := testHandler()
+
+	ops := `{"op":"mkdir","path":"","name":"a"},{"op":"move","path":"","name":"missing.txt","dest":"a"},{"op":"move","path":"","name":"x.txt","dest":"a"}`
+	results, ok, failed, skipped := runBatch(t, h, `{"ops":[`+ops+`]}`)
+	if ok != 1 || failed != 1 || skipped != 1 || len(results) != 2 || !results[0].OK || results[1].Status != http.StatusNotFound {
+		t.Errorf("默认遇错即停：结果 %+v（成功 %d 失败 %d 跳过 %d）", results, ok, failed, skipped)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "x.txt")); err != nil {
+		t.Error("失败之后的操作仍被执行")
+	}
+
+	os.Remove(filepath.Join(dir, "a"))
+	results, ok, failed, skipped = runBatch(t, h, `{"continue_on_error":true,"ops":[`+ops+`]}`)
+	if ok != 2 || failed != 1 || skipped != 0 || len(results) != 3 || results[1].OK || !results[2].OK {
+		t.Errorf("continue_on_error：结果 %+v", results)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a", "x.txt")); err != nil {
+		t.Error("x.txt 未移动到 a")
+	}
+}
+
+func TestBatchRejectsDotNames(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "sub/keep.txt", "x")
+	h := testHandler()
+
+	for _, op := range []string{
+		`{"op":"delete","path":"","name":"."}`,
+		`{"op":"delete","path":"sub","name":".."}`,
+		`{"op":"move","path":"sub","name":"..","dest":""}`,
+		`{"op":"copy","path":"","name":".","dest":"sub"}`,
+		`{"op":"rename","path":"sub","old":"..","new":"x"}`,
+		`{"op":"rename","path":"","old":"sub","new":"../escaped"}`,
+		`{"op":"mkdir","path":"","name":"a/../../b","recursive":true}`,
+	} {
+		results, _, failed, _ := runBatch(t, h, `{"ops":[`+op+`]}`)
+		if failed != 1 || results[0].Status != http.StatusBadRequest {
+			t.Errorf("%s 的结果为 %+v", op, results)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sub", "keep.txt")); err != nil {
+		t.Error("目录内容被删除")
+	}
+}
+
+func TestStaticAssetCaching(t *testing.T) {
+	setupTest(t)
+	addTestUser(t, "alice", roleViewer, "")
+	h := testHandler()
+
+	for _, name := range []string{"app.css", "app.js", "login.css", "login.js"} {
+		rec := serve(h, "GET", "/static/"+name, nil)
+		etag := rec.Header().Get("ETag")
+		if rec.Code != http.StatusOK || etag == "" || rec.Header().Get("Last-Modified") == "" || rec.Header().Get("Cache-Control") != "no-cache" {
+			t.Fatalf("%s: %d ETag=%q Cache-Control=%q", name, rec.Code, etag, rec.Header().Get("Cache-Control"))
+		}
+		req := httptest.NewRequest("GET", "/static/"+name, nil)
+		req.Header.Set("If-None-Match", etag)
+		if rec := serveReq(h, req); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("%s: If-None-Match 匹配时返回 %d", name, rec.Code)
+		}
+		req = httptest.NewRequest("GET", "/static/"+name, nil)
+		req.Header.Set("If-None-Match", `"stale"`)
+		if rec := serveReq(h, req); rec.Code != http.StatusOK {
+			t.Errorf("%s: ETag 不匹配时返回 %d", name, rec.Code)
+		}
+		// 带当前版本号的地址可长期缓存
+		rec = serve(h, "GET", "/static/"+name+"?v="+strings.Trim(etag, `"`), nil)
+		if !strings.Contains(rec.Header().Get("Cache-Control"), "immutable") {
+			t.Errorf("%s: 带版本号时 Cache-Control=%q", name, rec.Header().Get("Cache-Control"))
+		}
+	}
+	if rec := serve(h, "GET", "/static/missing.js", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("不存在的资源返回 %d", rec.Code)
+	}
+
+	// 页面通过外链引用资源，而不是内联
+	body := serve(h, "GET", "/login", nil).Body.String()
+	if !strings.Contains(body, `href="`+assetURL("login.css")+`"`) || !strings.Contains(body, `src="`+assetURL("login.js")+`"`) {
+		t.Error("登录页未引用外部样式与脚本")
+	}
+}
+
+func TestTemplatesDirOverride(t *testing.T) {
+	setupTest(t)
+	tmpl := t.TempDir()
+	data, err := embeddedTemplates.ReadFile("templates/login.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom := strings.Replace(string(data), "<title>", "<title>Custom Portal · ", 1)
+	if custom == string(data) {
+		t.Fatal("login.html 中没有 <title>")
+	}
+	os.WriteFile(filepath.Join(tmpl, "login.html"), []byte(custom), 0644)
+	os.WriteFile(filepath.Join(tmpl, "app.css"), []byte("body{color:red}"), 0644)
+	if err := loadTemplates(tmpl); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { loadTemplates("") })
+	addTestUser(t, "alice", roleViewer, "")
+	h := testHandler()
+
+	if body := serve(h, "GET", "/login", nil).Body.String(); !strings.Contains(body, "<title>Custom Portal · ") {
+		t.Error("自定义模板未替换默认标题")
+	}
+	if body := serve(h, "GET", "/static/app.css", nil).Body.String(); body != "body{color:red}" {
+		t.Errorf("自定义样式未生效: %q", body)
+	}
+	// 目录中缺少的文件回退到内嵌默认值
+	js, _ := embeddedTemplates.ReadFile("templates/app.js")
+	if body := serve(h, "GET", "/static/app.js", nil).Body.String(); body != string(js) {
+		t.Error("缺少的文件未回退到内嵌默认值")
+	}
+
+	os.WriteFile(filepath.Join(tmpl, "main.html"), []byte("{{.Broken"), 0644)
+	if err := loadTemplates(tmpl); err == nil || !strings.Contains(err.Error(), "main.html") {
+		t.Errorf("模板语法错误时返回 %v", err)
+	}
+}
+
+func TestNormalizeRelPath(t *testing.T) {
+	for in, want := range map[string]string{
+		"":            "",
+		"/":           "",
+		"a\\b\\c":     "a/b/c",
+		"//a//b//":    "a/b",
+		"/a/b":        "a/b",
+		"./a/./b/.":   "a/b",
+		"\\a\\./b\\":  "a/b",
+		"a/../b":      "a/../b",
+		"..foo/bar":   "..foo/bar",
+		"中文\\目录//文件夹": "中文/目录/文件夹",
+	} {
+		if got, err := normalizeRelPath(in); err != nil || got != want {
+			t.Errorf("normalizeRelPath(%q) = %q, %v，期望 %q", in, got, err, want)
+		}
+	}
+	if _, err := normalizeRelPath("a\x00b"); err == nil {
+		t.Error("空字节未被拒绝")
+	}
+}
+
+func TestPathParamNormalized(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "a/b/note.txt", "hi")
+	h := testHandler()
+
+	for _, p := range []string{"a/b", `a\b`, "a//b", "/a/b/", "./a/./b", `\a\.\b\`} {
+		rec := serve(h, "GET", "/download?path="+url.QueryEscape(p)+"&file=note.txt", nil)
+		if rec.Code != http.StatusOK || rec.Body.String() != "hi" {
+			t.Errorf("path=%q 下载返回 %d", p, rec.Code)
+		}
+		rec = serve(h, "GET", "/api/list?path="+url.QueryEscape(p), nil)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "note.txt") {
+			t.Errorf("path=%q 列表返回 %d", p, rec.Code)
+		}
+	}
+	for _, p := range []string{"a/%00", `..\..`, "/../"} {
+		if rec := serve(h, "GET", "/api/list?path="+url.QueryEscape(p), nil); rec.Code < 400 || rec.Code >= 500 {
+			t.Errorf("path=%q 返回 %d", p, rec.Code)
+		}
+	}
+}
+
+func TestNotFoundRoute(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "listed.txt", "x")
+	h := testHandler()
+
+	req := httptest.NewRequest("GET", "/nonexistent", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	rec := serveReq(h, req)
+	if rec.Code != http.StatusNotFound || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("/nonexistent 返回 %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if body := rec.Body.String(); strings.Contains(body, "listed.txt") || !strings.Contains(body, "/nonexistent") {
+		t.Error("404 页面显示了目录列表或缺少请求路径")
+	}
+
+	rec = serve(h, "GET", "/api/nonexistent", nil)
+	if code, _ := apiErrorBody(t, rec); rec.Code != http.StatusNotFound || code != "not_found" {
+		t.Errorf("/api/nonexistent 返回 %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(h, "GET", "/nonexistent", nil); rec.Code != http.StatusNotFound || strings.Contains(rec.Body.String(), "<html") {
+		t.Errorf("非浏览器请求返回 %d", rec.Code)
+	}
+	if rec := serve(h, "GET", "/?path=", nil); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "listed.txt") {
+		t.Errorf("根目录列表返回 %d", rec.Code)
+	}
+}
+
+func TestFSErrorClasses(t *testing.T) {
+	setupTest(t)
+	for _, c := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{&fs.PathError{Op: "open", Path: "x", Err: syscall.ENOENT}, http.StatusNotFound, "not_found"},
+		{&fs.PathError{Op: "open", Path: "x", Err: syscall.EACCES}, http.StatusForbidden, "permission_denied"},
+		{&fs.PathError{Op: "open", Path: "x", Err: syscall.EPERM}, http.StatusForbidden, "permission_denied"},
+		{&fs.PathError{Op: "read", Path: "x", Err: syscall.EIO}, http.StatusInternalServerError, "io_error"},
+	} {
+		rec := httptest.NewRecorder()
+		fsError(rec, httptest.NewRequest("GET", "/download", nil), c.err, "读取文件失败")
+		if rec.Code != c.status || rec.Header().Get("X-Error-Code") != c.code {
+			t.Errorf("fsError(%v) = %d %s", c.err, rec.Code, rec.Header().Get("X-Error-Code"))
+		}
+		rec = httptest.NewRecorder()
+		apiFSError(rec, httptest.NewRequest("GET", "/api/list", nil), c.err, "读取文件失败")
+		if code, _ := apiErrorBody(t, rec); rec.Code != c.status || code != c.code {
+			t.Errorf("apiFSError(%v) = %d %s", c.err, rec.Code, code)
+		}
+	}
+
+	// 英文界面下同样是本地化的提示
+	req := httptest.NewRequest("GET", "/download", nil)
+	req.Header.Set("Accept-Language", "en")
+	rec := httptest.NewRecorder()
+	fsError(rec, req, &fs.PathError{Op: "open", Path: "x", Err: syscall.ENOENT}, "读取文件失败")
+	if strings.ContainsFunc(rec.Body.String(), func(c rune) bool { return c > 0x7f }) {
+		t.Errorf("英文界面返回 %q", rec.Body)
+	}
+}
+
+func TestFileVanishedBeforeAction(t *testing.T) {
+	setupTest(t)
+	h := testHandler()
+
+	rec := serve(h, "GET", "/download?file=gone.txt", nil)
+	if rec.Code != http.StatusNotFound || rec.Header().Get("X-Error-Code") != "not_found" {
+		t.Errorf("下载已删除的文件返回 %d %s", rec.Code, rec.Header().Get("X-Error-Code"))
+	}
+	req := httptest.NewRequest("GET", "/delete?file=gone.txt", nil)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	if rec := serveReq(h, req); rec.Code != http.StatusNotFound || rec.Header().Get("X-Error-Code") != "not_found" {
+		t.Errorf("删除已删除的文件返回 %d %s", rec.Code, rec.Header().Get("X-Error-Code"))
+	}
+	if rec := postForm(h, "/rename", url.Values{"old": {"gone.txt"}, "new": {"x.txt"}}); rec.Code != http.StatusNotFound || rec.Header().Get("X-Error-Code") != "not_found" {
+		t.Errorf("重命名已删除的文件返回 %d %s", rec.Code, rec.Header().Get("X-Error-Code"))
+	}
+}
+
+func TestLogRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	w, err := newRotatingWriter(path, 100, 0, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.f.Close() })
+	line := strings.Repeat("x", 59) + "\n"
+	for i := 0; i < 2; i++ {
+		w.Write([]byte(line))
+	}
+	if data, err := os.ReadFile(path + ".1"); err != nil || string(data) != line {
+		t.Fatalf("超过大小后未生成备份: %q %v", data, err)
+	}
+	for i := 0; i < 4; i++ {
+		w.Write([]byte(line))
+	}
+	if _, err := os.Stat(path + ".2"); err != nil {
+		t.Error("备份未依次后移")
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("超出 -log-backups 的备份未删除")
+	}
+
+	// 并发写入时每行保持完整
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				w.Write([]byte(line))
+			}
+		}()
+	}
+	wg.Wait()
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		data, _ := os.ReadFile(name)
+		if string(data) != line {
+			t.Errorf("%s 内容为 %q", filepath.Base(name), data)
+		}
+	}
+}
+
+func TestLogRotationCompress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	w, err := newRotatingWriter(path, 10, 0, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.f.Close() })
+	w.Write([]byte("first line\n"))
+	w.Write([]byte("second\n"))
+	f, err := os.Open(path + ".1.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(zr); string(data) != "first line\n" {
+		t.Errorf("压缩备份内容为 %q", data)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "second\n" {
+		t.Errorf("当前日志内容为 %q", data)
+	}
+}
+
+func TestUploadChunkValidation(t *testing.T) {
+	dir := setupTest(t)
+	h := testHandler()
+	chunk := func(id string, offset, total int, data, extra string) *httptest.ResponseRecorder {
+		target := fmt.Sprintf("/upload-chunk?uploadId=%s&path=&name=%s.txt&total=%d&offset=%d%s", id, id, total, offset, extra)
+		return serve(h, "POST", target, strings.NewReader(data))
+	}
+	received := func(id string) int64 {
+		uploadsMu.Lock()
+		defer uploadsMu.Unlock()
+		u := uploads[id]
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		if info, err := os.Stat(u.TempPath); err != nil || info.Size() != u.Received {
+			t.Errorf("%s: 临时文件与已接收字节数 %d 不一致", id, u.Received)
+		}
+		return u.Received
+	}
+
+	// 缺失分片：跳过 offset 5 直接发送 offset 10
+	chunk("gap", 0, 15, "01234", "")
+	if rec := chunk("gap", 10, 15, "abcde", ""); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "5") {
+		t.Errorf("缺失分片返回 %d %s", rec.Code, rec.Body)
+	}
+	if received("gap") != 5 {
+		t.Error("缺失分片的数据被写入")
+	}
+
+	// 重复分片：再次发送 offset 0
+	chunk("dup", 0, 10, "01234", "")
+	if rec := chunk("dup", 0, 10, "01234", ""); rec.Code != http.StatusConflict {
+		t.Errorf("重复分片返回 %d", rec.Code)
+	}
+	chunk("dup", 5, 10, "56789", "")
+	if b, _ := os.ReadFile(filepath.Join(dir, "dup.txt")); string(b) != "0123456789" {
+		t.Errorf("重复分片后合并内容为 %q", b)
+	}
+
+	// 大小不符：total 改变、分片超出 total
+	chunk("size", 0, 10, "01234", "")
+	if rec := chunk("size", 5, 12, "56789", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("total 改变时返回 %d", rec.Code)
+	}
+	if rec := chunk("size", 5, 10, "56789ab", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("分片超出 total 时返回 %d", rec.Code)
+	}
+	if received("size") != 5 {
+		t.Error("超出 total 的分片未被丢弃")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "size.txt")); !os.IsNotExist(err) {
+		t.Error("大小不符时仍生成了目标文件")
+	}
+
+	// 摘要不符：清空已接收数据，不生成目标文件
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte("0123456789")))
+	chunk("sum", 0, 10, "01234", "&sha256="+sum)
+	if rec := chunk("sum", 5, 10, "XXXXX", ""); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), sum) {
+		t.Errorf("摘要不符时返回 %d %s", rec.Code, rec.Body)
+	}
+	if received("sum") != 0 {
+		t.Error("摘要不符后未清空已接收数据")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sum.txt")); !os.IsNotExist(err) {
+		t.Error("摘要不符时仍生成了目标文件")
+	}
+	chunk("sum", 0, 10, "01234", "")
+	if rec := chunk("sum", 5, 10, "56789", ""); rec.Code != http.StatusOK {
+		t.Errorf("重新上传返回 %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestDefaultStateDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Setenv("LOCALAPPDATA", `C:\Users\u\AppData\Local`)
+		if got := defaultStateDir(); got != `C:\Users\u\AppData\Local\hfs` {
+			t.Errorf("defaultStateDir() = %q", got)
+		}
+		return
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_STATE_HOME", "/var/lib/state")
+	if got := defaultStateDir(); got != "/var/lib/state/hfs" {
+		t.Errorf("设置 XDG_STATE_HOME 时为 %q", got)
+	}
+	// 相对路径按规范忽略
+	t.Setenv("XDG_STATE_HOME", "relative/state")
+	if got := defaultStateDir(); got != filepath.Join(home, ".hfs") {
+		t.Errorf("XDG_STATE_HOME 为相对路径时为 %q", got)
+	}
+	t.Setenv("XDG_STATE_HOME", "")
+	if got := defaultStateDir(); got != filepath.Join(home, ".hfs") {
+		t.Errorf("未设置 XDG_STATE_HOME 时为 %q", got)
+	}
+}
+
+func TestAPITree(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "root.txt", "12345")
+	writeTestFile(t, dir, "a/one.txt", "1")
+	writeTestFile(t, dir, "a/b/two.txt", "22")
+	writeTestFile(t, dir, "a/b/c/three.txt", "333")
+	os.Symlink("..", filepath.Join(dir, "a", "b", "up"))
+	h := testHandler()
+
+	type node struct {
+		Name     string
+		IsDir    bool `json:"is_dir"`
+		Size     int64
+		Children []*node
+	}
+	tree := func(target string) (top *node, truncated bool, nodes int) {
+		t.Helper()
+		rec := serve(h, "GET", target, nil)
+		var v struct {
+			Tree      *node
+			Truncated bool
+			Nodes     int
+		}
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &v) != nil {
+			t.Fatalf("%s 返回 %d %s", target, rec.Code, rec.Body)
+		}
+		return v.Tree, v.Truncated, v.Nodes
+	}
+	// render 把树展开为 "名称[/](大小)" 列表，便于比较结构
+	var render func(n *node, prefix string) []string
+	render = func(n *node, prefix string) []string {
+		var out []string
+		for _, c := range n.Children {
+			if c.IsDir {
+				out = append(out, prefix+c.Name+"/")
+				out = append(out, render(c, prefix+c.Name+"/")...)
+			} else {
+				out = append(out, fmt.Sprintf("%s%s(%d)", prefix, c.Name, c.Size))
+			}
+		}
+		return out
+	}
+
+	// 指向上级目录的符号链接只作为条目出现，不会展开
+	top, truncated, nodes := tree("/api/v1/tree")
+	want := "a/ a/b/ a/b/c/ a/b/c/three.txt(3) a/b/two.txt(2) a/b/up(2) a/one.txt(1) root.txt(5)"
+	if got := strings.Join(render(top, ""), " "); got != want || truncated || nodes != 8 {
+		t.Errorf("完整目录树为 %s（truncated=%v nodes=%d）", got, truncated, nodes)
+	}
+
+	top, _, _ = tree("/api/v1/tree?path=a&depth=1")
+	if got := strings.Join(render(top, ""), " "); top.Name != "a" || got != "b/ one.txt(1)" {
+		t.Errorf("depth=1 时为 %s %s", top.Name, got)
+	}
+
+	old := treeNodeLimit
+	treeNodeLimit = 3
+	t.Cleanup(func() { treeNodeLimit = old })
+	if top, truncated, nodes = tree("/api/v1/tree"); !truncated || nodes != 3 || len(render(top, "")) != 3 {
+		t.Errorf("超过节点上限时 truncated=%v nodes=%d", truncated, nodes)
+	}
+
+	if rec := serve(h, "GET", "/api/v1/tree?path=../", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("越界路径返回 %d", rec.Code)
+	}
+	if rec := serve(h, "GET", "/api/v1/tree?depth=0", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("depth=0 返回 %d", rec.Code)
+	}
+}
+
+func TestFaviconWithoutAuth(t *testing.T) {
+	setupTest(t)
+	addTestUser(t, "alice", roleViewer, "")
+	h := testHandler()
+
+	if rec := serve(h, "GET", "/favicon.ico", nil); rec.Code != http.StatusNoContent || rec.Header().Get("Location") != "" {
+		t.Errorf("/favicon.ico 返回 %d Location=%q", rec.Code, rec.Header().Get("Location"))
+	}
+	rec := serve(h, "GET", "/static/favicon.svg", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Errorf("/static/favicon.svg 返回 %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if body := serve(h, "GET", "/login", nil).Body.String(); !strings.Contains(body, `rel="icon"`) {
+		t.Error("登录页未声明图标")
+	}
+}
+
+func TestDirSortFile(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "sub/a.txt", "ccc")
+	writeTestFile(t, dir, "sub/b.txt", "a")
+	writeTestFile(t, dir, "sub/c.txt", "bb")
+	writeTestFile(t, dir, "other/a.txt", "ccc")
+	writeTestFile(t, dir, "other/b.txt", "a")
+	h := testHandler()
+
+	order := func(req *http.Request) string {
+		t.Helper()
+		var page struct{ Files []listEntry }
+		json.Unmarshal(serveReq(h, req).Body.Bytes(), &page)
+		var names []string
+		for _, f := range page.Files {
+			names = append(names, f.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	if rec := postForm(h, "/dir-sort", url.Values{"path": {"sub"}, "sort": {"size"}, "order": {"desc"}}); rec.Code != http.StatusOK {
+		t.Fatalf("保存目录排序返回 %d %s", rec.Code, rec.Body)
+	}
+	if got := order(httptest.NewRequest("GET", "/api/list?path=sub", nil)); got != "a.txt,c.txt,b.txt" {
+		t.Errorf(".hfs-sort 生效后为 %s（应隐藏该文件）", got)
+	}
+	if got := order(httptest.NewRequest("GET", "/api/list?path=other", nil)); got != "a.txt,b.txt" {
+		t.Errorf("其他目录受到影响: %s", got)
+	}
+	// 显式参数与目录设置优先于 Cookie
+	req := httptest.NewRequest("GET", "/api/list?path=sub", nil)
+	req.AddCookie(&http.Cookie{Name: sortCookie, Value: "name:asc"})
+	if got := order(req); got != "a.txt,c.txt,b.txt" {
+		t.Errorf("Cookie 覆盖了目录排序: %s", got)
+	}
+	if got := order(httptest.NewRequest("GET", "/api/list?path=sub&sort=name", nil)); got != "a.txt,b.txt,c.txt" {
+		t.Errorf("显式参数未覆盖目录排序: %s", got)
+	}
+
+	// 内容无效时忽略
+	os.WriteFile(filepath.Join(dir, "sub", dirSortFile), []byte(`{"sort":"bogus"}`), 0644)
+	if got := order(httptest.NewRequest("GET", "/api/list?path=sub", nil)); got != "a.txt,b.txt,c.txt" {
+		t.Errorf("无效的 .hfs-sort 未被忽略: %s", got)
+	}
+	if rec := postForm(h, "/dir-sort", url.Values{"path": {"sub"}, "sort": {"bogus"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("无效排序方式返回 %d", rec.Code)
+	}
+	if rec := postForm(h, "/dir-sort", url.Values{"path": {"sub"}}); rec.Code != http.StatusOK {
+		t.Errorf("清除目录排序返回 %d", rec.Code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sub", dirSortFile)); !os.IsNotExist(err) {
+		t.Error("清除后 .hfs-sort 仍存在")
+	}
+}
+
+func TestDownloadDeepLink(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "a/b/c.txt", "deep")
+	os.WriteFile(filepath.Join(filepath.Dir(dir), "outside.txt"), []byte("secret"), 0644)
+	h := testHandler()
+
+	for _, target := range []string{
+		"/download?file=a/b/c.txt",
+		"/download?file=" + url.QueryEscape(`a\b\c.txt`),
+		"/download?file=/a//b/./c.txt",
+		"/download?path=a&file=b/c.txt",
+		"/download?path=a/b&file=c.txt",
+	} {
+		rec := serve(h, "GET", target, nil)
+		if rec.Code != http.StatusOK || rec.Body.String() != "deep" {
+			t.Errorf("%s 返回 %d %q", target, rec.Code, rec.Body)
+		}
+		if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, `filename="c.txt"`) {
+			t.Errorf("%s 的 Content-Disposition 为 %q", target, cd)
+		}
+	}
+	for _, target := range []string{
+		"/download?file=../outside.txt",
+		"/download?file=a/../../outside.txt",
+		"/download?path=a&file=../../outside.txt",
+		"/download?file=a/b/..",
+		"/download?file=a/b/",
+	} {
+		if rec := serve(h, "GET", target, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s 返回 %d", target, rec.Code)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	setupTest(t)
+	var logBuf bytes.Buffer
+	accessLog = &logBuf
+	h := testHandler()
+
+	req := httptest.NewRequest("GET", "/api/list?path=missing", nil)
+	req.Header.Set("X-Request-ID", "trace-123:abc")
+	rec := serveReq(h, req)
+	if got := rec.Header().Get("X-Request-ID"); got != "trace-123:abc" {
+		t.Errorf("传入的请求ID未回显: %q", got)
+	}
+	var v struct {
+		Error struct {
+			RequestID string `json:"request_id"`
+		}
+	}
+	if json.Unmarshal(rec.Body.Bytes(), &v); v.Error.RequestID != "trace-123:abc" {
+		t.Errorf("错误响应中的请求ID为 %q", v.Error.RequestID)
+	}
+	if !strings.Contains(logBuf.String(), "trace-123:abc") {
+		t.Errorf("访问日志未包含请求ID: %s", logBuf.String())
+	}
+
+	// 未提供或格式不合法时生成新的ID
+	seen := map[string]bool{}
+	for _, supplied := range []string{"", "bad id\n", strings.Repeat("x", 200)} {
+		req := httptest.NewRequest("GET", "/api/list", nil)
+		if supplied != "" {
+			req.Header.Set("X-Request-ID", supplied)
+		}
+		id := serveReq(h, req).Header().Get("X-Request-ID")
+		if len(id) != 32 || strings.Trim(id, "0123456789abcdef") != "" || seen[id] {
+			t.Errorf("X-Request-ID=%q 时生成的ID为 %q", supplied, id)
+		}
+		seen[id] = true
+	}
+
+	// 服务端错误的纯文本响应同样附带请求ID
+	rec = httptest.NewRecorder()
+	rec.Header().Set("X-Request-ID", "trace-500")
+	httpError(rec, httptest.NewRequest("GET", "/", nil), "无法读取文件", http.StatusInternalServerError)
+	if !strings.Contains(rec.Body.String(), "trace-500") {
+		t.Errorf("5xx 响应未附带请求ID: %q", rec.Body)
+	}
+}
+
+func TestUploadAllowWriteHandlers(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "inbox/a.txt", "hello")
+	writeTestFile(t, dir, "locked/b.txt", "world")
+	writeTestFile(t, dir, "proj/src.txt", "x")
+	writeTestZip(t, filepath.Join(dir, "inbox", "arc.zip"), "z.txt", "z")
+	uploadAllow = stringList{"inbox"}
+	h := testHandler()
+
+	for _, c := range []struct {
+		target string
+		form   url.Values
+	}{
+		{"/create", url.Values{"path": {"locked"}, "type": {"folder"}, "name": {"new"}}},
+		{"/create", url.Values{"path": {""}, "type": {"file"}, "name": {"new.txt"}}},
+		{"/extract", url.Values{"path": {"inbox"}, "file": {"arc.zip"}, "dest": {"locked"}}},
+		{"/truncate", url.Values{"path": {"locked"}, "file": {"b.txt"}, "size": {"1"}}},
+		{"/compress", url.Values{"path": {""}, "name": {"proj"}, "dest": {"locked"}}},
+		{"/compress", url.Values{"path": {""}, "name": {"proj"}}},
+		{"/copy", url.Values{"path": {"inbox"}, "name": {"a.txt"}, "dest": {"locked"}}},
+		{"/move", url.Values{"path": {"inbox"}, "name": {"a.txt"}, "dest": {""}}},
+	} {
+		if rec := postForm(h, c.target, c.form); rec.Code != http.StatusForbidden {
+			t.Errorf("%s %v 返回 %d %s", c.target, c.form, rec.Code, rec.Body)
+		}
+	}
+	if rec := serveReq(h, uploadRequest(t, "/upload?path=locked", nil, "up.txt", "x")); rec.Code != http.StatusForbidden {
+		t.Errorf("上传到受限目录返回 %d", rec.Code)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "locked")); len(entries) != 1 {
+		t.Errorf("受限目录被写入: %v", entries)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "locked", "b.txt")); string(b) != "world" {
+		t.Errorf("受限目录中的文件被修改: %q", b)
+	}
+
+	// 目标位于允许的目录内时正常执行
+	for _, c := range []struct {
+		target string
+		form   url.Values
+	}{
+		{"/create", url.Values{"path": {"inbox"}, "type": {"folder"}, "name": {"new"}}},
+		{"/extract", url.Values{"path": {"inbox"}, "file": {"arc.zip"}}},
+		{"/truncate", url.Values{"path": {"inbox"}, "file": {"a.txt"}, "size": {"2"}}},
+		{"/compress", url.Values{"path": {""}, "name": {"proj"}, "dest": {"inbox"}}},
+		{"/copy", url.Values{"path": {"locked"}, "name": {"b.txt"}, "dest": {"inbox"}}},
+		{"/move", url.Values{"path": {"proj"}, "name": {"src.txt"}, "dest": {"inbox"}}},
+	} {
+		if rec := postForm(h, c.target, c.form); rec.Code != http.StatusOK {
+			t.Errorf("%s %v 返回 %d %s", c.target, c.form, rec.Code, rec.Body)
+		}
+	}
+	if rec := serveReq(h, uploadRequest(t, "/upload?path=inbox/new", nil, "up.txt", "x")); rec.Code != http.StatusOK {
+		t.Errorf("上传到允许的子目录返回 %d", rec.Code)
+	}
+	for _, name := range []string{"z.txt", "proj.zip", "b.txt", "src.txt", "new/up.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, "inbox", name)); err != nil {
+			t.Errorf("inbox 中缺少 %s", name)
+		}
+	}
+}
+
+func TestUploadBlocked(t *testing.T) {
+	dir := setupTest(t)
+	blockedExts = map[string]bool{"exe": true, "sh": true}
+	h := testHandler()
+
+	for _, name := range []string{"setup.exe", "Setup.EXE", "run.Sh"} {
+		if rec := serveReq(h, uploadRequest(t, "/upload", nil, name, "data")); rec.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("上传 %s 返回 %d", name, rec.Code)
+		}
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s 被写入", name)
+		}
+	}
+	if rec := serveReq(h, uploadRequest(t, "/upload", nil, "notes.txt", "#!/bin/sh")); rec.Code != http.StatusOK {
+		t.Errorf("未启用 -block-executables 时按内容拒绝: %d", rec.Code)
+	}
+
+	// 扩展名伪装成图片的可执行文件
+	sniffExecutables = true
+	for _, content := range []string{"\x7fELF\x02\x01\x01", "MZ\x90\x00", "#!/bin/sh\nrm -rf /"} {
+		if rec := serveReq(h, uploadRequest(t, "/upload", nil, "photo.jpg", content)); rec.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("内容 %q 返回 %d", content[:2], rec.Code)
+		}
+		if rec := serve(h, "PUT", "/api/v1/files/photo.jpg", strings.NewReader(content)); rec.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("PUT 内容 %q 返回 %d", content[:2], rec.Code)
+		}
+		target := fmt.Sprintf("/upload-chunk?uploadId=sniff&path=&name=photo.jpg&total=%d&offset=0", len(content))
+		if rec := serve(h, "POST", target, strings.NewReader(content)); rec.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("分片上传内容 %q 返回 %d", content[:2], rec.Code)
+		}
+		uploadsMu.Lock()
+		os.Remove(uploads["sniff"].TempPath)
+		delete(uploads, "sniff")
+		uploadsMu.Unlock()
+	}
+	if _, err := os.Stat(filepath.Join(dir, "photo.jpg")); !os.IsNotExist(err) {
+		t.Error("可执行内容被写入")
+	}
+	if rec := serveReq(h, uploadRequest(t, "/upload", nil, "photo.jpg", "\xff\xd8\xff\xe0JFIF")); rec.Code != http.StatusOK {
+		t.Errorf("普通图片返回 %d", rec.Code)
+	}
+}
+
+func TestEmptyDir(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "box/a.txt", "a")
+	writeTestFile(t, dir, "box/sub/b.txt", "b")
+	writeTestFile(t, dir, "box/sub/deeper/c.txt", "c")
+	writeTestFile(t, dir, "keep.txt", "k")
+	h := testHandler()
+
+	call := func(form url.Values) (int, map[string]interface{}) {
+		rec := postForm(h, "/empty-dir", form)
+		var v map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &v)
+		return rec.Code, v
+	}
+
+	code, v := call(url.Values{"path": {"box"}})
+	token, _ := v["token"].(string)
+	if code != http.StatusOK || token == "" || v["entries"] != 2.0 {
+		t.Fatalf("申请确认令牌返回 %d %v", code, v)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "box", "a.txt")); err != nil {
+		t.Fatal("未确认前已删除内容")
+	}
+	if code, _ := call(url.Values{"path": {"box/sub"}, "token": {token}}); code != http.StatusForbidden {
+		t.Errorf("令牌用于其他目录时返回 %d", code)
+	}
+
+	// 令牌只能使用一次，上面的失败尝试已使其作废
+	_, v = call(url.Values{"path": {"box"}})
+	token, _ = v["token"].(string)
+	code, v = call(url.Values{"path": {"box"}, "token": {token}})
+	if code != http.StatusOK || v["removed_files"] != 3.0 || v["removed_dirs"] != 2.0 {
+		t.Errorf("清空目录返回 %d %v", code, v)
+	}
+	if entries, err := os.ReadDir(filepath.Join(dir, "box")); err != nil || len(entries) != 0 {
+		t.Errorf("目录未保留或内容未清空: %v %v", entries, err)
+	}
+	if code, _ := call(url.Values{"path": {"box"}, "token": {token}}); code != http.StatusForbidden {
+		t.Errorf("重复使用令牌返回 %d", code)
+	}
+
+	if code, _ := call(url.Values{"path": {""}}); code != http.StatusForbidden {
+		t.Errorf("清空根目录返回 %d", code)
+	}
+	if code, _ := call(url.Values{"path": {"/./"}}); code != http.StatusForbidden {
+		t.Errorf("以 /./ 指定根目录时返回 %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "keep.txt")); err != nil {
+		t.Error("根目录内容被删除")
+	}
+}
+
+func TestDeleteRejectsDotNames(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "sub/keep.txt", "x")
+	h := testHandler()
+
+	for _, target := range []string{
+		"/delete?file=.",
+		"/delete?path=sub&file=..",
+		"/delete?path=sub&file=" + url.QueryEscape("../sub"),
+		"/delete?path=sub/..&file=.",
+	} {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		if rec := serveReq(h, req); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s 返回 %d", target, rec.Code)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sub", "keep.txt")); err != nil {
+		t.Error("目录被删除")
+	}
+}
+
+func TestCORS(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "a.txt", "a")
+	corsOrigins = stringList{"https://app.example.com"}
+	h := testHandler()
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", "/api/list", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "content-type")
+		return serveReq(h, req)
+	}
+	rec := preflight("https://app.example.com")
+	hdr := rec.Header()
+	if rec.Code != http.StatusNoContent || hdr.Get("Access-Control-Allow-Origin") != "https://app.example.com" || hdr.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Fatalf("预检返回 %d %v", rec.Code, hdr)
+	}
+	if !strings.Contains(hdr.Get("Access-Control-Allow-Methods"), "POST") || !strings.Contains(hdr.Get("Access-Control-Allow-Headers"), "Content-Type") || hdr.Get("Vary") == "" {
+		t.Errorf("预检响应头为 %v", hdr)
+	}
+	if rec := preflight("https://evil.example.com"); rec.Code != http.StatusForbidden || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("未授权来源的预检返回 %d %v", rec.Code, rec.Header())
+	}
+
+	req := httptest.NewRequest("GET", "/api/list", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec = serveReq(h, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" || !strings.Contains(rec.Header().Get("Access-Control-Expose-Headers"), "X-Request-ID") {
+		t.Errorf("跨域请求返回 %d %v", rec.Code, rec.Header())
+	}
+	// 未授权来源的实际请求照常处理，但不带 CORS 头，浏览器会拦截响应
+	req = httptest.NewRequest("GET", "/api/list", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	if rec := serveReq(h, req); rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("未授权来源得到 %v", rec.Header())
+	}
+	// 仅 /api/ 下的接口启用 CORS
+	req = httptest.NewRequest("GET", "/download?file=a.txt", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	if rec := serveReq(h, req); rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("非 /api/ 路径返回了 CORS 头")
+	}
+
+	corsOrigins = stringList{"*"}
+	rec = preflight("https://any.example.com")
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "*" || rec.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("* 时的预检返回 %d %v", rec.Code, rec.Header())
+	}
+
+	// 预检请求不携带凭据，开启认证后同样直接返回
+	addTestUser(t, "alice", roleViewer, "")
+	if rec := preflight("https://any.example.com"); rec.Code != http.StatusNoContent {
+		t.Errorf("开启认证后预检返回 %d", rec.Code)
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "docs/Report-2023.txt", "x")
+	h := testHandler()
+
+	type searchResp struct {
+		Source  string
+		Results []searchResult
+	}
+	search := func(q string) searchResp {
+		t.Helper()
+		var v searchResp
+		rec := serve(h, "GET", "/search?q="+url.QueryEscape(q), nil)
+		if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+			t.Fatalf("/search 返回 %d %s", rec.Code, rec.Body)
+		}
+		return v
+	}
+	// waitFor 等待后台索引处理完排队的更新，直到 cond 成立
+	waitFor := func(q string, cond func(searchResp) bool) searchResp {
+		t.Helper()
+		var v searchResp
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if v = search(q); cond(v) {
+				break
+			}
+		}
+		return v
+	}
+
+	if v := search("report"); v.Source != "walk" || len(v.Results) != 1 {
+		t.Errorf("未启用 -index 时为 %+v", v)
+	}
+
+	searchIndex = newNameIndex()
+	v := waitFor("report", func(v searchResp) bool { return v.Source == "index" })
+	if len(v.Results) != 1 || v.Results[0].Path != "docs/Report-2023.txt" {
+		t.Fatalf("索引建立后为 %+v", v)
+	}
+
+	// 新上传的文件无需重建即可通过索引搜到
+	if rec := serveReq(h, uploadRequest(t, "/upload?path=docs", nil, "report-2024.txt", "y")); rec.Code != http.StatusOK {
+		t.Fatalf("上传返回 %d", rec.Code)
+	}
+	v = waitFor("report", func(v searchResp) bool { return len(v.Results) == 2 })
+	if v.Source != "index" || len(v.Results) != 2 || v.Results[1].Path != "docs/report-2024.txt" {
+		t.Errorf("上传后为 %+v", v)
+	}
+
+	// 删除后从索引中移除
+	req := httptest.NewRequest("GET", "/delete?path=docs&file=Report-2023.txt", nil)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	serveReq(h, req)
+	if v = waitFor("2023", func(v searchResp) bool { return len(v.Results) == 0 }); v.Source != "index" || len(v.Results) != 0 {
+		t.Errorf("删除后为 %+v", v)
+	}
+
+	// 重命名与移动同时更新源与目标
+	postForm(h, "/rename", url.Values{"path": {"docs"}, "old": {"report-2024.txt"}, "new": {"summary.txt"}})
+	if v = waitFor("summary", func(v searchResp) bool { return len(v.Results) == 1 }); len(v.Results) != 1 || v.Results[0].Path != "docs/summary.txt" {
+		t.Errorf("重命名后为 %+v", v)
+	}
+	if v = search("report"); len(v.Results) != 0 {
+		t.Errorf("重命名后旧名称仍可搜到: %+v", v)
+	}
+	postForm(h, "/move", url.Values{"path": {"docs"}, "name": {"summary.txt"}, "dest": {""}})
+	if v = waitFor("summary", func(v searchResp) bool { return len(v.Results) == 1 && v.Results[0].Path == "summary.txt" }); v.Source != "index" || len(v.Results) != 1 || v.Results[0].Path != "summary.txt" {
+		t.Errorf("移动后为 %+v", v)
+	}
+
+	// 服务外部的修改由 /events 的轮询同步
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/events?path=docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	writeTestFile(t, dir, "docs/external.txt", "z")
+	if v = waitFor("external", func(v searchResp) bool { return len(v.Results) == 1 }); v.Source != "index" || len(v.Results) != 1 {
+		t.Errorf("外部新增的文件没有进入索引: %+v", v)
+	}
+}
+
+func TestPreviewRange(t *testing.T) {
+	dir := setupTest(t)
+	content := strings.Repeat("0123456789", 100)
+	writeTestFile(t, dir, "media/clip.mp4", content)
+	h := testHandler()
+
+	req := httptest.NewRequest("GET", "/preview?path=media&file=clip.mp4", nil)
+	req.Header.Set("Range", "bytes=100-199")
+	rec := serveReq(h, req)
+	if rec.Code != http.StatusPartialContent || rec.Header().Get("Content-Range") != "bytes 100-199/1000" || rec.Body.String() != content[100:200] {
+		t.Fatalf("Range 预览返回 %d Content-Range=%q", rec.Code, rec.Header().Get("Content-Range"))
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "video/mp4" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "inline") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	if rec.Header().Get("Accept-Ranges") != "bytes" {
+		t.Error("缺少 Accept-Ranges")
+	}
+
+	// 播放器拖动到末尾时常用的后缀范围
+	req = httptest.NewRequest("GET", "/preview?path=media&file=clip.mp4", nil)
+	req.Header.Set("Range", "bytes=-10")
+	if rec := serveReq(h, req); rec.Code != http.StatusPartialContent || rec.Header().Get("Content-Range") != "bytes 990-999/1000" {
+		t.Errorf("后缀范围返回 %d %q", rec.Code, rec.Header().Get("Content-Range"))
+	}
+	req = httptest.NewRequest("GET", "/preview?path=media&file=clip.mp4", nil)
+	req.Header.Set("Range", "bytes=2000-")
+	if rec := serveReq(h, req); rec.Code != http.StatusRequestedRangeNotSatisfiable || rec.Header().Get("Content-Range") != "bytes */1000" {
+		t.Errorf("越界范围返回 %d %q", rec.Code, rec.Header().Get("Content-Range"))
+	}
+}
+
+func TestPathPrefixMount(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "a.txt", "hello")
+	pathPrefix, cookieName, cookiePath = "/files", "files_token", "/files/"
+	addTestUser(t, "alice", roleViewer, "")
+	h := testHandler()
+
+	if rec := serve(h, "GET", "/files?path=x", nil); rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/files/?path=x" {
+		t.Errorf("/files 返回 %d Location=%q", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := serve(h, "GET", "/download?file=a.txt", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("前缀之外的路径返回 %d", rec.Code)
+	}
+	if rec := serve(h, "GET", "/files/", nil); rec.Code != http.StatusFound || !strings.HasPrefix(rec.Header().Get("Location"), "/files/login") {
+		t.Errorf("未登录访问首页返回 %d Location=%q", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := serve(h, "GET", "/files/static/app.css", nil); rec.Code != http.StatusOK {
+		t.Errorf("静态资源返回 %d", rec.Code)
+	}
+
+	body, _ := json.Marshal(map[string]string{"username": "alice", "password": "pw"})
+	rec := serve(h, "POST", "/files/api/login", bytes.NewReader(body))
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "files_token" {
+			cookie = c
+		}
+	}
+	if rec.Code != http.StatusOK || cookie == nil || cookie.Path != "/files/" {
+		t.Fatalf("登录返回 %d，cookie 为 %v", rec.Code, rec.Result().Cookies())
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.AddCookie(cookie)
+		return serveReq(h, req)
+	}
+	rec = get("/files/")
+	page := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(page, `var basePath = "/files"`) || !strings.Contains(page, `"/files/static/app.js?v=`) {
+		t.Errorf("首页返回 %d，未注入路径前缀", rec.Code)
+	}
+	if rec := get("/files/download?file=a.txt"); rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+		t.Errorf("下载返回 %d", rec.Code)
+	}
+	if rec := get("/files/api/list"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "a.txt") {
+		t.Errorf("/files/api/list 返回 %d", rec.Code)
+	}
+}
+
+func TestMoveToAncestor(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "a/b/c/file.txt", "x")
+	writeTestFile(t, dir, "a/b/c/d/inner.txt", "y")
+	writeTestFile(t, dir, "a/file.txt", "taken")
+	h := testHandler()
+
+	// 移动到上一级
+	if rec := postForm(h, "/move", url.Values{"path": {"a/b/c"}, "name": {"file.txt"}, "dest": {"a/b"}}); rec.Code != http.StatusOK {
+		t.Errorf("移动到上一级返回 %d %s", rec.Code, rec.Body)
+	}
+	// 将目录拖到面包屑中的根目录
+	if rec := postForm(h, "/move", url.Values{"path": {"a/b/c"}, "name": {"d"}, "dest": {""}}); rec.Code != http.StatusOK {
+		t.Errorf("移动到根目录返回 %d %s", rec.Code, rec.Body)
+	}
+	for _, p := range []string{"a/b/file.txt", "d/inner.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, p)); err != nil {
+			t.Errorf("缺少 %s", p)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "a", "b", "c")); len(entries) != 0 {
+		t.Errorf("源目录仍有 %d 项", len(entries))
+	}
+
+	// 祖先目录中已有同名文件时返回冲突，不覆盖
+	if rec := postForm(h, "/move", url.Values{"path": {"a/b"}, "name": {"file.txt"}, "dest": {"a"}}); rec.Code != http.StatusConflict {
+		t.Errorf("同名冲突返回 %d", rec.Code)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a", "file.txt")); string(b) != "taken" {
+		t.Error("祖先目录中的同名文件被覆盖")
+	}
+	// 目录不能移动到其自身之下，但可以移动到祖先目录
+	if rec := postForm(h, "/move", url.Values{"path": {"a"}, "name": {"b"}, "dest": {"a/b/c"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("移动到自身子目录返回 %d", rec.Code)
+	}
+	if rec := postForm(h, "/move", url.Values{"path": {"a/b"}, "name": {"c"}, "dest": {""}}); rec.Code != http.StatusOK {
+		t.Errorf("目录移动到根目录返回 %d %s", rec.Code, rec.Body)
+	}
+	// 祖先之外的路径被拒绝
+	if rec := postForm(h, "/move", url.Values{"path": {"a"}, "name": {"file.txt"}, "dest": {".."}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("移动到根目录之外返回 %d", rec.Code)
+	}
+}
+
+func TestUploadTempDir(t *testing.T) {
+	dir := setupTest(t)
+	tmp := filepath.Join(t.TempDir(), "uploads", "tmp")
+	if err := checkTempDir(tmp); err != nil {
+		t.Fatalf("checkTempDir: %v", err)
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("检查后遗留了 %d 个文件", len(entries))
+	}
+	// 与 main 相同，通过 TMPDIR 让 multipart 解析把超出阈值的部分写到该目录
+	t.Setenv("TMPDIR", tmp)
+	uploadTempDir, multipartMemory = tmp, 1024
+	h := testHandler()
+
+	content := strings.Repeat("large-upload ", 5000)
+	if rec := serveReq(h, uploadRequest(t, "/upload", nil, "big.txt", content)); rec.Code != http.StatusOK {
+		t.Fatalf("上传返回 %d %s", rec.Code, rec.Body)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "big.txt")); string(b) != content {
+		t.Errorf("保存的内容长度为 %d，应为 %d", len(b), len(content))
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("上传结束后临时目录中仍有 %d 个文件", len(entries))
+	}
+
+	// 临时目录不可用时，超过阈值的上传失败而阈值以内的不受影响，说明确实使用了该目录
+	os.RemoveAll(tmp)
+	if rec := serveReq(h, uploadRequest(t, "/upload", nil, "big2.txt", content)); rec.Code == http.StatusOK {
+		t.Error("临时目录不存在时大文件上传仍然成功")
+	}
+	if rec := serveReq(h, uploadRequest(t, "/upload", nil, "small.txt", "tiny")); rec.Code != http.StatusOK {
+		t.Errorf("阈值以内的上传返回 %d", rec.Code)
+	}
+}
+
+func TestChecksumStatus(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "a.txt", "alpha")
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	h := testHandler()
+
+	if rec := serve(h, "GET", "/checksum-status", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("未启用时返回 %d", rec.Code)
+	}
+
+	checksumAlgo = "sha256"
+	checksums = newChecksumCache(2)
+	type status struct {
+		Checksums map[string]string
+		Pending   []string
+	}
+	poll := func(name, want string) status {
+		t.Helper()
+		var v status
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			v = status{}
+			json.Unmarshal(serve(h, "GET", "/checksum-status", nil).Body.Bytes(), &v)
+			if v.Checksums[name] == want {
+				break
+			}
+		}
+		return v
+	}
+
+	var first status
+	json.Unmarshal(serve(h, "GET", "/checksum-status", nil).Body.Bytes(), &first)
+	if first.Checksums["a.txt"] == "" && (len(first.Pending) != 1 || first.Pending[0] != "a.txt") {
+		t.Errorf("首次查询时为 %+v（目录不应出现）", first)
+	}
+	want := fmt.Sprintf("%x", sha256.Sum256([]byte("alpha")))
+	if v := poll("a.txt", want); v.Checksums["a.txt"] != want || len(v.Pending) != 0 {
+		t.Fatalf("计算完成后为 %+v", v)
+	}
+
+	// 内容变化后缓存失效并重新计算
+	p := filepath.Join(dir, "a.txt")
+	os.WriteFile(p, []byte("changed"), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(p, later, later)
+	want = fmt.Sprintf("%x", sha256.Sum256([]byte("changed")))
+	if v := poll("a.txt", want); v.Checksums["a.txt"] != want {
+		t.Errorf("修改后为 %+v", v)
+	}
+}
+
+func TestAPIPutFile(t *testing.T) {
+	dir := setupTest(t)
+	os.Mkdir(filepath.Join(dir, "docs"), 0755)
+	h := testHandler()
+
+	rec := serve(h, "PUT", "/api/v1/files/docs/a.txt", strings.NewReader("first"))
+	if rec.Code != http.StatusCreated || rec.Header().Get("Location") == "" {
+		t.Fatalf("新建返回 %d Location=%q %s", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+	get := serve(h, "GET", rec.Header().Get("Location"), nil)
+	if get.Code != http.StatusOK || get.Body.String() != "first" {
+		t.Errorf("读回 %s 得到 %d %q", rec.Header().Get("Location"), get.Code, get.Body)
+	}
+	etag := get.Header().Get("ETag")
+
+	if rec := serve(h, "PUT", "/api/v1/files/docs/a.txt", strings.NewReader("second!")); rec.Code != http.StatusNoContent {
+		t.Errorf("覆盖返回 %d", rec.Code)
+	}
+	if body := serve(h, "GET", "/download?file=docs/a.txt", nil).Body.String(); body != "second!" {
+		t.Errorf("覆盖后读回 %q", body)
+	}
+	req := httptest.NewRequest("PUT", "/api/v1/files/docs/a.txt", strings.NewReader("third"))
+	req.Header.Set("If-Match", etag)
+	if rec := serveReq(h, req); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("If-Match 过期时返回 %d", rec.Code)
+	}
+
+	// 上级目录不存在时需显式要求创建
+	if rec := serve(h, "PUT", "/api/v1/files/new/deep/b.txt", strings.NewReader("b")); rec.Code != http.StatusNotFound {
+		t.Errorf("上级目录不存在时返回 %d", rec.Code)
+	}
+	req = httptest.NewRequest("PUT", "/api/v1/files/new/deep/b.txt", strings.NewReader("b"))
+	req.Header.Set("X-Create-Parents", "true")
+	if rec := serveReq(h, req); rec.Code != http.StatusCreated {
+		t.Errorf("X-Create-Parents 时返回 %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(h, "PUT", "/api/v1/files/p/c.txt?parents=true", strings.NewReader("c")); rec.Code != http.StatusCreated {
+		t.Errorf("parents=true 时返回 %d", rec.Code)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "new", "deep", "b.txt")); string(b) != "b" {
+		t.Errorf("读回 %q", b)
+	}
+
+	maxUploadSize = 4
+	if rec := serve(h, "PUT", "/api/v1/files/big.txt", strings.NewReader("too large")); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("超过大小限制时返回 %d", rec.Code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "big.txt")); !os.IsNotExist(err) {
+		t.Error("超限的内容被写入")
+	}
+	if rec := serve(h, "PUT", "/api/v1/files/..%5Cescape.txt", strings.NewReader("x")); rec.Code != http.StatusBadRequest {
+		t.Errorf("越界路径返回 %d", rec.Code)
+	}
+
+	// 跨域前端可以通过预检后发起 PUT
+	corsOrigins = stringList{"https://app.example.com"}
+	req = httptest.NewRequest("OPTIONS", "/api/v1/files/docs/a.txt", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	rec = serveReq(h, req)
+	if !strings.Contains(rec.Header().Get("Access-Control-Allow-Methods"), "PUT") || !strings.Contains(rec.Header().Get("Access-Control-Allow-Headers"), "X-Create-Parents") {
+		t.Errorf("预检未允许 PUT: %v", rec.Header())
+	}
+}
+
+func TestAPIDeleteFile(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "docs/a.txt", "a")
+	writeTestFile(t, dir, "tree/sub/b.txt", "b")
+	os.WriteFile(filepath.Join(filepath.Dir(dir), "outside.txt"), []byte("x"), 0644)
+	h := testHandler()
+
+	for _, target := range []string{"/api/v1/files/docs/a.txt", "/api/v1/files/tree"} {
+		if rec := serve(h, "DELETE", target, nil); rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+			t.Errorf("DELETE %s 返回 %d %s", target, rec.Code, rec.Body)
+		}
+	}
+	for _, p := range []string{"docs/a.txt", "tree"} {
+		if _, err := os.Lstat(filepath.Join(dir, p)); !os.IsNotExist(err) {
+			t.Errorf("%s 未删除", p)
+		}
+	}
+
+	rec := serve(h, "DELETE", "/api/v1/files/docs/a.txt", nil)
+	if code, _ := apiErrorBody(t, rec); rec.Code != http.StatusNotFound || code != "not_found" {
+		t.Errorf("再次删除返回 %d %s", rec.Code, code)
+	}
+
+	for _, target := range []string{
+		"/api/v1/files/..%5Coutside.txt",
+		"/api/v1/files/docs%5C..%5C..%5Coutside.txt",
+		"/api/v1/files/",
+		"/api/v1/files/.%5C",
+	} {
+		rec := serve(h, "DELETE", target, nil)
+		if code, _ := apiErrorBody(t, rec); rec.Code != http.StatusBadRequest || code != "invalid_path" {
+			t.Errorf("DELETE %s 返回 %d %s", target, rec.Code, code)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "outside.txt")); err != nil {
+		t.Error("根目录之外的文件被删除")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "docs")); err != nil {
+		t.Error("根目录内容被删除")
+	}
+
+	// 删除需要 admin 角色
+	writeTestFile(t, dir, "docs/keep.txt", "k")
+	addTestUser(t, "ed", roleEditor, "")
+	token := login(t, h, "ed")
+	if rec := serveReq(h, authed(token, "DELETE", "/api/v1/files/docs/keep.txt", nil)); rec.Code != http.StatusForbidden {
+		t.Errorf("editor 删除返回 %d", rec.Code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "docs", "keep.txt")); err != nil {
+		t.Error("editor 删除了文件")
+	}
+
+	// 跨域前端可以通过预检后发起 DELETE
+	corsOrigins = stringList{"https://app.example.com"}
+	req := httptest.NewRequest("OPTIONS", "/api/v1/files/docs/keep.txt", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "DELETE")
+	if rec := serveReq(h, req); !strings.Contains(rec.Header().Get("Access-Control-Allow-Methods"), "DELETE") {
+		t.Errorf("预检未允许 DELETE: %v", rec.Header())
+	}
+}
+
+func TestCopyPreservesAttrs(t *testing.T) {
+	dir := setupTest(t)
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	older := time.Date(2019, 6, 7, 8, 9, 10, 0, time.UTC)
+	f := writeTestFile(t, dir, "src/data.txt", "data")
+	nested := writeTestFile(t, dir, "src/sub/nested.sh", "#!/bin/sh")
+	os.Chmod(f, 0640)
+	os.Chmod(nested, 0750)
+	os.Chtimes(f, old, old)
+	os.Chtimes(nested, old, old)
+	os.Chmod(filepath.Join(dir, "src", "sub"), 0710)
+	os.Chtimes(filepath.Join(dir, "src", "sub"), older, older)
+	os.Chtimes(filepath.Join(dir, "src"), older, older)
+	os.Mkdir(filepath.Join(dir, "dst"), 0755)
+	h := testHandler()
+
+	check := func(p string, mode os.FileMode, mtime time.Time) {
+		t.Helper()
+		info, err := os.Stat(filepath.Join(dir, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != mode || !info.ModTime().Equal(mtime) {
+			t.Errorf("%s: 权限 %v 修改时间 %v，期望 %v %v", p, info.Mode().Perm(), info.ModTime().UTC(), mode, mtime)
+		}
+	}
+
+	if rec := postForm(h, "/copy", url.Values{"path": {""}, "name": {"src"}, "dest": {"dst"}}); rec.Code != http.StatusOK {
+		t.Fatalf("复制返回 %d %s", rec.Code, rec.Body)
+	}
+	check("dst/src/data.txt", 0640, old)
+	check("dst/src/sub/nested.sh", 0750, old)
+	check("dst/src/sub", 0710, older)
+	check("dst/src", 0755, older)
+
+	// 关闭 -copy-preserve 后得到新的修改时间
+	copyPreserve = false
+	if rec := postForm(h, "/copy", url.Values{"path": {"src"}, "name": {"data.txt"}, "dest": {"dst"}}); rec.Code != http.StatusOK {
+		t.Fatalf("复制返回 %d %s", rec.Code, rec.Body)
+	}
+	if info, _ := os.Stat(filepath.Join(dir, "dst", "data.txt")); info == nil || info.ModTime().Equal(old) {
+		t.Error("-copy-preserve=false 时仍保留了修改时间")
+	}
+}
+
+func TestMoveAcrossDevices(t *testing.T) {
+	dir := setupTest(t)
+	mtime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	f := writeTestFile(t, dir, "src/sub/a.txt", "a")
+	os.Chmod(f, 0600)
+	os.Chtimes(f, mtime, mtime)
+	os.Symlink("sub/a.txt", filepath.Join(dir, "src", "link"))
+	os.Mkdir(filepath.Join(dir, "dst"), 0755)
+
+	if err := moveAcrossDevices(filepath.Join(dir, "src"), filepath.Join(dir, "dst", "moved")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "src")); !os.IsNotExist(err) {
+		t.Error("源未删除")
+	}
+	info, err := os.Stat(filepath.Join(dir, "dst", "moved", "sub", "a.txt"))
+	if err != nil || info.Mode().Perm() != 0600 || !info.ModTime().Equal(mtime) {
+		t.Errorf("移动后的文件: %v %v", info, err)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "dst", "moved", "link")); err != nil || target != "sub/a.txt" {
+		t.Errorf("符号链接变为 %q %v", target, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "dst")); len(entries) != 1 {
+		t.Errorf("目标目录中遗留了临时文件: %v", entries)
+	}
+
+	// 复制失败时源保持不变，也不留下临时文件
+	g := writeTestFile(t, dir, "keep.txt", "k")
+	if err := moveAcrossDevices(g, filepath.Join(dir, "missing", "keep.txt")); err == nil {
+		t.Error("目标目录不存在时未返回错误")
+	}
+	if _, err := os.Stat(g); err != nil {
+		t.Error("失败后源被删除")
+	}
+}
+
+func TestRenamePathEXDEV(t *testing.T) {
+	setupTest(t)
+	// 需要两个不同的文件系统，常见环境中 /dev/shm 为单独挂载的 tmpfs
+	other, err := os.MkdirTemp("/dev/shm", "hfs-test-")
+	if err != nil {
+		t.Skip("没有可用的第二个文件系统")
+	}
+	defer os.RemoveAll(other)
+	src := writeTestFile(t, t.TempDir(), "x.txt", "cross")
+	dst := filepath.Join(other, "x.txt")
+	if err := os.Rename(src, dst); !errors.Is(err, syscall.EXDEV) {
+		os.Remove(dst)
+		t.Skipf("临时目录与 /dev/shm 位于同一文件系统: %v", err)
+	}
+	if err := renamePath(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(dst); string(b) != "cross" {
+		t.Errorf("跨设备移动后的内容为 %q", b)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Error("跨设备移动后源仍存在")
+	}
+}
+
+func TestStalledUploadAborted(t *testing.T) {
+	dir := setupTest(t)
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	uploadTempDir, multipartMemory = tmp, 16
+	uploadIdle = 200 * time.Millisecond
+	srv := httptest.NewServer(testHandler())
+	defer srv.Close()
+
+	// stall 发送请求头与部分请求体后停止发送，返回服务器的响应状态行
+	stall := func(head, partial string) string {
+		t.Helper()
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		io.WriteString(conn, head+partial)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			t.Fatalf("未收到响应: %v", err)
+		}
+		return strings.TrimSpace(line)
+	}
+
+	start := time.Now()
+	line := stall("PUT /api/v1/files/stall.bin HTTP/1.1\r\nHost: x\r\nContent-Length: 1000\r\n\r\n", strings.Repeat("p", 100))
+	if !strings.HasPrefix(line, "HTTP/1.1 408") {
+		t.Errorf("PUT 停滞后返回 %q", line)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("%v 后即被中断，早于 -upload-idle-timeout", elapsed)
+	}
+
+	body := "--b\r\nContent-Disposition: form-data; name=\"file\"; filename=\"stall.txt\"\r\nContent-Type: text/plain\r\n\r\n" + strings.Repeat("m", 100)
+	line = stall("POST /upload HTTP/1.1\r\nHost: x\r\nContent-Type: multipart/form-data; boundary=b\r\nContent-Length: 100000\r\n\r\n", body)
+	if !strings.HasPrefix(line, "HTTP/1.1 408") {
+		t.Errorf("表单上传停滞后返回 %q", line)
+	}
+
+	line = stall("POST /upload-chunk?uploadId=stall&path=&name=c.bin&total=1000&offset=0 HTTP/1.1\r\nHost: x\r\nContent-Length: 1000\r\n\r\n", strings.Repeat("c", 100))
+	if !strings.HasPrefix(line, "HTTP/1.1 408") {
+		t.Errorf("分片上传停滞后返回 %q", line)
+	}
+	// 中断的分片被丢弃，客户端可从原 offset 重试
+	uploadsMu.Lock()
+	u := uploads["stall"]
+	uploadsMu.Unlock()
+	u.mu.Lock()
+	received, tempPath := u.Received, u.TempPath
+	u.mu.Unlock()
+	if info, err := os.Stat(tempPath); received != 0 || err != nil || info.Size() != 0 {
+		t.Errorf("中断后已接收 %d 字节，临时文件 %v %v", received, info, err)
+	}
+	os.Remove(tempPath)
+
+	// 临时文件均已清理，目标文件未出现
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("工作目录中遗留了 %v", entries)
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("临时目录中遗留了 %v", entries)
+	}
+}
+
+func TestPasteMoveCopy(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "a.txt", "a")
+	writeTestFile(t, dir, "folder/inner.txt", "i")
+	os.Mkdir(filepath.Join(dir, "dest"), 0755)
+	h := testHandler()
+
+	// 粘贴到原目录：剪切为无操作，复制自动改名
+	if rec := postForm(h, "/move", url.Values{"path": {""}, "name": {"a.txt"}, "dest": {""}}); rec.Code != http.StatusOK {
+		t.Errorf("剪切后粘贴到原目录返回 %d %s", rec.Code, rec.Body)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(b) != "a" {
+		t.Error("剪切到原目录改变了文件")
+	}
+	if rec := postForm(h, "/copy", url.Values{"path": {""}, "name": {"a.txt"}, "dest": {""}}); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "a (1).txt") {
+		t.Errorf("复制到原目录返回 %d %s", rec.Code, rec.Body)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a (1).txt")); string(b) != "a" {
+		t.Errorf("副本内容为 %q", b)
+	}
+
+	// 粘贴到其他目录
+	if rec := postForm(h, "/copy", url.Values{"path": {""}, "name": {"folder"}, "dest": {"dest"}}); rec.Code != http.StatusOK {
+		t.Errorf("复制文件夹返回 %d %s", rec.Code, rec.Body)
+	}
+	if rec := postForm(h, "/move", url.Values{"path": {""}, "name": {"a.txt"}, "dest": {"dest"}}); rec.Code != http.StatusOK {
+		t.Errorf("移动返回 %d %s", rec.Code, rec.Body)
+	}
+	for _, p := range []string{"folder/inner.txt", "dest/folder/inner.txt", "dest/a.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, p)); err != nil {
+			t.Errorf("缺少 %s", p)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.txt")); !os.IsNotExist(err) {
+		t.Error("移动后源文件仍存在")
+	}
+
+	// 冲突与非法目标
+	writeTestFile(t, dir, "a.txt", "new")
+	if rec := postForm(h, "/move", url.Values{"path": {""}, "name": {"a.txt"}, "dest": {"dest"}}); rec.Code != http.StatusConflict {
+		t.Errorf("目标已存在时移动返回 %d", rec.Code)
+	}
+	if rec := postForm(h, "/copy", url.Values{"path": {""}, "name": {"folder"}, "dest": {"folder"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("复制到自身返回 %d", rec.Code)
+	}
+	if rec := postForm(h, "/move", url.Values{"path": {""}, "name": {"missing.txt"}, "dest": {"dest"}}); rec.Code != http.StatusNotFound {
+		t.Errorf("源不存在时返回 %d", rec.Code)
+	}
+	if rec := postForm(h, "/copy", url.Values{"path": {""}, "name": {"a.txt"}, "dest": {"nowhere"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("目标目录不存在时返回 %d", rec.Code)
+	}
+}
+
+func TestCopySymlinkLoop(t *testing.T) {
+	dir := setupTest(t)
+	writeTestFile(t, dir, "a/f.txt", "f")
+	os.Symlink(".", filepath.Join(dir, "a", "self"))
+	os.Symlink("..", filepath.Join(dir, "a", "up"))
+	os.Mkdir(filepath.Join(dir, "dst"), 0755)
+	followSymlinks = true
+	h := testHandler()
+
+	if rec := postForm(h, "/copy", url.Values{"path": {""}, "name": {"a"}, "dest": {"dst"}}); rec.Code != http.StatusOK {
+		t.Fatalf("复制返回 %d %s", rec.Code, rec.Body)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "dst", "a", "f.txt")); string(b) != "f" {
+		t.Errorf("f.txt 内容为 %q", b)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "dst", "a", "self")); !os.IsNotExist(err) {
+		t.Error("指向自身的链接被展开复制")
+	}
+	// up 指向根目录：其中的 a（正在复制的源）与 dst/a（正在创建的目标）都被跳过，只复制一层
+	var files []string
+	filepath.WalkDir(filepath.Join(dir, "dst"), func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(dir, p)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if strings.Join(files, " ") != "dst/a/f.txt" {
+		t.Errorf("复制结果为 %v", files)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dst", "a", "up", "dst", "a")); !os.IsNotExist(err) {
+		t.Error("复制进入了正在创建的目标目录")
+	}
+}
@@ -0,0 +1,1102 @@
+function sub(a, b) { return a - b; }
+
+// tr 按当前语言翻译界面文字，缺少译文时原样返回
+function tr(s) {
+  return i18n[s] || s;
+}
+
+// saveDirSort 将当前排序保存为该目录的默认排序（写入目录中的 .hfs-sort）
+function saveDirSort() {
+  var xhr = new XMLHttpRequest();
+  xhr.open('POST', basePath + '/dir-sort', true);
+  xhr.setRequestHeader('Content-Type', 'application/x-www-form-urlencoded');
+  xhr.onload = function () {
+    alert(xhr.status === 200 ? xhr.responseText : tr('保存排序失败: ') + xhr.responseText);
+  };
+  xhr.send('path=' + encodeURIComponent(currentPath) + '&sort=' + encodeURIComponent(currentSort) + '&order=' + encodeURIComponent(currentOrder));
+}
+
+// changeCategory 切换分类筛选，由服务端过滤列表
+function changeCategory(category) {
+  currentCategory = category;
+  var url = new URL(window.location.href);
+  if (category) {
+    url.searchParams.set('category', category);
+  } else {
+    url.searchParams.delete('category');
+  }
+  history.replaceState(null, '', url);
+  refreshFileList();
+}
+
+function uploadFile() {
+  var fileInput = document.getElementById('fileInput');
+  var files = fileInput.files;
+  if (files.length === 0) {
+    alert(tr('请选择至少一个文件'));
+    return;
+  }
+  var formData = new FormData();
+  for (var i = 0; i < files.length; i++) {
+    formData.append('files[]', files[i]);
+    // 与 files[] 一一对应，用于在服务端保留原始修改时间
+    formData.append('lastModified[]', files[i].lastModified);
+  }
+  var xhr = new XMLHttpRequest();
+  xhr.open('POST', basePath + '/upload?path=' + encodeURIComponent(currentPath), true);
+  var progressBar = document.getElementById('progressBar');
+  var progressContainer = document.getElementById('progressContainer');
+  progressBar.style.width = '0';
+  progressBar.innerText = '0%';
+  progressContainer.style.display = 'block';
+  xhr.upload.onprogress = function (event) {
+    if (event.lengthComputable) {
+      var percentComplete = Math.round((event.loaded / event.total) * 100);
+      progressBar.style.width = percentComplete + '%';
+      progressBar.innerText = percentComplete + '%';
+    }
+  };
+  xhr.onload = function () {
+    progressContainer.style.display = 'none';
+    if (xhr.status === 200) {
+      var message = tr('文件上传成功');
+      try {
+        // 启用去重时服务端返回 JSON，列出与已有文件内容相同的上传
+        var result = JSON.parse(xhr.responseText);
+        var dups = (result.files || []).filter(function(f) { return f.duplicate; });
+        if (dups.length > 0) {
+          message += tr('\n\n以下文件与已有文件内容相同') + (dups[0].linked ? tr('（已使用硬链接节省空间）') : '') + tr('：\n') +
+            dups.map(function(f) { return f.name + (f.duplicate_of ? ' = ' + f.duplicate_of : ''); }).join('\n');
+        }
+      } catch (e) {}
+      alert(message);
+      refreshFileList();
+    } else {
+      alert(tr('文件上传失败'));
+    }
+  };
+  xhr.send(formData);
+}
+
+// copyCurrentPath 复制当前目录路径：提供了绝对路径时复制绝对路径，否则复制以 / 开头的相对路径
+function copyCurrentPath() {
+  var text = absPath || ('/' + currentPath.replace(/^\/+/, ''));
+  var done = function() { alert(tr('已复制: ') + text); };
+  if (navigator.clipboard && window.isSecureContext) {
+    navigator.clipboard.writeText(text).then(done, function() { fallbackCopy(text, done); });
+  } else {
+    fallbackCopy(text, done);
+  }
+}
+
+// fallbackCopy 在不支持 Clipboard API 的环境（如非 HTTPS）下借助临时文本框复制
+function fallbackCopy(text, done) {
+  var input = document.createElement('textarea');
+  input.value = text;
+  input.style.position = 'fixed';
+  input.style.opacity = '0';
+  document.body.appendChild(input);
+  input.select();
+  try {
+    document.execCommand('copy');
+    done();
+  } catch (e) {
+    prompt(tr('请手动复制路径:'), text);
+  }
+  document.body.removeChild(input);
+}
+
+// toggleRecent 在当前目录列表与整个子树中最近修改的文件之间切换
+var recentMode = false;
+function toggleRecent() {
+  var button = document.getElementById('recentToggle');
+  if (recentMode) {
+    recentMode = false;
+    button.innerText = tr('最近修改');
+    refreshFileList();
+    return;
+  }
+  var xhr = new XMLHttpRequest();
+  xhr.open('GET', basePath + '/recent?limit=50&path=' + encodeURIComponent(currentPath), true);
+  xhr.onload = function () {
+    if (xhr.status !== 200) {
+      alert(tr('获取最近修改的文件失败: ') + xhr.responseText);
+      return;
+    }
+    var result = JSON.parse(xhr.responseText);
+    recentMode = true;
+    button.innerText = tr('返回目录');
+    renderRecent(result.files, result.truncated);
+  };
+  xhr.send();
+}
+
+// renderRecent 以表格显示最近修改的文件，点击文件名下载
+function renderRecent(files, truncated) {
+  var container = document.getElementById('fileListContainer');
+  container.innerHTML = '';
+  var summary = document.createElement('div');
+  summary.className = 'dir-summary';
+  summary.innerText = tr('最近修改的 ') + files.length + tr(' 个文件') + (truncated ? tr('（目录过大，仅扫描了部分文件）') : '');
+  container.appendChild(summary);
+  var table = document.createElement('table');
+  var head = table.createTHead().insertRow();
+  [tr('名称'), tr('最后修改'), tr('大小'), tr('所在目录')].forEach(function(title) {
+    var th = document.createElement('th');
+    th.innerText = title;
+    head.appendChild(th);
+  });
+  var body = table.createTBody();
+  files.forEach(function(f) {
+    var dir = f.path.lastIndexOf('/') >= 0 ? f.path.substring(0, f.path.lastIndexOf('/')) : '';
+    var row = body.insertRow();
+    var nameCell = row.insertCell();
+    nameCell.className = 'file-name';
+    nameCell.innerText = f.name;
+    nameCell.title = f.path;
+    nameCell.onclick = function() { downloadFile(f.name, dir, null); };
+    row.insertCell().innerText = new Date(f.mod_time).toLocaleString();
+    row.insertCell().innerText = f.size;
+    var dirCell = row.insertCell();
+    dirCell.className = 'recent-path';
+    dirCell.innerText = '/' + dir;
+  });
+  container.appendChild(table);
+}
+
+function refreshFileList() {
+  if (recentMode) {
+    recentMode = false;
+    document.getElementById('recentToggle').innerText = tr('最近修改');
+  }
+  var yOffset = window.pageYOffset;
+  var xhr = new XMLHttpRequest();
+  xhr.open('GET', basePath + '/list?path=' + encodeURIComponent(currentPath) + '&sort=' + encodeURIComponent(currentSort) + '&order=' + encodeURIComponent(currentOrder) + (currentCategory ? '&category=' + encodeURIComponent(currentCategory) : ''), true);
+  xhr.onload = function () {
+    if (xhr.status === 200) {
+      document.getElementById("fileListContainer").innerHTML = xhr.responseText;
+      window.scrollTo(0, yOffset);
+      restoreSelection();
+      pollChecksums();
+    } else {
+      alert(tr('刷新文件列表失败'));
+    }
+  };
+  xhr.send();
+}
+
+// pollChecksums 列表中有“计算中...”的校验和时每 2 秒查询一次 /checksum-status 并填入结果，全部算好后停止
+var checksumTimer = null;
+
+function pollChecksums() {
+  if (checksumTimer || !document.querySelector('#fileListContainer td.checksum[data-pending]')) return;
+  checksumTimer = setTimeout(function () {
+    var xhr = new XMLHttpRequest();
+    xhr.open('GET', basePath + '/checksum-status?path=' + encodeURIComponent(currentPath), true);
+    xhr.onload = function () {
+      checksumTimer = null;
+      if (xhr.status !== 200) return;
+      var sums = JSON.parse(xhr.responseText).checksums;
+      var cells = document.querySelectorAll('#fileListContainer td.checksum[data-pending]');
+      Array.prototype.forEach.call(cells, function (cell) {
+        var sum = sums[cell.parentNode.getAttribute('data-name')];
+        if (sum) {
+          cell.textContent = sum;
+          cell.title = sum;
+          cell.removeAttribute('data-pending');
+        }
+      });
+      pollChecksums();
+    };
+    xhr.onerror = function () {
+      checksumTimer = null;
+    };
+    xhr.send();
+  }, 2000);
+}
+pollChecksums();
+
+function showModal(modalId) {
+  document.getElementById(modalId).style.display = "block";
+}
+
+function closeModal(modalId) {
+  document.getElementById(modalId).style.display = "none";
+}
+
+function submitCreateFile() {
+  var fileName = document.getElementById('modalFileName').value.trim();
+  if (!fileName) {
+    alert(tr('请输入文件名'));
+    return;
+  }
+  var xhr = new XMLHttpRequest();
+  xhr.open('POST', basePath + '/create', true);
+  xhr.setRequestHeader('Content-Type', 'application/x-www-form-urlencoded');
+  xhr.onload = function () {
+    if (xhr.status === 200) {
+      alert(tr('文件创建成功'));
+      closeModal('modalCreateFile');
+      refreshFileList();
+    } else {
+      alert(tr('文件创建失败: ') + xhr.responseText);
+    }
+  };
+  xhr.send('type=file&name=' + encodeURIComponent(fileName) + '&path=' + encodeURIComponent(currentPath));
+}
+
+function submitCreateFolder() {
+  var folderName = document.getElementById('modalFolderName').value.trim();
+  if (!folderName) {
+    alert(tr('请输入文件夹名'));
+    return;
+  }
+  var xhr = new XMLHttpRequest();
+  xhr.open('POST', basePath + '/create', true);
+  xhr.setRequestHeader('Content-Type', 'application/x-www-form-urlencoded');
+  xhr.onload = function () {
+    if (xhr.status === 200) {
+      alert(tr('文件夹创建成功'));
+      closeModal('modalCreateFolder');
+      refreshFileList();
+    } else {
+      alert(tr('文件夹创建失败: ') + xhr.responseText);
+    }
+  };
+  xhr.send('type=folder&name=' + encodeURIComponent(folderName) + '&path=' + encodeURIComponent(currentPath));
+}
+
+function renameFile(oldName) {
+  var newName = prompt(tr("请输入新的名称"), oldName);
+  if (!newName || newName === oldName) return;
+  closeModal('modalFileOptions');
+  submitRename(oldName, newName, false);
+}
+
+// submitRename 提交重命名；目标文件已存在时询问是否覆盖
+function submitRename(oldName, newName, overwrite) {
+  var xhr = new XMLHttpRequest();
+  xhr.open('POST', basePath + '/rename', true);
+  xhr.setRequestHeader('Content-Type', 'application/x-www-form-urlencoded');
+  xhr.onload = function () {
+    if (xhr.status === 200) {
+      alert(tr('重命名成功'));
+      refreshFileList();
+    } else if (xhr.status === 409 && !overwrite && xhr.responseText.trim() === tr('已存在同名文件')) {
+      if (confirm(newName + tr(' 已存在，是否覆盖？'))) {
+        submitRename(oldName, newName, true);
+      }
+    } else if (!fileGone(xhr.status)) {
+      alert(tr('重命名失败: ') + xhr.responseText);
+    }
+  };
+  xhr.send('old=' + encodeURIComponent(oldName) + '&new=' + encodeURIComponent(newName) + '&path=' + encodeURIComponent(currentPath) + (overwrite ? '&overwrite=true' : ''));
+}
+
+// fileGone 目标已被删除（404）时提示并刷新列表，返回是否已处理
+function fileGone(status) {
+  if (status !== 404) return false;
+  alert(tr('文件已不存在，列表已刷新'));
+  refreshFileList();
+  return true;
+}
+
+function downloadFile(fileName, path, element) {
+  closeModal('modalFileOptions');
+  var url = basePath + '/download?file=' + encodeURIComponent(fileName) + '&path=' + encodeURIComponent(path);
+  function start() {
+    if (document.getElementById('accelEnabled').checked && window.Blob) {
+      acceleratedDownload(url, fileName);
+      return;
+    }
+    saveURL(url, fileName);
+  }
+  if (!window.fetch) {
+    saveURL(url, fileName);
+    return;
+  }
+  // 先用 HEAD 确认文件仍然存在，避免跳转到错误页面
+  fetch(url, { method: 'HEAD' }).then(function (resp) {
+    if (resp.ok) {
+      start();
+    } else if (!fileGone(resp.status)) {
+      alert(resp.status === 403 ? tr('没有权限访问该文件') : tr('下载失败'));
+    }
+  }, start);
+}
+
+function saveURL(url, fileName) {
+  var link = document.createElement('a');
+  link.href = url;
+  link.download = fileName;
+  document.body.appendChild(link);
+  link.click();
+  document.body.removeChild(link);
+}
+
+// 多线程下载：每段 8MB，使用多个 Range 请求并行获取后在浏览器内拼接。
+// 整个文件会暂存在内存中，因此超过 accelMaxSize 的文件仍使用普通下载
+var accelSegmentSize = 8 * 1024 * 1024;
+var accelMaxSize = 2 * 1024 * 1024 * 1024;
+
+function loadAccelOptions() {
+  document.getElementById('accelEnabled').checked = localStorage.getItem('accelEnabled') === '1';
+  document.getElementById('accelConcurrency').value = localStorage.getItem('accelConcurrency') || '4';
+}
+
+function saveAccelOptions() {
+  localStorage.setItem('accelEnabled', document.getElementById('accelEnabled').checked ? '1' : '0');
+  localStorage.setItem('accelConcurrency', document.getElementById('accelConcurrency').value);
+}
+
+function fetchSegment(url, start, end) {
+  return fetch(url, { headers: { 'Range': 'bytes=' + start + '-' + end }, credentials: 'same-origin' }).then(function (resp) {
+    return resp.blob().then(function (blob) {
+      return { status: resp.status, contentRange: resp.headers.get('Content-Range'), blob: blob };
+    });
+  });
+}
+
+function acceleratedDownload(url, fileName) {
+  var concurrency = parseInt(document.getElementById('accelConcurrency').value, 10) || 4;
+  var progressBar = document.getElementById('progressBar');
+  var progressContainer = document.getElementById('progressContainer');
+  progressBar.style.width = '0';
+  progressBar.innerText = '0%';
+  progressContainer.style.display = 'block';
+
+  // 首段请求用于探测文件大小与服务器是否支持 Range
+  fetchSegment(url, 0, accelSegmentSize - 1).then(function (first) {
+    var match = first.contentRange && /^bytes 0-(\d+)\/(\d+)$/.exec(first.contentRange);
+    if (first.status !== 206 || !match) {
+      // 服务器返回完整内容（不支持 Range），直接保存
+      progressContainer.style.display = 'none';
+      if (first.status === 200) {
+        saveBlob(first.blob, fileName);
+      } else {
+        alert(tr('下载失败'));
+      }
+      return;
+    }
+    var total = parseInt(match[2], 10);
+    if (total > accelMaxSize) {
+      progressContainer.style.display = 'none';
+      saveURL(url, fileName);
+      return;
+    }
+    var count = Math.ceil(total / accelSegmentSize);
+    var parts = new Array(count);
+    parts[0] = first.blob;
+    var done = 1;
+    var next = 1;
+    var failed = false;
+
+    function updateProgress() {
+      var percent = Math.round(done / count * 100);
+      progressBar.style.width = percent + '%';
+      progressBar.innerText = percent + '%';
+    }
+    updateProgress();
+
+    function worker() {
+      if (failed || next >= count) return Promise.resolve();
+      var index = next++;
+      var start = index * accelSegmentSize;
+      var end = Math.min(start + accelSegmentSize, total) - 1;
+      return fetchSegment(url, start, end).then(function (seg) {
+        if (seg.status !== 206 || seg.contentRange !== 'bytes ' + start + '-' + end + '/' + total) {
+          throw new Error(tr('分段响应不正确'));
+        }
+        parts[index] = seg.blob;
+        done++;
+        updateProgress();
+        return worker();
+      });
+    }
+
+    var workers = [];
+    for (var i = 0; i < Math.min(concurrency, count - 1); i++) {
+      workers.push(worker());
+    }
+    return Promise.all(workers).then(function () {
+      progressContainer.style.display = 'none';
+      saveBlob(new Blob(parts), fileName);
+    });
+  }).catch(function () {
+    progressContainer.style.display = 'none';
+    // 多线程下载失败时退回普通下载
+    saveURL(url, fileName);
+  });
+}
+
+function saveBlob(blob, fileName) {
+  var objectURL = URL.createObjectURL(blob);
+  saveURL(objectURL, fileName);
+  setTimeout(function () { URL.revokeObjectURL(objectURL); }, 10000);
+}
+
+function deleteFile(fileName, path, element) {
+  if (!confirm(tr("确定要删除 ") + fileName + tr(" 吗？"))) return;
+  closeModal('modalFileOptions');
+  var xhr = new XMLHttpRequest();
+  xhr.open('GET', basePath + '/delete?file=' + encodeURIComponent(fileName) + '&path=' + encodeURIComponent(path), true);
+  xhr.setRequestHeader('X-Requested-With', 'XMLHttpRequest');
+  xhr.onload = function () {
+    if (xhr.status === 200) {
+      alert(tr('删除成功'));
+      refreshFileList();
+    } else if (!fileGone(xhr.status)) {
+      alert(tr('删除失败: ') + xhr.responseText);
+    }
+  };
+  xhr.send();
+}
+
+// 保留showFileOptions函数以防某些地方还在使用，但现在主要使用双击和右键菜单
+function showFileOptions(fileName, isDir) {
+  // 直接执行默认操作：目录进入，文件下载
+  if (isDir) {
+    enterDirectory(fileName);
+  } else {
+    downloadFile(fileName, currentPath, null);
+  }
+}
+
+function enterDirectory(fileName) {
+  closeModal('modalFileOptions');
+  var newPath = currentPath ? currentPath + '/' + fileName : fileName;
+  window.location.href = basePath + '/?' + browseParam + 'path=' + encodeURIComponent(newPath) + '&sort=' + encodeURIComponent(currentSort) + '&order=' + encodeURIComponent(currentOrder) + (currentCategory ? '&category=' + encodeURIComponent(currentCategory) : '');
+}
+
+var contextFileName = "";
+var contextIsDir = false;
+var touchTimer = null;
+var touchStartTime = 0;
+
+// 点击其他地方隐藏右键菜单
+document.addEventListener('click', function() {
+  var contextMenu = document.getElementById('contextMenu');
+  if (contextMenu) {
+    contextMenu.style.display = 'none';
+  }
+});
+
+// 阻止默认右键菜单，允许自定义右键菜单
+document.addEventListener('contextmenu', function(e) {
+  // 如果是在文件名上右键，阻止默认菜单以显示自定义菜单
+  if (e.target.closest('.file-name')) {
+    e.preventDefault();
+  }
+  // 其他地方也阻止默认右键菜单，保持界面一致性
+  else {
+    e.preventDefault();
+  }
+});
+
+// 移动端长按支持
+function handleTouchStart(event, fileName, isDir) {
+  touchStartTime = Date.now();
+  touchTimer = setTimeout(function() {
+    // 长按500ms后显示菜单
+    event.preventDefault();
+    showContextMenu(event, fileName, isDir);
+  }, 500);
+}
+
+function handleTouchEnd(event) {
+  if (touchTimer) {
+    clearTimeout(touchTimer);
+    touchTimer = null;
+  }
+  // 如果是短按（小于500ms），不阻止默认的click事件
+  if (Date.now() - touchStartTime < 500) {
+    // 短按，让click事件正常执行
+    return;
+  } else {
+    // 长按，阻止click事件
+    event.preventDefault();
+  }
+}
+
+function showContextMenu(event, fileName, isDir) {
+  event.preventDefault();
+  contextFileName = fileName;
+  contextIsDir = isDir;
+  
+  // 创建右键菜单（如果不存在）
+  var contextMenu = document.getElementById('contextMenu');
+  if (!contextMenu) {
+    contextMenu = document.createElement('div');
+    contextMenu.id = 'contextMenu';
+    contextMenu.style.cssText = 'position: fixed; background: white; border: 1px solid #ccc; border-radius: 4px; padding: 5px 0; box-shadow: 2px 2px 10px rgba(0,0,0,0.3); z-index: 9999; display: none; min-width: 120px;';
+    document.body.appendChild(contextMenu);
+  }
+  
+  // 清空菜单内容
+  contextMenu.innerHTML = '';
+  
+  // 添加菜单项（移除进入和下载选项），按当前角色隐藏无权限的操作
+  if (canEdit) {
+    addMenuItem(contextMenu, tr('重命名'), function() {
+      renameFile(fileName);
+      contextMenu.style.display = 'none';
+    }, '#2196F3'); // 蓝色
+
+    addMenuItem(contextMenu, tr('剪切'), function() {
+      setClipboard('move', fileName);
+      contextMenu.style.display = 'none';
+    });
+
+    addMenuItem(contextMenu, tr('复制'), function() {
+      setClipboard('copy', fileName);
+      contextMenu.style.display = 'none';
+    });
+
+    if (currentPath) {
+      addMenuItem(contextMenu, tr('移动到上级目录'), function() {
+        moveEntry(fileName, parentPath(currentPath));
+        contextMenu.style.display = 'none';
+      });
+    }
+  }
+
+  if (!isDir && isTailable(fileName)) {
+    addMenuItem(contextMenu, tr('实时查看'), function() {
+      openTail(fileName);
+      contextMenu.style.display = 'none';
+    });
+  }
+
+  if (isDir) {
+    addMenuItem(contextMenu, tr('下载为 tar.gz'), function() {
+      window.location.href = basePath + '/download-tar?path=' + encodeURIComponent(currentPath) + '&name=' + encodeURIComponent(fileName);
+      contextMenu.style.display = 'none';
+    });
+  }
+
+  if (canEdit && isDir) {
+    addMenuItem(contextMenu, tr('压缩'), function() {
+      compressFolder(fileName);
+      contextMenu.style.display = 'none';
+    });
+  }
+
+  if (canEdit && !isDir && /\.zip$/i.test(fileName)) {
+    addMenuItem(contextMenu, tr('解压到此处'), function() {
+      extractZip(fileName);
+      contextMenu.style.display = 'none';
+    });
+  }
+
+  addMenuItem(contextMenu, tr('属性'), function() {
+    showProperties(fileName);
+    contextMenu.style.display = 'none';
+  });
+
+  if (canDelete) {
+    addMenuItem(contextMenu, tr('删除'), function() {
+      deleteFile(fileName, currentPath, null);
+      contextMenu.style.display = 'none';
+    }, '#e74c3c'); // 红色
+  }
+  
+  // 显示菜单
+  contextMenu.style.display = 'block';
+  
+  // 获取菜单尺寸
+  var rect = contextMenu.getBoundingClientRect();
+  var menuWidth = rect.width;
+  var menuHeight = rect.height;
+  
+  // 计算最佳位置
+  var x = event.clientX;
+  var y = event.clientY;
+  
+  // 确保菜单不超出屏幕右边
+  if (x + menuWidth > window.innerWidth) {
+    x = window.innerWidth - menuWidth - 10;
+  }
+  
+  // 确保菜单不超出屏幕底部
+  if (y + menuHeight > window.innerHeight) {
+    y = window.innerHeight - menuHeight - 10;
+  }
+  
+  // 确保菜单不超出屏幕左边和顶部
+  if (x < 10) x = 10;
+  if (y < 10) y = 10;
+  
+  contextMenu.style.left = x + 'px';
+  contextMenu.style.top = y + 'px';
+}
+
+// compressFolder 在服务端将文件夹压缩为当前目录中的 zip 文件
+function compressFolder(fileName) {
+  var output = prompt(tr('压缩文件名:'), fileName + '.zip');
+  if (!output) return;
+  var xhr = new XMLHttpRequest();
+  xhr.open('POST', basePath + '/compress', true);
+  xhr.setRequestHeader('Content-Type', 'application/x-www-form-urlencoded');
+  xhr.onload = function () {
+    if (xhr.status !== 200) {
+      alert(xhr.responseText);
+      return;
+    }
+    var result = JSON.parse(xhr.responseText);
+    alert(tr('已压缩 ') + result.files + tr(' 个文件到 ') + result.name + '（' + result.size_human + '）');
+    refreshFileList();
+  };
+  xhr.send('path=' + encodeURIComponent(currentPath) + '&name=' + encodeURIComponent(fileName) + '&output=' + encodeURIComponent(output));
+}
+
+// extractZip 在服务端将 zip 解压到当前目录，并提示解压与跳过的条目数
+function extractZip(fileName) {
+  var xhr = new XMLHttpRequest();
+  xhr.open('POST', basePath + '/extract', true);
+  xhr.setRequestHeader('Content-Type', 'application/x-www-form-urlencoded');
+  xhr.onload = function () {
+    if (xhr.status !== 200) {
+      alert(xhr.responseText);
+      return;
+    }
+    var result = JSON.parse(xhr.responseText);
+    var message = tr('已解压 ') + result.extracted.length + tr(' 项');
+    if (result.skipped.length > 0) {
+      message += tr('，跳过 ') + result.skipped.length + tr(' 项：\n') +
+        result.skipped.slice(0, 20).map(function(s) { return s.name + '（' + s.reason + '）'; }).join('\n');
+    }
+    if (result.error) {
+      message += '\n\n' + result.error;
+    }
+    alert(message);
+    refreshFileList();
+  };
+  xhr.send('path=' + encodeURIComponent(currentPath) + '&file=' + encodeURIComponent(fileName));
+}
+
+// showProperties 通过 /stat 获取并显示文件属性
+function showProperties(fileName) {
+  var xhr = new XMLHttpRequest();
+  xhr.open('GET', basePath + '/stat?path=' + encodeURIComponent(currentPath) + '&file=' + encodeURIComponent(fileName), true);
+  xhr.onload = function () {
+    if (xhr.status !== 200) {
+      alert(tr('获取属性失败: ') + xhr.responseText);
+      return;
+    }
+    var info = JSON.parse(xhr.responseText);
+    var rows = [
+      [tr('名称'), info.name],
+      [tr('类型'), info.is_dir ? tr('文件夹') : tr('文件')],
+      [tr('大小'), info.size_human + ' (' + info.size + tr(' 字节)')],
+      [tr('修改时间'), new Date(info.mod_time).toLocaleString()],
+      [tr('权限'), info.mode + ' (' + info.perm + ')']
+    ];
+    if (info.is_symlink) rows.push([tr('链接目标'), info.link_target]);
+    if (info.uid !== undefined) {
+      rows.push([tr('属主'), (info.owner || '') + ' (' + info.uid + ')']);
+      rows.push([tr('属组'), (info.group || '') + ' (' + info.gid + ')']);
+    }
+    var table = document.getElementById('propertiesTable');
+    table.innerHTML = '';
+    rows.forEach(function(r) {
+      var row = table.insertRow();
+      row.insertCell().innerText = r[0];
+      row.insertCell().innerText = r[1];
+    });
+    if (canChmod) {
+      propertiesFile = fileName;
+      var perm = parseInt(info.perm, 8);
+      document.querySelectorAll('#permTable input').forEach(function(box) {
+        box.checked = (perm & parseInt(box.dataset.bit, 10)) !== 0;
+      });
+    }
+    showModal('modalProperties');
+  };
+  xhr.send();
+}
+
+// submitChmod 按权限复选框计算八进制权限并提交
+var propertiesFile = '';
+function submitChmod() {
+  var perm = 0;
+  document.querySelectorAll('#permTable input').forEach(function(box) {
+    if (box.checked) perm |= parseInt(box.dataset.bit, 10);
+  });
+  var mode = ('000' + perm.toString(8)).slice(-4);
+  var xhr = new XMLHttpRequest();
+  xhr.open('POST', basePath + '/chmod', true);
+  xhr.setRequestHeader('Content-Type', 'application/x-www-form-urlencoded');
+  xhr.onload = function () {
+    alert(xhr.responseText);
+    if (xhr.status === 200) {
+      showProperties(propertiesFile);
+    }
+  };
+  xhr.send('path=' + encodeURIComponent(currentPath) + '&file=' + encodeURIComponent(propertiesFile) + '&mode=' + mode);
+}
+
+// isTailable 日志等文本文件（含无扩展名文件）可实时查看，最终由服务端检查是否为文本
+var tailExts = ['txt', 'log', 'out', 'err', 'csv', 'tsv', 'json', 'jsonl', 'xml', 'md', 'yaml', 'yml', 'ini', 'conf', 'cfg'];
+function isTailable(fileName) {
+  var dot = fileName.lastIndexOf('.');
+  if (dot <= 0) return true;
+  return tailExts.indexOf(fileName.substring(dot + 1).toLowerCase()) >= 0;
+}
+
+// openTail 打开实时查看窗口，通过 /tail 事件流持续追加文件新增内容
+var tailSource = null;
+function openTail(fileName) {
+  closeTail();
+  var output = document.getElementById('tailOutput');
+  output.textContent = '';
+  document.getElementById('tailTitle').innerText = tr('实时查看: ') + fileName;
+  showModal('modalTail');
+  tailSource = new EventSource(basePath + '/tail?path=' + encodeURIComponent(currentPath) + '&file=' + encodeURIComponent(fileName));
+  tailSource.addEventListener('append', function (e) {
+    output.textContent += JSON.parse(e.data).text;
+    // 只保留最近约 1MB 的内容，避免页面占用过多内存
+    if (output.textContent.length > 1048576) {
+      output.textContent = output.textContent.slice(-1048576);
+    }
+    if (document.getElementById('tailFollow').checked) {
+      output.scrollTop = output.scrollHeight;
+    }
+  });
+  tailSource.addEventListener('truncate', function () {
+    output.textContent += tr('\n--- 文件已被截断或替换，从头读取 ---\n');
+  });
+  tailSource.addEventListener('gone', function () {
+    output.textContent += tr('\n--- 文件已被删除 ---\n');
+    tailSource.close();
+  });
+  tailSource.onerror = function () {
+    // 连接建立前失败（如二进制文件被拒绝）时不再重试
+    if (tailSource && tailSource.readyState === EventSource.CLOSED) {
+      output.textContent += tr('\n--- 无法查看该文件 ---\n');
+    }
+  };
+}
+
+function closeTail() {
+  if (tailSource) {
+    tailSource.close();
+    tailSource = null;
+  }
+  closeModal('modalTail');
+}
+
+function addMenuItem(menu, text, onclick, color) {
+  var item = document.createElement('div');
+  item.textContent = text;
+  item.style.cssText = 'padding: 8px 15px; cursor: pointer; border-bottom: 1px solid #eee; color: ' + (color || '#333') + ';';
+  item.onmouseover = function() {
+    if (color === '#e74c3c') {
+      this.style.backgroundColor = '#ffebee';
+    } else if (color === '#2196F3') {
+      this.style.backgroundColor = '#e3f2fd';
+    } else {
+      this.style.backgroundColor = '#f0f0f0';
+    }
+  };
+  item.onmouseout = function() {
+    this.style.backgroundColor = 'white';
+  };
+  item.onclick = onclick;
+  menu.appendChild(item);
+}
+
+// 鼠标悬停目录时按需获取递归大小，显示在提示中
+function loadDirInfo(cell, dirName) {
+  if (cell.getAttribute('data-dirinfo')) return;
+  cell.setAttribute('data-dirinfo', 'loading');
+  var dirPath = currentPath ? currentPath + '/' + dirName : dirName;
+  var xhr = new XMLHttpRequest();
+  xhr.open('GET', basePath + '/dirinfo?path=' + encodeURIComponent(dirPath), true);
+  xhr.onload = function () {
+    if (xhr.status === 200) {
+      var info = JSON.parse(xhr.responseText);
+      cell.title = cell.title + tr('\n共 ') + info.total_size_human + '，' + info.file_count + tr(' 个文件，') + info.dir_count + tr(' 个文件夹');
+      cell.setAttribute('data-dirinfo', 'done');
+    } else {
+      cell.removeAttribute('data-dirinfo');
+    }
+  };
+  xhr.send();
+}
+
+// fileCellTarget 返回事件所在的文件名单元格及对应行的 data-name/data-dir，不在文件行上时返回 null
+function fileCellTarget(event) {
+  var cell = event.target.closest ? event.target.closest('td.file-name') : null;
+  if (!cell || !cell.parentNode.hasAttribute('data-name')) return null;
+  var row = cell.parentNode;
+  return { cell: cell, name: row.getAttribute('data-name'), isDir: row.getAttribute('data-dir') === 'true' };
+}
+
+// 文件行的点击、右键、长按和悬停统一委托到列表容器上处理，文件名只从 data-name 读取，
+// 不拼接进内联脚本，AJAX 刷新列表后也无需重新绑定
+(function () {
+  var container = document.getElementById('fileListContainer');
+  container.addEventListener('click', function (event) {
+    var t = fileCellTarget(event);
+    if (!t) return;
+    if (t.isDir) {
+      enterDirectory(t.name);
+    } else {
+      downloadFile(t.name, currentPath, null);
+    }
+  });
+  container.addEventListener('contextmenu', function (event) {
+    var t = fileCellTarget(event);
+    if (t) showContextMenu(event, t.name, t.isDir);
+  });
+  container.addEventListener('touchstart', function (event) {
+    var t = fileCellTarget(event);
+    if (t) handleTouchStart(event, t.name, t.isDir);
+  });
+  container.addEventListener('touchend', function (event) {
+    if (fileCellTarget(event)) handleTouchEnd(event);
+  });
+  container.addEventListener('mouseover', function (event) {
+    var t = fileCellTarget(event);
+    if (t && t.isDir) loadDirInfo(t.cell, t.name);
+  });
+})();
+
+// 剪贴板保存在 sessionStorage 中，切换目录（整页跳转）后仍可粘贴
+function getClipboard() {
+  try {
+    return JSON.parse(sessionStorage.getItem('clipboard') || 'null');
+  } catch (e) {
+    return null;
+  }
+}
+
+function setClipboard(op, fileName) {
+  sessionStorage.setItem('clipboard', JSON.stringify({ op: op, name: fileName, path: currentPath }));
+  updateClipboardIndicator();
+}
+
+function clearClipboard() {
+  sessionStorage.removeItem('clipboard');
+  updateClipboardIndicator();
+}
+
+function updateClipboardIndicator() {
+  var clip = getClipboard();
+  var bar = document.getElementById('clipboardBar');
+  if (!clip || !canEdit) {
+    bar.style.display = 'none';
+    return;
+  }
+  var source = clip.path ? clip.path + '/' + clip.name : clip.name;
+  document.getElementById('clipboardText').textContent = (clip.op === 'move' ? tr('已剪切: ') : tr('已复制: ')) + source;
+  bar.style.display = 'flex';
+}
+
+function pasteClipboard() {
+  var clip = getClipboard();
+  if (!clip) return;
+  if (clip.op === 'move' && clip.path === currentPath) {
+    // 剪切后粘贴到原目录，无需移动
+    clearClipboard();
+    return;
+  }
+  var xhr = new XMLHttpRequest();
+  xhr.open('POST', clip.op === 'move' ? basePath + '/move' : basePath + '/copy', true);
+  xhr.setRequestHeader('Content-Type', 'application/x-www-form-urlencoded');
+  xhr.onload = function () {
+    if (xhr.status === 200) {
+      if (clip.op === 'move') {
+        clearClipboard();
+      }
+      refreshFileList();
+    } else {
+      alert(tr('粘贴失败: ') + xhr.responseText);
+    }
+  };
+  xhr.send('name=' + encodeURIComponent(clip.name) + '&path=' + encodeURIComponent(clip.path) + '&dest=' + encodeURIComponent(currentPath));
+}
+
+// moveEntry 将当前目录中的 fileName 移动到 dest 目录（相对于根目录），用于“移动到上级目录”和拖放到路径导航
+function moveEntry(fileName, dest) {
+  if (dest === currentPath) return;
+  var xhr = new XMLHttpRequest();
+  xhr.open('POST', basePath + '/move', true);
+  xhr.setRequestHeader('Content-Type', 'application/x-www-form-urlencoded');
+  xhr.onload = function () {
+    if (xhr.status === 200) {
+      refreshFileList();
+    } else if (!fileGone(xhr.status)) {
+      alert(tr('移动失败') + ': ' + xhr.responseText);
+    }
+  };
+  xhr.send('name=' + encodeURIComponent(fileName) + '&path=' + encodeURIComponent(currentPath) + '&dest=' + encodeURIComponent(dest));
+}
+
+// 拖动文件行到路径导航中的上级目录（或 ".." 行）上即移动到该目录；
+// 目标通过 data-drop-path 标记，拖放数据使用自定义类型，不会与外部拖入的文件混淆
+(function () {
+  var dragType = 'application/x-hfs-entry';
+  function dropTarget(event) {
+    if (!event.dataTransfer || Array.prototype.indexOf.call(event.dataTransfer.types, dragType) < 0) return null;
+    return event.target.closest ? event.target.closest('[data-drop-path]') : null;
+  }
+  document.getElementById('fileListContainer').addEventListener('dragstart', function (event) {
+    var row = event.target.closest ? event.target.closest('tr[data-name]') : null;
+    if (!row || !canEdit) return;
+    event.dataTransfer.setData(dragType, row.getAttribute('data-name'));
+    event.dataTransfer.effectAllowed = 'move';
+  });
+  document.addEventListener('dragover', function (event) {
+    var target = dropTarget(event);
+    if (!target || target.getAttribute('data-drop-path') === currentPath) return;
+    event.preventDefault();
+    event.dataTransfer.dropEffect = 'move';
+    target.classList.add('drop-target');
+  });
+  document.addEventListener('dragleave', function (event) {
+    var target = dropTarget(event);
+    if (target) target.classList.remove('drop-target');
+  });
+  document.addEventListener('drop', function (event) {
+    var target = dropTarget(event);
+    if (!target) return;
+    event.preventDefault();
+    target.classList.remove('drop-target');
+    moveEntry(event.dataTransfer.getData(dragType), target.getAttribute('data-drop-path'));
+  });
+})();
+
+// 键盘导航：方向键移动高亮行，Enter 打开/下载，Backspace 返回上级，
+// Delete 删除，F2 重命名，"/" 聚焦搜索框。输入框中或弹窗打开时不拦截按键
+var selectedName = null;
+
+function visibleRows() {
+  var rows = document.querySelectorAll('#fileListContainer tbody tr[data-name]');
+  return Array.prototype.filter.call(rows, function (row) {
+    return row.style.display !== 'none';
+  });
+}
+
+function selectRow(row) {
+  var old = document.querySelector('#fileListContainer tr.selected');
+  if (old) old.classList.remove('selected');
+  if (!row) {
+    selectedName = null;
+    return;
+  }
+  row.classList.add('selected');
+  selectedName = row.getAttribute('data-name');
+  row.scrollIntoView({ block: 'nearest' });
+}
+
+function restoreSelection() {
+  if (selectedName === null) return;
+  var rows = visibleRows();
+  for (var i = 0; i < rows.length; i++) {
+    if (rows[i].getAttribute('data-name') === selectedName) {
+      selectRow(rows[i]);
+      return;
+    }
+  }
+  selectedName = null;
+}
+
+function parentPath(path) {
+  var idx = path.lastIndexOf('/');
+  return idx < 0 ? '' : path.substring(0, idx);
+}
+
+function goUp() {
+  if (!currentPath) return;
+  window.location.href = basePath + '/?' + browseParam + 'path=' + encodeURIComponent(parentPath(currentPath)) + '&sort=' + encodeURIComponent(currentSort) + '&order=' + encodeURIComponent(currentOrder) + (currentCategory ? '&category=' + encodeURIComponent(currentCategory) : '');
+}
+
+function modalOpen() {
+  var modals = document.querySelectorAll('.modal');
+  for (var i = 0; i < modals.length; i++) {
+    if (modals[i].style.display === 'block') return true;
+  }
+  return false;
+}
+
+document.addEventListener('keydown', function (e) {
+  var tag = e.target.tagName;
+  if (tag === 'INPUT' || tag === 'TEXTAREA' || tag === 'SELECT' || e.target.isContentEditable) return;
+  if (e.ctrlKey || e.metaKey || e.altKey || modalOpen()) return;
+
+  var rows = visibleRows();
+  var current = document.querySelector('#fileListContainer tr.selected');
+  var index = rows.indexOf(current);
+  var name = current ? current.getAttribute('data-name') : null;
+  var isDir = current ? current.getAttribute('data-dir') === 'true' : false;
+
+  switch (e.key) {
+    case 'ArrowDown':
+      selectRow(rows[Math.min(index + 1, rows.length - 1)]);
+      break;
+    case 'ArrowUp':
+      selectRow(rows[Math.max(index - 1, 0)]);
+      break;
+    case 'Enter':
+      if (!current) return;
+      if (isDir) {
+        enterDirectory(name);
+      } else {
+        downloadFile(name, currentPath, null);
+      }
+      break;
+    case 'Backspace':
+      goUp();
+      break;
+    case 'Delete':
+      if (!current || !canDelete) return;
+      deleteFile(name, currentPath, null);
+      break;
+    case 'F2':
+      if (!current || !canEdit) return;
+      renameFile(name);
+      break;
+    case '/':
+      document.getElementById('searchInput').focus();
+      break;
+    default:
+      return;
+  }
+  e.preventDefault();
+});
+
+function filterFiles() {
+  var input = document.getElementById("searchInput");
+  var filter = input.value.toLowerCase();
+  var rows = document.querySelectorAll("#fileListContainer tbody tr");
+  rows.forEach(function (row) {
+    var cellText = row.cells[0].innerText.toLowerCase();
+    row.style.display = cellText.indexOf(filter) > -1 ? "" : "none";
+  });
+}
+
+loadAccelOptions();
+updateClipboardIndicator();
+
+// 订阅当前目录的变化事件，其他用户修改后自动刷新列表
+if (window.EventSource) {
+  var dirEvents = new EventSource(basePath + '/events?path=' + encodeURIComponent(currentPath));
+  var refreshPending = null;
+  dirEvents.addEventListener('change', function () {
+    // 合并短时间内的多次变化，避免频繁刷新；查看最近修改时不打断
+    if (refreshPending || recentMode) return;
+    refreshPending = setTimeout(function () {
+      refreshPending = null;
+      refreshFileList();
+    }, 300);
+  });
+  dirEvents.addEventListener('gone', function () {
+    dirEvents.close();
+  });
+}
+
+function logout() {
+  // auth_token 为 HttpOnly cookie，由登出页面在服务端清除
+  window.location.href = basePath + '/logout';
+}
@@ -0,0 +1,41 @@
+// tr 按当前语言翻译界面文字，缺少译文时原样返回
+function tr(s) {
+  return i18n[s] || s;
+}
+
+document.getElementById('loginForm').addEventListener('submit', async function(e) {
+  e.preventDefault();
+  
+  const username = document.getElementById('username').value;
+  const password = document.getElementById('password').value;
+  const rememberMe = document.getElementById('rememberMe').checked;
+  const errorMsg = document.getElementById('errorMsg');
+  
+  try {
+    const response = await fetch(basePath + '/api/login', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({
+        username: username,
+        password: password,
+        remember_me: rememberMe
+      })
+    });
+    
+    const data = await response.json();
+    
+    if (response.ok) {
+      // cookie 由服务端通过 Set-Cookie 设置（HttpOnly）
+      // 跳转到主页
+      window.location.href = basePath + '/';
+    } else {
+      errorMsg.textContent = (data.error && data.error.message) || tr('登录失败');
+      errorMsg.style.display = 'block';
+    }
+  } catch (error) {
+    errorMsg.textContent = tr('网络错误，请重试');
+    errorMsg.style.display = 'block';
+  }
+});